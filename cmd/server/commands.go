@@ -0,0 +1,834 @@
+// ABOUTME: Subcommands for headless operation: sync, blog management, OPML, read state, backups and newsletter archives.
+// ABOUTME: Each command opens the database directly and reuses the scanner and service layers.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/backup"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/config"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/fetch"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/model"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/newsletter"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/opml"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/retention"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/scanner"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/service"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/storage"
+)
+
+// errUsage signals that usage has already been printed and the process should
+// exit with status 2. errHelp means help was requested and printed.
+var (
+	errUsage = errors.New("usage")
+	errHelp  = errors.New("help")
+)
+
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(ctx context.Context, args []string) error
+}
+
+// commands lists every subcommand in the order shown by help. It is a function
+// rather than a variable because the commands themselves look up their usage.
+func commands() []command {
+	return []command{
+		{"serve", "[-config FILE] [-db PATH] [-listen ADDR] [-base-url URL] [timeouts] [-sync-interval D]", "Start the web server (default when no command is given)", runServe},
+		{"sync", "[-config FILE] [-db PATH] [-blog NAME] [-timeout DURATION]", "Scan all blogs, or one blog, for new articles", runSync},
+		{"add", "[-config FILE] [-db PATH] [-feed URL] [-selector CSS] NAME URL", "Track a new blog; the feed URL is discovered when omitted", runAdd},
+		{"remove", "[-config FILE] [-db PATH] [-keep-articles] NAME", "Stop tracking a blog and delete its articles", runRemove},
+		{"list", "[-config FILE] [-db PATH] [-articles] [-filter unread|read|all] [-blog NAME] [-search QUERY] [-limit N]", "List tracked blogs, or articles with -articles", runList},
+		{"import-opml", "[-config FILE] [-db PATH] FILE", "Add every feed in an OPML file (use - for stdin)", runImportOPML},
+		{"export-opml", "[-config FILE] [-db PATH] [-o FILE]", "Write tracked blogs as OPML to stdout or a file", runExportOPML},
+		{"mark-read", "[-config FILE] [-db PATH] [-all] [-blog NAME] [ID...]", "Mark articles as read by ID, or all unread with -all", runMarkRead},
+		{"thumbnails", "[-config FILE] [-db PATH]", "Fetch thumbnails for articles that are missing one", runThumbnails},
+		{"prune", "[-config FILE] [-db PATH] [-dry-run]", "Delete old read articles according to the retention settings", runPrune},
+		{"config", "print [serve flags]", "Show the effective server configuration as JSON", runConfig},
+		{"backup", "[-config FILE] [-db PATH] [-o FILE]", "Back up the database into the backup directory, or to a file with -o", runBackup},
+		{"restore", "[-config FILE] [-db PATH] BACKUP", "Replace the database with a backup (stop the server first)", runRestore},
+		{"export", "[-config FILE] [-db PATH] [-o FILE] [-include-secrets]", "Write blogs, articles, read/starred state and settings as JSON", runExport},
+		{"import", "[-config FILE] [-db PATH] FILE", "Merge a JSON export into the database (use - for stdin)", runImport},
+		{"import-newsletters", "[-config FILE] [-db PATH] PATH...", "Import newsletters from mbox files, Maildir directories or zipped Maildirs", runImportNewsletters},
+		{"migrate", "status|up [-config FILE] [-db PATH] [-dry-run] [-no-backup]", "Show or apply pending database schema migrations", runMigrate},
+	}
+}
+
+// stdout and stderr are variables so output can be redirected.
+var (
+	stdout io.Writer = os.Stdout
+	stderr io.Writer = os.Stderr
+)
+
+// dispatch runs the subcommand named by args[0]. No arguments starts the server
+// so existing deployments keep working.
+func dispatch(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return runServe(ctx, nil)
+	}
+	name := args[0]
+	if name == "help" || name == "-h" || name == "-help" || name == "--help" {
+		printUsage(stdout)
+		return nil
+	}
+	for _, c := range commands() {
+		if c.name == name {
+			return c.run(ctx, args[1:])
+		}
+	}
+	fmt.Fprintf(stderr, "unknown command %q\n\n", name)
+	printUsage(stderr)
+	return errUsage
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: blogwatcher-ui <command> [flags] [args]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, c := range commands() {
+		fmt.Fprintf(tw, "  %s\t%s\n", c.name, c.summary)
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `Run "blogwatcher-ui <command> -h" for a command's flags.`)
+}
+
+// newFlagSet returns a FlagSet for the named command with the shared -config
+// and -db flags.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	for _, c := range commands() {
+		if c.name == name {
+			fs.Usage = func() {
+				fmt.Fprintf(stderr, "Usage: blogwatcher-ui %s %s\n\n%s\n\n", c.name, c.args, c.summary)
+				fs.PrintDefaults()
+			}
+		}
+	}
+	config.RegisterConfigFlag(fs)
+	fs.String("db", "", "database path (default ~/.blogwatcher/blogwatcher.db; env BLOGWATCHER_DB)")
+	return fs
+}
+
+// openDatabase opens the database chosen by -db, the environment or the config
+// file, and applies the configured logging and User-Agent for commands that fetch.
+func openDatabase(fs *flag.FlagSet) (*storage.Database, error) {
+	return openDatabaseWithOptions(fs, storage.OpenOptions{})
+}
+
+// openDatabaseWithOptions is openDatabase with control over migrations.
+func openDatabaseWithOptions(fs *flag.FlagSet, opts storage.OpenOptions) (*storage.Database, error) {
+	cfg, err := loadConfig(fs)
+	if err != nil {
+		return nil, err
+	}
+	return storage.OpenDatabaseWithOptions(cfg.DatabasePath, opts)
+}
+
+// loadConfig loads the configuration for a command and applies its logging
+// and User-Agent settings.
+func loadConfig(fs *flag.FlagSet) (config.Config, error) {
+	cfg, err := config.Load(fs, os.Getenv)
+	if err != nil {
+		return cfg, err
+	}
+	if _, err := setupLogging(cfg); err != nil {
+		return cfg, err
+	}
+	fetch.SetUserAgent(cfg.UserAgent)
+	return cfg, nil
+}
+
+// parseFlags parses args, mapping -h to errHelp and flag errors to errUsage.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return errHelp
+		}
+		return errUsage
+	}
+	return nil
+}
+
+// openBlog looks up a blog by name and reports a friendly error when missing.
+func openBlog(db *storage.Database, name string) (*model.Blog, error) {
+	blog, err := db.GetBlogByName(name)
+	if err != nil {
+		return nil, err
+	}
+	if blog == nil {
+		return nil, fmt.Errorf("blog %q not found", name)
+	}
+	return blog, nil
+}
+
+func runSync(ctx context.Context, args []string) error {
+	fs := newFlagSet("sync")
+	blogName := fs.String("blog", "", "only scan the blog with this name")
+	timeout := fs.Duration("timeout", 3*time.Minute, "give up on slow sites after this long")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	db, err := openDatabase(fs)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	var results []scanner.ScanResult
+	if *blogName != "" {
+		blog, err := openBlog(db, *blogName)
+		if err != nil {
+			return err
+		}
+		results = []scanner.ScanResult{scanner.ScanBlog(ctx, db, *blog)}
+	} else {
+		results, err = scanner.ScanAllBlogs(ctx, db)
+		if err != nil {
+			return err
+		}
+	}
+
+	totalNew, failed := 0, 0
+	for _, r := range results {
+		if r.Error != "" {
+			failed++
+			fmt.Fprintf(stderr, "%s: %s\n", r.BlogName, r.Error)
+			continue
+		}
+		totalNew += r.NewArticles
+		fmt.Fprintf(stdout, "%s: %d new (%d found, source: %s)\n", r.BlogName, r.NewArticles, r.TotalFound, r.Source)
+	}
+	fmt.Fprintf(stdout, "Scanned %d blogs: %d new articles, %d errors\n", len(results), totalNew, failed)
+	// Exit non-zero so cron and scripts notice
+	if failed > 0 {
+		return fmt.Errorf("%d of %d blogs failed to sync", failed, len(results))
+	}
+	return nil
+}
+
+func runAdd(ctx context.Context, args []string) error {
+	fs := newFlagSet("add")
+	feedURL := fs.String("feed", "", "feed URL (discovered from the blog URL when omitted)")
+	selector := fs.String("selector", "", "CSS selector for scraping when the blog has no feed")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errUsage
+	}
+
+	db, err := openDatabase(fs)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	result, err := service.NewBlogService(db).AddBlog(ctx, service.AddBlogInput{
+		Name:           fs.Arg(0),
+		URL:            fs.Arg(1),
+		FeedURL:        *feedURL,
+		ScrapeSelector: *selector,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "Added %s", result.Blog.Name)
+	if result.Blog.FeedURL != "" {
+		fmt.Fprintf(stdout, " (feed: %s)", result.Blog.FeedURL)
+	} else if result.Blog.ScrapeSelector == "" {
+		fmt.Fprint(stdout, " (no feed found; add -selector to scrape instead)")
+	}
+	fmt.Fprintln(stdout)
+	return nil
+}
+
+func runRemove(ctx context.Context, args []string) error {
+	fs := newFlagSet("remove")
+	keep := fs.Bool("keep-articles", false, "keep the blog's articles instead of deleting them")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errUsage
+	}
+
+	db, err := openDatabase(fs)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	blog, err := openBlog(db, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *keep {
+		err = db.DeleteBlogOnly(blog.ID)
+	} else {
+		err = db.DeleteBlogWithArticles(blog.ID)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Removed %s\n", blog.Name)
+	return nil
+}
+
+func runList(ctx context.Context, args []string) error {
+	fs := newFlagSet("list")
+	articles := fs.Bool("articles", false, "list articles instead of blogs")
+	filter := fs.String("filter", "unread", "article status: unread, read or all")
+	blogName := fs.String("blog", "", "only list articles from this blog")
+	search := fs.String("search", "", "full-text search query")
+	limit := fs.Int("limit", 50, "maximum number of articles")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	db, err := openDatabase(fs)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	defer tw.Flush()
+
+	if !*articles {
+		blogs, err := db.ListBlogs()
+		if err != nil {
+			return err
+		}
+		unread, err := db.UnreadCountsByBlog()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(tw, "NAME\tUNREAD\tLAST SCANNED\tURL\tFEED")
+		for _, b := range blogs {
+			scanned := "never"
+			if b.LastScanned != nil {
+				scanned = b.LastScanned.Local().Format("2006-01-02 15:04")
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", b.Name, unread[b.ID], scanned, b.URL, b.FeedURL)
+		}
+		return nil
+	}
+
+	opts := model.SearchOptions{SearchQuery: *search, Limit: *limit}
+	switch *filter {
+	case "unread", "read":
+		isRead := *filter == "read"
+		opts.IsRead = &isRead
+	case "all":
+	default:
+		return fmt.Errorf("invalid -filter %q: want unread, read or all", *filter)
+	}
+	if *blogName != "" {
+		blog, err := openBlog(db, *blogName)
+		if err != nil {
+			return err
+		}
+		opts.BlogID = &blog.ID
+	}
+
+	list, _, err := db.SearchArticles(opts)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(tw, "ID\tPUBLISHED\tBLOG\tTITLE\tURL")
+	for _, a := range list {
+		published := ""
+		if a.PublishedDate != nil {
+			published = a.PublishedDate.Local().Format("2006-01-02")
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", a.ID, published, a.BlogName, a.Title, a.URL)
+	}
+	return nil
+}
+
+func runImportOPML(ctx context.Context, args []string) error {
+	fs := newFlagSet("import-opml")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errUsage
+	}
+
+	var r io.Reader = os.Stdin
+	if path := fs.Arg(0); path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	feeds, err := opml.Parse(r)
+	if err != nil {
+		return err
+	}
+
+	db, err := openDatabase(fs)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	blogService := service.NewBlogService(db)
+	added, skipped := 0, 0
+	for _, f := range feeds {
+		input := service.AddBlogInput{
+			Name:    f.Title,
+			URL:     f.SiteURL,
+			FeedURL: f.FeedURL,
+		}
+		if input.URL == "" {
+			input.URL = f.FeedURL
+		}
+		if input.Name == "" {
+			input.Name = hostName(input.URL)
+		}
+
+		if _, err := blogService.AddBlog(ctx, input); err != nil {
+			var dupErr service.BlogAlreadyExistsError
+			if !errors.As(err, &dupErr) {
+				return fmt.Errorf("add %s: %w", input.Name, err)
+			}
+			skipped++
+			fmt.Fprintf(stderr, "skipped %s: %v\n", input.Name, dupErr)
+			continue
+		}
+		added++
+		fmt.Fprintf(stdout, "Added %s (%s)\n", input.Name, input.FeedURL)
+	}
+	fmt.Fprintf(stdout, "Imported %d blogs, skipped %d already tracked\n", added, skipped)
+	return nil
+}
+
+// hostName returns the host of rawURL, or rawURL itself if it cannot be parsed.
+func hostName(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return rawURL
+}
+
+func runExportOPML(ctx context.Context, args []string) error {
+	fs := newFlagSet("export-opml")
+	output := fs.String("o", "", "write to this file instead of stdout")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	db, err := openDatabase(fs)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	blogs, err := db.ListBlogs()
+	if err != nil {
+		return err
+	}
+	var feeds []opml.Feed
+	for _, b := range blogs {
+		// Newsletters and scrape-only blogs have no feed another reader could follow.
+		if b.Type == model.BlogTypeNewsletter || b.FeedURL == "" {
+			fmt.Fprintf(stderr, "skipped %s: no feed URL\n", b.Name)
+			continue
+		}
+		feeds = append(feeds, opml.Feed{Title: b.Name, FeedURL: b.FeedURL, SiteURL: b.URL})
+	}
+
+	w := stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	return opml.Write(w, "BlogWatcher subscriptions", feeds)
+}
+
+func runMarkRead(ctx context.Context, args []string) error {
+	fs := newFlagSet("mark-read")
+	all := fs.Bool("all", false, "mark every unread article as read")
+	blogName := fs.String("blog", "", "with -all, only mark articles from this blog")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *all == (fs.NArg() > 0) {
+		fs.Usage()
+		return errUsage
+	}
+
+	db, err := openDatabase(fs)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if *all {
+		var blogID *int64
+		if *blogName != "" {
+			blog, err := openBlog(db, *blogName)
+			if err != nil {
+				return err
+			}
+			blogID = &blog.ID
+		}
+		if err := db.MarkAllUnreadArticlesRead(blogID); err != nil {
+			return err
+		}
+		fmt.Fprintln(stdout, "Marked all unread articles as read")
+		return nil
+	}
+
+	for _, arg := range fs.Args() {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid article ID %q", arg)
+		}
+		found, err := db.MarkArticleRead(id)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("article %d not found", id)
+		}
+	}
+	fmt.Fprintf(stdout, "Marked %d articles as read\n", fs.NArg())
+	return nil
+}
+
+func runThumbnails(ctx context.Context, args []string) error {
+	fs := newFlagSet("thumbnails")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	db, err := openDatabase(fs)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	result, err := scanner.SyncThumbnails(ctx, db)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Checked %d articles: %d thumbnails updated, %d errors\n", result.Total, result.Updated, result.Errors)
+	return nil
+}
+
+func runPrune(ctx context.Context, args []string) error {
+	fs := newFlagSet("prune")
+	dryRun := fs.Bool("dry-run", false, "report what would be deleted without deleting")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	db, err := openDatabase(fs)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	policy, err := retention.LoadSettings(db)
+	if err != nil {
+		return err
+	}
+	if !policy.Enabled() {
+		fmt.Fprintln(stderr, "no global retention configured; only per-blog overrides apply")
+	}
+	result, err := retention.Run(db, time.Now(), *dryRun)
+	if err != nil {
+		return err
+	}
+	verb := "Deleted"
+	if *dryRun {
+		verb = "Would delete"
+	}
+	for _, b := range result.Blogs {
+		fmt.Fprintf(stdout, "%s: %d\n", b.BlogName, b.Pruned)
+	}
+	fmt.Fprintf(stdout, "%s %d read articles from %d blogs\n", verb, result.Pruned, len(result.Blogs))
+	return nil
+}
+
+func runMigrate(ctx context.Context, args []string) error {
+	if len(args) == 0 || (args[0] != "status" && args[0] != "up") {
+		fmt.Fprintln(stderr, "Usage: blogwatcher-ui migrate status|up [-config FILE] [-db PATH] [-dry-run] [-no-backup]")
+		return errUsage
+	}
+	action := args[0]
+	fs := newFlagSet("migrate")
+	dryRun := fs.Bool("dry-run", false, "with up, list the migrations that would run without applying them")
+	noBackup := fs.Bool("no-backup", false, "with up, skip the copy of the database taken before migrating")
+	if err := parseFlags(fs, args[1:]); err != nil {
+		return err
+	}
+
+	db, err := openDatabaseWithOptions(fs, storage.OpenOptions{SkipMigrations: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	status, err := db.MigrationStatus()
+	if err != nil {
+		return err
+	}
+
+	if action == "status" || *dryRun {
+		tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tSTATE\tAPPLIED")
+		pending := 0
+		for _, m := range status {
+			applied := ""
+			if m.AppliedAt != nil {
+				applied = m.AppliedAt.Local().Format(time.DateTime)
+			} else {
+				pending++
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", m.Version, m.Name, m.State, applied)
+		}
+		tw.Flush()
+		fmt.Fprintf(stdout, "%d of %d migrations pending\n", pending, len(status))
+		return nil
+	}
+
+	result, err := db.Migrate(!*noBackup)
+	if result.BackupPath != "" {
+		fmt.Fprintf(stdout, "Backed up database to %s\n", result.BackupPath)
+	}
+	for _, m := range result.Applied {
+		verb := "Applied"
+		if m.State == storage.MigrationPresent {
+			verb = "Recorded existing"
+		}
+		fmt.Fprintf(stdout, "%s migration %d: %s\n", verb, m.Version, m.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if len(result.Applied) == 0 {
+		fmt.Fprintln(stdout, "Database schema is up to date")
+	}
+	return nil
+}
+
+func runBackup(ctx context.Context, args []string) error {
+	fs := newFlagSet("backup")
+	output := fs.String("o", "", "write the backup to this file instead of the backup directory")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig(fs)
+	if err != nil {
+		return err
+	}
+	db, err := storage.OpenDatabase(cfg.DatabasePath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if *output != "" {
+		if err := db.BackupTo(*output); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "Backed up %s to %s\n", db.Path(), *output)
+		return nil
+	}
+	b, err := backup.NewManager(db, cfg.Backup.Dir, cfg.Backup.Keep, nil).Create()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Backed up %s to %s (%d bytes)\n", db.Path(), filepath.Join(cfg.Backup.Dir, b.Name), b.Size)
+	return nil
+}
+
+func runRestore(ctx context.Context, args []string) error {
+	fs := newFlagSet("restore")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errUsage
+	}
+
+	cfg, err := loadConfig(fs)
+	if err != nil {
+		return err
+	}
+	// A bare name refers to a file in the backup directory
+	src := fs.Arg(0)
+	if _, err := os.Stat(src); errors.Is(err, os.ErrNotExist) && filepath.Base(src) == src {
+		src = filepath.Join(cfg.Backup.Dir, src)
+	}
+
+	previous, err := backup.Restore(src, cfg.DatabasePath)
+	if err != nil {
+		return err
+	}
+	if previous != "" {
+		fmt.Fprintf(stdout, "Saved the replaced database as %s\n", previous)
+	}
+	fmt.Fprintf(stdout, "Restored %s from %s\n", cfg.DatabasePath, src)
+	return nil
+}
+
+func runExport(ctx context.Context, args []string) error {
+	fs := newFlagSet("export")
+	output := fs.String("o", "", "write to this file instead of stdout")
+	includeSecrets := fs.Bool("include-secrets", false, "include passwords, webhook secrets and signing keys")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	db, err := openDatabase(fs)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	exp, err := db.Export(ctx, storage.ExportOptions{IncludeSecrets: *includeSecrets})
+	if err != nil {
+		return err
+	}
+
+	w := stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(exp); err != nil {
+		return err
+	}
+	if *output != "" {
+		fmt.Fprintf(stdout, "Exported %d blogs and %d articles to %s\n", len(exp.Blogs), len(exp.Articles), *output)
+	}
+	return nil
+}
+
+func runImport(ctx context.Context, args []string) error {
+	fs := newFlagSet("import")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errUsage
+	}
+
+	var r io.Reader = os.Stdin
+	if name := fs.Arg(0); name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	var exp storage.Export
+	if err := json.NewDecoder(r).Decode(&exp); err != nil {
+		return fmt.Errorf("read export: %w", err)
+	}
+
+	db, err := openDatabase(fs)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	result, err := db.Import(&exp)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Imported %d blogs and %d articles; updated read state of %d articles; %d settings\n",
+		result.BlogsAdded, result.ArticlesAdded, result.ArticlesUpdated, result.Settings)
+	return nil
+}
+
+// runImportNewsletters runs every message in the given archives through
+// newsletter ingestion. Messages already stored are skipped by Message-ID, so
+// an interrupted import can simply be run again.
+func runImportNewsletters(ctx context.Context, args []string) error {
+	fs := newFlagSet("import-newsletters")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errUsage
+	}
+
+	db, err := openDatabase(fs)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	im := newsletter.NewImporter(db)
+	var importErr error
+	for _, p := range fs.Args() {
+		if err := im.ImportFile(ctx, p); err != nil {
+			importErr = fmt.Errorf("%s: %w", p, err)
+			break
+		}
+	}
+
+	result := im.Result()
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	if len(result.Senders) > 0 {
+		fmt.Fprintln(tw, "SENDER\tEMAIL\tIMPORTED\tALREADY IMPORTED")
+		for _, s := range result.Senders {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%d\n", s.Name, s.Email, s.Imported, s.Duplicates)
+		}
+	}
+	tw.Flush()
+	for _, f := range result.Failures {
+		fmt.Fprintf(stderr, "failed: %s: %s\n", f.Message, f.Error)
+	}
+	if more := result.Failed - len(result.Failures); more > 0 {
+		fmt.Fprintf(stderr, "failed: ...and %d more\n", more)
+	}
+	fmt.Fprintf(stdout, "Imported %d newsletters; %d already imported, %d failed\n",
+		result.Imported, result.Duplicates, result.Failed)
+	return importErr
+}
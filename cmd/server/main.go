@@ -1,27 +1,91 @@
-// ABOUTME: Main entry point for the BlogWatcher HTTP server
-// ABOUTME: Handles graceful shutdown and server lifecycle management
+// ABOUTME: Main entry point for the BlogWatcher server and command-line interface
+// ABOUTME: Dispatches subcommands; serve handles graceful shutdown and server lifecycle
 package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
+	"fmt"
 	"io/fs"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
 	"github.com/esttorhe/blogwatcher-ui/v2/assets"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/config"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/digest"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/fetch"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/logging"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/newsletter"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/retention"
 	"github.com/esttorhe/blogwatcher-ui/v2/internal/server"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/smtpd"
 	"github.com/esttorhe/blogwatcher-ui/v2/internal/storage"
 	"github.com/esttorhe/blogwatcher-ui/v2/internal/version"
 )
 
-func run(ctx context.Context) error {
+// loadServerConfig parses the serve flags in args and returns the validated
+// effective configuration.
+func loadServerConfig(name string, args []string) (config.Config, error) {
+	flags := newFlagSet(name)
+	config.RegisterFlags(flags)
+	if err := parseFlags(flags, args); err != nil {
+		return config.Config{}, err
+	}
+	cfg, err := config.Load(flags, os.Getenv)
+	if err != nil {
+		return cfg, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return cfg, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return cfg, nil
+}
+
+// setupLogging builds the logger described by cfg and makes it the default,
+// so the standard log package and slog's package functions use it too.
+func setupLogging(cfg config.Config) (*slog.Logger, error) {
+	logger, err := logging.New(os.Stderr, cfg.LogFormat, cfg.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	slog.SetDefault(logger)
+	return logger, nil
+}
+
+// listen opens a TCP address or Unix socket. A stale socket file left by an
+// unclean shutdown is removed first.
+func listen(network, address string) (net.Listener, error) {
+	if network == "unix" {
+		if info, err := os.Stat(address); err == nil && info.Mode()&os.ModeSocket != 0 {
+			if err := os.Remove(address); err != nil {
+				return nil, fmt.Errorf("remove stale socket: %w", err)
+			}
+		}
+	}
+	return net.Listen(network, address)
+}
+
+// runServe starts the web server and blocks until ctx is cancelled.
+func runServe(ctx context.Context, args []string) error {
+	cfg, err := loadServerConfig("serve", args)
+	if err != nil {
+		return err
+	}
+	logger, err := setupLogging(cfg)
+	if err != nil {
+		return err
+	}
+	fetch.SetUserAgent(cfg.UserAgent)
+
 	// Open database
-	db, err := storage.OpenDatabase("")
+	db, err := storage.OpenDatabase(cfg.DatabasePath)
 	if err != nil {
 		return err
 	}
@@ -40,29 +104,108 @@ func run(ctx context.Context) error {
 	}
 
 	// Create server with embedded filesystems
-	handler, err := server.NewServerWithFS(db, templateFiles, staticFiles, version.Version)
+	handler, err := server.NewServerWithOptions(db, templateFiles, staticFiles, server.Options{
+		Version:        version.Version,
+		BaseURL:        cfg.BaseURL,
+		BasePath:       cfg.BasePath,
+		TrustedProxies: cfg.TrustedProxies,
+		Logger:         logger,
+		SyncTimeout:    time.Duration(cfg.Sync.Timeout),
+		BackupDir:      cfg.Backup.Dir,
+		BackupKeep:     cfg.Backup.Keep,
+	})
 	if err != nil {
 		return err
 	}
 
 	// Configure HTTP server with timeouts
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
 	srv := &http.Server{
-		Addr:         ":" + port,
 		Handler:      handler,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  120 * time.Second,
+		ReadTimeout:  time.Duration(cfg.ReadTimeout),
+		WriteTimeout: time.Duration(cfg.WriteTimeout),
+		IdleTimeout:  time.Duration(cfg.IdleTimeout),
+		ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+	}
+	if cfg.TLSEnabled() {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			return fmt.Errorf("load TLS certificate: %w", err)
+		}
+		srv.TLSConfig = &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			Certificates: []tls.Certificate{cert},
+		}
+	}
+	// Live event streams never go idle, so end them when shutdown begins
+	srv.RegisterOnShutdown(handler.Close)
+
+	ln, err := listen(cfg.Network())
+	if err != nil {
+		return err
+	}
+
+	// Digest links need an absolute URL even without a request to derive it from
+	scheme := "http"
+	if cfg.TLSEnabled() {
+		scheme = "https"
+	}
+	baseURL := cfg.BaseURL
+	if baseURL == "" {
+		baseURL = scheme + "://localhost"
+		if tcp, ok := ln.Addr().(*net.TCPAddr); ok {
+			baseURL += ":" + strconv.Itoa(tcp.Port)
+		}
+		baseURL += cfg.BasePath
+	}
+
+	// Send scheduled email digests in the background
+	go digest.RunScheduler(logging.WithLogger(ctx, logger.With("component", "digest")), db, baseURL, time.Hour)
+
+	// Remove old read articles according to the retention settings
+	go retention.RunScheduler(logging.WithLogger(ctx, logger.With("component", "retention")), db, time.Hour)
+
+	// Import newsletters from the IMAP mailbox configured in Settings, if any
+	go newsletter.NewIMAPPoller(db, handler.Events()).RunScheduler(logging.WithLogger(ctx, logger.With("component", "imap")), time.Minute)
+
+	// Sync all blogs periodically when configured
+	if cfg.Sync.Interval > 0 {
+		go handler.SyncJobs().RunEvery(ctx, time.Duration(cfg.Sync.Interval))
+	}
+
+	// Back up the database periodically when configured
+	if cfg.Backup.Interval > 0 {
+		go handler.Backups().RunEvery(ctx, time.Duration(cfg.Backup.Interval))
+	}
+
+	serverErr := make(chan error, 2)
+
+	// Receive newsletters over SMTP or LMTP when configured
+	var mailSrv *smtpd.Server
+	if cfg.Mail.Listen != "" {
+		mailLn, err := listen(cfg.Mail.Network())
+		if err != nil {
+			return fmt.Errorf("mail listener: %w", err)
+		}
+		mailSrv = newMailServer(cfg, db, handler, srv.TLSConfig, logger.With("component", "mail"))
+		go func() {
+			logger.Info("mail listener starting", "listen", cfg.Mail.Listen, "protocol", cfg.Mail.Protocol)
+			if err := mailSrv.Serve(mailLn); err != nil && !errors.Is(err, smtpd.ErrServerClosed) {
+				serverErr <- fmt.Errorf("mail listener: %w", err)
+			}
+		}()
 	}
 
 	// Start server in goroutine
-	serverErr := make(chan error, 1)
 	go func() {
-		log.Printf("Server starting on %s", srv.Addr)
-		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Info("server starting", "listen", cfg.Listen, "scheme", scheme, "base_path", cfg.BasePath+"/", "version", version.Version)
+		var err error
+		if srv.TLSConfig != nil {
+			// Certificates are already loaded into TLSConfig
+			err = srv.ServeTLS(ln, "", "")
+		} else {
+			err = srv.Serve(ln)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			serverErr <- err
 		}
 	}()
@@ -70,29 +213,72 @@ func run(ctx context.Context) error {
 	// Wait for context cancellation or server error
 	select {
 	case <-ctx.Done():
-		log.Println("Shutdown signal received")
+		logger.Info("shutdown signal received")
 	case err := <-serverErr:
 		return err
 	}
 
-	// Graceful shutdown with 10s timeout
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// Graceful shutdown bounded by the configured timeout
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout))
 	defer cancel()
 
 	if err := srv.Shutdown(shutdownCtx); err != nil {
 		return err
 	}
+	if mailSrv != nil {
+		if err := mailSrv.Shutdown(shutdownCtx); err != nil {
+			return err
+		}
+	}
 
-	log.Println("Server stopped gracefully")
+	logger.Info("server stopped gracefully")
 	return nil
 }
 
+// newMailServer builds the SMTP/LMTP listener that feeds newsletter
+// ingestion. It offers STARTTLS with the web server's certificate when TLS is
+// configured.
+func newMailServer(cfg config.Config, db *storage.Database, handler *server.Server, tlsConfig *tls.Config, logger *slog.Logger) *smtpd.Server {
+	hostname := cfg.Mail.Hostname
+	if hostname == "" {
+		hostname, _ = os.Hostname()
+	}
+	return &smtpd.Server{
+		Backend:         newsletter.NewMailBackend(db, cfg.Mail.Recipients, cfg.Mail.Protocol, handler.Events()),
+		Hostname:        hostname,
+		LMTP:            cfg.Mail.Protocol == "lmtp",
+		MaxMessageBytes: int64(cfg.Mail.MaxMessageBytes),
+		TLSConfig:       tlsConfig,
+		Logger:          logger,
+	}
+}
+
+// runConfig implements "config print", which shows the effective server
+// configuration after applying the file, environment and flags.
+func runConfig(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintln(stderr, "Usage: blogwatcher-ui config print [serve flags]")
+		return errUsage
+	}
+	cfg, err := loadServerConfig("config", args[1:])
+	if err != nil {
+		return err
+	}
+	return cfg.Print(stdout)
+}
+
 func main() {
 	// Create context with signal handling for SIGINT and SIGTERM
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	if err := run(ctx); err != nil {
-		log.Fatalf("Server error: %v", err)
+	err := dispatch(ctx, os.Args[1:])
+	switch {
+	case err == nil, errors.Is(err, errHelp):
+	case errors.Is(err, errUsage):
+		os.Exit(2)
+	default:
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		os.Exit(1)
 	}
 }
@@ -6,6 +6,8 @@ require (
 	github.com/PuerkitoBio/goquery v1.10.3
 	github.com/mmcdole/gofeed v1.3.0
 	github.com/otiai10/opengraph/v2 v2.2.0
+	golang.org/x/net v0.39.0
+	golang.org/x/text v0.24.0
 	modernc.org/sqlite v1.44.3
 )
 
@@ -21,9 +23,7 @@ require (
 	github.com/ncruces/go-strftime v1.0.0 // indirect
 	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
 	golang.org/x/exp v0.0.0-20251023183803-a4bb9ffd2546 // indirect
-	golang.org/x/net v0.39.0 // indirect
 	golang.org/x/sys v0.37.0 // indirect
-	golang.org/x/text v0.24.0 // indirect
 	modernc.org/libc v1.67.6 // indirect
 	modernc.org/mathutil v1.7.1 // indirect
 	modernc.org/memory v1.11.0 // indirect
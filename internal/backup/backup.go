@@ -0,0 +1,263 @@
+// ABOUTME: Online SQLite backups with VACUUM INTO, kept in a directory with count-based retention.
+// ABOUTME: Also restores a backup over a database file while the server is stopped.
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/metrics"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/storage"
+)
+
+// DefaultKeep is how many backups are kept when no limit is configured.
+const DefaultKeep = 7
+
+const (
+	filePrefix = "blogwatcher-"
+	fileSuffix = ".db"
+	// timeLayout sorts lexically in creation order.
+	timeLayout = "20060102T150405.000Z"
+)
+
+// Backup describes one backup file.
+type Backup struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size_bytes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Manager writes backups of a database into a directory and keeps the newest
+// few.
+type Manager struct {
+	db     *storage.Database
+	dir    string
+	keep   int
+	logger *slog.Logger
+
+	mu sync.Mutex // serialises Create and prune
+}
+
+// NewManager returns a Manager that keeps the newest keep backups of db in
+// dir. dir defaults to a "backups" directory next to the database and keep
+// to DefaultKeep; a nil logger uses slog.Default().
+func NewManager(db *storage.Database, dir string, keep int, logger *slog.Logger) *Manager {
+	if dir == "" {
+		dir = DefaultDir(db.Path())
+	}
+	if keep <= 0 {
+		keep = DefaultKeep
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Manager{db: db, dir: dir, keep: keep, logger: logger}
+}
+
+// DefaultDir returns the backups directory used for the database at dbPath.
+func DefaultDir(dbPath string) string {
+	return filepath.Join(filepath.Dir(dbPath), "backups")
+}
+
+// Dir returns the directory backups are written to.
+func (m *Manager) Dir() string { return m.dir }
+
+// Keep returns how many backups are retained.
+func (m *Manager) Keep() int { return m.keep }
+
+// Create writes a new backup of the live database, then deletes the oldest
+// backups beyond the retention limit.
+func (m *Manager) Create() (Backup, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	start := time.Now()
+	b, err := m.create(start.UTC())
+	if err != nil {
+		metrics.Backups.Inc("failure")
+		m.logger.Error("database backup failed", "dir", m.dir, "err", err)
+		return Backup{}, err
+	}
+	metrics.Backups.Inc("success")
+	m.logger.Info("database backed up", "name", b.Name, "size_bytes", b.Size, "duration", time.Since(start))
+
+	if err := m.prune(); err != nil {
+		m.logger.Warn("pruning old backups", "dir", m.dir, "err", err)
+	}
+	return b, nil
+}
+
+func (m *Manager) create(now time.Time) (Backup, error) {
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return Backup{}, fmt.Errorf("create backup directory: %w", err)
+	}
+	name := filePrefix + now.Format(timeLayout) + fileSuffix
+	path := filepath.Join(m.dir, name)
+	if err := m.db.BackupTo(path); err != nil {
+		_ = os.Remove(path)
+		return Backup{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return Backup{}, err
+	}
+	return Backup{Name: name, Size: info.Size(), CreatedAt: now}, nil
+}
+
+// prune deletes backups beyond the newest m.keep.
+func (m *Manager) prune() error {
+	backups, err := m.List()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, b := range backups[min(m.keep, len(backups)):] {
+		if err := os.Remove(filepath.Join(m.dir, b.Name)); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		m.logger.Debug("removed old backup", "name", b.Name)
+	}
+	return errors.Join(errs...)
+}
+
+// List returns the backups in the directory, newest first. A missing
+// directory has none.
+func (m *Manager) List() ([]Backup, error) {
+	entries, err := os.ReadDir(m.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Backup{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	backups := []Backup{}
+	for _, e := range entries {
+		created, ok := parseName(e.Name())
+		if !ok || !e.Type().IsRegular() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, Backup{Name: e.Name(), Size: info.Size(), CreatedAt: created})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Name > backups[j].Name })
+	return backups, nil
+}
+
+// Path returns the file for the backup called name, or an error if name is
+// not a backup in this directory.
+func (m *Manager) Path(name string) (string, error) {
+	if _, ok := parseName(name); !ok || filepath.Base(name) != name {
+		return "", fmt.Errorf("invalid backup name %q", name)
+	}
+	path := filepath.Join(m.dir, name)
+	if _, err := os.Stat(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// RunEvery creates a backup every interval until ctx is cancelled.
+func (m *Manager) RunEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// Create logs its own failures
+			_, _ = m.Create()
+		}
+	}
+}
+
+// parseName reports whether name is a backup file and when it was taken.
+func parseName(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(timeLayout, strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), fileSuffix))
+	return t, err == nil
+}
+
+// Restore replaces the database at dbPath with the backup at src. The server
+// must not be running. src is checked to be a BlogWatcher database first, and
+// the current database (if any) is kept as dbPath.pre-restore-<time>.bak,
+// whose path is returned. The restored copy is migrated on next open.
+func Restore(src, dbPath string) (previous string, err error) {
+	if err := verify(src); err != nil {
+		return "", fmt.Errorf("%s: %w", src, err)
+	}
+
+	if _, err := os.Stat(dbPath); err == nil {
+		current, err := storage.OpenDatabaseWithOptions(dbPath, storage.OpenOptions{SkipMigrations: true})
+		if err != nil {
+			return "", err
+		}
+		previous = dbPath + ".pre-restore-" + time.Now().UTC().Format("20060102T150405") + ".bak"
+		err = current.BackupTo(previous)
+		current.Close()
+		if err != nil {
+			return "", fmt.Errorf("save current database: %w", err)
+		}
+	}
+
+	// Copy next to the target and rename so a failed copy never leaves a
+	// half-written database behind
+	tmp := dbPath + ".restore-tmp"
+	if err := copyFile(src, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return previous, err
+	}
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(dbPath + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			_ = os.Remove(tmp)
+			return previous, err
+		}
+	}
+	if err := os.Rename(tmp, dbPath); err != nil {
+		_ = os.Remove(tmp)
+		return previous, err
+	}
+	return previous, nil
+}
+
+// verify checks that path holds an intact BlogWatcher schema, reading it
+// without changing the backup file or adding -wal and -shm files beside it.
+func verify(path string) error {
+	return storage.CheckFile(path)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,121 @@
+// ABOUTME: Tests for database backups: retention of the newest copies and restoring a backup.
+// ABOUTME: Uses real SQLite databases in temporary directories.
+package backup
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/model"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/storage"
+)
+
+func openTestDB(t *testing.T) *storage.Database {
+	t.Helper()
+	db, err := storage.OpenDatabase(filepath.Join(t.TempDir(), "blogwatcher.db"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestCreateKeepsNewestBackups(t *testing.T) {
+	db := openTestDB(t)
+	m := NewManager(db, "", 2, nil)
+	if m.Dir() != filepath.Join(filepath.Dir(db.Path()), "backups") {
+		t.Errorf("Dir = %s, want backups next to the database", m.Dir())
+	}
+
+	var names []string
+	for range 3 {
+		b, err := m.Create()
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if b.Size == 0 {
+			t.Errorf("backup %s is empty", b.Name)
+		}
+		names = append(names, b.Name)
+	}
+
+	backups, err := m.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(backups) != 2 || backups[0].Name != names[2] || backups[1].Name != names[1] {
+		t.Errorf("List = %+v, want the two newest of %v", backups, names)
+	}
+
+	if _, err := m.Path(names[2]); err != nil {
+		t.Errorf("Path(%s): %v", names[2], err)
+	}
+	for _, bad := range []string{names[0], "../blogwatcher.db", "notes.txt"} {
+		if _, err := m.Path(bad); err == nil {
+			t.Errorf("Path(%q) should fail", bad)
+		}
+	}
+}
+
+func TestRestoreReplacesDatabase(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := db.AddBlog(model.Blog{Name: "Kept", URL: "https://kept.example.com"}); err != nil {
+		t.Fatalf("add blog: %v", err)
+	}
+	b, err := NewManager(db, "", 0, nil).Create()
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := db.AddBlog(model.Blog{Name: "Later", URL: "https://later.example.com"}); err != nil {
+		t.Fatalf("add blog: %v", err)
+	}
+	db.Close()
+
+	src := filepath.Join(DefaultDir(db.Path()), b.Name)
+	before, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, err := Restore(src, db.Path())
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	// The backup is only read, so it can be restored again or live on read-only media
+	if after, err := os.ReadFile(src); err != nil || !bytes.Equal(after, before) {
+		t.Errorf("backup file changed by Restore (err %v)", err)
+	}
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if _, err := os.Stat(src + suffix); !os.IsNotExist(err) {
+			t.Errorf("Restore left %s next to the backup", filepath.Base(src+suffix))
+		}
+	}
+	if _, err := os.Stat(previous); err != nil {
+		t.Errorf("replaced database not kept: %v", err)
+	}
+
+	restored, err := storage.OpenDatabase(db.Path())
+	if err != nil {
+		t.Fatalf("open restored: %v", err)
+	}
+	defer restored.Close()
+	if n, _ := restored.CountBlogs(); n != 1 {
+		t.Errorf("restored database has %d blogs, want 1", n)
+	}
+}
+
+func TestRestoreRejectsNonDatabase(t *testing.T) {
+	dir := t.TempDir()
+	junk := filepath.Join(dir, "junk.db")
+	if err := os.WriteFile(junk, []byte("not sqlite"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(dir, "blogwatcher.db")
+	if _, err := Restore(junk, target); err == nil {
+		t.Fatal("Restore accepted a file that is not a database")
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Errorf("target should be untouched, stat err = %v", err)
+	}
+}
@@ -0,0 +1,502 @@
+// ABOUTME: Server configuration loaded from defaults, a JSON file, environment variables and flags.
+// ABOUTME: Later sources override earlier ones; the result is validated before the server starts.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/backup"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/fetch"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/logging"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/smtpd"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/storage"
+)
+
+// unixPrefix marks a listen address as a Unix domain socket path.
+const unixPrefix = "unix:"
+
+// Duration is a time.Duration that reads and writes as a string such as "10s".
+type Duration time.Duration
+
+// MarshalJSON encodes d as a duration string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON accepts a duration string such as "90s" or "2m".
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Config holds every setting the server reads at startup.
+type Config struct {
+	// Listen is a TCP address (":8080", "127.0.0.1:8080") or "unix:/path/to.sock".
+	Listen       string `json:"listen"`
+	DatabasePath string `json:"database_path"`
+	BaseURL      string `json:"base_url"`
+	// BasePath serves every route under a URL prefix such as "/reader".
+	// Defaults to the path of BaseURL.
+	BasePath string `json:"base_path"`
+	// TLSCert and TLSKey enable HTTPS when both are set.
+	TLSCert string `json:"tls_cert"`
+	TLSKey  string `json:"tls_key"`
+	// TrustedProxies lists IPs and CIDRs whose X-Forwarded-* headers are
+	// believed. "unix" trusts connections over a Unix socket.
+	TrustedProxies  []string     `json:"trusted_proxies"`
+	ReadTimeout     Duration     `json:"read_timeout"`
+	WriteTimeout    Duration     `json:"write_timeout"`
+	IdleTimeout     Duration     `json:"idle_timeout"`
+	ShutdownTimeout Duration     `json:"shutdown_timeout"`
+	Sync            SyncConfig   `json:"sync"`
+	Backup          BackupConfig `json:"backup"`
+	Mail            MailConfig   `json:"mail"`
+	UserAgent       string       `json:"user_agent"`
+	// LogFormat is "text" or "json"; LogLevel is debug, info, warn or error.
+	LogFormat string `json:"log_format"`
+	LogLevel  string `json:"log_level"`
+}
+
+// SyncConfig controls background syncing.
+type SyncConfig struct {
+	// Interval between automatic syncs; zero disables them.
+	Interval Duration `json:"interval"`
+	// Timeout bounds a single sync of all blogs.
+	Timeout Duration `json:"timeout"`
+}
+
+// BackupConfig controls database backups.
+type BackupConfig struct {
+	// Dir receives backup files. Defaults to "backups" next to the database.
+	Dir string `json:"dir"`
+	// Interval between automatic backups; zero disables them.
+	Interval Duration `json:"interval"`
+	// Keep is how many backups are retained; older ones are deleted.
+	Keep int `json:"keep"`
+}
+
+// MailConfig controls the built-in SMTP/LMTP listener for newsletters.
+type MailConfig struct {
+	// Listen is a TCP address (":2525") or "unix:/path/to.sock"; empty
+	// disables the listener.
+	Listen string `json:"listen"`
+	// Protocol is "smtp" or "lmtp".
+	Protocol string `json:"protocol"`
+	// Hostname is announced to clients. Defaults to the machine's hostname.
+	Hostname string `json:"hostname"`
+	// Recipients lists accepted addresses ("news@example.com") or domains
+	// ("@example.com"), in addition to the inbox address set in Settings.
+	Recipients []string `json:"recipients"`
+	// MaxMessageBytes caps the size of one message.
+	MaxMessageBytes int `json:"max_message_bytes"`
+}
+
+// Network returns the network and address to pass to net.Listen.
+func (m MailConfig) Network() (network, address string) {
+	return splitListen(m.Listen)
+}
+
+// Default returns the built-in configuration.
+func Default() Config {
+	dbPath, _ := storage.DefaultDBPath()
+	return Config{
+		Listen:          ":8080",
+		DatabasePath:    dbPath,
+		ReadTimeout:     Duration(5 * time.Second),
+		WriteTimeout:    Duration(10 * time.Second),
+		IdleTimeout:     Duration(120 * time.Second),
+		ShutdownTimeout: Duration(10 * time.Second),
+		Sync: SyncConfig{
+			Timeout: Duration(3 * time.Minute),
+		},
+		Backup: BackupConfig{
+			Keep: backup.DefaultKeep,
+		},
+		Mail: MailConfig{
+			Protocol:        "smtp",
+			MaxMessageBytes: smtpd.DefaultMaxMessageBytes,
+		},
+		UserAgent: fetch.DefaultUserAgent,
+		LogFormat: logging.FormatText,
+		LogLevel:  "info",
+	}
+}
+
+// DefaultPath returns ~/.blogwatcher/config.json, which is read when present
+// and no other file is given.
+func DefaultPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".blogwatcher", "config.json")
+}
+
+// IsUnix reports whether Listen names a Unix domain socket.
+func (c Config) IsUnix() bool {
+	return strings.HasPrefix(c.Listen, unixPrefix)
+}
+
+// TLSEnabled reports whether the server should serve HTTPS.
+func (c Config) TLSEnabled() bool {
+	return c.TLSCert != "" && c.TLSKey != ""
+}
+
+// Network returns the network and address to pass to net.Listen.
+func (c Config) Network() (network, address string) {
+	return splitListen(c.Listen)
+}
+
+// splitListen turns a listen setting into a network and address.
+func splitListen(listen string) (network, address string) {
+	if strings.HasPrefix(listen, unixPrefix) {
+		return "unix", strings.TrimPrefix(listen, unixPrefix)
+	}
+	return "tcp", listen
+}
+
+// validateListen checks a TCP host:port or unix:/path listen setting.
+func validateListen(name, listen string) error {
+	if strings.HasPrefix(listen, unixPrefix) {
+		if strings.TrimPrefix(listen, unixPrefix) == "" {
+			return fmt.Errorf("%s: unix socket path is empty", name)
+		}
+		return nil
+	}
+	_, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return fmt.Errorf("%s: %q is not host:port or unix:/path (%v)", name, listen, err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("%s: invalid port %q", name, port)
+	}
+	return nil
+}
+
+// Validate reports every invalid setting at once.
+func (c Config) Validate() error {
+	var errs []error
+
+	if err := validateListen("listen", c.Listen); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.DatabasePath == "" {
+		errs = append(errs, errors.New("database_path: must be set"))
+	}
+
+	if c.BaseURL != "" {
+		u, err := url.Parse(c.BaseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("base_url: %q must be an absolute http(s) URL", c.BaseURL))
+		}
+	}
+
+	if c.BasePath != "" && (!strings.HasPrefix(c.BasePath, "/") || strings.ContainsAny(c.BasePath, "?#")) {
+		errs = append(errs, fmt.Errorf("base_path: %q must be a path starting with /", c.BasePath))
+	}
+
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		errs = append(errs, errors.New("tls_cert and tls_key: both must be set to enable TLS"))
+	}
+
+	for _, p := range c.TrustedProxies {
+		if p == "unix" {
+			continue
+		}
+		if _, err := netip.ParsePrefix(p); err == nil {
+			continue
+		}
+		if _, err := netip.ParseAddr(p); err != nil {
+			errs = append(errs, fmt.Errorf("trusted_proxies: %q is not an IP address or CIDR", p))
+		}
+	}
+
+	for _, d := range []struct {
+		name string
+		v    Duration
+	}{
+		{"read_timeout", c.ReadTimeout},
+		{"write_timeout", c.WriteTimeout},
+		{"idle_timeout", c.IdleTimeout},
+		{"shutdown_timeout", c.ShutdownTimeout},
+		{"sync.timeout", c.Sync.Timeout},
+	} {
+		if d.v <= 0 {
+			errs = append(errs, fmt.Errorf("%s: must be positive, got %s", d.name, time.Duration(d.v)))
+		}
+	}
+	if c.Sync.Interval < 0 {
+		errs = append(errs, fmt.Errorf("sync.interval: must not be negative, got %s", time.Duration(c.Sync.Interval)))
+	} else if c.Sync.Interval > 0 && c.Sync.Interval < Duration(time.Minute) {
+		errs = append(errs, fmt.Errorf("sync.interval: must be at least 1m or 0 to disable, got %s", time.Duration(c.Sync.Interval)))
+	}
+
+	if c.Backup.Interval < 0 {
+		errs = append(errs, fmt.Errorf("backup.interval: must not be negative, got %s", time.Duration(c.Backup.Interval)))
+	} else if c.Backup.Interval > 0 && c.Backup.Interval < Duration(time.Minute) {
+		errs = append(errs, fmt.Errorf("backup.interval: must be at least 1m or 0 to disable, got %s", time.Duration(c.Backup.Interval)))
+	}
+	if c.Backup.Keep < 1 {
+		errs = append(errs, fmt.Errorf("backup.keep: must be at least 1, got %d", c.Backup.Keep))
+	}
+
+	if c.Mail.Listen != "" {
+		if err := validateListen("mail.listen", c.Mail.Listen); err != nil {
+			errs = append(errs, err)
+		}
+		if c.Mail.Protocol != "smtp" && c.Mail.Protocol != "lmtp" {
+			errs = append(errs, fmt.Errorf("mail.protocol: must be smtp or lmtp, got %q", c.Mail.Protocol))
+		}
+		if c.Mail.MaxMessageBytes < 1 {
+			errs = append(errs, fmt.Errorf("mail.max_message_bytes: must be positive, got %d", c.Mail.MaxMessageBytes))
+		}
+		for _, r := range c.Mail.Recipients {
+			if local, domain, ok := strings.Cut(r, "@"); !ok || domain == "" || strings.ContainsAny(local+domain, "@ <>") {
+				errs = append(errs, fmt.Errorf("mail.recipients: %q is not an address or @domain", r))
+			}
+		}
+	}
+
+	if strings.TrimSpace(c.UserAgent) == "" {
+		errs = append(errs, errors.New("user_agent: must not be empty"))
+	}
+
+	if _, err := logging.New(io.Discard, c.LogFormat, c.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("log_format/log_level: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Print writes the configuration as indented JSON.
+func (c Config) Print(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(c)
+}
+
+// setting maps one config field to its flag and environment variable.
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	get   func(Config) string
+	set   func(*Config, string) error
+}
+
+func stringSetting(flagName, env, usage string, field func(*Config) *string) setting {
+	return setting{
+		flag:  flagName,
+		env:   env,
+		usage: usage,
+		get:   func(c Config) string { return *field(&c) },
+		set:   func(c *Config, v string) error { *field(c) = v; return nil },
+	}
+}
+
+func durationSetting(flagName, env, usage string, field func(*Config) *Duration) setting {
+	return setting{
+		flag:  flagName,
+		env:   env,
+		usage: usage,
+		get:   func(c Config) string { return time.Duration(*field(&c)).String() },
+		set: func(c *Config, v string) error {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return err
+			}
+			*field(c) = Duration(d)
+			return nil
+		},
+	}
+}
+
+func intSetting(flagName, env, usage string, field func(*Config) *int) setting {
+	return setting{
+		flag:  flagName,
+		env:   env,
+		usage: usage,
+		get:   func(c Config) string { return strconv.Itoa(*field(&c)) },
+		set: func(c *Config, v string) error {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return err
+			}
+			*field(c) = n
+			return nil
+		},
+	}
+}
+
+func listSetting(flagName, env, usage string, field func(*Config) *[]string) setting {
+	return setting{
+		flag:  flagName,
+		env:   env,
+		usage: usage,
+		get:   func(c Config) string { return strings.Join(*field(&c), ",") },
+		set: func(c *Config, v string) error {
+			var list []string
+			for _, item := range strings.Split(v, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					list = append(list, item)
+				}
+			}
+			*field(c) = list
+			return nil
+		},
+	}
+}
+
+// settings lists every field that can be overridden by flag or environment.
+// The database path flag (-db) is registered by the command itself.
+var settings = []setting{
+	stringSetting("listen", "BLOGWATCHER_LISTEN", "listen address: host:port or unix:/path/to.sock", func(c *Config) *string { return &c.Listen }),
+	stringSetting("db", "BLOGWATCHER_DB", "database path", func(c *Config) *string { return &c.DatabasePath }),
+	stringSetting("base-url", "BLOGWATCHER_BASE_URL", "public URL of this server, used in feeds and digests", func(c *Config) *string { return &c.BaseURL }),
+	stringSetting("base-path", "BLOGWATCHER_BASE_PATH", "URL prefix to serve under, e.g. /reader (default: path of -base-url)", func(c *Config) *string { return &c.BasePath }),
+	stringSetting("tls-cert", "BLOGWATCHER_TLS_CERT", "TLS certificate file; serves HTTPS with -tls-key", func(c *Config) *string { return &c.TLSCert }),
+	stringSetting("tls-key", "BLOGWATCHER_TLS_KEY", "TLS private key file", func(c *Config) *string { return &c.TLSKey }),
+	listSetting("trusted-proxies", "BLOGWATCHER_TRUSTED_PROXIES", "comma-separated IPs/CIDRs allowed to set X-Forwarded-* headers (\"unix\" for socket peers)", func(c *Config) *[]string { return &c.TrustedProxies }),
+	durationSetting("read-timeout", "BLOGWATCHER_READ_TIMEOUT", "HTTP read timeout", func(c *Config) *Duration { return &c.ReadTimeout }),
+	durationSetting("write-timeout", "BLOGWATCHER_WRITE_TIMEOUT", "HTTP write timeout", func(c *Config) *Duration { return &c.WriteTimeout }),
+	durationSetting("idle-timeout", "BLOGWATCHER_IDLE_TIMEOUT", "HTTP keep-alive idle timeout", func(c *Config) *Duration { return &c.IdleTimeout }),
+	durationSetting("shutdown-timeout", "BLOGWATCHER_SHUTDOWN_TIMEOUT", "time allowed for graceful shutdown", func(c *Config) *Duration { return &c.ShutdownTimeout }),
+	durationSetting("sync-interval", "BLOGWATCHER_SYNC_INTERVAL", "automatic sync interval (0 disables)", func(c *Config) *Duration { return &c.Sync.Interval }),
+	durationSetting("sync-timeout", "BLOGWATCHER_SYNC_TIMEOUT", "time limit for one sync of all blogs", func(c *Config) *Duration { return &c.Sync.Timeout }),
+	stringSetting("backup-dir", "BLOGWATCHER_BACKUP_DIR", "directory for database backups (default: backups next to the database)", func(c *Config) *string { return &c.Backup.Dir }),
+	durationSetting("backup-interval", "BLOGWATCHER_BACKUP_INTERVAL", "automatic backup interval (0 disables)", func(c *Config) *Duration { return &c.Backup.Interval }),
+	intSetting("backup-keep", "BLOGWATCHER_BACKUP_KEEP", "number of backups to keep", func(c *Config) *int { return &c.Backup.Keep }),
+	stringSetting("mail-listen", "BLOGWATCHER_MAIL_LISTEN", "SMTP/LMTP listen address for newsletters: host:port or unix:/path (empty disables)", func(c *Config) *string { return &c.Mail.Listen }),
+	stringSetting("mail-protocol", "BLOGWATCHER_MAIL_PROTOCOL", "mail listener protocol: smtp or lmtp", func(c *Config) *string { return &c.Mail.Protocol }),
+	stringSetting("mail-hostname", "BLOGWATCHER_MAIL_HOSTNAME", "host name the mail listener announces (default: machine host name)", func(c *Config) *string { return &c.Mail.Hostname }),
+	listSetting("mail-recipients", "BLOGWATCHER_MAIL_RECIPIENTS", "comma-separated addresses or @domains the mail listener accepts, besides the inbox address in Settings", func(c *Config) *[]string { return &c.Mail.Recipients }),
+	intSetting("mail-max-message-bytes", "BLOGWATCHER_MAIL_MAX_MESSAGE_BYTES", "largest message the mail listener accepts", func(c *Config) *int { return &c.Mail.MaxMessageBytes }),
+	stringSetting("log-format", "BLOGWATCHER_LOG_FORMAT", "log output format: text or json", func(c *Config) *string { return &c.LogFormat }),
+	stringSetting("log-level", "BLOGWATCHER_LOG_LEVEL", "minimum log level: debug, info, warn or error", func(c *Config) *string { return &c.LogLevel }),
+	stringSetting("user-agent", "BLOGWATCHER_USER_AGENT", "User-Agent sent when fetching feeds and pages", func(c *Config) *string { return &c.UserAgent }),
+}
+
+// RegisterFlags adds -config and one flag per setting to fs. Flags are only
+// applied by Load when set explicitly, so their defaults never mask the file
+// or environment. Skips flags fs already defines (such as -db).
+func RegisterFlags(fs *flag.FlagSet) {
+	if fs.Lookup("config") == nil {
+		RegisterConfigFlag(fs)
+	}
+	def := Default()
+	for _, s := range settings {
+		if fs.Lookup(s.flag) != nil {
+			continue
+		}
+		fs.String(s.flag, s.get(def), s.usage+" (env "+s.env+")")
+	}
+}
+
+// RegisterConfigFlag adds only the -config flag, for commands that need the
+// database path and User-Agent but none of the server settings.
+func RegisterConfigFlag(fs *flag.FlagSet) {
+	fs.String("config", "", "config file (default "+DefaultPath()+" if it exists; env BLOGWATCHER_CONFIG)")
+}
+
+// Load builds the effective configuration: defaults, then the config file,
+// then environment variables, then flags explicitly set on fs. getenv is
+// usually os.Getenv.
+func Load(fs *flag.FlagSet, getenv func(string) string) (Config, error) {
+	cfg := Default()
+
+	path, explicit := getenv("BLOGWATCHER_CONFIG"), false
+	if path != "" {
+		explicit = true
+	}
+	if f := fs.Lookup("config"); f != nil && f.Value.String() != "" {
+		path, explicit = f.Value.String(), true
+	}
+	if path == "" {
+		path = DefaultPath()
+	}
+	if path != "" {
+		if err := loadFile(&cfg, path); err != nil {
+			if explicit || !errors.Is(err, os.ErrNotExist) {
+				return cfg, err
+			}
+		}
+	}
+
+	// PORT predates the config layer; honour it unless BLOGWATCHER_LISTEN is set.
+	if port := getenv("PORT"); port != "" {
+		cfg.Listen = ":" + port
+	}
+	for _, s := range settings {
+		if v := getenv(s.env); v != "" {
+			if err := s.set(&cfg, v); err != nil {
+				return cfg, fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name && flagErr == nil {
+				if err := s.set(&cfg, f.Value.String()); err != nil {
+					flagErr = fmt.Errorf("-%s: %w", f.Name, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return cfg, flagErr
+	}
+
+	if cfg.DatabasePath == "" {
+		cfg.DatabasePath = Default().DatabasePath
+	}
+	if cfg.Backup.Dir == "" {
+		cfg.Backup.Dir = backup.DefaultDir(cfg.DatabasePath)
+	}
+	if cfg.BasePath == "" && cfg.BaseURL != "" {
+		if u, err := url.Parse(cfg.BaseURL); err == nil {
+			cfg.BasePath = u.Path
+		}
+	}
+	cfg.BasePath = strings.TrimRight(cfg.BasePath, "/")
+	return cfg, nil
+}
+
+// loadFile overlays the JSON file at path onto cfg. Unknown keys are errors so
+// typos are not silently ignored.
+func loadFile(cfg *Config, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,292 @@
+// ABOUTME: Tests for configuration loading and validation.
+// ABOUTME: Covers file < env < flag precedence, PORT compatibility, Unix sockets and error reporting.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	return path
+}
+
+func newTestFlagSet(t *testing.T, args ...string) *flag.FlagSet {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	RegisterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatalf("parse flags: %v", err)
+	}
+	return fs
+}
+
+func envMap(m map[string]string) func(string) string {
+	return func(k string) string { return m[k] }
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfigFile(t, `{
+		"listen": ":9000",
+		"database_path": "/tmp/file.db",
+		"read_timeout": "7s",
+		"sync": {"interval": "30m"}
+	}`)
+
+	fs := newTestFlagSet(t, "-config", path, "-read-timeout", "9s")
+	cfg, err := Load(fs, envMap(map[string]string{
+		"BLOGWATCHER_LISTEN": ":9100",
+		"BLOGWATCHER_DB":     "/tmp/env.db",
+	}))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if cfg.Listen != ":9100" {
+		t.Errorf("Listen = %q, want env value :9100", cfg.Listen)
+	}
+	if cfg.DatabasePath != "/tmp/env.db" {
+		t.Errorf("DatabasePath = %q, want env value", cfg.DatabasePath)
+	}
+	if time.Duration(cfg.ReadTimeout) != 9*time.Second {
+		t.Errorf("ReadTimeout = %s, want flag value 9s", time.Duration(cfg.ReadTimeout))
+	}
+	if time.Duration(cfg.Sync.Interval) != 30*time.Minute {
+		t.Errorf("Sync.Interval = %s, want file value 30m", time.Duration(cfg.Sync.Interval))
+	}
+	if time.Duration(cfg.WriteTimeout) != 10*time.Second {
+		t.Errorf("WriteTimeout = %s, want default 10s", time.Duration(cfg.WriteTimeout))
+	}
+}
+
+func TestLoadMissingExplicitFile(t *testing.T) {
+	fs := newTestFlagSet(t)
+	_, err := Load(fs, envMap(map[string]string{
+		"BLOGWATCHER_CONFIG": filepath.Join(t.TempDir(), "missing.json"),
+	}))
+	if err == nil {
+		t.Fatal("expected error for missing explicit config file")
+	}
+}
+
+func TestLoadUnsetFlagsDoNotOverrideEnv(t *testing.T) {
+	fs := newTestFlagSet(t)
+	cfg, err := Load(fs, envMap(map[string]string{
+		"BLOGWATCHER_CONFIG":       writeConfigFile(t, `{}`),
+		"BLOGWATCHER_IDLE_TIMEOUT": "1m",
+	}))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if time.Duration(cfg.IdleTimeout) != time.Minute {
+		t.Errorf("IdleTimeout = %s, want env value 1m", time.Duration(cfg.IdleTimeout))
+	}
+}
+
+func TestLoadPortCompatibility(t *testing.T) {
+	fs := newTestFlagSet(t, "-config", writeConfigFile(t, `{}`))
+
+	cfg, err := Load(fs, envMap(map[string]string{"PORT": "3000"}))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Listen != ":3000" {
+		t.Errorf("Listen = %q, want :3000 from PORT", cfg.Listen)
+	}
+
+	cfg, err = Load(fs, envMap(map[string]string{"PORT": "3000", "BLOGWATCHER_LISTEN": "127.0.0.1:4000"}))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Listen != "127.0.0.1:4000" {
+		t.Errorf("Listen = %q, want BLOGWATCHER_LISTEN to win over PORT", cfg.Listen)
+	}
+}
+
+func TestLoadRejectsUnknownKeys(t *testing.T) {
+	fs := newTestFlagSet(t, "-config", writeConfigFile(t, `{"lisen": ":8080"}`))
+	_, err := Load(fs, envMap(nil))
+	if err == nil || !strings.Contains(err.Error(), "lisen") {
+		t.Fatalf("Load error = %v, want unknown field error", err)
+	}
+}
+
+func TestLoadRejectsBadEnvDuration(t *testing.T) {
+	fs := newTestFlagSet(t, "-config", writeConfigFile(t, `{}`))
+	_, err := Load(fs, envMap(map[string]string{"BLOGWATCHER_SYNC_TIMEOUT": "soon"}))
+	if err == nil || !strings.Contains(err.Error(), "BLOGWATCHER_SYNC_TIMEOUT") {
+		t.Fatalf("Load error = %v, want error naming the variable", err)
+	}
+}
+
+func TestValidateReportsAllErrors(t *testing.T) {
+	cfg := Default()
+	cfg.Listen = "8080"
+	cfg.BaseURL = "example.com"
+	cfg.ReadTimeout = 0
+	cfg.Sync.Interval = Duration(10 * time.Second)
+	cfg.Backup.Keep = 0
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+	for _, want := range []string{"listen", "base_url", "read_timeout", "sync.interval", "backup.keep"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+
+	if err := Default().Validate(); err != nil {
+		t.Errorf("default config invalid: %v", err)
+	}
+}
+
+func TestLoadBackupSettings(t *testing.T) {
+	fs := newTestFlagSet(t, "-config", writeConfigFile(t, `{"database_path": "/data/bw.db"}`))
+	cfg, err := Load(fs, envMap(map[string]string{"BLOGWATCHER_BACKUP_KEEP": "3"}))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Backup.Dir != "/data/backups" || cfg.Backup.Keep != 3 {
+		t.Errorf("Backup = %+v, want dir next to the database and keep 3", cfg.Backup)
+	}
+
+	fs = newTestFlagSet(t, "-config", writeConfigFile(t, `{}`))
+	if _, err := Load(fs, envMap(map[string]string{"BLOGWATCHER_BACKUP_KEEP": "many"})); err == nil {
+		t.Error("expected error for non-numeric BLOGWATCHER_BACKUP_KEEP")
+	}
+}
+
+func TestUnixListen(t *testing.T) {
+	cfg := Default()
+	cfg.Listen = "unix:/run/blogwatcher.sock"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	network, address := cfg.Network()
+	if network != "unix" || address != "/run/blogwatcher.sock" {
+		t.Errorf("Network = %q %q", network, address)
+	}
+
+	cfg.Listen = "unix:"
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for empty socket path")
+	}
+}
+
+func TestPrintRoundTrips(t *testing.T) {
+	cfg := Default()
+	cfg.Sync.Interval = Duration(15 * time.Minute)
+
+	var buf bytes.Buffer
+	if err := cfg.Print(&buf); err != nil {
+		t.Fatalf("Print: %v", err)
+	}
+	if !strings.Contains(buf.String(), `"interval": "15m0s"`) {
+		t.Errorf("Print output missing duration string:\n%s", buf.String())
+	}
+
+	var back Config
+	if err := json.Unmarshal(buf.Bytes(), &back); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if !reflect.DeepEqual(back, cfg) {
+		t.Errorf("round trip = %+v, want %+v", back, cfg)
+	}
+}
+
+func TestLoadBasePath(t *testing.T) {
+	fs := newTestFlagSet(t, "-config", writeConfigFile(t, `{"base_url": "https://example.com/reader/"}`))
+	cfg, err := Load(fs, envMap(nil))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.BasePath != "/reader" {
+		t.Errorf("BasePath = %q, want /reader derived from base_url", cfg.BasePath)
+	}
+
+	cfg, err = Load(fs, envMap(map[string]string{"BLOGWATCHER_BASE_PATH": "/news/"}))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.BasePath != "/news" {
+		t.Errorf("BasePath = %q, want explicit /news with trailing slash trimmed", cfg.BasePath)
+	}
+}
+
+func TestLoadTrustedProxiesList(t *testing.T) {
+	fs := newTestFlagSet(t, "-config", writeConfigFile(t, `{}`), "-trusted-proxies", "10.0.0.0/8, 127.0.0.1,unix")
+	cfg, err := Load(fs, envMap(nil))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	want := []string{"10.0.0.0/8", "127.0.0.1", "unix"}
+	if !reflect.DeepEqual(cfg.TrustedProxies, want) {
+		t.Errorf("TrustedProxies = %q, want %q", cfg.TrustedProxies, want)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+}
+
+func TestValidateTLSAndProxies(t *testing.T) {
+	cfg := Default()
+	cfg.TLSCert = "cert.pem"
+	cfg.TrustedProxies = []string{"proxy.local"}
+	cfg.BasePath = "reader"
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+	for _, want := range []string{"tls_cert", "trusted_proxies", "base_path"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}
+
+func TestLoadMailSettings(t *testing.T) {
+	fs := newTestFlagSet(t, "-config", writeConfigFile(t, `{"mail": {"listen": "unix:/run/bw-lmtp.sock", "protocol": "lmtp"}}`), "-mail-recipients", "news@example.com,@lists.example.com")
+	cfg, err := Load(fs, envMap(nil))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	network, address := cfg.Mail.Network()
+	if network != "unix" || address != "/run/bw-lmtp.sock" || cfg.Mail.Protocol != "lmtp" {
+		t.Errorf("Mail = %+v, network %q %q", cfg.Mail, network, address)
+	}
+	if len(cfg.Mail.Recipients) != 2 || cfg.Mail.Recipients[1] != "@lists.example.com" {
+		t.Errorf("Recipients = %q", cfg.Mail.Recipients)
+	}
+
+	cfg.Mail.Listen = "2525"
+	cfg.Mail.Protocol = "pop3"
+	cfg.Mail.MaxMessageBytes = 0
+	cfg.Mail.Recipients = []string{"not an address"}
+	err = cfg.Validate()
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+	for _, want := range []string{"mail.listen", "mail.protocol", "mail.max_message_bytes", "mail.recipients"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+// ABOUTME: Builds and sends email digests of articles discovered since the previous digest.
+// ABOUTME: Renders HTML and plain-text bodies grouped by blog; settings live in the settings table.
+package digest
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/model"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/storage"
+)
+
+// Schedule values for the digest_schedule setting.
+const (
+	ScheduleOff    = "off"
+	ScheduleDaily  = "daily"
+	ScheduleWeekly = "weekly"
+)
+
+// Setting keys used to persist digest configuration.
+const (
+	keySchedule     = "digest_schedule"
+	keyRecipients   = "digest_recipients"
+	keyFrom         = "digest_from"
+	keySMTPHost     = "digest_smtp_host"
+	keySMTPPort     = "digest_smtp_port"
+	keySMTPUsername = "digest_smtp_username"
+	keySMTPPassword = "digest_smtp_password"
+	keyLastSent     = "digest_last_sent"
+)
+
+// Settings holds the digest schedule, recipients and SMTP configuration.
+type Settings struct {
+	Schedule     string   // ScheduleOff, ScheduleDaily or ScheduleWeekly
+	Recipients   []string // email addresses
+	From         string
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	LastSent     *time.Time // nil when no digest has been sent yet
+}
+
+// Period returns the interval between digests for the schedule, or 0 when off.
+func (s Settings) Period() time.Duration {
+	switch s.Schedule {
+	case ScheduleDaily:
+		return 24 * time.Hour
+	case ScheduleWeekly:
+		return 7 * 24 * time.Hour
+	}
+	return 0
+}
+
+// Due reports whether a scheduled digest should be sent at now.
+func (s Settings) Due(now time.Time) bool {
+	period := s.Period()
+	if period == 0 || len(s.Recipients) == 0 || s.SMTPHost == "" {
+		return false
+	}
+	return s.LastSent == nil || !now.Before(s.LastSent.Add(period))
+}
+
+// Since returns the start of the window for the next digest: the last send
+// time, or one schedule period (default one day) before now.
+func (s Settings) Since(now time.Time) time.Time {
+	if s.LastSent != nil {
+		return *s.LastSent
+	}
+	period := s.Period()
+	if period == 0 {
+		period = 24 * time.Hour
+	}
+	return now.Add(-period)
+}
+
+// LoadSettings reads digest settings from the database.
+// Missing keys yield zero values and the "off" schedule.
+func LoadSettings(db *storage.Database) (Settings, error) {
+	get := func(key string) (string, error) {
+		value, err := db.GetSetting(key)
+		if err != nil {
+			return "", fmt.Errorf("read %s: %w", key, err)
+		}
+		return value, nil
+	}
+
+	var s Settings
+	var err error
+	if s.Schedule, err = get(keySchedule); err != nil {
+		return s, err
+	}
+	if s.Schedule == "" {
+		s.Schedule = ScheduleOff
+	}
+	recipients, err := get(keyRecipients)
+	if err != nil {
+		return s, err
+	}
+	s.Recipients = ParseRecipients(recipients)
+	if s.From, err = get(keyFrom); err != nil {
+		return s, err
+	}
+	if s.SMTPHost, err = get(keySMTPHost); err != nil {
+		return s, err
+	}
+	port, err := get(keySMTPPort)
+	if err != nil {
+		return s, err
+	}
+	s.SMTPPort, _ = strconv.Atoi(port)
+	if s.SMTPUsername, err = get(keySMTPUsername); err != nil {
+		return s, err
+	}
+	if s.SMTPPassword, err = get(keySMTPPassword); err != nil {
+		return s, err
+	}
+	lastSent, err := get(keyLastSent)
+	if err != nil {
+		return s, err
+	}
+	if t, err := time.Parse(time.RFC3339Nano, lastSent); err == nil {
+		s.LastSent = &t
+	}
+	return s, nil
+}
+
+// SaveSettings persists the digest configuration. LastSent is not written;
+// use MarkSent after a digest goes out.
+func SaveSettings(db *storage.Database, s Settings) error {
+	port := ""
+	if s.SMTPPort > 0 {
+		port = strconv.Itoa(s.SMTPPort)
+	}
+	values := map[string]string{
+		keySchedule:     s.Schedule,
+		keyRecipients:   strings.Join(s.Recipients, ", "),
+		keyFrom:         s.From,
+		keySMTPHost:     s.SMTPHost,
+		keySMTPPort:     port,
+		keySMTPUsername: s.SMTPUsername,
+		keySMTPPassword: s.SMTPPassword,
+	}
+	for key, value := range values {
+		if err := db.SetSetting(key, value); err != nil {
+			return fmt.Errorf("save %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// MarkSent records t as the time of the most recent digest.
+func MarkSent(db *storage.Database, t time.Time) error {
+	return db.SetSetting(keyLastSent, t.UTC().Format(time.RFC3339Nano))
+}
+
+// ParseRecipients splits a comma, semicolon or whitespace separated address list.
+func ParseRecipients(value string) []string {
+	fields := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' ' || r == '\n' || r == '\t' || r == '\r'
+	})
+	var recipients []string
+	for _, f := range fields {
+		if f = strings.TrimSpace(f); f != "" {
+			recipients = append(recipients, f)
+		}
+	}
+	return recipients
+}
+
+// Digest is the set of articles discovered in a time window, grouped by blog.
+type Digest struct {
+	Since   time.Time
+	Until   time.Time
+	Groups  []Group
+	Total   int
+	BaseURL string // absolute app URL used to link newsletter articles
+}
+
+// Group holds the digest articles for a single blog.
+type Group struct {
+	BlogID   int64
+	BlogName string
+	BlogURL  string
+	Articles []Entry
+}
+
+// Entry is a single article line in a digest.
+type Entry struct {
+	Title     string
+	URL       string
+	Published *time.Time
+}
+
+// Build collects the articles discovered in (since, until] from the database.
+func Build(db *storage.Database, since, until time.Time, baseURL string) (Digest, error) {
+	articles, err := db.ListArticlesDiscoveredSince(since, until)
+	if err != nil {
+		return Digest{}, fmt.Errorf("list articles: %w", err)
+	}
+	d := Digest{Since: since, Until: until, BaseURL: baseURL}
+	for _, a := range articles {
+		if len(d.Groups) == 0 || d.Groups[len(d.Groups)-1].BlogID != a.BlogID {
+			d.Groups = append(d.Groups, Group{BlogID: a.BlogID, BlogName: a.BlogName, BlogURL: a.BlogURL})
+		}
+		group := &d.Groups[len(d.Groups)-1]
+		group.Articles = append(group.Articles, Entry{
+			Title:     a.Title,
+			URL:       articleURL(baseURL, a),
+			Published: a.PublishedDate,
+		})
+		d.Total++
+	}
+	return d, nil
+}
+
+// articleURL returns the link for an article, pointing newsletters at the app.
+func articleURL(baseURL string, a model.ArticleWithBlog) string {
+	if strings.HasPrefix(a.URL, "message:") {
+		return fmt.Sprintf("%s/newsletter/article/%d", baseURL, a.ID)
+	}
+	return a.URL
+}
+
+// Subject returns the email subject line for the digest.
+func (d Digest) Subject() string {
+	noun := "articles"
+	if d.Total == 1 {
+		noun = "article"
+	}
+	return fmt.Sprintf("BlogWatcher digest: %d new %s", d.Total, noun)
+}
+
+var htmlTemplate = htmltemplate.Must(htmltemplate.New("digest").Funcs(htmltemplate.FuncMap{
+	"date": formatDate,
+}).Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', sans-serif; color: #1f1f1f; max-width: 640px; margin: 0 auto; padding: 16px;">
+<h1 style="font-size: 20px;">{{.Subject}}</h1>
+<p style="color: #666; font-size: 13px;">Discovered {{date .Since}} &ndash; {{date .Until}}</p>
+{{range .Groups}}
+<h2 style="font-size: 16px; margin-top: 24px; border-bottom: 1px solid #ddd; padding-bottom: 4px;">{{.BlogName}}</h2>
+<ul style="padding-left: 18px;">
+{{range .Articles}}<li style="margin-bottom: 6px;"><a href="{{.URL}}">{{.Title}}</a>{{if .Published}} <span style="color: #888; font-size: 12px;">{{date .Published}}</span>{{end}}</li>
+{{end}}</ul>
+{{else}}
+<p>No new articles.</p>
+{{end}}
+</body>
+</html>
+`))
+
+var textTemplate = texttemplate.Must(texttemplate.New("digest").Funcs(texttemplate.FuncMap{
+	"date": formatDate,
+}).Parse(`{{.Subject}}
+Discovered {{date .Since}} - {{date .Until}}
+{{range .Groups}}
+== {{.BlogName}} ==
+{{range .Articles}}
+* {{.Title}}{{if .Published}} ({{date .Published}}){{end}}
+  {{.URL}}
+{{end}}{{else}}
+No new articles.
+{{end}}`))
+
+// RenderHTML renders the HTML email body.
+func (d Digest) RenderHTML() (string, error) {
+	buf := &bytes.Buffer{}
+	if err := htmlTemplate.Execute(buf, d); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// RenderText renders the plain-text email body.
+func (d Digest) RenderText() (string, error) {
+	buf := &bytes.Buffer{}
+	if err := textTemplate.Execute(buf, d); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// formatDate accepts time.Time or *time.Time so templates can use either.
+func formatDate(v any) string {
+	switch t := v.(type) {
+	case time.Time:
+		return t.Format("Jan 2, 2006")
+	case *time.Time:
+		if t != nil {
+			return t.Format("Jan 2, 2006")
+		}
+	}
+	return ""
+}
@@ -0,0 +1,227 @@
+// ABOUTME: Tests for digest building, scheduling and message rendering.
+// ABOUTME: Uses a real temporary SQLite database; SMTP delivery itself is not exercised.
+package digest
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/model"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/storage"
+)
+
+func openTestDB(t *testing.T) *storage.Database {
+	t.Helper()
+	db, err := storage.OpenDatabase(filepath.Join(t.TempDir(), "bw.db"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestBuildGroupsArticlesDiscoveredInWindow(t *testing.T) {
+	db := openTestDB(t)
+
+	alpha, err := db.AddBlog(model.Blog{Name: "Alpha", URL: "https://alpha.example.com"})
+	if err != nil {
+		t.Fatalf("add blog: %v", err)
+	}
+	beta, err := db.AddBlog(model.Blog{Name: "Beta", URL: "https://beta.example.com"})
+	if err != nil {
+		t.Fatalf("add blog: %v", err)
+	}
+
+	now := time.Now()
+	old := now.Add(-48 * time.Hour)
+	recent := now.Add(-2 * time.Hour)
+	_, err = db.AddArticlesBulk([]model.Article{
+		{BlogID: beta.ID, Title: "Beta New", URL: "https://beta.example.com/new", DiscoveredDate: &recent},
+		{BlogID: alpha.ID, Title: "Alpha New", URL: "https://alpha.example.com/new", DiscoveredDate: &recent},
+		{BlogID: alpha.ID, Title: "Alpha Old", URL: "https://alpha.example.com/old", DiscoveredDate: &old},
+		{BlogID: alpha.ID, Title: "Alpha Letter", URL: "message:<letter@alpha>", DiscoveredDate: &recent},
+	})
+	if err != nil {
+		t.Fatalf("add articles: %v", err)
+	}
+
+	d, err := Build(db, now.Add(-24*time.Hour), now, "https://reader.example.com")
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if d.Total != 3 {
+		t.Fatalf("Total = %d, want 3", d.Total)
+	}
+	if len(d.Groups) != 2 || d.Groups[0].BlogName != "Alpha" || d.Groups[1].BlogName != "Beta" {
+		t.Fatalf("groups = %+v, want Alpha then Beta", d.Groups)
+	}
+	for _, e := range d.Groups[0].Articles {
+		if e.Title == "Alpha Old" {
+			t.Error("article discovered before the window must be excluded")
+		}
+		if e.Title == "Alpha Letter" && !strings.HasPrefix(e.URL, "https://reader.example.com/newsletter/article/") {
+			t.Errorf("newsletter URL = %q, want in-app link", e.URL)
+		}
+	}
+}
+
+func TestBuildKeepsSameNamedBlogsApart(t *testing.T) {
+	db := openTestDB(t)
+
+	var ids []int64
+	for _, url := range []string{"https://one.example.com", "https://two.example.com"} {
+		blog, err := db.AddBlog(model.Blog{Name: "Weekly", URL: url})
+		if err != nil {
+			t.Fatalf("add blog: %v", err)
+		}
+		ids = append(ids, blog.ID)
+	}
+
+	now := time.Now()
+	recent := now.Add(-time.Hour)
+	earlier := now.Add(-2 * time.Hour)
+	_, err := db.AddArticlesBulk([]model.Article{
+		{BlogID: ids[1], Title: "Two", URL: "https://two.example.com/a", PublishedDate: &recent, DiscoveredDate: &recent},
+		{BlogID: ids[0], Title: "One", URL: "https://one.example.com/a", PublishedDate: &earlier, DiscoveredDate: &recent},
+	})
+	if err != nil {
+		t.Fatalf("add articles: %v", err)
+	}
+
+	d, err := Build(db, now.Add(-24*time.Hour), now, "https://reader.example.com")
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if len(d.Groups) != 2 || d.Groups[0].BlogURL == d.Groups[1].BlogURL {
+		t.Fatalf("groups = %+v, want one per blog", d.Groups)
+	}
+}
+
+func TestSettingsDue(t *testing.T) {
+	now := time.Date(2024, 5, 10, 9, 0, 0, 0, time.UTC)
+	yesterday := now.Add(-23 * time.Hour)
+	lastWeek := now.Add(-8 * 24 * time.Hour)
+
+	configured := Settings{Schedule: ScheduleDaily, Recipients: []string{"a@example.com"}, SMTPHost: "smtp.example.com"}
+
+	tests := []struct {
+		name string
+		s    Settings
+		want bool
+	}{
+		{"off", Settings{Schedule: ScheduleOff, Recipients: configured.Recipients, SMTPHost: "smtp.example.com"}, false},
+		{"never sent", configured, true},
+		{"sent within period", withLastSent(configured, yesterday), false},
+		{"weekly overdue", withLastSent(Settings{Schedule: ScheduleWeekly, Recipients: configured.Recipients, SMTPHost: "smtp.example.com"}, lastWeek), true},
+		{"no recipients", Settings{Schedule: ScheduleDaily, SMTPHost: "smtp.example.com"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.s.Due(now); got != tt.want {
+			t.Errorf("%s: Due = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func withLastSent(s Settings, t time.Time) Settings {
+	s.LastSent = &t
+	return s
+}
+
+func TestSettingsRoundTrip(t *testing.T) {
+	db := openTestDB(t)
+
+	in := Settings{
+		Schedule:     ScheduleWeekly,
+		Recipients:   []string{"a@example.com", "b@example.com"},
+		From:         "bw@example.com",
+		SMTPHost:     "smtp.example.com",
+		SMTPPort:     2525,
+		SMTPUsername: "user",
+		SMTPPassword: "pass",
+	}
+	if err := SaveSettings(db, in); err != nil {
+		t.Fatalf("SaveSettings: %v", err)
+	}
+	sentAt := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	if err := MarkSent(db, sentAt); err != nil {
+		t.Fatalf("MarkSent: %v", err)
+	}
+
+	out, err := LoadSettings(db)
+	if err != nil {
+		t.Fatalf("LoadSettings: %v", err)
+	}
+	if out.Schedule != in.Schedule || out.SMTPPort != 2525 || out.SMTPPassword != "pass" || len(out.Recipients) != 2 {
+		t.Errorf("LoadSettings = %+v, want %+v", out, in)
+	}
+	if out.LastSent == nil || !out.LastSent.Equal(sentAt) {
+		t.Errorf("LastSent = %v, want %v", out.LastSent, sentAt)
+	}
+}
+
+func TestBuildMessageHasTextAndHTMLParts(t *testing.T) {
+	published := time.Date(2024, 5, 9, 12, 0, 0, 0, time.UTC)
+	d := Digest{
+		Since: published.Add(-24 * time.Hour),
+		Until: published,
+		Total: 1,
+		Groups: []Group{{
+			BlogName: "Alpha",
+			Articles: []Entry{{Title: "Café & <Tags>", URL: "https://alpha.example.com/p", Published: &published}},
+		}},
+	}
+
+	raw, err := buildMessage("bw@example.com", []string{"a@example.com"}, d, published)
+	if err != nil {
+		t.Fatalf("buildMessage: %v", err)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("parse message: %v", err)
+	}
+	if got := msg.Header.Get("Subject"); got != "BlogWatcher digest: 1 new article" {
+		t.Errorf("Subject = %q", got)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q (%v)", msg.Header.Get("Content-Type"), err)
+	}
+
+	bodies := map[string]string{}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("next part: %v", err)
+		}
+		body, _ := io.ReadAll(part)
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		bodies[partType] = string(body)
+	}
+
+	if !strings.Contains(bodies["text/plain"], "Café & <Tags>") {
+		t.Errorf("text part missing title: %q", bodies["text/plain"])
+	}
+	if !strings.Contains(bodies["text/html"], "Café &amp; &lt;Tags&gt;") {
+		t.Errorf("html part should contain escaped title: %q", bodies["text/html"])
+	}
+}
+
+func TestParseRecipients(t *testing.T) {
+	got := ParseRecipients(" a@example.com, b@example.com;c@example.com\n ")
+	want := []string{"a@example.com", "b@example.com", "c@example.com"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("ParseRecipients = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,214 @@
+// ABOUTME: Delivers rendered digests over SMTP and runs the periodic digest schedule.
+// ABOUTME: Uses stdlib net/smtp with STARTTLS or implicit TLS (port 465) — no external dependencies.
+package digest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/logging"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/storage"
+)
+
+// Send renders d and delivers it to every recipient in s.
+func Send(ctx context.Context, s Settings, d Digest) error {
+	if s.SMTPHost == "" {
+		return errors.New("SMTP host is not configured")
+	}
+	if len(s.Recipients) == 0 {
+		return errors.New("no digest recipients configured")
+	}
+	from := s.From
+	if from == "" {
+		from = s.SMTPUsername
+	}
+	if from == "" {
+		return errors.New("no sender address configured")
+	}
+
+	msg, err := buildMessage(from, s.Recipients, d, time.Now())
+	if err != nil {
+		return err
+	}
+	return deliver(ctx, s, from, msg)
+}
+
+// buildMessage assembles an RFC 5322 multipart/alternative message with
+// plain-text and HTML renderings of the digest.
+func buildMessage(from string, to []string, d Digest, now time.Time) ([]byte, error) {
+	htmlBody, err := d.RenderHTML()
+	if err != nil {
+		return nil, fmt.Errorf("render html: %w", err)
+	}
+	textBody, err := d.RenderText()
+	if err != nil {
+		return nil, fmt.Errorf("render text: %w", err)
+	}
+
+	boundary := randomToken()
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "From: %s\r\n", from)
+	fmt.Fprintf(buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", d.Subject()))
+	fmt.Fprintf(buf, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(buf, "Message-ID: <digest-%s@blogwatcher>\r\n", randomToken())
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(buf, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", boundary)
+
+	for _, part := range []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain; charset=utf-8", textBody},
+		{"text/html; charset=utf-8", htmlBody},
+	} {
+		fmt.Fprintf(buf, "--%s\r\n", boundary)
+		fmt.Fprintf(buf, "Content-Type: %s\r\n", part.contentType)
+		buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		qp := quotedprintable.NewWriter(buf)
+		if _, err := qp.Write([]byte(part.body)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+		buf.WriteString("\r\n")
+	}
+	fmt.Fprintf(buf, "--%s--\r\n", boundary)
+	return buf.Bytes(), nil
+}
+
+// deliver sends msg over SMTP. Port 465 uses implicit TLS; other ports
+// upgrade with STARTTLS when the server offers it.
+func deliver(ctx context.Context, s Settings, from string, msg []byte) error {
+	port := s.SMTPPort
+	if port == 0 {
+		port = 587
+	}
+	addr := net.JoinHostPort(s.SMTPHost, strconv.Itoa(port))
+	tlsConfig := &tls.Config{ServerName: s.SMTPHost}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	var conn net.Conn
+	var err error
+	if port == 465 {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("connect to %s: %w", addr, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, s.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("smtp handshake: %w", err)
+	}
+	defer client.Close()
+
+	if port != 465 {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				return fmt.Errorf("starttls: %w", err)
+			}
+		}
+	}
+	if s.SMTPUsername != "" {
+		auth := smtp.PlainAuth("", s.SMTPUsername, s.SMTPPassword, s.SMTPHost)
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("smtp auth: %w", err)
+		}
+	}
+	if err := client.Mail(from); err != nil {
+		return fmt.Errorf("smtp MAIL FROM: %w", err)
+	}
+	for _, rcpt := range s.Recipients {
+		if err := client.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("smtp RCPT TO %s: %w", rcpt, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("smtp DATA: %w", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return fmt.Errorf("write message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("finish message: %w", err)
+	}
+	return client.Quit()
+}
+
+// SendDue builds and sends the scheduled digest if one is due at now.
+// Empty digests are skipped but still advance the last-sent marker so the
+// next digest covers only the following period.
+func SendDue(ctx context.Context, db *storage.Database, baseURL string, now time.Time) (sent bool, err error) {
+	s, err := LoadSettings(db)
+	if err != nil {
+		return false, err
+	}
+	if !s.Due(now) {
+		return false, nil
+	}
+
+	d, err := Build(db, s.Since(now), now, baseURL)
+	if err != nil {
+		return false, err
+	}
+	if d.Total > 0 {
+		if err := Send(ctx, s, d); err != nil {
+			return false, err
+		}
+		sent = true
+	}
+	return sent, MarkSent(db, now)
+}
+
+// RunScheduler checks every interval whether a digest is due and sends it.
+// Blocks until ctx is cancelled. Logs go to the logger carried by ctx.
+func RunScheduler(ctx context.Context, db *storage.Database, baseURL string, interval time.Duration) {
+	logger := logging.FromContext(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		sendCtx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+		sent, err := SendDue(sendCtx, db, baseURL, time.Now())
+		cancel()
+		if err != nil {
+			logger.Error("digest send failed", "err", err)
+		} else if sent {
+			logger.Info("digest sent")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func randomToken() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		panic("crypto/rand unavailable: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
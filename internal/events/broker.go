@@ -0,0 +1,92 @@
+// ABOUTME: In-process publish/subscribe broker for live UI update events.
+// ABOUTME: The server streams these events to browsers over Server-Sent Events.
+package events
+
+import (
+	"sync"
+)
+
+// Event types pushed to connected browsers.
+const (
+	TypeArticlesNew      = "articles-new"
+	TypeSyncStarted      = "sync-started"
+	TypeSyncProgress     = "sync-progress"
+	TypeSyncFinished     = "sync-finished"
+	TypeReadStateChanged = "read-state-changed"
+)
+
+// subscriberBuffer is how many undelivered events a slow subscriber may queue
+// before further events are dropped for it.
+const subscriberBuffer = 16
+
+// Event is a single notification. Data is JSON-encoded when streamed.
+type Event struct {
+	Type string
+	Data any
+}
+
+// Broker fans out published events to all current subscribers.
+// Publishing never blocks: events are dropped for subscribers whose buffer is full.
+type Broker struct {
+	mu     sync.Mutex
+	subs   map[chan Event]struct{}
+	closed bool
+}
+
+// NewBroker returns an empty Broker.
+func NewBroker() *Broker {
+	return &Broker{subs: make(map[chan Event]struct{})}
+}
+
+// Subscribe registers a new subscriber. The returned channel is closed when
+// the unsubscribe function is called or the broker is closed.
+func (b *Broker) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	b.subs[ch] = struct{}{}
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			if _, ok := b.subs[ch]; ok {
+				delete(b.subs, ch)
+				close(ch)
+			}
+		})
+	}
+}
+
+// Publish delivers e to every subscriber without blocking.
+func (b *Broker) Publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			// Subscriber is not keeping up; drop rather than stall publishers.
+		}
+	}
+}
+
+// Close disconnects all subscribers. Later subscriptions are closed immediately.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for ch := range b.subs {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
@@ -0,0 +1,84 @@
+// ABOUTME: Tests for the publish/subscribe event broker.
+// ABOUTME: Covers fan-out, unsubscribe, slow subscribers and shutdown.
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func receive(t *testing.T, ch <-chan Event) Event {
+	t.Helper()
+	select {
+	case e, ok := <-ch:
+		if !ok {
+			t.Fatal("channel closed unexpectedly")
+		}
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+	return Event{}
+}
+
+func TestPublishFansOutToAllSubscribers(t *testing.T) {
+	b := NewBroker()
+	first, unsubFirst := b.Subscribe()
+	defer unsubFirst()
+	second, unsubSecond := b.Subscribe()
+	defer unsubSecond()
+
+	b.Publish(Event{Type: TypeArticlesNew, Data: 3})
+
+	for _, ch := range []<-chan Event{first, second} {
+		if e := receive(t, ch); e.Type != TypeArticlesNew || e.Data != 3 {
+			t.Errorf("got %+v", e)
+		}
+	}
+}
+
+func TestUnsubscribeClosesChannel(t *testing.T) {
+	b := NewBroker()
+	ch, unsub := b.Subscribe()
+	unsub()
+	unsub() // idempotent
+
+	if _, ok := <-ch; ok {
+		t.Error("channel should be closed after unsubscribe")
+	}
+	b.Publish(Event{Type: TypeSyncStarted}) // must not panic on closed channel
+}
+
+func TestPublishDoesNotBlockOnSlowSubscriber(t *testing.T) {
+	b := NewBroker()
+	_, unsub := b.Subscribe()
+	defer unsub()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < subscriberBuffer*4; i++ {
+			b.Publish(Event{Type: TypeReadStateChanged})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Publish blocked on a subscriber that never reads")
+	}
+}
+
+func TestCloseDisconnectsSubscribers(t *testing.T) {
+	b := NewBroker()
+	ch, unsub := b.Subscribe()
+	b.Close()
+	unsub() // safe after close
+
+	if _, ok := <-ch; ok {
+		t.Error("channel should be closed after broker Close")
+	}
+	late, _ := b.Subscribe()
+	if _, ok := <-late; ok {
+		t.Error("subscriptions after Close should be closed immediately")
+	}
+}
@@ -0,0 +1,342 @@
+// ABOUTME: Renders aggregated article lists as Atom, RSS 2.0 and JSON Feed 1.1 documents.
+// ABOUTME: Used by the server to re-publish filtered reading lists for downstream feed readers.
+package feed
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// Format identifies one of the supported output feed formats.
+type Format string
+
+const (
+	FormatAtom Format = "atom"
+	FormatRSS  Format = "rss"
+	FormatJSON Format = "json"
+)
+
+// ContentType returns the MIME type to serve for the format.
+func (f Format) ContentType() string {
+	switch f {
+	case FormatAtom:
+		return "application/atom+xml; charset=utf-8"
+	case FormatRSS:
+		return "application/rss+xml; charset=utf-8"
+	case FormatJSON:
+		return "application/feed+json; charset=utf-8"
+	}
+	return "application/octet-stream"
+}
+
+// ParseFormat returns the Format for a name such as "atom", "rss" or "json".
+func ParseFormat(name string) (Format, bool) {
+	switch Format(name) {
+	case FormatAtom, FormatRSS, FormatJSON:
+		return Format(name), true
+	}
+	return "", false
+}
+
+// Feed is a format-neutral description of an output feed.
+// All URLs must be absolute so the document is usable outside the app.
+type Feed struct {
+	Title       string
+	Description string
+	SelfURL     string // URL of the feed document itself
+	SiteURL     string // URL of the HTML view of the same list
+	Updated     time.Time
+	Items       []Item
+}
+
+// Item is a single entry in an output feed.
+type Item struct {
+	ID          string // stable, globally unique identifier
+	Title       string
+	URL         string
+	SourceName  string // name of the originating blog or newsletter
+	SourceURL   string
+	ImageURL    string
+	ContentHTML string // optional full HTML body (newsletters)
+	Published   time.Time
+	Updated     time.Time
+}
+
+// Write renders f in the requested format.
+func Write(w io.Writer, format Format, f Feed) error {
+	switch format {
+	case FormatAtom:
+		return WriteAtom(w, f)
+	case FormatRSS:
+		return WriteRSS(w, f)
+	case FormatJSON:
+		return WriteJSON(w, f)
+	}
+	return fmt.Errorf("unsupported feed format %q", format)
+}
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title    string      `xml:"title"`
+	ID       string      `xml:"id"`
+	Updated  string      `xml:"updated"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	Links    []atomLink  `xml:"link"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title     string       `xml:"title"`
+	ID        string       `xml:"id"`
+	Links     []atomLink   `xml:"link"`
+	Published string       `xml:"published,omitempty"`
+	Updated   string       `xml:"updated"`
+	Author    *atomPerson  `xml:"author,omitempty"`
+	Source    *atomSource  `xml:"source,omitempty"`
+	Content   *atomContent `xml:"content,omitempty"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomSource struct {
+	Title string    `xml:"title"`
+	Link  *atomLink `xml:"link,omitempty"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// WriteAtom renders f as an RFC 4287 Atom feed.
+func WriteAtom(w io.Writer, f Feed) error {
+	doc := atomFeed{
+		Title:    f.Title,
+		ID:       f.SelfURL,
+		Updated:  f.Updated.UTC().Format(time.RFC3339),
+		Subtitle: f.Description,
+		Links: []atomLink{
+			{Href: f.SelfURL, Rel: "self", Type: "application/atom+xml"},
+			{Href: f.SiteURL, Rel: "alternate", Type: "text/html"},
+		},
+	}
+	for _, item := range f.Items {
+		entry := atomEntry{
+			Title:   item.Title,
+			ID:      item.ID,
+			Links:   []atomLink{{Href: item.URL, Rel: "alternate"}},
+			Updated: item.updated().UTC().Format(time.RFC3339),
+		}
+		if !item.Published.IsZero() {
+			entry.Published = item.Published.UTC().Format(time.RFC3339)
+		}
+		if item.SourceName != "" {
+			entry.Author = &atomPerson{Name: item.SourceName}
+			entry.Source = &atomSource{Title: item.SourceName}
+			if item.SourceURL != "" {
+				entry.Source.Link = &atomLink{Href: item.SourceURL}
+			}
+		}
+		if item.ImageURL != "" {
+			entry.Links = append(entry.Links, atomLink{Href: item.ImageURL, Rel: "enclosure", Type: imageType(item.ImageURL)})
+		}
+		if item.ContentHTML != "" {
+			entry.Content = &atomContent{Type: "html", Body: item.ContentHTML}
+		}
+		doc.Entries = append(doc.Entries, entry)
+	}
+	return writeXML(w, doc)
+}
+
+type rssDocument struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string     `xml:"title"`
+	Link          string     `xml:"link"`
+	Description   string     `xml:"description"`
+	AtomLink      atomLinkNS `xml:"atom:link"`
+	LastBuildDate string     `xml:"lastBuildDate"`
+	Items         []rssItem  `xml:"item"`
+}
+
+// atomLinkNS is an atom:link element inside an RSS channel (self reference).
+type atomLinkNS struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link"`
+	GUID        rssGUID       `xml:"guid"`
+	PubDate     string        `xml:"pubDate,omitempty"`
+	Source      *rssSource    `xml:"source,omitempty"`
+	Description string        `xml:"description,omitempty"`
+	Enclosure   *rssEnclosure `xml:"enclosure,omitempty"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssSource struct {
+	URL  string `xml:"url,attr"`
+	Name string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int    `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// WriteRSS renders f as an RSS 2.0 feed.
+func WriteRSS(w io.Writer, f Feed) error {
+	doc := rssDocument{
+		Version: "2.0",
+		AtomNS:  "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:         f.Title,
+			Link:          f.SiteURL,
+			Description:   f.Description,
+			AtomLink:      atomLinkNS{Href: f.SelfURL, Rel: "self", Type: "application/rss+xml"},
+			LastBuildDate: f.Updated.UTC().Format(time.RFC1123Z),
+		},
+	}
+	for _, item := range f.Items {
+		ri := rssItem{
+			Title:       item.Title,
+			Link:        item.URL,
+			GUID:        rssGUID{IsPermaLink: false, Value: item.ID},
+			Description: item.ContentHTML,
+		}
+		if !item.Published.IsZero() {
+			ri.PubDate = item.Published.UTC().Format(time.RFC1123Z)
+		}
+		// RSS requires the source url attribute, so only emit it when known.
+		if item.SourceName != "" && item.SourceURL != "" {
+			ri.Source = &rssSource{URL: item.SourceURL, Name: item.SourceName}
+		}
+		if item.ImageURL != "" {
+			ri.Enclosure = &rssEnclosure{URL: item.ImageURL, Type: imageType(item.ImageURL)}
+		}
+		doc.Channel.Items = append(doc.Channel.Items, ri)
+	}
+	return writeXML(w, doc)
+}
+
+type jsonFeed struct {
+	Version     string     `json:"version"`
+	Title       string     `json:"title"`
+	HomePageURL string     `json:"home_page_url,omitempty"`
+	FeedURL     string     `json:"feed_url,omitempty"`
+	Description string     `json:"description,omitempty"`
+	Items       []jsonItem `json:"items"`
+}
+
+type jsonItem struct {
+	ID            string       `json:"id"`
+	URL           string       `json:"url,omitempty"`
+	Title         string       `json:"title,omitempty"`
+	ContentHTML   string       `json:"content_html,omitempty"`
+	ContentText   string       `json:"content_text,omitempty"`
+	Image         string       `json:"image,omitempty"`
+	DatePublished string       `json:"date_published,omitempty"`
+	DateModified  string       `json:"date_modified,omitempty"`
+	Authors       []jsonAuthor `json:"authors,omitempty"`
+}
+
+type jsonAuthor struct {
+	Name string `json:"name"`
+	URL  string `json:"url,omitempty"`
+}
+
+// WriteJSON renders f as a JSON Feed 1.1 document.
+func WriteJSON(w io.Writer, f Feed) error {
+	doc := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       f.Title,
+		HomePageURL: f.SiteURL,
+		FeedURL:     f.SelfURL,
+		Description: f.Description,
+		Items:       make([]jsonItem, 0, len(f.Items)),
+	}
+	for _, item := range f.Items {
+		ji := jsonItem{
+			ID:           item.ID,
+			URL:          item.URL,
+			Title:        item.Title,
+			ContentHTML:  item.ContentHTML,
+			Image:        item.ImageURL,
+			DateModified: item.updated().UTC().Format(time.RFC3339),
+		}
+		// JSON Feed requires either content_html or content_text on every item.
+		if ji.ContentHTML == "" {
+			ji.ContentText = item.Title
+		}
+		if !item.Published.IsZero() {
+			ji.DatePublished = item.Published.UTC().Format(time.RFC3339)
+		}
+		if item.SourceName != "" {
+			ji.Authors = []jsonAuthor{{Name: item.SourceName, URL: item.SourceURL}}
+		}
+		doc.Items = append(doc.Items, ji)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+// updated returns the item's modification time, falling back to its publish time.
+func (i Item) updated() time.Time {
+	if !i.Updated.IsZero() {
+		return i.Updated
+	}
+	return i.Published
+}
+
+// imageType guesses an image MIME type from the URL's file extension.
+// Defaults to image/jpeg, the most common thumbnail format.
+func imageType(imageURL string) string {
+	if u, err := url.Parse(imageURL); err == nil {
+		if t := mime.TypeByExtension(path.Ext(u.Path)); strings.HasPrefix(t, "image/") {
+			return t
+		}
+	}
+	return "image/jpeg"
+}
+
+func writeXML(w io.Writer, doc any) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
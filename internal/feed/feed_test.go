@@ -0,0 +1,122 @@
+// ABOUTME: Tests for Atom, RSS and JSON Feed rendering.
+// ABOUTME: Round-trips rendered documents through gofeed to check they are valid.
+package feed
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+func sampleFeed() Feed {
+	published := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	return Feed{
+		Title:       "BlogWatcher - Unread",
+		Description: "Articles collected by BlogWatcher",
+		SelfURL:     "https://reader.example.com/feeds/atom?filter=unread",
+		SiteURL:     "https://reader.example.com/articles?filter=unread",
+		Updated:     published,
+		Items: []Item{
+			{
+				ID:         "https://blog.example.com/post-1",
+				Title:      "First <Post>",
+				URL:        "https://blog.example.com/post-1",
+				SourceName: "Example Blog",
+				SourceURL:  "https://blog.example.com",
+				ImageURL:   "https://blog.example.com/cover.png",
+				Published:  published,
+			},
+			{
+				ID:          "https://reader.example.com/newsletter/article/7",
+				Title:       "Issue 7",
+				URL:         "https://reader.example.com/newsletter/article/7",
+				SourceName:  "Acme News",
+				ContentHTML: "<p>Hello &amp; welcome</p>",
+				Updated:     published.Add(time.Hour),
+			},
+		},
+	}
+}
+
+func TestWriteFormatsParseAsFeeds(t *testing.T) {
+	for _, format := range []Format{FormatAtom, FormatRSS, FormatJSON} {
+		t.Run(string(format), func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			if err := Write(buf, format, sampleFeed()); err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+
+			parsed, err := gofeed.NewParser().Parse(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("parse rendered %s feed: %v\n%s", format, err, buf.String())
+			}
+			if parsed.Title != "BlogWatcher - Unread" {
+				t.Errorf("Title = %q", parsed.Title)
+			}
+			if len(parsed.Items) != 2 {
+				t.Fatalf("got %d items, want 2", len(parsed.Items))
+			}
+			if parsed.Items[0].Title != "First <Post>" {
+				t.Errorf("item title = %q, want escaped title to round-trip", parsed.Items[0].Title)
+			}
+			if parsed.Items[0].Link != "https://blog.example.com/post-1" {
+				t.Errorf("item link = %q", parsed.Items[0].Link)
+			}
+			if parsed.Items[0].PublishedParsed == nil || !parsed.Items[0].PublishedParsed.Equal(sampleFeed().Items[0].Published) {
+				t.Errorf("item published = %v", parsed.Items[0].PublishedParsed)
+			}
+			if !strings.Contains(parsed.Items[1].Content+parsed.Items[1].Description, "Hello") {
+				t.Errorf("newsletter item should carry its HTML body, got content=%q description=%q",
+					parsed.Items[1].Content, parsed.Items[1].Description)
+			}
+		})
+	}
+}
+
+func TestWriteJSONRequiresContentOnEveryItem(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := WriteJSON(buf, sampleFeed()); err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+
+	var doc struct {
+		Version string `json:"version"`
+		Items   []struct {
+			ContentHTML string `json:"content_html"`
+			ContentText string `json:"content_text"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if doc.Version != "https://jsonfeed.org/version/1.1" {
+		t.Errorf("version = %q", doc.Version)
+	}
+	for i, item := range doc.Items {
+		if item.ContentHTML == "" && item.ContentText == "" {
+			t.Errorf("item %d has neither content_html nor content_text", i)
+		}
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	if f, ok := ParseFormat("rss"); !ok || f != FormatRSS {
+		t.Errorf("ParseFormat(rss) = %q, %v", f, ok)
+	}
+	if _, ok := ParseFormat("opml"); ok {
+		t.Error("ParseFormat(opml) should be rejected")
+	}
+}
+
+func TestImageType(t *testing.T) {
+	if got := imageType("https://example.com/a/cover.png?w=300"); got != "image/png" {
+		t.Errorf("imageType(png) = %q", got)
+	}
+	if got := imageType("https://example.com/image"); got != "image/jpeg" {
+		t.Errorf("imageType(no extension) = %q", got)
+	}
+}
@@ -0,0 +1,53 @@
+// ABOUTME: Shared HTTP client for fetching feeds, pages and images from tracked blogs.
+// ABOUTME: Applies the configured User-Agent to every outgoing request.
+package fetch
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/version"
+)
+
+// DefaultUserAgent identifies BlogWatcher to the sites it fetches from.
+var DefaultUserAgent = "blogwatcher-ui/" + version.Version + " (+https://github.com/esttorhe/blogwatcher-ui)"
+
+var userAgent atomic.Value // string
+
+// SetUserAgent changes the User-Agent sent by Client. An empty value restores
+// DefaultUserAgent.
+func SetUserAgent(ua string) {
+	if ua == "" {
+		ua = DefaultUserAgent
+	}
+	userAgent.Store(ua)
+}
+
+// UserAgent returns the User-Agent currently sent by Client.
+func UserAgent() string {
+	if ua, ok := userAgent.Load().(string); ok {
+		return ua
+	}
+	return DefaultUserAgent
+}
+
+// Client returns an HTTP client that sets the configured User-Agent on
+// requests that do not already carry one. Callers bound requests with their
+// context rather than a client timeout.
+func Client() *http.Client {
+	return &http.Client{Transport: userAgentTransport{base: http.DefaultTransport}}
+}
+
+type userAgentTransport struct {
+	base http.RoundTripper
+}
+
+func (t userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") != "" {
+		return t.base.RoundTrip(req)
+	}
+	// RoundTrippers must not modify the caller's request.
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", UserAgent())
+	return t.base.RoundTrip(req)
+}
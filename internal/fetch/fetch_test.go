@@ -0,0 +1,47 @@
+// ABOUTME: Tests for the shared fetch client.
+// ABOUTME: Verifies the configured User-Agent is sent and explicit headers are preserved.
+package fetch
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientSetsUserAgent(t *testing.T) {
+	var got string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("User-Agent")
+	}))
+	defer ts.Close()
+	defer SetUserAgent("")
+
+	SetUserAgent("TestAgent/1.0")
+	resp, err := Client().Get(ts.URL)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	resp.Body.Close()
+	if got != "TestAgent/1.0" {
+		t.Errorf("User-Agent = %q, want TestAgent/1.0", got)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	req.Header.Set("User-Agent", "Explicit/2.0")
+	resp, err = Client().Do(req)
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	resp.Body.Close()
+	if got != "Explicit/2.0" {
+		t.Errorf("User-Agent = %q, want explicit header preserved", got)
+	}
+}
+
+func TestSetUserAgentEmptyRestoresDefault(t *testing.T) {
+	SetUserAgent("Other")
+	SetUserAgent("")
+	if UserAgent() != DefaultUserAgent {
+		t.Errorf("UserAgent = %q, want default", UserAgent())
+	}
+}
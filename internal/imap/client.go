@@ -0,0 +1,481 @@
+// ABOUTME: A minimal IMAP4rev1 client for reading newsletters out of a mailbox.
+// ABOUTME: Supports LOGIN, STARTTLS, SELECT, STATUS and UID SEARCH/FETCH/STORE/MOVE with a COPY fallback.
+package imap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	// maxLiteralBytes bounds a single message fetched from the server.
+	maxLiteralBytes = 64 << 20
+	// maxLineBytes bounds a reply line, excluding literals. SEARCH results
+	// for large mailboxes are the longest lines we expect.
+	maxLineBytes = 8 << 20
+)
+
+// Error is a NO or BAD reply to a command.
+type Error struct {
+	Command string
+	Status  string // "NO" or "BAD"
+	Text    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("IMAP %s: %s %s", e.Command, e.Status, e.Text)
+}
+
+// ErrNoStartTLS is returned by Dial when a server on a port other than 993
+// does not offer STARTTLS and plaintext was not allowed.
+var ErrNoStartTLS = errors.New("server does not offer STARTTLS; refusing to log in without TLS")
+
+// Client is a connection to an IMAP server. It is not safe for concurrent use.
+type Client struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+	tag  int
+	caps map[string]bool
+}
+
+// Dial connects to host:port and reads the server's capabilities. Port 993
+// uses implicit TLS; other ports must upgrade with STARTTLS, and Dial fails
+// with ErrNoStartTLS when the server does not offer it, unless
+// allowPlaintext is set. The context's deadline, if any, applies to the whole
+// session.
+func Dial(ctx context.Context, host string, port int, allowPlaintext bool) (*Client, error) {
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	tlsConfig := &tls.Config{ServerName: host}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	var conn net.Conn
+	var err error
+	if port == 993 {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("connect to %s: %w", addr, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	c, err := NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if port != 993 {
+		switch {
+		case c.Supports("STARTTLS"):
+			if err := c.StartTLS(tlsConfig); err != nil {
+				c.Close()
+				return nil, err
+			}
+		case !allowPlaintext:
+			c.Close()
+			return nil, ErrNoStartTLS
+		}
+	}
+	return c, nil
+}
+
+// NewClient reads the greeting on an established connection and asks for the
+// server's capabilities.
+func NewClient(conn net.Conn) (*Client, error) {
+	c := &Client{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+	greeting, err := c.readLine()
+	if err != nil {
+		return nil, fmt.Errorf("read IMAP greeting: %w", err)
+	}
+	if !hasPrefixFold(greeting.text, "* OK") && !hasPrefixFold(greeting.text, "* PREAUTH") {
+		return nil, fmt.Errorf("unexpected IMAP greeting %q", greeting.text)
+	}
+	if err := c.Capability(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Capability refreshes the server's capability list.
+func (c *Client) Capability() error {
+	untagged, err := c.command("CAPABILITY")
+	if err != nil {
+		return err
+	}
+	for _, l := range untagged {
+		if hasPrefixFold(l.text, "* CAPABILITY ") {
+			c.caps = make(map[string]bool)
+			for _, name := range strings.Fields(l.text[len("* CAPABILITY "):]) {
+				c.caps[strings.ToUpper(name)] = true
+			}
+		}
+	}
+	return nil
+}
+
+// Supports reports whether the server advertised the named capability.
+func (c *Client) Supports(name string) bool {
+	return c.caps[strings.ToUpper(name)]
+}
+
+// StartTLS upgrades the connection to TLS.
+func (c *Client) StartTLS(config *tls.Config) error {
+	if _, err := c.command("STARTTLS"); err != nil {
+		return err
+	}
+	tlsConn := tls.Client(c.conn, config)
+	if err := tlsConn.Handshake(); err != nil {
+		return fmt.Errorf("IMAP STARTTLS: %w", err)
+	}
+	c.conn = tlsConn
+	c.r = bufio.NewReader(tlsConn)
+	c.w = bufio.NewWriter(tlsConn)
+	return c.Capability()
+}
+
+// Login authenticates with a user name and password.
+func (c *Client) Login(username, password string) error {
+	if c.Supports("LOGINDISABLED") {
+		return errors.New("IMAP server does not allow LOGIN on this connection; use port 993 or a server offering STARTTLS")
+	}
+	if _, err := c.command("LOGIN", astring(username), astring(password)); err != nil {
+		return err
+	}
+	// Servers often advertise more once the user is known
+	return c.Capability()
+}
+
+// Select opens mailbox for reading and changes and returns how many
+// messages it holds.
+func (c *Client) Select(mailbox string) (int, error) {
+	untagged, err := c.command("SELECT", astring(encodeMailbox(mailbox)))
+	if err != nil {
+		return 0, err
+	}
+	exists := 0
+	for _, l := range untagged {
+		fields := strings.Fields(l.text)
+		if len(fields) == 3 && strings.EqualFold(fields[2], "EXISTS") {
+			exists, _ = strconv.Atoi(fields[1])
+		}
+	}
+	return exists, nil
+}
+
+// Status checks that mailbox exists without selecting it and returns how many
+// messages it holds.
+func (c *Client) Status(mailbox string) (int, error) {
+	untagged, err := c.command("STATUS", astring(encodeMailbox(mailbox)), "(MESSAGES)")
+	if err != nil {
+		return 0, err
+	}
+	for _, l := range untagged {
+		upper := strings.ToUpper(l.text)
+		if i := strings.Index(upper, "(MESSAGES "); strings.HasPrefix(upper, "* STATUS ") && i >= 0 {
+			n, _, _ := strings.Cut(l.text[i+len("(MESSAGES "):], ")")
+			return strconv.Atoi(n)
+		}
+	}
+	return 0, nil
+}
+
+// SearchUnseen returns the UIDs of messages in the selected mailbox without
+// the \Seen flag, in ascending order.
+func (c *Client) SearchUnseen() ([]uint32, error) {
+	untagged, err := c.command("UID SEARCH", "UNSEEN")
+	if err != nil {
+		return nil, err
+	}
+	var uids []uint32
+	for _, l := range untagged {
+		if !hasPrefixFold(l.text, "* SEARCH") {
+			continue
+		}
+		for _, field := range strings.Fields(l.text[len("* SEARCH"):]) {
+			uid, err := strconv.ParseUint(field, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("IMAP UID SEARCH: bad UID %q", field)
+			}
+			uids = append(uids, uint32(uid))
+		}
+	}
+	return uids, nil
+}
+
+// Fetch returns the raw RFC 822 message with the given UID. It does not set
+// the \Seen flag.
+func (c *Client) Fetch(uid uint32) ([]byte, error) {
+	untagged, err := c.command("UID FETCH", formatUID(uid), "(BODY.PEEK[])")
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range untagged {
+		if strings.Contains(strings.ToUpper(l.text), " FETCH ") && len(l.literals) > 0 {
+			return l.literals[0], nil
+		}
+	}
+	return nil, fmt.Errorf("IMAP UID FETCH: message %d not found", uid)
+}
+
+// MarkSeen sets the \Seen flag on the message with the given UID.
+func (c *Client) MarkSeen(uid uint32) error {
+	_, err := c.command("UID STORE", formatUID(uid), "+FLAGS.SILENT", `(\Seen)`)
+	return err
+}
+
+// Move moves the message with the given UID to mailbox. Servers without MOVE
+// get a copy followed by a delete and expunge; without UIDPLUS that expunge
+// also removes any other messages already marked \Deleted.
+func (c *Client) Move(uid uint32, mailbox string) error {
+	id := formatUID(uid)
+	if c.Supports("MOVE") {
+		_, err := c.command("UID MOVE", id, astring(encodeMailbox(mailbox)))
+		return err
+	}
+	if _, err := c.command("UID COPY", id, astring(encodeMailbox(mailbox))); err != nil {
+		return err
+	}
+	if _, err := c.command("UID STORE", id, "+FLAGS.SILENT", `(\Deleted)`); err != nil {
+		return err
+	}
+	if c.Supports("UIDPLUS") {
+		_, err := c.command("UID EXPUNGE", id)
+		return err
+	}
+	_, err := c.command("EXPUNGE")
+	return err
+}
+
+// Logout ends the session and closes the connection.
+func (c *Client) Logout() error {
+	_, err := c.command("LOGOUT")
+	if closeErr := c.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Close closes the connection without logging out.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// astring is a command argument sent as a quoted string, or as a literal when
+// it cannot be quoted.
+type astring string
+
+// line is one server reply. Literals are cut out of text, which keeps their
+// {n} markers.
+type line struct {
+	text     string
+	literals [][]byte
+}
+
+// command sends a tagged command and returns the untagged replies that came
+// before its tagged completion. Arguments are raw atoms (string) or astring
+// values. Errors name only the command, never its arguments, so passwords
+// stay out of logs.
+func (c *Client) command(name string, args ...interface{}) ([]line, error) {
+	c.tag++
+	tag := "a" + strconv.Itoa(c.tag)
+	c.w.WriteString(tag + " " + name)
+	for _, arg := range args {
+		c.w.WriteByte(' ')
+		switch v := arg.(type) {
+		case string:
+			c.w.WriteString(v)
+		case astring:
+			if err := c.writeAstring(name, string(v)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	c.w.WriteString("\r\n")
+	if err := c.w.Flush(); err != nil {
+		return nil, fmt.Errorf("IMAP %s: %w", name, err)
+	}
+
+	var untagged []line
+	for {
+		l, err := c.readLine()
+		if err != nil {
+			return untagged, fmt.Errorf("IMAP %s: %w", name, err)
+		}
+		switch {
+		case strings.HasPrefix(l.text, "* "):
+			untagged = append(untagged, l)
+		case strings.HasPrefix(l.text, tag+" "):
+			status, text, _ := strings.Cut(l.text[len(tag)+1:], " ")
+			if strings.EqualFold(status, "OK") {
+				return untagged, nil
+			}
+			return untagged, &Error{Command: name, Status: strings.ToUpper(status), Text: text}
+		default:
+			return untagged, fmt.Errorf("IMAP %s: unexpected reply %q", name, l.text)
+		}
+	}
+}
+
+// writeAstring writes s quoted when possible and as a synchronising literal
+// otherwise.
+func (c *Client) writeAstring(name, s string) error {
+	if quotable(s) {
+		c.w.WriteByte('"')
+		for i := 0; i < len(s); i++ {
+			if s[i] == '"' || s[i] == '\\' {
+				c.w.WriteByte('\\')
+			}
+			c.w.WriteByte(s[i])
+		}
+		c.w.WriteByte('"')
+		return nil
+	}
+	fmt.Fprintf(c.w, "{%d}\r\n", len(s))
+	if err := c.w.Flush(); err != nil {
+		return fmt.Errorf("IMAP %s: %w", name, err)
+	}
+	l, err := c.readLine()
+	if err != nil {
+		return fmt.Errorf("IMAP %s: %w", name, err)
+	}
+	if !strings.HasPrefix(l.text, "+") {
+		return fmt.Errorf("IMAP %s: server refused literal: %s", name, l.text)
+	}
+	c.w.WriteString(s)
+	return nil
+}
+
+// quotable reports whether s can be sent as a quoted string: 7-bit text
+// without NUL, CR or LF.
+func quotable(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] == 0 || s[i] == '\r' || s[i] == '\n' || s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// readLine reads one reply, following any literals it announces.
+func (c *Client) readLine() (line, error) {
+	var l line
+	var text strings.Builder
+	for {
+		segment, err := c.readSegment()
+		if err != nil {
+			return l, err
+		}
+		text.WriteString(segment)
+		if text.Len() > maxLineBytes {
+			return l, errors.New("reply line too long")
+		}
+		n, ok := literalSize(segment)
+		if !ok {
+			l.text = text.String()
+			return l, nil
+		}
+		if n > maxLiteralBytes {
+			return l, fmt.Errorf("literal of %d bytes exceeds the %d byte limit", n, maxLiteralBytes)
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			return l, err
+		}
+		l.literals = append(l.literals, data)
+	}
+}
+
+// readSegment reads up to the next CRLF and returns the text without it.
+func (c *Client) readSegment() (string, error) {
+	var buf []byte
+	for {
+		chunk, err := c.r.ReadSlice('\n')
+		buf = append(buf, chunk...)
+		if len(buf) > maxLineBytes {
+			return "", errors.New("reply line too long")
+		}
+		if err == nil {
+			return string(bytes.TrimRight(buf, "\r\n")), nil
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return "", err
+		}
+	}
+}
+
+// literalSize reports the size announced by a trailing {n} or {n+}.
+func literalSize(segment string) (int64, bool) {
+	if !strings.HasSuffix(segment, "}") {
+		return 0, false
+	}
+	open := strings.LastIndexByte(segment, '{')
+	if open < 0 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(strings.TrimSuffix(segment[open+1:len(segment)-1], "+"), 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+func formatUID(uid uint32) string {
+	return strconv.FormatUint(uint64(uid), 10)
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+// mailboxBase64 is the modified base64 of RFC 3501 mailbox names.
+var mailboxBase64 = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+,").WithPadding(base64.NoPadding)
+
+// encodeMailbox converts a UTF-8 mailbox name to IMAP's modified UTF-7.
+func encodeMailbox(name string) string {
+	var b strings.Builder
+	var run []rune
+	flush := func() {
+		if len(run) == 0 {
+			return
+		}
+		units := utf16.Encode(run)
+		buf := make([]byte, 2*len(units))
+		for i, u := range units {
+			binary.BigEndian.PutUint16(buf[2*i:], u)
+		}
+		b.WriteByte('&')
+		b.WriteString(mailboxBase64.EncodeToString(buf))
+		b.WriteByte('-')
+		run = run[:0]
+	}
+	for _, r := range name {
+		switch {
+		case r == '&':
+			flush()
+			b.WriteString("&-")
+		case r >= 0x20 && r <= 0x7e:
+			flush()
+			b.WriteRune(r)
+		default:
+			run = append(run, r)
+		}
+	}
+	flush()
+	return b.String()
+}
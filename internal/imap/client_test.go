@@ -0,0 +1,152 @@
+// ABOUTME: Tests for the IMAP client against the in-memory imaptest server.
+// ABOUTME: Covers login, search, fetch, flagging, moving with and without MOVE, and mailbox name encoding.
+package imap
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/imap/imaptest"
+)
+
+func dialTest(t *testing.T, srv *imaptest.Server) *Client {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := Dial(ctx, "127.0.0.1", srv.Port(), true)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestClientFetchAndMove(t *testing.T) {
+	srv := imaptest.NewServer("reader", `pa"ss\word`)
+	t.Cleanup(srv.Close)
+	srv.CreateMailbox("Archive")
+	srv.Append("INBOX", []byte("Subject: old\r\n\r\nalready read\r\n"), `\Seen`)
+	first := srv.Append("INBOX", []byte("Subject: one\r\n\r\nfirst\r\n"))
+	second := srv.Append("INBOX", []byte("Subject: two\r\n\r\nsecond\r\n"))
+
+	c := dialTest(t, srv)
+	if !c.Supports("move") {
+		t.Error("MOVE capability not recorded")
+	}
+	var imapErr *Error
+	if err := c.Login("reader", "wrong"); !errors.As(err, &imapErr) || imapErr.Status != "NO" {
+		t.Fatalf("Login with a bad password = %v, want NO", err)
+	}
+	if err := c.Login("reader", `pa"ss\word`); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if n, err := c.Select("INBOX"); err != nil || n != 3 {
+		t.Fatalf("Select = %d, %v; want 3 messages", n, err)
+	}
+	if _, err := c.Status("Missing"); err == nil {
+		t.Error("Status of a missing mailbox succeeded")
+	}
+
+	uids, err := c.SearchUnseen()
+	if err != nil || !slices.Equal(uids, []uint32{first, second}) {
+		t.Fatalf("SearchUnseen = %v, %v; want [%d %d]", uids, err, first, second)
+	}
+	data, err := c.Fetch(first)
+	if err != nil || string(data) != "Subject: one\r\n\r\nfirst\r\n" {
+		t.Fatalf("Fetch = %q, %v", data, err)
+	}
+	if err := c.MarkSeen(first); err != nil {
+		t.Fatalf("MarkSeen: %v", err)
+	}
+	if err := c.Move(second, "Archive"); err != nil {
+		t.Fatalf("Move: %v", err)
+	}
+	if uids, _ := c.SearchUnseen(); len(uids) != 0 {
+		t.Errorf("unseen after processing = %v, want none", uids)
+	}
+	if n, err := c.Status("Archive"); err != nil || n != 1 {
+		t.Errorf("Status(Archive) = %d, %v; want 1", n, err)
+	}
+	if err := c.Logout(); err != nil {
+		t.Errorf("Logout: %v", err)
+	}
+}
+
+func TestClientMoveWithoutMoveExtension(t *testing.T) {
+	srv := imaptest.NewServer("reader", "secret")
+	t.Cleanup(srv.Close)
+	srv.DisableMove()
+	srv.CreateMailbox("Done")
+	keep := srv.Append("INBOX", []byte("Subject: keep\r\n\r\nx\r\n"))
+	move := srv.Append("INBOX", []byte("Subject: move\r\n\r\ny\r\n"))
+
+	c := dialTest(t, srv)
+	if c.Supports("MOVE") {
+		t.Fatal("MOVE advertised after DisableMove")
+	}
+	if err := c.Login("reader", "secret"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if _, err := c.Select("INBOX"); err != nil {
+		t.Fatalf("Select: %v", err)
+	}
+	if err := c.Move(move, "Done"); err != nil {
+		t.Fatalf("Move: %v", err)
+	}
+	inbox := srv.Messages("INBOX")
+	if len(inbox) != 1 || inbox[0].UID != keep {
+		t.Errorf("INBOX = %+v, want only message %d", inbox, keep)
+	}
+	if done := srv.Messages("Done"); len(done) != 1 || string(done[0].Data) != "Subject: move\r\n\r\ny\r\n" {
+		t.Errorf("Done = %+v, want the moved message", done)
+	}
+}
+
+func TestClientLoginLiteral(t *testing.T) {
+	srv := imaptest.NewServer("reader", "pässword")
+	t.Cleanup(srv.Close)
+
+	c := dialTest(t, srv)
+	if err := c.Login("reader", "pässword"); err != nil {
+		t.Fatalf("Login with a non-ASCII password: %v", err)
+	}
+	if srv.Logins() != 1 {
+		t.Errorf("server saw %d logins, want 1", srv.Logins())
+	}
+}
+
+func TestDialRequiresStartTLS(t *testing.T) {
+	srv := imaptest.NewServer("reader", "secret")
+	t.Cleanup(srv.Close)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The test server offers no STARTTLS, so logging in would leak the password
+	if c, err := Dial(ctx, "127.0.0.1", srv.Port(), false); !errors.Is(err, ErrNoStartTLS) {
+		if c != nil {
+			c.Close()
+		}
+		t.Errorf("Dial without STARTTLS = %v, want ErrNoStartTLS", err)
+	}
+	if srv.Logins() != 0 {
+		t.Errorf("server saw %d logins, want none", srv.Logins())
+	}
+}
+
+func TestEncodeMailbox(t *testing.T) {
+	tests := map[string]string{
+		"INBOX":              "INBOX",
+		"Newsletters/Tech":   "Newsletters/Tech",
+		"R&D":                "R&-D",
+		"Boîte de réception": "Bo&AO4-te de r&AOk-ception",
+		"日本語":                "&ZeVnLIqe-",
+	}
+	for in, want := range tests {
+		if got := encodeMailbox(in); got != want {
+			t.Errorf("encodeMailbox(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
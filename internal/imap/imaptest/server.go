@@ -0,0 +1,407 @@
+// ABOUTME: An in-memory IMAP server for tests of code that reads mailboxes.
+// ABOUTME: Speaks just enough IMAP4rev1 for the imap client: LOGIN, SELECT, STATUS, UID SEARCH/FETCH/STORE/COPY/MOVE and EXPUNGE.
+package imaptest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Message is a message held by the server.
+type Message struct {
+	UID   uint32
+	Flags []string
+	Data  []byte
+}
+
+// Server is an IMAP server on a loopback port with a single user.
+type Server struct {
+	// Addr is the host:port the server listens on.
+	Addr string
+
+	username, password string
+	ln                 net.Listener
+	wg                 sync.WaitGroup
+
+	mu        sync.Mutex
+	conns     map[net.Conn]bool
+	mailboxes map[string][]*Message
+	nextUID   uint32
+	noMove    bool
+	logins    int
+}
+
+// NewServer starts a server accepting username and password, with an empty
+// INBOX. Close it when done.
+func NewServer(username, password string) *Server {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("imaptest: listen: %v", err))
+	}
+	s := &Server{
+		Addr:      ln.Addr().String(),
+		username:  username,
+		password:  password,
+		ln:        ln,
+		conns:     make(map[net.Conn]bool),
+		mailboxes: map[string][]*Message{"INBOX": nil},
+		nextUID:   1,
+	}
+	s.wg.Add(1)
+	go s.accept()
+	return s
+}
+
+// Port returns the port the server listens on.
+func (s *Server) Port() int {
+	return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+// Close stops the server, closes open sessions and waits for them to end.
+func (s *Server) Close() {
+	s.ln.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// DisableMove stops advertising and accepting MOVE, so clients must fall back
+// to COPY and EXPUNGE.
+func (s *Server) DisableMove() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.noMove = true
+}
+
+// CreateMailbox adds an empty mailbox. Names are in their wire form.
+func (s *Server) CreateMailbox(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.mailboxes[name]; !ok {
+		s.mailboxes[name] = nil
+	}
+}
+
+// Append adds a message to mailbox with the given flags and returns its UID.
+func (s *Server) Append(mailbox string, data []byte, flags ...string) uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uid := s.nextUID
+	s.nextUID++
+	s.mailboxes[mailbox] = append(s.mailboxes[mailbox], &Message{UID: uid, Flags: flags, Data: data})
+	return uid
+}
+
+// Messages returns a copy of the messages in mailbox.
+func (s *Server) Messages(mailbox string) []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []Message
+	for _, m := range s.mailboxes[mailbox] {
+		out = append(out, Message{UID: m.UID, Flags: slices.Clone(m.Flags), Data: m.Data})
+	}
+	return out
+}
+
+// Logins returns how many successful LOGIN commands the server has seen.
+func (s *Server) Logins() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.logins
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serve(conn)
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// session is the state of one connection.
+type session struct {
+	s        *Server
+	r        *bufio.Reader
+	w        *bufio.Writer
+	authed   bool
+	selected string
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+	ss := &session{s: s, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+	ss.untagged("OK imaptest ready")
+	ss.w.Flush()
+	for {
+		args, err := ss.readCommand()
+		if err != nil {
+			return
+		}
+		if len(args) < 2 {
+			ss.reply("*", "BAD", "missing command")
+			ss.w.Flush()
+			continue
+		}
+		tag, cmd := args[0], strings.ToUpper(args[1])
+		args = args[2:]
+		if cmd == "UID" && len(args) > 0 {
+			cmd += " " + strings.ToUpper(args[0])
+			args = args[1:]
+		}
+		s.mu.Lock()
+		status, text, done := ss.handle(cmd, args)
+		s.mu.Unlock()
+		ss.reply(tag, status, text)
+		ss.w.Flush()
+		if done {
+			return
+		}
+	}
+}
+
+// handle runs one command with the server locked and returns the tagged
+// status and text.
+func (ss *session) handle(cmd string, args []string) (status, text string, done bool) {
+	s := ss.s
+	switch cmd {
+	case "CAPABILITY":
+		caps := "IMAP4rev1 UIDPLUS"
+		if !s.noMove {
+			caps += " MOVE"
+		}
+		ss.untagged("CAPABILITY " + caps)
+		return "OK", "CAPABILITY completed", false
+	case "NOOP":
+		return "OK", "NOOP completed", false
+	case "LOGOUT":
+		ss.untagged("BYE logging out")
+		return "OK", "LOGOUT completed", true
+	case "LOGIN":
+		if len(args) != 2 || args[0] != s.username || args[1] != s.password {
+			return "NO", "[AUTHENTICATIONFAILED] invalid credentials", false
+		}
+		ss.authed = true
+		s.logins++
+		return "OK", "LOGIN completed", false
+	}
+
+	if !ss.authed {
+		return "NO", "not authenticated", false
+	}
+	switch cmd {
+	case "SELECT", "EXAMINE":
+		if len(args) != 1 {
+			return "BAD", "expected a mailbox", false
+		}
+		msgs, ok := s.mailboxes[args[0]]
+		if !ok {
+			return "NO", "[NONEXISTENT] no such mailbox", false
+		}
+		ss.selected = args[0]
+		ss.untagged(fmt.Sprintf("%d EXISTS", len(msgs)))
+		return "OK", "[READ-WRITE] SELECT completed", false
+	case "STATUS":
+		if len(args) != 2 {
+			return "BAD", "expected a mailbox and items", false
+		}
+		msgs, ok := s.mailboxes[args[0]]
+		if !ok {
+			return "NO", "[NONEXISTENT] no such mailbox", false
+		}
+		ss.untagged(fmt.Sprintf("STATUS %s (MESSAGES %d)", quote(args[0]), len(msgs)))
+		return "OK", "STATUS completed", false
+	}
+
+	if ss.selected == "" {
+		return "BAD", "no mailbox selected", false
+	}
+	msgs := s.mailboxes[ss.selected]
+	switch cmd {
+	case "UID SEARCH":
+		if len(args) != 1 || !strings.EqualFold(args[0], "UNSEEN") {
+			return "BAD", "only UNSEEN is supported", false
+		}
+		var uids []string
+		for _, m := range msgs {
+			if !slices.Contains(m.Flags, `\Seen`) {
+				uids = append(uids, strconv.FormatUint(uint64(m.UID), 10))
+			}
+		}
+		ss.untagged(strings.TrimSpace("SEARCH " + strings.Join(uids, " ")))
+		return "OK", "SEARCH completed", false
+	case "UID FETCH":
+		if len(args) != 2 || !strings.EqualFold(args[1], "(BODY.PEEK[])") {
+			return "BAD", "only BODY.PEEK[] is supported", false
+		}
+		if i, m := find(msgs, args[0]); m != nil {
+			ss.untagged(fmt.Sprintf("%d FETCH (UID %d BODY[] {%d}", i+1, m.UID, len(m.Data)))
+			ss.w.Write(m.Data)
+			ss.w.WriteString(")\r\n")
+		}
+		return "OK", "FETCH completed", false
+	case "UID STORE":
+		if len(args) != 3 || !strings.EqualFold(args[1], "+FLAGS.SILENT") {
+			return "BAD", "only +FLAGS.SILENT is supported", false
+		}
+		if _, m := find(msgs, args[0]); m != nil {
+			for _, flag := range strings.Fields(strings.Trim(args[2], "()")) {
+				if !slices.Contains(m.Flags, flag) {
+					m.Flags = append(m.Flags, flag)
+				}
+			}
+		}
+		return "OK", "STORE completed", false
+	case "UID COPY", "UID MOVE":
+		if cmd == "UID MOVE" && s.noMove {
+			return "BAD", "unknown command", false
+		}
+		if len(args) != 2 {
+			return "BAD", "expected a UID and a mailbox", false
+		}
+		if _, ok := s.mailboxes[args[1]]; !ok {
+			return "NO", "[TRYCREATE] no such mailbox", false
+		}
+		i, m := find(msgs, args[0])
+		if m == nil {
+			return "OK", "nothing to do", false
+		}
+		flags := slices.DeleteFunc(slices.Clone(m.Flags), func(f string) bool { return f == `\Deleted` })
+		s.mailboxes[args[1]] = append(s.mailboxes[args[1]], &Message{UID: s.nextUID, Flags: flags, Data: m.Data})
+		s.nextUID++
+		if cmd == "UID MOVE" {
+			s.mailboxes[ss.selected] = slices.Delete(msgs, i, i+1)
+			ss.untagged(fmt.Sprintf("%d EXPUNGE", i+1))
+		}
+		return "OK", cmd[len("UID "):] + " completed", false
+	case "EXPUNGE", "UID EXPUNGE":
+		only := ""
+		if cmd == "UID EXPUNGE" {
+			if len(args) != 1 {
+				return "BAD", "expected a UID", false
+			}
+			only = args[0]
+		}
+		var kept []*Message
+		for _, m := range msgs {
+			if slices.Contains(m.Flags, `\Deleted`) && (only == "" || only == strconv.FormatUint(uint64(m.UID), 10)) {
+				// Sequence numbers shift down after each expunge
+				ss.untagged(fmt.Sprintf("%d EXPUNGE", len(kept)+1))
+				continue
+			}
+			kept = append(kept, m)
+		}
+		s.mailboxes[ss.selected] = kept
+		return "OK", "EXPUNGE completed", false
+	}
+	return "BAD", "unknown command", false
+}
+
+func find(msgs []*Message, uid string) (int, *Message) {
+	for i, m := range msgs {
+		if strconv.FormatUint(uint64(m.UID), 10) == uid {
+			return i, m
+		}
+	}
+	return -1, nil
+}
+
+func (ss *session) untagged(text string) {
+	ss.w.WriteString("* " + text + "\r\n")
+}
+
+func (ss *session) reply(tag, status, text string) {
+	ss.w.WriteString(tag + " " + status + " " + text + "\r\n")
+}
+
+// readCommand reads one command line, accepting literals, and splits it into
+// atoms, unquoted strings and parenthesised lists.
+func (ss *session) readCommand() ([]string, error) {
+	var args []string
+	for {
+		line, err := ss.r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		size := -1
+		if strings.HasSuffix(line, "}") {
+			if open := strings.LastIndexByte(line, '{'); open >= 0 {
+				if n, err := strconv.Atoi(line[open+1 : len(line)-1]); err == nil {
+					size, line = n, line[:open]
+				}
+			}
+		}
+		args = append(args, split(line)...)
+		if size < 0 {
+			return args, nil
+		}
+		ss.w.WriteString("+ Ready for literal\r\n")
+		ss.w.Flush()
+		data := make([]byte, size)
+		if _, err := io.ReadFull(ss.r, data); err != nil {
+			return nil, err
+		}
+		args = append(args, string(data))
+	}
+}
+
+func split(line string) []string {
+	var args []string
+	for i := 0; i < len(line); {
+		switch c := line[i]; {
+		case c == ' ':
+			i++
+		case c == '"':
+			var b strings.Builder
+			for i++; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) {
+					i++
+				}
+				b.WriteByte(line[i])
+			}
+			args = append(args, b.String())
+			i++
+		case c == '(':
+			end := strings.IndexByte(line[i:], ')')
+			if end < 0 {
+				end = len(line) - i - 1
+			}
+			args = append(args, line[i:i+end+1])
+			i += end + 1
+		default:
+			end := strings.IndexByte(line[i:], ' ')
+			if end < 0 {
+				end = len(line) - i
+			}
+			args = append(args, line[i:i+end])
+			i += end
+		}
+	}
+	return args
+}
+
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
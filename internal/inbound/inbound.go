@@ -0,0 +1,112 @@
+// ABOUTME: Adapters for email providers' inbound webhooks: Mailgun, Postmark, SendGrid Inbound Parse and Amazon SES via SNS.
+// ABOUTME: Each verifies the provider's signature and turns its payload into the raw RFC 822 message that newsletter ingestion takes.
+package inbound
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var (
+	// ErrNotConfigured means the provider's key or topic has not been set, so
+	// no request can be verified.
+	ErrNotConfigured = errors.New("provider webhook not configured")
+	// ErrUnauthorized means the request's signature or credentials did not verify.
+	ErrUnauthorized = errors.New("webhook signature did not verify")
+	// ErrInvalid means the payload is not in the provider's format.
+	ErrInvalid = errors.New("invalid webhook payload")
+	// ErrNoMessage means the request was valid but carried no email, such as
+	// an SNS subscription confirmation.
+	ErrNoMessage = errors.New("webhook carried no message")
+)
+
+// MaxClockSkew is how far a signed timestamp may be from the current time.
+// Older requests are refused so a captured post cannot be replayed later.
+const MaxClockSkew = 5 * time.Minute
+
+// Providers lists the provider names accepted in webhook URLs.
+var Providers = []string{"mailgun", "postmark", "sendgrid", "ses"}
+
+// Provider turns one provider's inbound webhook request into an email.
+type Provider interface {
+	// Parse verifies r, whose body has already been read into body, and
+	// returns the raw RFC 822 message it carries.
+	Parse(r *http.Request, body []byte) ([]byte, error)
+}
+
+// fresh reports whether signedAt is within MaxClockSkew of the time now
+// returns, or of the current time when now is nil.
+func fresh(signedAt time.Time, now func() time.Time) bool {
+	current := time.Now()
+	if now != nil {
+		current = now()
+	}
+	return current.Sub(signedAt).Abs() <= MaxClockSkew
+}
+
+// parseUnix reads a timestamp given in seconds since the epoch.
+func parseUnix(s string) (time.Time, bool) {
+	secs, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(secs, 0), true
+}
+
+// form is a decoded multipart or URL-encoded form body.
+type form struct {
+	values url.Values
+	files  map[string][]*multipart.FileHeader
+}
+
+// readForm decodes body as the form described by r's Content-Type.
+func readForm(r *http.Request, body []byte) (*form, error) {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: Content-Type: %w", ErrInvalid, err)
+	}
+	switch mediaType {
+	case "multipart/form-data":
+		// The body is already in memory, so keep every part there too
+		mf, err := multipart.NewReader(bytes.NewReader(body), params["boundary"]).ReadForm(int64(len(body)))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalid, err)
+		}
+		return &form{values: mf.Value, files: mf.File}, nil
+	case "application/x-www-form-urlencoded":
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalid, err)
+		}
+		return &form{values: values}, nil
+	default:
+		return nil, fmt.Errorf("%w: unexpected Content-Type %s", ErrInvalid, mediaType)
+	}
+}
+
+func (f *form) value(key string) string {
+	return f.values.Get(key)
+}
+
+// file returns the contents of the uploaded file named key, if any.
+func (f *form) file(key string) (*multipart.FileHeader, []byte, error) {
+	if len(f.files[key]) == 0 {
+		return nil, nil, nil
+	}
+	fh := f.files[key][0]
+	file, err := fh.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	return fh, data, err
+}
@@ -0,0 +1,107 @@
+// ABOUTME: Mailgun inbound routes: verifies the HMAC signature and reads the forwarded message.
+// ABOUTME: Uses body-mime when the route forwards to a URL ending in "mime", otherwise rebuilds the parsed message.
+package inbound
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Mailgun accepts messages forwarded by a Mailgun route. Every post is signed
+// with the account's HTTP webhook signing key.
+type Mailgun struct {
+	SigningKey string
+	// Seen records a signed token until expires and reports whether it had
+	// already been recorded, so a captured post cannot be sent again.
+	Seen func(token string, expires time.Time) bool
+	// Now returns the current time; nil means time.Now.
+	Now func() time.Time
+}
+
+// Parse implements Provider.
+func (m *Mailgun) Parse(r *http.Request, body []byte) ([]byte, error) {
+	if m.SigningKey == "" {
+		return nil, ErrNotConfigured
+	}
+	f, err := readForm(r, body)
+	if err != nil {
+		return nil, err
+	}
+	if !m.verify(f.value("timestamp"), f.value("token"), f.value("signature")) {
+		return nil, ErrUnauthorized
+	}
+	if raw := f.value("body-mime"); raw != "" {
+		return []byte(raw), nil
+	}
+
+	// A parsed post: rebuild the message from its headers, bodies and files
+	var pairs [][2]string
+	if err := json.Unmarshal([]byte(f.value("message-headers")), &pairs); err != nil {
+		return nil, fmt.Errorf("%w: message-headers: %w", ErrInvalid, err)
+	}
+	headers := make([]headerField, len(pairs))
+	for i, p := range pairs {
+		headers[i] = headerField{Name: p[0], Value: p[1]}
+	}
+
+	// content-id-map maps "<cid>" to the attachment field holding it
+	contentIDs := make(map[string]string)
+	if cidMap := f.value("content-id-map"); cidMap != "" {
+		var byCID map[string]string
+		if err := json.Unmarshal([]byte(cidMap), &byCID); err != nil {
+			return nil, fmt.Errorf("%w: content-id-map: %w", ErrInvalid, err)
+		}
+		for cid, field := range byCID {
+			contentIDs[field] = strings.Trim(cid, "<>")
+		}
+	}
+	count, _ := strconv.Atoi(f.value("attachment-count"))
+	var attachments []attachment
+	for i := 1; i <= count; i++ {
+		field := "attachment-" + strconv.Itoa(i)
+		fh, data, err := f.file(field)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", ErrInvalid, field, err)
+		}
+		if fh == nil {
+			continue
+		}
+		attachments = append(attachments, attachment{
+			Filename:    fh.Filename,
+			ContentType: fh.Header.Get("Content-Type"),
+			ContentID:   contentIDs[field],
+			Data:        data,
+		})
+	}
+	return buildMessage(headers, f.value("body-plain"), f.value("body-html"), attachments), nil
+}
+
+// verify checks Mailgun's signature, the hex HMAC-SHA256 of timestamp and
+// token under the signing key, and that the timestamp is recent and the
+// token has not been used before.
+func (m *Mailgun) verify(timestamp, token, signature string) bool {
+	if timestamp == "" || token == "" {
+		return false
+	}
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(m.SigningKey))
+	mac.Write([]byte(timestamp + token))
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return false
+	}
+	signedAt, ok := parseUnix(timestamp)
+	if !ok || !fresh(signedAt, m.Now) {
+		return false
+	}
+	return m.Seen == nil || !m.Seen(token, signedAt.Add(MaxClockSkew))
+}
@@ -0,0 +1,122 @@
+// ABOUTME: Rebuilds an RFC 822 message from the parsed fields some providers post instead of the raw email.
+// ABOUTME: Text and HTML become a multipart/alternative; attachments keep their Content-IDs so inline images resolve.
+package inbound
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+	"unicode/utf8"
+)
+
+// headerField is one header of a rebuilt message, in its original order.
+type headerField struct {
+	Name  string
+	Value string
+}
+
+// attachment is a file posted alongside a parsed message.
+type attachment struct {
+	Filename    string
+	ContentType string
+	ContentID   string // without angle brackets; empty for regular attachments
+	Data        []byte
+}
+
+// addressHeaders may carry UTF-8 display names as they are; other headers
+// with non-ASCII text are encoded.
+var addressHeaders = map[string]bool{"From": true, "To": true, "Cc": true, "Reply-To": true, "Sender": true}
+
+// buildMessage assembles a message from headers, bodies and attachments. The
+// headers' own Content-Type and transfer encoding describe the original
+// message's structure, not this one, so they are dropped.
+func buildMessage(headers []headerField, text, html string, attachments []attachment) []byte {
+	var buf bytes.Buffer
+	for _, h := range headers {
+		name := textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(h.Name))
+		if name == "" || name == "Mime-Version" || strings.HasPrefix(name, "Content-") {
+			continue
+		}
+		value := strings.Join(strings.Fields(h.Value), " ")
+		if value == "" {
+			continue
+		}
+		if !addressHeaders[name] && !isASCII(value) {
+			value = mime.QEncoding.Encode("utf-8", value)
+		}
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+	}
+
+	mixed := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=%q\r\n\r\n", mixed.Boundary())
+
+	var alt bytes.Buffer
+	altWriter := multipart.NewWriter(&alt)
+	for _, body := range []struct{ mediaType, content string }{{"text/plain", text}, {"text/html", html}} {
+		if body.content == "" {
+			continue
+		}
+		w, _ := altWriter.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {body.mediaType + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		qp := quotedprintable.NewWriter(w)
+		qp.Write([]byte(body.content))
+		qp.Close()
+	}
+	altWriter.Close()
+	w, _ := mixed.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": altWriter.Boundary()})},
+	})
+	w.Write(alt.Bytes())
+
+	for _, a := range attachments {
+		contentType := a.ContentType
+		if _, _, err := mime.ParseMediaType(contentType); err != nil {
+			contentType = "application/octet-stream"
+		}
+		disposition := "attachment"
+		header := textproto.MIMEHeader{"Content-Transfer-Encoding": {"base64"}}
+		if a.ContentID != "" {
+			disposition = "inline"
+			header.Set("Content-ID", "<"+strings.Trim(a.ContentID, "<>")+">")
+		}
+		if a.Filename != "" {
+			mediaType, params, _ := mime.ParseMediaType(contentType)
+			params["name"] = a.Filename
+			contentType = mime.FormatMediaType(mediaType, params)
+			disposition = mime.FormatMediaType(disposition, map[string]string{"filename": a.Filename})
+		}
+		header.Set("Content-Type", contentType)
+		header.Set("Content-Disposition", disposition)
+		w, _ := mixed.CreatePart(header)
+		writeBase64Lines(w, a.Data)
+	}
+	mixed.Close()
+	return buf.Bytes()
+}
+
+// writeBase64Lines writes data as base64 in 76-character lines.
+func writeBase64Lines(w io.Writer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		w.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	w.Write([]byte(encoded + "\r\n"))
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+// ABOUTME: Postmark inbound webhooks: checks the basic-auth credentials in the webhook URL and reads the JSON payload.
+// ABOUTME: Uses RawEmail when the server includes it, otherwise rebuilds the message from the parsed fields.
+package inbound
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/textproto"
+)
+
+// Postmark accepts Postmark's inbound webhook. Postmark does not sign
+// webhooks; it sends the basic-auth credentials embedded in the webhook URL,
+// so the password must be one of Secrets: the webhook secret, and the
+// previous one while a rotation is in its grace period.
+type Postmark struct {
+	Secrets []string
+}
+
+type postmarkAddress struct {
+	Email string
+	Name  string
+}
+
+type postmarkPayload struct {
+	FromFull postmarkAddress
+	To       string
+	Cc       string
+	Subject  string
+	Date     string
+	TextBody string
+	HtmlBody string
+	Headers  []struct {
+		Name  string
+		Value string
+	}
+	Attachments []struct {
+		Name        string
+		Content     string // base64
+		ContentType string
+		ContentID   string
+	}
+	RawEmail string // only with "Include raw email content" turned on
+}
+
+// Parse implements Provider.
+func (p *Postmark) Parse(r *http.Request, body []byte) ([]byte, error) {
+	if len(p.Secrets) == 0 {
+		return nil, ErrNotConfigured
+	}
+	_, password, ok := r.BasicAuth()
+	if !ok || !p.authorized(password) {
+		return nil, ErrUnauthorized
+	}
+
+	var payload postmarkPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	if payload.RawEmail != "" {
+		return []byte(payload.RawEmail), nil
+	}
+	if payload.FromFull.Email == "" {
+		return nil, fmt.Errorf("%w: no sender", ErrInvalid)
+	}
+
+	from := (&mail.Address{Name: payload.FromFull.Name, Address: payload.FromFull.Email}).String()
+	headers := []headerField{{"From", from}, {"To", payload.To}, {"Subject", payload.Subject}, {"Date", payload.Date}}
+	if payload.Cc != "" {
+		headers = append(headers, headerField{"Cc", payload.Cc})
+	}
+	for _, h := range payload.Headers {
+		switch textproto.CanonicalMIMEHeaderKey(h.Name) {
+		case "From", "To", "Cc", "Subject", "Date":
+			// Already taken from the decoded top-level fields
+		default:
+			headers = append(headers, headerField{h.Name, h.Value})
+		}
+	}
+	attachments := make([]attachment, 0, len(payload.Attachments))
+	for _, a := range payload.Attachments {
+		data, err := base64.StdEncoding.DecodeString(a.Content)
+		if err != nil {
+			return nil, fmt.Errorf("%w: attachment %s: %w", ErrInvalid, a.Name, err)
+		}
+		attachments = append(attachments, attachment{Filename: a.Name, ContentType: a.ContentType, ContentID: a.ContentID, Data: data})
+	}
+	return buildMessage(headers, payload.TextBody, payload.HtmlBody, attachments), nil
+}
+
+// authorized compares password with every secret in constant time.
+func (p *Postmark) authorized(password string) bool {
+	matched := 0
+	for _, secret := range p.Secrets {
+		matched |= subtle.ConstantTimeCompare([]byte(password), []byte(secret))
+	}
+	return matched == 1
+}
@@ -0,0 +1,265 @@
+// ABOUTME: Amazon SES receipt rules with an SNS action: verifies the SNS message signature and topic, then decodes the email.
+// ABOUTME: Confirms the SNS subscription on first contact; SNSClient fetches and caches the signing certificates.
+package inbound
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	_ "crypto/sha1" // SNS signature version 1
+	_ "crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// snsTimeout bounds fetching a signing certificate or confirming a subscription.
+const snsTimeout = 15 * time.Second
+
+// snsHost matches the SNS endpoints certificates and subscription links may
+// come from; anything else could be an attacker's certificate.
+var snsHost = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+// SES accepts SNS notifications from an SES receipt rule's SNS action. Only
+// messages signed by SNS for one of TopicARNs are accepted.
+type SES struct {
+	TopicARNs []string
+	// Certificate returns the certificate at an SNS SigningCertURL.
+	Certificate func(ctx context.Context, certURL string) (*x509.Certificate, error)
+	// Confirm visits a subscription's SubscribeURL.
+	Confirm func(ctx context.Context, subscribeURL string) error
+	// Now returns the current time; nil means time.Now.
+	Now func() time.Time
+}
+
+// snsMessage is the envelope SNS posts over HTTP.
+type snsMessage struct {
+	Type             string
+	MessageId        string
+	Token            string
+	TopicArn         string
+	Subject          *string // signed only when present
+	Message          string
+	Timestamp        string
+	SignatureVersion string
+	Signature        string
+	SigningCertURL   string
+	SubscribeURL     string
+}
+
+// sesNotification is the SES notification inside an SNS message.
+type sesNotification struct {
+	NotificationType string `json:"notificationType"`
+	Receipt          struct {
+		Action struct {
+			Type     string `json:"type"`
+			Encoding string `json:"encoding"`
+		} `json:"action"`
+	} `json:"receipt"`
+	Content string `json:"content"`
+}
+
+// Parse implements Provider.
+func (s *SES) Parse(r *http.Request, body []byte) ([]byte, error) {
+	if len(s.TopicARNs) == 0 {
+		return nil, ErrNotConfigured
+	}
+	var msg snsMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	if !slices.Contains(s.TopicARNs, msg.TopicArn) {
+		return nil, fmt.Errorf("%w: unexpected topic %q", ErrUnauthorized, msg.TopicArn)
+	}
+	if err := s.verify(r.Context(), &msg); err != nil {
+		return nil, err
+	}
+
+	switch msg.Type {
+	case "SubscriptionConfirmation":
+		if err := s.Confirm(r.Context(), msg.SubscribeURL); err != nil {
+			return nil, fmt.Errorf("confirm SNS subscription: %w", err)
+		}
+		return nil, ErrNoMessage
+	case "UnsubscribeConfirmation":
+		return nil, ErrNoMessage
+	case "Notification":
+	default:
+		return nil, fmt.Errorf("%w: SNS message type %q", ErrInvalid, msg.Type)
+	}
+
+	var n sesNotification
+	if err := json.Unmarshal([]byte(msg.Message), &n); err != nil {
+		return nil, fmt.Errorf("%w: SES notification: %w", ErrInvalid, err)
+	}
+	if n.NotificationType != "Received" {
+		// Such as the setup notification SES sends when the rule is created
+		return nil, ErrNoMessage
+	}
+	if n.Content == "" {
+		return nil, fmt.Errorf("%w: SES notification has no content; use an SNS action, not S3", ErrInvalid)
+	}
+	if strings.EqualFold(n.Receipt.Action.Encoding, "BASE64") {
+		raw, err := base64.StdEncoding.DecodeString(n.Content)
+		if err != nil {
+			return nil, fmt.Errorf("%w: content: %w", ErrInvalid, err)
+		}
+		return raw, nil
+	}
+	return []byte(n.Content), nil
+}
+
+// verify checks the SNS signature over the message's canonical string.
+func (s *SES) verify(ctx context.Context, msg *snsMessage) error {
+	var hash crypto.Hash
+	switch msg.SignatureVersion {
+	case "1":
+		hash = crypto.SHA1
+	case "2":
+		hash = crypto.SHA256
+	default:
+		return fmt.Errorf("%w: SNS signature version %q", ErrUnauthorized, msg.SignatureVersion)
+	}
+	sig, err := base64.StdEncoding.DecodeString(msg.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: signature: %w", ErrUnauthorized, err)
+	}
+	cert, err := s.Certificate(ctx, msg.SigningCertURL)
+	if err != nil {
+		return fmt.Errorf("%w: signing certificate: %w", ErrUnauthorized, err)
+	}
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("%w: signing certificate does not hold an RSA key", ErrUnauthorized)
+	}
+
+	h := hash.New()
+	h.Write([]byte(snsStringToSign(msg)))
+	if err := rsa.VerifyPKCS1v15(key, hash, h.Sum(nil), sig); err != nil {
+		return fmt.Errorf("%w: %w", ErrUnauthorized, err)
+	}
+	signedAt, err := time.Parse(time.RFC3339Nano, msg.Timestamp)
+	if err != nil {
+		return fmt.Errorf("%w: timestamp: %w", ErrUnauthorized, err)
+	}
+	if !fresh(signedAt, s.Now) {
+		return fmt.Errorf("%w: message sent at %s is too old", ErrUnauthorized, msg.Timestamp)
+	}
+	return nil
+}
+
+// snsStringToSign builds the text SNS signs: selected fields as name and
+// value lines, in byte order of the names.
+func snsStringToSign(msg *snsMessage) string {
+	fields := [][2]string{{"Message", msg.Message}, {"MessageId", msg.MessageId}}
+	if msg.Type == "Notification" {
+		if msg.Subject != nil {
+			fields = append(fields, [2]string{"Subject", *msg.Subject})
+		}
+	} else {
+		fields = append(fields, [2]string{"SubscribeURL", msg.SubscribeURL})
+	}
+	fields = append(fields, [2]string{"Timestamp", msg.Timestamp})
+	if msg.Type != "Notification" {
+		fields = append(fields, [2]string{"Token", msg.Token})
+	}
+	fields = append(fields, [2]string{"TopicArn", msg.TopicArn}, [2]string{"Type", msg.Type})
+
+	var b strings.Builder
+	for _, f := range fields {
+		b.WriteString(f[0] + "\n" + f[1] + "\n")
+	}
+	return b.String()
+}
+
+// SNSClient fetches SNS signing certificates, caching them by URL, and
+// confirms subscriptions. It only contacts SNS endpoints over HTTPS.
+type SNSClient struct {
+	client *http.Client
+	mu     sync.Mutex
+	certs  map[string]*x509.Certificate
+}
+
+// NewSNSClient returns an SNSClient making requests with client.
+func NewSNSClient(client *http.Client) *SNSClient {
+	return &SNSClient{client: client, certs: make(map[string]*x509.Certificate)}
+}
+
+// Certificate returns the PEM certificate at certURL.
+func (c *SNSClient) Certificate(ctx context.Context, certURL string) (*x509.Certificate, error) {
+	if err := checkSNSURL(certURL); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	cert, ok := c.certs[certURL]
+	c.mu.Unlock()
+	if ok {
+		return cert, nil
+	}
+
+	data, err := c.get(ctx, certURL)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM certificate found")
+	}
+	cert, err = x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.certs[certURL] = cert
+	c.mu.Unlock()
+	return cert, nil
+}
+
+// Confirm visits subscribeURL, which completes an SNS subscription.
+func (c *SNSClient) Confirm(ctx context.Context, subscribeURL string) error {
+	if err := checkSNSURL(subscribeURL); err != nil {
+		return err
+	}
+	_, err := c.get(ctx, subscribeURL)
+	return err
+}
+
+func (c *SNSClient) get(ctx context.Context, rawURL string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, snsTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", rawURL, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+func checkSNSURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" || !snsHost.MatchString(u.Hostname()) {
+		return fmt.Errorf("%q is not an SNS endpoint", rawURL)
+	}
+	return nil
+}
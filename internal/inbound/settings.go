@@ -0,0 +1,76 @@
+// ABOUTME: Persists the keys and topics used to verify provider webhooks in the settings table.
+// ABOUTME: Postmark needs none of its own; it authenticates with the newsletter webhook secret.
+package inbound
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/storage"
+)
+
+// Setting keys used to persist provider verification.
+const (
+	keyMailgunSigningKey       = "mailgun_signing_key"
+	keySendGridVerificationKey = "sendgrid_verification_key"
+	keySESTopicARNs            = "ses_topic_arns"
+)
+
+// Settings holds what each provider's requests are verified with. An empty
+// field leaves that provider's webhook turned off.
+type Settings struct {
+	MailgunSigningKey       string
+	SendGridVerificationKey string   // as shown by SendGrid; see ParseSendGridKey
+	SESTopicARNs            []string // SNS topics the SES receipt rule publishes to
+}
+
+// LoadSettings reads the provider settings.
+func LoadSettings(db *storage.Database) (Settings, error) {
+	var s Settings
+	var err error
+	if s.MailgunSigningKey, err = db.GetSetting(keyMailgunSigningKey); err != nil {
+		return s, err
+	}
+	if s.SendGridVerificationKey, err = db.GetSetting(keySendGridVerificationKey); err != nil {
+		return s, err
+	}
+	arns, err := db.GetSetting(keySESTopicARNs)
+	if err != nil {
+		return s, err
+	}
+	s.SESTopicARNs = splitList(arns)
+	return s, nil
+}
+
+// SaveSettings stores the provider settings.
+func SaveSettings(db *storage.Database, s Settings) error {
+	values := map[string]string{
+		keyMailgunSigningKey:       s.MailgunSigningKey,
+		keySendGridVerificationKey: s.SendGridVerificationKey,
+		keySESTopicARNs:            strings.Join(s.SESTopicARNs, ","),
+	}
+	for key, value := range values {
+		if err := db.SetSetting(key, value); err != nil {
+			return fmt.Errorf("store %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// ParseTopicARNs splits a comma- or space-separated list of SNS topic ARNs,
+// rejecting anything that is not one.
+func ParseTopicARNs(s string) ([]string, error) {
+	arns := splitList(s)
+	for _, arn := range arns {
+		if parts := strings.Split(arn, ":"); len(parts) != 6 || parts[0] != "arn" || parts[2] != "sns" {
+			return nil, fmt.Errorf("%q is not an SNS topic ARN", arn)
+		}
+	}
+	return arns, nil
+}
+
+func splitList(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n' || r == '\t' || r == '\r'
+	})
+}
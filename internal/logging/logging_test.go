@@ -0,0 +1,76 @@
+// ABOUTME: Tests for logger construction and context propagation.
+// ABOUTME: Covers text/JSON formats, level filtering and request IDs.
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestNewJSONRespectsLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "json", "warn")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	logger.Info("hidden")
+	logger.Warn("shown", "blog_id", 7)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("got %d lines, want 1:\n%s", len(lines), buf.String())
+	}
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("output is not JSON: %v", err)
+	}
+	if entry["msg"] != "shown" || entry["blog_id"] != float64(7) {
+		t.Errorf("entry = %v", entry)
+	}
+}
+
+func TestNewText(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "text", "")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	logger.Info("hello", "k", "v")
+	if !strings.Contains(buf.String(), `msg=hello k=v`) {
+		t.Errorf("text output = %q", buf.String())
+	}
+}
+
+func TestNewRejectsUnknownSettings(t *testing.T) {
+	if _, err := New(&bytes.Buffer{}, "xml", "info"); err == nil {
+		t.Error("expected error for unknown format")
+	}
+	if _, err := New(&bytes.Buffer{}, "text", "loud"); err == nil {
+		t.Error("expected error for unknown level")
+	}
+}
+
+func TestContextLogger(t *testing.T) {
+	if FromContext(context.Background()) != slog.Default() {
+		t.Error("FromContext without a logger should return slog.Default()")
+	}
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil)).With("request_id", "abc")
+	ctx := WithLogger(context.Background(), logger)
+	FromContext(ctx).Info("scoped")
+	if !strings.Contains(buf.String(), "request_id=abc") {
+		t.Errorf("context logger lost its attributes: %q", buf.String())
+	}
+}
+
+func TestNewRequestID(t *testing.T) {
+	a, b := NewRequestID(), NewRequestID()
+	if len(a) != 16 || a == b {
+		t.Errorf("request IDs %q and %q should be distinct 16-char strings", a, b)
+	}
+}
@@ -0,0 +1,59 @@
+// ABOUTME: The metrics BlogWatcher exports, registered in the Default registry.
+// ABOUTME: Scanner, storage, server and newsletter code record into these directly.
+package metrics
+
+import "time"
+
+var (
+	// Scans counts blog scans by blog name and result ("success" or "failure").
+	Scans = NewCounterVec("blogwatcher_scans_total",
+		"Blog scans by blog and result.", "blog", "result")
+	// ScanDuration measures how long each blog scan took.
+	ScanDuration = NewHistogramVec("blogwatcher_scan_duration_seconds",
+		"Time spent scanning one blog.", []float64{.25, .5, 1, 2.5, 5, 10, 30, 60, 120}, "blog")
+	// ScanArticlesFound counts articles seen in feeds or scraped pages.
+	ScanArticlesFound = NewCounterVec("blogwatcher_scan_articles_found_total",
+		"Articles found while scanning, including already known ones.", "blog")
+	// ScanArticlesNew counts articles added to the database by scans.
+	ScanArticlesNew = NewCounterVec("blogwatcher_scan_articles_new_total",
+		"New articles stored by scans.", "blog")
+
+	// HTTPRequests counts requests by route pattern and status code.
+	HTTPRequests = NewCounterVec("blogwatcher_http_requests_total",
+		"HTTP requests by route and status code.", "route", "code")
+	// HTTPRequestDuration measures request latency by route pattern.
+	HTTPRequestDuration = NewHistogramVec("blogwatcher_http_request_duration_seconds",
+		"HTTP request latency by route.", DefBuckets, "route")
+
+	// NewsletterIngests counts inbound newsletter emails by source and result.
+	NewsletterIngests = NewCounterVec("blogwatcher_newsletter_ingests_total",
+		"Inbound newsletter emails by source and result.", "source", "result")
+
+	// Backups counts database backups by result ("success" or "failure").
+	Backups = NewCounterVec("blogwatcher_backups_total",
+		"Database backups by result.", "result")
+	// ArticlesPruned counts articles deleted by retention, by blog.
+	ArticlesPruned = NewCounterVec("blogwatcher_articles_pruned_total",
+		"Read articles deleted by retention policies.", "blog")
+
+	// DBQueryDuration measures SQL statement execution by kind
+	// (select, insert, update, delete, other).
+	DBQueryDuration = NewHistogramVec("blogwatcher_db_query_duration_seconds",
+		"Database statement execution time by statement kind.", DefBuckets, "statement")
+)
+
+func init() {
+	Default.MustRegister(
+		Scans, ScanDuration, ScanArticlesFound, ScanArticlesNew,
+		HTTPRequests, HTTPRequestDuration,
+		NewsletterIngests,
+		Backups,
+		ArticlesPruned,
+		DBQueryDuration,
+	)
+}
+
+// Since returns the seconds elapsed since start, for Observe calls.
+func Since(start time.Time) float64 {
+	return time.Since(start).Seconds()
+}
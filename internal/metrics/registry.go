@@ -0,0 +1,309 @@
+// ABOUTME: Minimal Prometheus-compatible counters, histograms and text exposition.
+// ABOUTME: Avoids pulling in the full client library for the handful of metrics we export.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the Prometheus text exposition format served by Handler.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefBuckets are histogram buckets in seconds suited to request and query latency.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Collector is a metric family that can write itself in text format.
+type Collector interface {
+	Name() string
+	write(w *bufio.Writer)
+}
+
+// Registry holds the collectors exposed together on one endpoint.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []Collector
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Default is the registry holding every metric defined in this package.
+var Default = NewRegistry()
+
+// MustRegister adds collectors to r, panicking on a duplicate name since
+// that is always a programming error.
+func (r *Registry) MustRegister(cs ...Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range cs {
+		for _, existing := range r.collectors {
+			if existing.Name() == c.Name() {
+				panic("metrics: duplicate metric " + c.Name())
+			}
+		}
+		r.collectors = append(r.collectors, c)
+	}
+}
+
+// Write renders every registered collector, sorted by name.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	cs := append([]Collector(nil), r.collectors...)
+	r.mu.Unlock()
+	sort.Slice(cs, func(i, j int) bool { return cs[i].Name() < cs[j].Name() })
+
+	bw := bufio.NewWriter(w)
+	for _, c := range cs {
+		c.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler serves the registry in the Prometheus text format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		if err := r.Write(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// family holds what every metric type shares: a name, help text, label
+// names and one series per distinct combination of label values.
+type family[T any] struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*series[T]
+}
+
+type series[T any] struct {
+	labelValues []string
+	value       T
+}
+
+func (f *family[T]) Name() string { return f.name }
+
+// get returns the series for labelValues, creating it with newValue.
+// Callers must hold f.mu.
+func (f *family[T]) get(labelValues []string, newValue func() T) *series[T] {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series[T]{labelValues: append([]string(nil), labelValues...), value: newValue()}
+		f.series[key] = s
+	}
+	return s
+}
+
+// sorted returns the series ordered by label values for stable output.
+// Callers must hold f.mu.
+func (f *family[T]) sorted() []*series[T] {
+	out := make([]*series[T], 0, len(f.series))
+	for _, s := range f.series {
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return strings.Join(out[i].labelValues, "\xff") < strings.Join(out[j].labelValues, "\xff")
+	})
+	return out
+}
+
+func (f *family[T]) writeHeader(w *bufio.Writer, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, typ)
+}
+
+// CounterVec is a set of monotonically increasing counters keyed by labels.
+type CounterVec struct {
+	family[float64]
+}
+
+// NewCounterVec creates a counter family. It is not registered anywhere.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{family[float64]{name: name, help: help, labels: labels, series: map[string]*series[float64]{}}}
+}
+
+// Inc adds one to the counter for labelValues.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v, which must not be negative, to the counter for labelValues.
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("metrics: counters cannot decrease")
+	}
+	c.mu.Lock()
+	c.get(labelValues, func() float64 { return 0 }).value += v
+	c.mu.Unlock()
+}
+
+// Value returns the current count for labelValues, for tests.
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.get(labelValues, func() float64 { return 0 }).value
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeHeader(w, "counter")
+	for _, s := range c.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, labelPairs(c.labels, s.labelValues, "", ""), formatFloat(s.value))
+	}
+}
+
+type histogramValue struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// HistogramVec is a set of histograms keyed by labels.
+type HistogramVec struct {
+	family[*histogramValue]
+	buckets []float64
+}
+
+// NewHistogramVec creates a histogram family with the given upper bounds,
+// which must be sorted. It is not registered anywhere.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{
+		family:  family[*histogramValue]{name: name, help: help, labels: labels, series: map[string]*series[*histogramValue]{}},
+		buckets: buckets,
+	}
+}
+
+// Observe records v in the histogram for labelValues.
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.get(labelValues, func() *histogramValue {
+		return &histogramValue{counts: make([]uint64, len(h.buckets))}
+	})
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.value.counts[i]++
+	}
+	s.value.count++
+	s.value.sum += v
+}
+
+// Count returns the number of observations for labelValues, for tests.
+func (h *HistogramVec) Count(labelValues ...string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.series[strings.Join(labelValues, "\xff")]; ok {
+		return s.value.count
+	}
+	return 0
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(w, "histogram")
+	for _, s := range h.sorted() {
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += s.value.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelPairs(h.labels, s.labelValues, "le", formatFloat(upper)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelPairs(h.labels, s.labelValues, "le", "+Inf"), s.value.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labelPairs(h.labels, s.labelValues, "", ""), formatFloat(s.value.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labelPairs(h.labels, s.labelValues, "", ""), s.value.count)
+	}
+}
+
+// Gauge is a single value computed when it is written, such as a row count.
+// Gauges are usually written directly with WriteGauge rather than registered.
+type Gauge struct {
+	name  string
+	help  string
+	value func() float64
+}
+
+// NewGaugeFunc creates a gauge whose value is read from fn at scrape time.
+func NewGaugeFunc(name, help string, fn func() float64) *Gauge {
+	return &Gauge{name: name, help: help, value: fn}
+}
+
+func (g *Gauge) Name() string { return g.name }
+
+func (g *Gauge) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, escapeHelp(g.help), g.name, g.name, formatFloat(g.value()))
+}
+
+// WriteGauge writes a single gauge sample, for values computed per scrape.
+func WriteGauge(w io.Writer, name, help string, value float64) error {
+	bw := bufio.NewWriter(w)
+	NewGaugeFunc(name, help, func() float64 { return value }).write(bw)
+	return bw.Flush()
+}
+
+// labelPairs renders {a="x",b="y"}, optionally with one extra pair such as
+// the histogram "le" bound. Returns "" when there are no labels.
+func labelPairs(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(values[i]))
+		b.WriteByte('"')
+	}
+	if extraName != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(extraName)
+		b.WriteString(`="`)
+		b.WriteString(extraValue)
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,98 @@
+// ABOUTME: Tests for the Prometheus text exposition of counters, histograms and gauges.
+// ABOUTME: Checks label escaping, cumulative buckets and stable ordering.
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCounterVecExposition(t *testing.T) {
+	reg := NewRegistry()
+	c := NewCounterVec("test_events_total", "Events seen.", "kind")
+	reg.MustRegister(c)
+
+	c.Inc("b")
+	c.Add(2, "a")
+	c.Inc(`quote"and\newline` + "\n")
+
+	var buf bytes.Buffer
+	if err := reg.Write(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	want := `# HELP test_events_total Events seen.
+# TYPE test_events_total counter
+test_events_total{kind="a"} 2
+test_events_total{kind="b"} 1
+test_events_total{kind="quote\"and\\newline\n"} 1
+`
+	if buf.String() != want {
+		t.Errorf("exposition =\n%s\nwant\n%s", buf.String(), want)
+	}
+	if got := c.Value("a"); got != 2 {
+		t.Errorf("Value(a) = %v, want 2", got)
+	}
+}
+
+func TestHistogramVecExposition(t *testing.T) {
+	reg := NewRegistry()
+	h := NewHistogramVec("test_latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+	reg.MustRegister(h)
+
+	h.Observe(0.05, "/x")
+	h.Observe(0.1, "/x")
+	h.Observe(0.5, "/x")
+	h.Observe(3, "/x")
+
+	var buf bytes.Buffer
+	if err := reg.Write(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	for _, want := range []string{
+		`test_latency_seconds_bucket{route="/x",le="0.1"} 2`,
+		`test_latency_seconds_bucket{route="/x",le="1"} 3`,
+		`test_latency_seconds_bucket{route="/x",le="+Inf"} 4`,
+		`test_latency_seconds_sum{route="/x"} 3.65`,
+		`test_latency_seconds_count{route="/x"} 4`,
+	} {
+		if !strings.Contains(buf.String(), want+"\n") {
+			t.Errorf("exposition missing %q:\n%s", want, buf.String())
+		}
+	}
+	if h.Count("/x") != 4 || h.Count("/y") != 0 {
+		t.Errorf("Count = %d, %d", h.Count("/x"), h.Count("/y"))
+	}
+}
+
+func TestWriteGauge(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteGauge(&buf, "test_items", "Items.", 42); err != nil {
+		t.Fatalf("WriteGauge: %v", err)
+	}
+	want := "# HELP test_items Items.\n# TYPE test_items gauge\ntest_items 42\n"
+	if buf.String() != want {
+		t.Errorf("gauge = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestMustRegisterRejectsDuplicates(t *testing.T) {
+	reg := NewRegistry()
+	reg.MustRegister(NewCounterVec("dup_total", "x"))
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic on duplicate registration")
+		}
+	}()
+	reg.MustRegister(NewCounterVec("dup_total", "y"))
+}
+
+func TestWrongLabelCountPanics(t *testing.T) {
+	c := NewCounterVec("labels_total", "x", "a", "b")
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for wrong label count")
+		}
+	}()
+	c.Inc("only-one")
+}
@@ -29,9 +29,61 @@ type Article struct {
 	PublishedDate  *time.Time
 	DiscoveredDate *time.Time
 	IsRead         bool
+	IsStarred      bool   // starred articles are never removed by retention
 	Content        string // HTML body for newsletter articles; empty for RSS/scraped
 }
 
+// NewsletterPart is an image or file carried by a newsletter email. Parts the
+// HTML body references by Content-ID are Inline; the rest are attachments.
+type NewsletterPart struct {
+	ID          int64
+	ArticleID   int64
+	ContentID   string // without angle brackets; empty when the part has none
+	Filename    string
+	ContentType string
+	Inline      bool
+	Size        int64
+	Data        []byte // only loaded when a single part is fetched
+}
+
+// NewsletterMeta is what a newsletter email says about itself beyond its body:
+// where to read it on the web and how to unsubscribe.
+type NewsletterMeta struct {
+	ArticleID         int64
+	WebURL            string // "view in browser" link from the body
+	UnsubscribeURL    string // http(s) link from List-Unsubscribe
+	UnsubscribeMailto string // mailto: link from List-Unsubscribe
+}
+
+// NewsletterAliasSender and NewsletterAliasListID are the kinds of
+// NewsletterAlias.
+const (
+	NewsletterAliasSender = "sender"
+	NewsletterAliasListID = "list_id"
+)
+
+// NewsletterAlias routes newsletters from a sender address, or carrying a
+// List-Id, to a newsletter blog, so a publication that mails from several
+// addresses stays one blog.
+type NewsletterAlias struct {
+	Kind     string // NewsletterAliasSender or NewsletterAliasListID
+	Value    string // lowercase address, or the List-Id without angle brackets
+	BlogID   int64
+	BlogName string
+}
+
+// QuarantinedNewsletter is an email held back by the sender rules or
+// authentication checks until it is approved or discarded.
+type QuarantinedNewsletter struct {
+	ID         int64
+	MessageID  string
+	Sender     string
+	Subject    string
+	Reason     string
+	ReceivedAt time.Time
+	Size       int64
+}
+
 // ArticleWithBlog extends Article with blog metadata for display in article cards.
 // Used when rendering article lists where blog name and favicon are needed.
 type ArticleWithBlog struct {
@@ -43,6 +95,7 @@ type ArticleWithBlog struct {
 	PublishedDate  *time.Time
 	DiscoveredDate *time.Time
 	IsRead         bool
+	IsStarred      bool
 	BlogName       string
 	BlogURL        string
 	Content        string // HTML body for newsletter articles; empty for RSS/scraped
@@ -53,11 +106,24 @@ type ArticleWithBlog struct {
 type SearchOptions struct {
 	SearchQuery string     // FTS5 search query (empty = skip FTS5)
 	IsRead      *bool      // nil = all, true = read only, false = unread only
+	Starred     *bool      // nil = all, true = starred only, false = unstarred only
 	BlogID      *int64     // nil = all blogs
 	DateFrom    *time.Time // nil = no lower bound
 	DateTo      *time.Time // nil = no upper bound
 	Limit       int        // 0 = use default (20)
-	Offset      int        // 0 = start from beginning
+	Offset      int        // 0 = start from beginning; ignored when After is set
+	After       string     // cursor from ArticlePage.NextCursor; continue after that article
+}
+
+// ArticlePage is one page of search results, newest first.
+type ArticlePage struct {
+	Articles []ArticleWithBlog
+	// Total counts every matching article. It is only computed for the
+	// first page (After empty) and is -1 otherwise.
+	Total int
+	// NextCursor continues the listing after the last article on this page;
+	// empty when there are no more.
+	NextCursor string
 }
 
 // DefaultPageSize is the default number of articles per page.
@@ -0,0 +1,351 @@
+// ABOUTME: Imports newsletter archives from mbox files, Maildir directories and zipped Maildirs.
+// ABOUTME: Every message goes through the inbound handler, so Message-ID de-duplication makes re-imports safe.
+package newsletter
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/storage"
+)
+
+const (
+	// maxArchiveMessageBytes bounds one message read from an archive.
+	maxArchiveMessageBytes = 64 << 20
+	// maxReportedFailures caps the failures listed in an ImportResult; the
+	// rest are only counted.
+	maxReportedFailures = 50
+)
+
+var errMessageTooLarge = fmt.Errorf("message larger than %d bytes", maxArchiveMessageBytes)
+
+// ImportResult summarises an archive import.
+type ImportResult struct {
+	Messages   int             `json:"messages"`   // messages read from the archive
+	Imported   int             `json:"imported"`   // stored as new articles
+	Duplicates int             `json:"duplicates"` // already stored, by Message-ID
+	Failed     int             `json:"failed"`
+	Senders    []SenderCount   `json:"senders"`
+	Failures   []ImportFailure `json:"failures,omitempty"` // the first maxReportedFailures
+}
+
+// SenderCount is what an import added to one sender's newsletter.
+type SenderCount struct {
+	BlogID     int64  `json:"blog_id"`
+	Name       string `json:"name"`
+	Email      string `json:"email"`
+	Imported   int    `json:"imported"`
+	Duplicates int    `json:"duplicates"`
+}
+
+// ImportFailure is a message that could not be imported.
+type ImportFailure struct {
+	Message string `json:"message"` // "#12" within an mbox, or the Maildir file
+	Error   string `json:"error"`
+}
+
+// Importer runs archived messages through the inbound handler and tallies the
+// results. Imported newsletters are discovered as of their own date, so an
+// old backlog stays out of digests.
+type Importer struct {
+	db      *storage.Database
+	handler *Handler
+	result  ImportResult
+	senders map[int64]*SenderCount
+}
+
+// NewImporter returns an Importer storing articles in db.
+func NewImporter(db *storage.Database) *Importer {
+	return &Importer{db: db, handler: NewHandler(db), senders: make(map[int64]*SenderCount)}
+}
+
+// Result returns the tally so far, with senders ordered by name.
+func (im *Importer) Result() ImportResult {
+	result := im.result
+	result.Senders = make([]SenderCount, 0, len(im.senders))
+	for _, s := range im.senders {
+		result.Senders = append(result.Senders, *s)
+	}
+	sort.Slice(result.Senders, func(i, j int) bool {
+		a, b := result.Senders[i], result.Senders[j]
+		if !strings.EqualFold(a.Name, b.Name) {
+			return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+		}
+		return a.Email < b.Email
+	})
+	return result
+}
+
+// add imports one message. Malformed messages are recorded as failures;
+// storage errors stop the import.
+func (im *Importer) add(ctx context.Context, where string, raw []byte, readErr error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	im.result.Messages++
+	if readErr != nil {
+		im.fail(where, readErr)
+		return nil
+	}
+	article, created, err := im.handler.ingest(ctx, raw, ingestArchived)
+	if err != nil {
+		if errors.Is(err, ErrMalformed) {
+			im.fail(where, err)
+			return nil
+		}
+		return fmt.Errorf("%s: %w", where, err)
+	}
+	if article.ID == 0 {
+		// Pruned by retention since it was first stored: nothing to count per sender
+		im.result.Duplicates++
+		return nil
+	}
+
+	sender, ok := im.senders[article.BlogID]
+	if !ok {
+		blog, err := im.db.GetBlogByID(article.BlogID)
+		if err != nil {
+			return fmt.Errorf("look up sender: %w", err)
+		}
+		sender = &SenderCount{BlogID: article.BlogID}
+		if blog != nil {
+			sender.Name = blog.Name
+			sender.Email = strings.TrimPrefix(blog.URL, "mailto:")
+		}
+		im.senders[article.BlogID] = sender
+	}
+	if created {
+		im.result.Imported++
+		sender.Imported++
+	} else {
+		im.result.Duplicates++
+		sender.Duplicates++
+	}
+	return nil
+}
+
+func (im *Importer) fail(where string, err error) {
+	im.result.Failed++
+	if len(im.result.Failures) < maxReportedFailures {
+		im.result.Failures = append(im.result.Failures, ImportFailure{Message: where, Error: err.Error()})
+	}
+}
+
+// ImportMbox imports every message in an mbox file. name labels failures.
+func (im *Importer) ImportMbox(ctx context.Context, name string, r io.Reader) error {
+	n := 0
+	return readMbox(r, func(raw []byte, err error) error {
+		n++
+		return im.add(ctx, fmt.Sprintf("%s #%d", name, n), raw, err)
+	})
+}
+
+// ImportMaildir imports every message in the cur and new directories under
+// dir, which covers a single Maildir, Maildir++ subfolders and a tree of
+// Maildirs.
+func (im *Importer) ImportMaildir(ctx context.Context, dir string) error {
+	found := false
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !isMaildirMessage(filepath.ToSlash(p)) {
+			return nil
+		}
+		found = true
+		raw, readErr := readMessageFile(p)
+		rel, _ := filepath.Rel(dir, p)
+		return im.add(ctx, rel, raw, readErr)
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%s: no Maildir messages found (expected cur/ or new/ directories)", dir)
+	}
+	return nil
+}
+
+// ImportZip imports a zip archive holding a Maildir (files under cur/ or
+// new/) and any .mbox files.
+func (im *Importer) ImportZip(ctx context.Context, r io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("read zip: %w", err)
+	}
+	found := false
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		switch {
+		case isMaildirMessage(f.Name):
+			found = true
+			raw, readErr := readZipFile(f)
+			if err := im.add(ctx, f.Name, raw, readErr); err != nil {
+				return err
+			}
+		case strings.EqualFold(path.Ext(f.Name), ".mbox"):
+			found = true
+			rc, err := f.Open()
+			if err != nil {
+				return fmt.Errorf("%s: %w", f.Name, err)
+			}
+			err = im.ImportMbox(ctx, f.Name, rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if !found {
+		return errors.New("zip holds no Maildir messages or .mbox files")
+	}
+	return nil
+}
+
+// ImportFile imports an mbox file, a zip or a Maildir directory at p.
+func (im *Importer) ImportFile(ctx context.Context, p string) error {
+	info, err := os.Stat(p)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return im.ImportMaildir(ctx, p)
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return im.ImportReader(ctx, filepath.Base(p), f, info.Size())
+}
+
+// ImportReader imports an uploaded archive, telling a zip from an mbox by
+// its first bytes.
+func (im *Importer) ImportReader(ctx context.Context, name string, r io.ReaderAt, size int64) error {
+	magic := make([]byte, 4)
+	n, _ := r.ReadAt(magic, 0)
+	if bytes.Equal(magic[:n], []byte("PK\x03\x04")) {
+		return im.ImportZip(ctx, r, size)
+	}
+	return im.ImportMbox(ctx, name, io.NewSectionReader(r, 0, size))
+}
+
+// isMaildirMessage reports whether a slash-separated path names a file in a
+// Maildir's cur or new directory. Hidden files such as .DS_Store are skipped.
+func isMaildirMessage(p string) bool {
+	dir, file := path.Split(p)
+	parent := path.Base(strings.TrimSuffix(dir, "/"))
+	return (parent == "cur" || parent == "new") && file != "" && !strings.HasPrefix(file, ".")
+}
+
+func readMessageFile(p string) ([]byte, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readLimited(f)
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return readLimited(rc)
+}
+
+func readLimited(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxArchiveMessageBytes+1))
+	if err == nil && len(data) > maxArchiveMessageBytes {
+		return nil, errMessageTooLarge
+	}
+	return data, err
+}
+
+// readMbox calls fn with each message of an mbox file. Messages start at a
+// "From " line at the top of the file or after a blank line; ">From " lines
+// are unescaped one level, which suits both mboxo and mboxrd. An oversized
+// message is passed as an error instead of its bytes.
+func readMbox(r io.Reader, fn func(raw []byte, err error) error) error {
+	br := bufio.NewReaderSize(r, 64<<10)
+	var msg bytes.Buffer
+	started, tooLarge := false, false
+	// prevBlank is whether the previous line was blank; lineStart is false
+	// while reading the rest of a line longer than the buffer
+	prevBlank, lineStart := true, true
+
+	emit := func() error {
+		if !started {
+			return nil
+		}
+		if tooLarge {
+			return fn(nil, errMessageTooLarge)
+		}
+		// The blank line before the next separator belongs to the mbox, not the message
+		raw := bytes.TrimSuffix(msg.Bytes(), []byte("\n"))
+		raw = bytes.TrimSuffix(raw, []byte("\r"))
+		return fn(raw, nil)
+	}
+
+	for {
+		line, err := br.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			// A very long line: keep reading it in pieces
+			err = nil
+		}
+		if len(line) > 0 {
+			switch {
+			case lineStart && prevBlank && bytes.HasPrefix(line, []byte("From ")):
+				if err := emit(); err != nil {
+					return err
+				}
+				msg.Reset()
+				started, tooLarge = true, false
+			case !started:
+				if len(bytes.TrimSpace(line)) > 0 {
+					return errors.New("not an mbox file: it does not start with a \"From \" line")
+				}
+			case !tooLarge:
+				if unescaped := bytes.TrimLeft(line, ">"); lineStart && len(unescaped) < len(line) && bytes.HasPrefix(unescaped, []byte("From ")) {
+					line = line[1:]
+				}
+				msg.Write(line)
+				if msg.Len() > maxArchiveMessageBytes {
+					tooLarge = true
+					msg.Reset()
+				}
+			}
+			if lineStart {
+				prevBlank = len(bytes.TrimRight(line, "\r\n")) == 0
+			} else {
+				prevBlank = false
+			}
+			lineStart = line[len(line)-1] == '\n'
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if !started {
+		return errors.New("not an mbox file: no messages found")
+	}
+	return emit()
+}
@@ -0,0 +1,140 @@
+// ABOUTME: Tests for importing newsletter archives from mbox files, Maildir directories and zips.
+// ABOUTME: Covers per-sender counts, failures, From-line unescaping, archive dating and re-import de-duplication.
+package newsletter_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/newsletter"
+)
+
+func TestImportMbox(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	im := newsletter.NewImporter(db)
+	if err := im.ImportFile(ctx, filepath.Join("testdata", "archive.mbox")); err != nil {
+		t.Fatalf("ImportFile: %v", err)
+	}
+	result := im.Result()
+	if result.Messages != 4 || result.Imported != 3 || result.Duplicates != 0 || result.Failed != 1 {
+		t.Errorf("Result = %+v, want 4 messages, 3 imported, 1 failed", result)
+	}
+	if len(result.Failures) != 1 || result.Failures[0].Message != "archive.mbox #3" {
+		t.Errorf("Failures = %+v, want message #3", result.Failures)
+	}
+	want := []newsletter.SenderCount{
+		{Name: "Acme Newsletter", Email: "news@acme.com", Imported: 2},
+		{Name: "Weekly Writer", Email: "writer@substack.com", Imported: 1},
+	}
+	if len(result.Senders) != len(want) {
+		t.Fatalf("Senders = %+v, want %d", result.Senders, len(want))
+	}
+	for i, s := range result.Senders {
+		s.BlogID = 0
+		if s != want[i] {
+			t.Errorf("Senders[%d] = %+v, want %+v", i, s, want[i])
+		}
+	}
+
+	article, err := db.GetArticleByURL("message:<week1@substack.com>")
+	if err != nil || article == nil {
+		t.Fatalf("GetArticleByURL = %v, %v", article, err)
+	}
+	if !strings.Contains(article.Content, "\nFrom the archives") {
+		t.Errorf("escaped From line not restored; Content = %q", article.Content)
+	}
+	// An archive is discovered as of its own date, keeping it out of digests
+	if wantDate := time.Date(2024, 1, 3, 8, 30, 0, 0, time.UTC); article.DiscoveredDate == nil || !article.DiscoveredDate.Equal(wantDate) {
+		t.Errorf("DiscoveredDate = %v, want %v", article.DiscoveredDate, wantDate)
+	}
+
+	// Importing again adds nothing, including the message without a Message-ID
+	again := newsletter.NewImporter(db)
+	if err := again.ImportFile(ctx, filepath.Join("testdata", "archive.mbox")); err != nil {
+		t.Fatalf("second ImportFile: %v", err)
+	}
+	if r := again.Result(); r.Imported != 0 || r.Duplicates != 3 {
+		t.Errorf("second import = %+v, want 3 duplicates", r)
+	}
+	if articles, _ := db.ListArticles(false, nil); len(articles) != 3 {
+		t.Errorf("stored %d articles, want 3", len(articles))
+	}
+}
+
+func TestImportMaildir(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"cur/1704103200.1.host:2,S": readFixture(t, "html_only.eml"),
+		"new/1704189600.2.host":     readFixture(t, "multipart.eml"),
+		"new/.DS_Store":             []byte("not a message"),
+		".Work/cur/1704276000.3.host:2,S": bytes.ReplaceAll(readFixture(t, "html_only.eml"),
+			[]byte("issue42@"), []byte("work42@")),
+		"tmp/partial": []byte("still being delivered"),
+	}
+	for name, data := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	im := newsletter.NewImporter(openTestDB(t))
+	if err := im.ImportFile(context.Background(), dir); err != nil {
+		t.Fatalf("ImportFile: %v", err)
+	}
+	if r := im.Result(); r.Messages != 3 || r.Imported != 3 || len(r.Senders) != 1 {
+		t.Errorf("Result = %+v, want 3 messages from one sender", r)
+	}
+
+	if err := newsletter.NewImporter(openTestDB(t)).ImportFile(context.Background(), t.TempDir()); err == nil {
+		t.Error("ImportFile of an empty directory succeeded, want an error")
+	}
+}
+
+func TestImportZip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range map[string][]byte{
+		"Newsletters/cur/1704103200.1.host:2,S": readFixture(t, "html_only.eml"),
+		"Newsletters/new/1704189600.2.host":     []byte("this is not an email"),
+		"old/archive.mbox":                      readFixture(t, "archive.mbox"),
+		"README.txt":                            []byte("ignored"),
+	} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	im := newsletter.NewImporter(openTestDB(t))
+	if err := im.ImportReader(context.Background(), "upload.zip", bytes.NewReader(buf.Bytes()), int64(buf.Len())); err != nil {
+		t.Fatalf("ImportReader: %v", err)
+	}
+	// issue42 is in both the Maildir and the mbox
+	if r := im.Result(); r.Messages != 6 || r.Imported != 3 || r.Duplicates != 1 || r.Failed != 2 {
+		t.Errorf("Result = %+v, want 6 messages: 3 imported, 1 duplicate, 2 failed", r)
+	}
+}
+
+func TestImportRejectsNonArchive(t *testing.T) {
+	im := newsletter.NewImporter(openTestDB(t))
+	data := readFixture(t, "html_only.eml")
+	if err := im.ImportReader(context.Background(), "message.eml", bytes.NewReader(data), int64(len(data))); err == nil || !strings.Contains(err.Error(), "not an mbox") {
+		t.Errorf("ImportReader of a single email = %v, want a not-an-mbox error", err)
+	}
+}
@@ -0,0 +1,320 @@
+// ABOUTME: Screens inbound newsletters with allow and block rules for senders and SPF/DKIM results from Authentication-Results.
+// ABOUTME: Emails that fail are quarantined for review instead of becoming articles; settings live in the settings table.
+package newsletter
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/storage"
+)
+
+// ErrQuarantined is wrapped by HandleInbound errors for emails held in
+// quarantine. The email was received; it waits for approval in settings.
+var ErrQuarantined = errors.New("newsletter quarantined")
+
+// ErrNotQuarantined is returned by Approve when there is no such quarantined
+// newsletter.
+var ErrNotQuarantined = errors.New("newsletter not in quarantine")
+
+// Setting keys used to persist the sender filter.
+const (
+	keyFilterAllow       = "newsletter_allow"
+	keyFilterBlock       = "newsletter_block"
+	keyFilterUnknown     = "newsletter_quarantine_unknown"
+	keyFilterCheckAuth   = "newsletter_check_authentication"
+	keyFilterAuthServIDs = "newsletter_authserv_ids"
+)
+
+// FilterSettings decides which newsletters are stored and which are held
+// in quarantine. Rules are full addresses ("news@example.com") or domains
+// ("@example.com", which also covers its subdomains).
+type FilterSettings struct {
+	Allow []string // always accepted, even when also blocked
+	Block []string
+	// QuarantineUnknown holds newsletters from senders that have no blog yet
+	// and are not allowed, so new senders must be approved once.
+	QuarantineUnknown bool
+	// CheckAuthentication holds newsletters unless Authentication-Results
+	// shows a passing DMARC check, or a passing SPF or DKIM check for the
+	// From domain, and no failing DMARC check.
+	CheckAuthentication bool
+	// AuthServIDs are the servers whose Authentication-Results are trusted.
+	// Anyone can add the header, so with none listed every newsletter is
+	// held while CheckAuthentication is on.
+	AuthServIDs []string
+}
+
+// LoadFilterSettings reads the sender filter.
+func LoadFilterSettings(db *storage.Database) (FilterSettings, error) {
+	var s FilterSettings
+	values := make(map[string]string)
+	for _, key := range []string{keyFilterAllow, keyFilterBlock, keyFilterUnknown, keyFilterCheckAuth, keyFilterAuthServIDs} {
+		v, err := db.GetSetting(key)
+		if err != nil {
+			return s, err
+		}
+		values[key] = v
+	}
+	s.Allow = splitRules(values[keyFilterAllow])
+	s.Block = splitRules(values[keyFilterBlock])
+	s.QuarantineUnknown = values[keyFilterUnknown] == "true"
+	s.CheckAuthentication = values[keyFilterCheckAuth] == "true"
+	s.AuthServIDs = splitRules(values[keyFilterAuthServIDs])
+	return s, nil
+}
+
+// SaveFilterSettings stores the sender filter.
+func SaveFilterSettings(db *storage.Database, s FilterSettings) error {
+	values := map[string]string{
+		keyFilterAllow:       strings.Join(s.Allow, "\n"),
+		keyFilterBlock:       strings.Join(s.Block, "\n"),
+		keyFilterUnknown:     fmt.Sprint(s.QuarantineUnknown),
+		keyFilterCheckAuth:   fmt.Sprint(s.CheckAuthentication),
+		keyFilterAuthServIDs: strings.Join(s.AuthServIDs, "\n"),
+	}
+	for key, value := range values {
+		if err := db.SetSetting(key, value); err != nil {
+			return fmt.Errorf("store %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// ParseSenderRules splits a comma- or line-separated list of addresses and
+// domains, lowercased. A bare domain becomes "@domain".
+func ParseSenderRules(s string) ([]string, error) {
+	rules := splitRules(s)
+	for i, rule := range rules {
+		if !strings.Contains(rule, "@") {
+			rule = "@" + rule
+		}
+		domain := rule[strings.LastIndex(rule, "@")+1:]
+		if strings.Count(rule, "@") > 1 || !strings.Contains(strings.Trim(domain, "."), ".") {
+			return nil, fmt.Errorf("%q is not an address or domain", rules[i])
+		}
+		rules[i] = rule
+	}
+	return rules, nil
+}
+
+func splitRules(s string) []string {
+	var rules []string
+	for _, field := range strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n' || r == '\t' || r == '\r'
+	}) {
+		rules = append(rules, strings.ToLower(field))
+	}
+	return rules
+}
+
+// matchSender reports whether addr matches one of rules.
+func matchSender(rules []string, addr string) bool {
+	addr = strings.ToLower(addr)
+	domain := addr[strings.LastIndex(addr, "@")+1:]
+	for _, rule := range rules {
+		if ruleDomain, ok := strings.CutPrefix(rule, "@"); ok {
+			if domain == ruleDomain || strings.HasSuffix(domain, "."+ruleDomain) {
+				return true
+			}
+		} else if addr == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// screen returns why a newsletter from sender should be quarantined, or ""
+// to accept it.
+func (h *Handler) screen(header mail.Header, sender string) (string, error) {
+	s, err := LoadFilterSettings(h.db)
+	if err != nil {
+		return "", fmt.Errorf("read sender filter: %w", err)
+	}
+	allowed := matchSender(s.Allow, sender)
+	if !allowed && matchSender(s.Block, sender) {
+		return "Sender is blocked", nil
+	}
+	if s.CheckAuthentication {
+		if reason := checkAuthentication(header, s.AuthServIDs, sender); reason != "" {
+			return reason, nil
+		}
+	}
+	if s.QuarantineUnknown && !allowed {
+		known, err := h.db.IsKnownNewsletterSender(sender)
+		if err != nil {
+			return "", fmt.Errorf("look up sender: %w", err)
+		}
+		if !known {
+			return "New sender", nil
+		}
+	}
+	return "", nil
+}
+
+// checkAuthentication reads the SPF, DKIM and DMARC results from the
+// Authentication-Results headers (RFC 8601) that trusted servers added and
+// returns why they are not good enough for mail from sender, or "" when
+// DMARC passed, or SPF or DKIM passed for a domain aligned with sender's,
+// and DMARC did not fail.
+func checkAuthentication(header mail.Header, trusted []string, sender string) string {
+	if len(trusted) == 0 {
+		return "No trusted mail servers listed"
+	}
+	fromDomain := strings.ToLower(sender[strings.LastIndex(sender, "@")+1:])
+	summary := make(map[string]string)
+	found, passed, dmarcFailed := false, false, false
+	for _, value := range header["Authentication-Results"] {
+		servID, results := parseAuthResults(value)
+		if !containsFold(trusted, servID) {
+			continue
+		}
+		found = true
+		for _, res := range results {
+			domain := res.domain()
+			if res.Method == "dmarc" && domain == "" {
+				// DMARC is evaluated for the From domain itself
+				domain = fromDomain
+			}
+			outcome := res.Result
+			if res.Result == "pass" && !aligned(domain, fromDomain) {
+				// Passed, but for someone else's domain
+				outcome = "pass for " + orNone(domain)
+			}
+			switch {
+			case res.Method == "dmarc" && res.Result == "fail":
+				dmarcFailed = true
+			case outcome == "pass" && (res.Method == "dmarc" || res.Method == "spf" || res.Method == "dkim"):
+				passed = true
+			}
+			// A pass from any trusted header wins over a failure from another
+			if summary[res.Method] != "pass" {
+				summary[res.Method] = outcome
+			}
+		}
+	}
+	switch {
+	case !found:
+		return "No trusted Authentication-Results"
+	case dmarcFailed:
+		return "DMARC failed"
+	case !passed:
+		return fmt.Sprintf("SPF %s, DKIM %s", orNone(summary["spf"]), orNone(summary["dkim"]))
+	}
+	return ""
+}
+
+// authResult is one method's result from an Authentication-Results header,
+// with its properties such as "smtp.mailfrom" or "header.d".
+type authResult struct {
+	Method string
+	Result string
+	Props  map[string]string
+}
+
+// domain returns the domain the result speaks for: the From domain for
+// DMARC, the envelope sender's for SPF and the signing domain for DKIM.
+func (r authResult) domain() string {
+	var value string
+	switch r.Method {
+	case "dmarc":
+		value = r.Props["header.from"]
+	case "spf":
+		value = r.Props["smtp.mailfrom"]
+	case "dkim":
+		if value = r.Props["header.d"]; value == "" {
+			value = r.Props["header.i"]
+		}
+	}
+	return strings.ToLower(value[strings.LastIndex(value, "@")+1:])
+}
+
+// aligned reports whether domain and fromDomain share an organizational
+// domain, DMARC's relaxed alignment.
+func aligned(domain, fromDomain string) bool {
+	if domain == "" || fromDomain == "" {
+		return false
+	}
+	return organizationalDomain(domain) == organizationalDomain(fromDomain)
+}
+
+// organizationalDomain returns the registrable part of domain, such as
+// "example.co.uk" for "news.example.co.uk".
+func organizationalDomain(domain string) string {
+	org, err := publicsuffix.EffectiveTLDPlusOne(strings.TrimSuffix(domain, "."))
+	if err != nil {
+		return domain
+	}
+	return org
+}
+
+// parseAuthResults splits an Authentication-Results value into its
+// authserv-id and its results, e.g. "mx.example.com" and spf "pass" with
+// smtp.mailfrom "a.com" from
+// "mx.example.com; spf=pass smtp.mailfrom=a.com; dkim=fail header.d=a.com".
+func parseAuthResults(value string) (string, []authResult) {
+	value = stripComments(value)
+	clauses := strings.Split(value, ";")
+	servID := ""
+	if fields := strings.Fields(clauses[0]); len(fields) > 0 {
+		servID = strings.ToLower(fields[0])
+	}
+	var results []authResult
+	for _, clause := range clauses[1:] {
+		fields := strings.Fields(clause)
+		if len(fields) == 0 {
+			continue
+		}
+		method, result, ok := strings.Cut(fields[0], "=")
+		if !ok {
+			continue
+		}
+		// "dkim/1=pass" names a method version
+		method, _, _ = strings.Cut(strings.ToLower(method), "/")
+		res := authResult{Method: method, Result: strings.ToLower(result), Props: make(map[string]string)}
+		for _, field := range fields[1:] {
+			if name, value, ok := strings.Cut(field, "="); ok {
+				res.Props[strings.ToLower(name)] = strings.Trim(value, `"`)
+			}
+		}
+		results = append(results, res)
+	}
+	return servID, results
+}
+
+// stripComments removes parenthesised comments, which may nest.
+func stripComments(s string) string {
+	var b strings.Builder
+	depth := 0
+	for _, r := range s {
+		switch {
+		case r == '(':
+			depth++
+		case r == ')' && depth > 0:
+			depth--
+		case depth == 0:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func orNone(result string) string {
+	if result == "" {
+		return "none"
+	}
+	return result
+}
@@ -0,0 +1,160 @@
+// ABOUTME: Tests for the newsletter sender filter: allow and block rules, new-sender and authentication quarantine.
+// ABOUTME: Quarantined emails are approved back into the feed through the Handler.
+package newsletter_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/newsletter"
+)
+
+// filterEmail builds a minimal newsletter with the given extra header lines.
+func filterEmail(from, id string, headers ...string) []byte {
+	lines := append(headers, "From: "+from, "Subject: Issue "+id, "Message-ID: <"+id+"@example.com>", "Content-Type: text/html", "", "<p>Hi</p>")
+	return []byte(strings.Join(lines, "\r\n"))
+}
+
+func TestParseSenderRules(t *testing.T) {
+	rules, err := newsletter.ParseSenderRules("News@Example.com, substack.com\n@mail.acme.com")
+	if err != nil {
+		t.Fatalf("ParseSenderRules: %v", err)
+	}
+	if strings.Join(rules, " ") != "news@example.com @substack.com @mail.acme.com" {
+		t.Errorf("rules = %q", rules)
+	}
+	for _, bad := range []string{"localhost", "a@b@example.com", "news@"} {
+		if _, err := newsletter.ParseSenderRules(bad); err == nil {
+			t.Errorf("ParseSenderRules(%q) should fail", bad)
+		}
+	}
+}
+
+func TestSenderFilterRules(t *testing.T) {
+	db := openTestDB(t)
+	h := newsletter.NewHandler(db)
+	if err := newsletter.SaveFilterSettings(db, newsletter.FilterSettings{
+		Allow:             []string{"friend@spam.example"},
+		Block:             []string{"@spam.example"},
+		QuarantineUnknown: true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// A sender with a blog already is known
+	if _, err := db.GetOrCreateNewsletterBlog("Known", "known@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		from        string
+		quarantined bool
+	}{
+		{"known@example.com", false},
+		{"friend@spam.example", false},       // allowed despite the blocked domain
+		{"bulk@news.spam.example", true},     // blocked, subdomain included
+		{"stranger@elsewhere.example", true}, // new sender
+	}
+	for i, tc := range tests {
+		_, _, err := h.HandleInbound(context.Background(), filterEmail(tc.from, string(rune('a'+i))))
+		if got := errors.Is(err, newsletter.ErrQuarantined); got != tc.quarantined {
+			t.Errorf("%s: quarantined = %v (err %v), want %v", tc.from, got, err, tc.quarantined)
+		}
+	}
+
+	held, err := db.ListQuarantinedNewsletters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(held) != 2 || held[0].Reason == "" {
+		t.Fatalf("quarantine = %+v, want 2 entries with reasons", held)
+	}
+	// Redelivery of a held message does not duplicate it
+	_, _, _ = h.HandleInbound(context.Background(), filterEmail("stranger@elsewhere.example", "d"))
+	if again, _ := db.ListQuarantinedNewsletters(); len(again) != 2 {
+		t.Errorf("redelivery duplicated the quarantine: %d entries", len(again))
+	}
+
+	// Approving creates the sender's blog, so their next issue is accepted
+	var stranger int64
+	for _, q := range held {
+		if strings.Contains(q.Sender, "stranger") {
+			stranger = q.ID
+		}
+	}
+	article, created, err := h.Approve(context.Background(), stranger)
+	if err != nil {
+		t.Fatalf("Approve: %v", err)
+	}
+	if !created || article.Title != "Issue d" || article.BlogID == 0 {
+		t.Errorf("approved article = %+v, created %v", article, created)
+	}
+	if _, _, err := h.HandleInbound(context.Background(), filterEmail("stranger@elsewhere.example", "e")); err != nil {
+		t.Errorf("approved sender's next issue: %v", err)
+	}
+	if _, _, err := h.Approve(context.Background(), stranger); !errors.Is(err, newsletter.ErrNotQuarantined) {
+		t.Errorf("second approval: err = %v, want ErrNotQuarantined", err)
+	}
+
+	// An email stored since it was held is not created a second time
+	var blocked int64
+	for _, q := range held {
+		if strings.Contains(q.Sender, "bulk") {
+			blocked = q.ID
+		}
+	}
+	if err := newsletter.SaveFilterSettings(db, newsletter.FilterSettings{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, created, err := h.HandleInbound(context.Background(), filterEmail("bulk@news.spam.example", "c")); err != nil || !created {
+		t.Fatalf("redelivery once unblocked: created %v, err %v", created, err)
+	}
+	if _, created, err := h.Approve(context.Background(), blocked); err != nil || created {
+		t.Errorf("approving an email stored since: created %v, err %v; want an existing article", created, err)
+	}
+	if left, _ := db.ListQuarantinedNewsletters(); len(left) != 0 {
+		t.Errorf("quarantine = %+v, want it emptied by the approval", left)
+	}
+}
+
+func TestSenderFilterAuthentication(t *testing.T) {
+	mx := []string{"mx.example.net"}
+	tests := []struct {
+		name        string
+		trusted     []string
+		headers     []string
+		quarantined bool
+	}{
+		{"dkim pass", mx, []string{"Authentication-Results: mx.example.net; dkim=pass header.d=acme.com; spf=fail"}, false},
+		{"spf pass with comments", mx, []string{"Authentication-Results: mx.example.net (postfix); spf=pass (sender ok) smtp.mailfrom=bounces@mail.acme.com"}, false},
+		{"dmarc pass", mx, []string{"Authentication-Results: mx.example.net; spf=pass smtp.mailfrom=esp.example; dmarc=pass header.from=acme.com"}, false},
+		{"both fail", mx, []string{"Authentication-Results: mx.example.net; spf=softfail; dkim=fail"}, true},
+		{"dmarc fail", mx, []string{"Authentication-Results: mx.example.net; dkim=pass header.d=acme.com; dmarc=fail"}, true},
+		{"no header", mx, nil, true},
+		// Passes for the sender's own domain say nothing about the From address
+		{"unaligned dkim", mx, []string{"Authentication-Results: mx.example.net; dkim=pass header.d=attacker.example"}, true},
+		{"unaligned spf", mx, []string{"Authentication-Results: mx.example.net; spf=pass smtp.mailfrom=attacker.example"}, true},
+		{"dkim pass without a domain", mx, []string{"Authentication-Results: mx.example.net; dkim=pass"}, true},
+		{"public suffix is not aligned", mx, []string{"Authentication-Results: mx.example.net; dkim=pass header.d=com"}, true},
+		// Without trusted servers any header could be the sender's own
+		{"no trusted servers", nil, []string{"Authentication-Results: mx.example.net; dkim=pass header.d=acme.com"}, true},
+		{"trusted server", []string{"mx.google.com"}, []string{
+			"Authentication-Results: relay.example.net; spf=none",
+			"Authentication-Results: mx.google.com; dkim=pass header.i=@acme.com",
+		}, false},
+		{"untrusted server", []string{"mx.google.com"}, []string{"Authentication-Results: attacker.example; dkim=pass header.d=acme.com"}, true},
+	}
+	for i, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			db := openTestDB(t)
+			if err := newsletter.SaveFilterSettings(db, newsletter.FilterSettings{CheckAuthentication: true, AuthServIDs: tc.trusted}); err != nil {
+				t.Fatal(err)
+			}
+			_, _, err := newsletter.NewHandler(db).HandleInbound(context.Background(), filterEmail("news@acme.com", string(rune('a'+i)), tc.headers...))
+			if got := errors.Is(err, newsletter.ErrQuarantined); got != tc.quarantined {
+				t.Errorf("quarantined = %v (err %v), want %v", got, err, tc.quarantined)
+			}
+		})
+	}
+}
@@ -1,21 +1,29 @@
 // ABOUTME: Parses inbound RFC 822 emails and ingests them as newsletter articles.
-// ABOUTME: Uses stdlib net/mail and mime/multipart — no external dependencies.
+// ABOUTME: Body decoding lives in mime.go; the sender's address, an alias or the List-Id picks the newsletter blog.
 package newsletter
 
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"io"
 	"mime"
-	"mime/multipart"
 	"net/mail"
 	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
 
 	"github.com/esttorhe/blogwatcher-ui/v2/internal/model"
 	"github.com/esttorhe/blogwatcher-ui/v2/internal/storage"
 )
 
+// ErrMalformed is wrapped by HandleInbound errors for emails that can never be
+// ingested, as opposed to storage failures that may succeed on retry.
+var ErrMalformed = errors.New("malformed email")
+
 // Handler ingests raw RFC 822 emails as newsletter articles.
 type Handler struct {
 	db *storage.Database
@@ -27,74 +35,175 @@ func NewHandler(db *storage.Database) *Handler {
 }
 
 // HandleInbound parses raw RFC 822 bytes, creates or reuses the sender's blog,
-// and inserts the email as an Article. Returns the stored Article.
+// and inserts the email as an Article with its inline images and attachments.
+// The article is dated by the Date header (or receipt time) and gets a
+// thumbnail, "view in browser" link and unsubscribe links when the email has
+// them. Returns the stored Article and whether it was newly created; a
+// redelivered email returns the existing Article and false.
+// Emails the sender filter holds back are quarantined and reported with an
+// error wrapping ErrQuarantined.
 // Calling it twice with the same raw email is idempotent (same Message-ID → same row).
-func (h *Handler) HandleInbound(ctx context.Context, raw []byte) (model.Article, error) {
+func (h *Handler) HandleInbound(ctx context.Context, raw []byte) (model.Article, bool, error) {
+	return h.ingest(ctx, raw, ingestLive)
+}
+
+// Approve ingests a quarantined newsletter without screening it again and
+// removes it from quarantine. Returns the stored Article and whether it was
+// newly created, as HandleInbound does; an email stored since it was held,
+// or pruned by retention, returns false.
+func (h *Handler) Approve(ctx context.Context, id int64) (model.Article, bool, error) {
+	q, raw, err := h.db.GetQuarantinedNewsletter(id)
+	if err != nil {
+		return model.Article{}, false, err
+	}
+	if q == nil {
+		return model.Article{}, false, ErrNotQuarantined
+	}
+	article, created, err := h.ingest(ctx, raw, ingestApproved)
+	if err != nil {
+		return model.Article{}, false, err
+	}
+	if _, err := h.db.DeleteQuarantinedNewsletter(id); err != nil {
+		return model.Article{}, false, fmt.Errorf("remove from quarantine: %w", err)
+	}
+	return article, created, nil
+}
+
+// ingestMode says where a message comes from.
+type ingestMode int
+
+const (
+	// ingestLive is newly arrived mail, screened by the sender filter.
+	ingestLive ingestMode = iota
+	// ingestArchived is mail imported from an archive. It is discovered as
+	// of its own date, so an imported backlog does not show up as new in
+	// digests, and it is not screened: the archive is the user's own.
+	ingestArchived
+	// ingestApproved is mail approved out of quarantine.
+	ingestApproved
+)
+
+// ingest stores raw and reports whether it was new.
+func (h *Handler) ingest(ctx context.Context, raw []byte, mode ingestMode) (model.Article, bool, error) {
 	msg, err := mail.ReadMessage(bytes.NewReader(raw))
 	if err != nil {
-		return model.Article{}, fmt.Errorf("parse email: %w", err)
+		return model.Article{}, false, fmt.Errorf("%w: %w", ErrMalformed, err)
 	}
 
 	// Extract sender address and display name.
 	fromHeader := msg.Header.Get("From")
 	senderName, senderEmail, err := parseFrom(fromHeader)
 	if err != nil {
-		return model.Article{}, fmt.Errorf("parse From header: %w", err)
+		return model.Article{}, false, fmt.Errorf("%w: From header: %w", ErrMalformed, err)
 	}
 
 	subject := decodeHeader(msg.Header.Get("Subject"))
 	messageID := strings.TrimSpace(msg.Header.Get("Message-ID"))
+	if messageID == "" {
+		messageID = contentID(raw)
+	}
 
 	// URL is the Message-ID encoded as a stable URI so we can de-duplicate.
 	articleURL := "message:" + messageID
 
-	// Get or create the newsletter blog for this sender.
-	blog, err := h.db.GetOrCreateNewsletterBlog(senderName, senderEmail)
-	if err != nil {
-		return model.Article{}, fmt.Errorf("get/create newsletter blog: %w", err)
-	}
-
 	// De-duplicate: if we already have an article with this URL, return it.
 	existing, err := h.db.GetArticleByURL(articleURL)
 	if err != nil {
-		return model.Article{}, fmt.Errorf("check existing article: %w", err)
+		return model.Article{}, false, fmt.Errorf("check existing article: %w", err)
 	}
 	if existing != nil {
-		return *existing, nil
+		return *existing, false, nil
+	}
+	// A newsletter retention pruned counts as a duplicate too, so a redelivery
+	// or a re-imported archive doesn't bring it back.
+	deleted, err := h.db.IsArticleDeleted(articleURL)
+	if err != nil {
+		return model.Article{}, false, fmt.Errorf("check deleted article: %w", err)
+	}
+	if deleted {
+		return model.Article{}, false, nil
 	}
 
-	htmlBody, err := extractHTMLBody(msg)
+	if mode == ingestLive {
+		reason, err := h.screen(msg.Header, senderEmail)
+		if err != nil {
+			return model.Article{}, false, err
+		}
+		if reason != "" {
+			q := model.QuarantinedNewsletter{MessageID: messageID, Sender: senderEmail, Subject: subject, Reason: reason}
+			if err := h.db.QuarantineNewsletter(q, raw); err != nil {
+				return model.Article{}, false, fmt.Errorf("quarantine: %w", err)
+			}
+			return model.Article{}, false, fmt.Errorf("%w: %s: %s", ErrQuarantined, senderEmail, reason)
+		}
+	}
+
+	// Find the newsletter blog for this sender or list, creating it if needed.
+	blog, err := h.db.ResolveNewsletterBlog(senderName, senderEmail, listID(msg.Header.Get("List-Id")))
 	if err != nil {
-		return model.Article{}, fmt.Errorf("extract body: %w", err)
+		return model.Article{}, false, fmt.Errorf("get/create newsletter blog: %w", err)
 	}
 
-	article := model.Article{
-		BlogID:  blog.ID,
-		Title:   subject,
-		URL:     articleURL,
-		Content: htmlBody,
+	htmlBody, parts, err := extractBody(msg)
+	if err != nil {
+		return model.Article{}, false, fmt.Errorf("%w: extract body: %w", ErrMalformed, err)
 	}
 
-	inserted, err := h.db.AddArticlesBulk([]model.Article{article})
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlBody))
 	if err != nil {
-		return model.Article{}, fmt.Errorf("store article: %w", err)
+		return model.Article{}, false, fmt.Errorf("parse body: %w", err)
+	}
+
+	receivedAt := time.Now().UTC()
+	published := publishedDate(msg.Header, receivedAt)
+	discovered := receivedAt
+	if mode == ingestArchived {
+		discovered = published
+	}
+	article := model.Article{
+		BlogID:         blog.ID,
+		Title:          subject,
+		URL:            articleURL,
+		ThumbnailURL:   findThumbnail(doc),
+		PublishedDate:  &published,
+		DiscoveredDate: &discovered,
+		Content:        htmlBody,
 	}
-	if inserted == 0 {
-		return model.Article{}, fmt.Errorf("article not stored")
+
+	if _, err := h.db.AddNewsletterArticle(article, parseMeta(msg.Header, doc), parts); err != nil {
+		return model.Article{}, false, fmt.Errorf("store article: %w", err)
 	}
 
 	// Fetch back to get the assigned ID.
 	stored, err := h.db.GetArticleByURL(articleURL)
 	if err != nil || stored == nil {
-		return model.Article{}, fmt.Errorf("fetch stored article: %w", err)
+		return model.Article{}, false, fmt.Errorf("fetch stored article: %w", err)
 	}
-	return *stored, nil
+	return *stored, true, nil
+}
+
+// contentID stands in for a missing Message-ID with a hash of the message,
+// ignoring line endings, so the same message still de-duplicates.
+func contentID(raw []byte) string {
+	sum := sha256.Sum256(bytes.ReplaceAll(raw, []byte("\r\n"), []byte("\n")))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// listID returns the identifier from a List-Id header (RFC 2919), e.g.
+// "weekly.acme.com" from "Acme Weekly <weekly.acme.com>", lowercased.
+func listID(header string) string {
+	if start := strings.LastIndex(header, "<"); start >= 0 {
+		if end := strings.Index(header[start:], ">"); end > 0 {
+			header = header[start+1 : start+end]
+		}
+	}
+	return strings.ToLower(strings.TrimSpace(header))
 }
 
 // parseFrom extracts the display name and email address from a From header value.
 // When the address has no display name, the local+domain part is used as the name.
 func parseFrom(from string) (name, email string, err error) {
-	addr, err := mail.ParseAddress(from)
+	addr, err := (&mail.AddressParser{WordDecoder: headerDecoder}).Parse(from)
 	if err != nil {
 		return "", "", err
 	}
@@ -106,62 +215,14 @@ func parseFrom(from string) (name, email string, err error) {
 	return name, email, nil
 }
 
+// headerDecoder decodes RFC 2047 encoded words in any charset x/text knows.
+var headerDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
 // decodeHeader decodes an RFC 2047 encoded header value (e.g. Subject).
 func decodeHeader(h string) string {
-	dec := new(mime.WordDecoder)
-	decoded, err := dec.DecodeHeader(h)
+	decoded, err := headerDecoder.DecodeHeader(h)
 	if err != nil {
 		return h
 	}
 	return decoded
 }
-
-// extractHTMLBody returns the HTML part of the email body.
-// For multipart/alternative emails it prefers the text/html part.
-// For single-part HTML emails it returns the body as-is.
-func extractHTMLBody(msg *mail.Message) (string, error) {
-	contentType := msg.Header.Get("Content-Type")
-	mediaType, params, err := mime.ParseMediaType(contentType)
-	if err != nil {
-		// No valid Content-Type — read body verbatim.
-		body, err := io.ReadAll(msg.Body)
-		if err != nil {
-			return "", err
-		}
-		return string(body), nil
-	}
-
-	if strings.HasPrefix(mediaType, "multipart/") {
-		return extractHTMLFromMultipart(msg.Body, params["boundary"])
-	}
-
-	body, err := io.ReadAll(msg.Body)
-	if err != nil {
-		return "", err
-	}
-	return string(body), nil
-}
-
-// extractHTMLFromMultipart walks multipart parts and returns the first text/html part.
-func extractHTMLFromMultipart(r io.Reader, boundary string) (string, error) {
-	mr := multipart.NewReader(r, boundary)
-	for {
-		part, err := mr.NextPart()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return "", err
-		}
-		ct := part.Header.Get("Content-Type")
-		partMedia, _, _ := mime.ParseMediaType(ct)
-		if partMedia == "text/html" {
-			body, err := io.ReadAll(part)
-			if err != nil {
-				return "", err
-			}
-			return string(body), nil
-		}
-	}
-	return "", nil
-}
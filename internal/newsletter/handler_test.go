@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/esttorhe/blogwatcher-ui/v2/internal/newsletter"
 	"github.com/esttorhe/blogwatcher-ui/v2/internal/storage"
@@ -37,7 +38,7 @@ func TestHandleInboundHTMLOnly(t *testing.T) {
 	h := newsletter.NewHandler(db)
 
 	raw := readFixture(t, "html_only.eml")
-	article, err := h.HandleInbound(context.Background(), raw)
+	article, _, err := h.HandleInbound(context.Background(), raw)
 	if err != nil {
 		t.Fatalf("HandleInbound: %v", err)
 	}
@@ -61,7 +62,7 @@ func TestHandleInboundMultipart(t *testing.T) {
 	h := newsletter.NewHandler(db)
 
 	raw := readFixture(t, "multipart.eml")
-	article, err := h.HandleInbound(context.Background(), raw)
+	article, _, err := h.HandleInbound(context.Background(), raw)
 	if err != nil {
 		t.Fatalf("HandleInbound: %v", err)
 	}
@@ -79,7 +80,7 @@ func TestHandleInboundNoDisplayName(t *testing.T) {
 	h := newsletter.NewHandler(db)
 
 	raw := readFixture(t, "no_display_name.eml")
-	article, err := h.HandleInbound(context.Background(), raw)
+	article, _, err := h.HandleInbound(context.Background(), raw)
 	if err != nil {
 		t.Fatalf("HandleInbound: %v", err)
 	}
@@ -95,20 +96,53 @@ func TestHandleInboundIdempotent(t *testing.T) {
 	h := newsletter.NewHandler(db)
 
 	raw := readFixture(t, "html_only.eml")
-	first, err := h.HandleInbound(context.Background(), raw)
-	if err != nil {
-		t.Fatalf("first HandleInbound: %v", err)
+	first, created, err := h.HandleInbound(context.Background(), raw)
+	if err != nil || !created {
+		t.Fatalf("first HandleInbound: created=%v err=%v", created, err)
 	}
 	// Second call with the same Message-ID should return same article without error.
-	second, err := h.HandleInbound(context.Background(), raw)
+	second, created, err := h.HandleInbound(context.Background(), raw)
 	if err != nil {
 		t.Fatalf("second HandleInbound: %v", err)
 	}
+	if created {
+		t.Error("repeated ingestion reported a new article")
+	}
 	if first.ID != second.ID {
 		t.Errorf("expected same article ID on repeated ingestion: first=%d second=%d", first.ID, second.ID)
 	}
 }
 
+func TestHandleInboundSkipsPrunedArticles(t *testing.T) {
+	db := openTestDB(t)
+	h := newsletter.NewHandler(db)
+
+	raw := readFixture(t, "html_only.eml")
+	article, _, err := h.HandleInbound(context.Background(), raw)
+	if err != nil {
+		t.Fatalf("HandleInbound: %v", err)
+	}
+	if _, err := db.MarkArticleRead(article.ID); err != nil {
+		t.Fatalf("MarkArticleRead: %v", err)
+	}
+	pruned, err := db.PruneArticles(&article.BlogID, storage.RetentionPolicy{ReadDays: 1}, time.Now().AddDate(1, 0, 0), false)
+	if err != nil || pruned != 1 {
+		t.Fatalf("PruneArticles = %d, %v; want 1", pruned, err)
+	}
+
+	// A redelivery of the pruned newsletter must not bring it back
+	_, created, err := h.HandleInbound(context.Background(), raw)
+	if err != nil {
+		t.Fatalf("HandleInbound after prune: %v", err)
+	}
+	if created {
+		t.Error("pruned newsletter was ingested again")
+	}
+	if again, _ := db.GetArticleByURL(article.URL); again != nil {
+		t.Errorf("pruned newsletter is back: %+v", again)
+	}
+}
+
 func TestHandleInboundSameSenderSameBlog(t *testing.T) {
 	db := openTestDB(t)
 	h := newsletter.NewHandler(db)
@@ -116,11 +150,11 @@ func TestHandleInboundSameSenderSameBlog(t *testing.T) {
 	raw1 := readFixture(t, "html_only.eml")
 	raw2 := readFixture(t, "multipart.eml")
 
-	a1, err := h.HandleInbound(context.Background(), raw1)
+	a1, _, err := h.HandleInbound(context.Background(), raw1)
 	if err != nil {
 		t.Fatalf("first ingest: %v", err)
 	}
-	a2, err := h.HandleInbound(context.Background(), raw2)
+	a2, _, err := h.HandleInbound(context.Background(), raw2)
 	if err != nil {
 		t.Fatalf("second ingest: %v", err)
 	}
@@ -130,3 +164,248 @@ func TestHandleInboundSameSenderSameBlog(t *testing.T) {
 		t.Errorf("expected same BlogID for same sender: %d vs %d", a1.BlogID, a2.BlogID)
 	}
 }
+
+func TestHandleInboundGroupsSenders(t *testing.T) {
+	db := openTestDB(t)
+	h := newsletter.NewHandler(db)
+	email := func(from, listID, id string) []byte {
+		lines := []string{"From: " + from, "Subject: Issue " + id, "Message-ID: <" + id + "@example.com>"}
+		if listID != "" {
+			lines = append(lines, "List-Id: "+listID)
+		}
+		return []byte(strings.Join(append(lines, "Content-Type: text/html", "", "<p>Hi</p>"), "\r\n"))
+	}
+	ingest := func(raw []byte) int64 {
+		t.Helper()
+		article, _, err := h.HandleInbound(context.Background(), raw)
+		if err != nil {
+			t.Fatalf("HandleInbound: %v", err)
+		}
+		return article.BlogID
+	}
+
+	// The same List-Id from another address lands in the first sender's blog
+	weekly := ingest(email("Acme <news@acme.com>", "Acme Weekly <Weekly.Acme.com>", "1"))
+	if got := ingest(email("Acme <bounce-123@mail.acme.com>", "<weekly.acme.com>", "2")); got != weekly {
+		t.Errorf("same List-Id went to blog %d, want %d", got, weekly)
+	}
+
+	// An alias routes a different address and List-Id there too
+	if err := db.SetNewsletterAlias("sender", "Editor@Acme.com", weekly); err != nil {
+		t.Fatalf("SetNewsletterAlias: %v", err)
+	}
+	if got := ingest(email("editor@acme.com", "<daily.acme.com>", "3")); got != weekly {
+		t.Errorf("aliased sender went to blog %d, want %d", got, weekly)
+	}
+
+	other := ingest(email("other@example.org", "", "4"))
+	if other == weekly {
+		t.Error("unrelated sender joined the aliased blog")
+	}
+}
+
+// TestHandleInboundDecodesBodies runs a corpus of real-world email shapes
+// through ingestion: transfer encodings, legacy charsets, nested multiparts
+// and plain-text-only newsletters.
+func TestHandleInboundDecodesBodies(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		title    string
+		blogName string
+		contains []string
+		excludes []string
+	}{
+		{
+			fixture:  "base64_html.eml",
+			title:    "Dispatch #7: Café culture",
+			blogName: "Weekly Dispatch",
+			contains: []string{"<h1>Café culture ☕</h1>", "three roasters — and one tea house", "utm_source=email&amp;utm_medium=newsletter"},
+		},
+		{
+			fixture:  "quoted_printable.eml",
+			title:    "Q1 market brief",
+			contains: []string{`<a href="https://markets.example.org/q1" style="color:#0066cc;">`, "Prices rose 5 € this quarter, the steepest climb since the series began in 2009 and well above"},
+			excludes: []string{"=3D", "=\r\n", "=E2=82=AC"},
+		},
+		{
+			fixture:  "latin1.eml",
+			title:    "Numéro 12",
+			blogName: "Journal du Café",
+			contains: []string{"Bienvenue au café de la gare. À bientôt, Zoë!"},
+		},
+		{
+			fixture:  "shift_jis.eml",
+			title:    "週刊ニュース",
+			contains: []string{"<p>今週のニュースをお届けします。</p>", `<a href="https://news.example.jp/weekly/42">https://news.example.jp/weekly/42</a>`},
+		},
+		{
+			fixture:  "nested.eml",
+			title:    "Nested issue",
+			contains: []string{"<p>Hello from the nested issue.</p>", `src="cid:logo@tinyletter.example.com"`},
+			excludes: []string{"PDF"},
+		},
+		{
+			fixture: "plain_only.eml",
+			title:   "Plain and simple",
+			contains: []string{
+				"<p>Hi all,</p>",
+				"why &lt;script&gt;alert(1)&lt;/script&gt; tags in comments are a bad idea, and what Q&amp;A sites do about it.",
+				`<a href="https://plain.example.com/posts/1?a=1&amp;b=2">https://plain.example.com/posts/1?a=1&amp;b=2</a>.<br>`,
+				"-- <br>\nPete",
+			},
+			excludes: []string{"<script>"},
+		},
+		{
+			fixture:  "plain_with_attachment.eml",
+			title:    "Your monthly summary",
+			contains: []string{"<p>Thanks for shopping — your summary is attached.</p>"},
+			excludes: []string{"Attached summary table"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			db := openTestDB(t)
+			h := newsletter.NewHandler(db)
+
+			article, _, err := h.HandleInbound(context.Background(), readFixture(t, tt.fixture))
+			if err != nil {
+				t.Fatalf("HandleInbound: %v", err)
+			}
+			if article.Title != tt.title {
+				t.Errorf("Title = %q, want %q", article.Title, tt.title)
+			}
+			if tt.blogName != "" {
+				blog, err := db.GetBlogByID(article.BlogID)
+				if err != nil || blog == nil {
+					t.Fatalf("GetBlogByID: %v", err)
+				}
+				if blog.Name != tt.blogName {
+					t.Errorf("blog name = %q, want %q", blog.Name, tt.blogName)
+				}
+			}
+			for _, want := range tt.contains {
+				if !strings.Contains(article.Content, want) {
+					t.Errorf("Content missing %q; got:\n%s", want, article.Content)
+				}
+			}
+			for _, unwanted := range tt.excludes {
+				if strings.Contains(article.Content, unwanted) {
+					t.Errorf("Content contains %q; got:\n%s", unwanted, article.Content)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleInboundStoresParts(t *testing.T) {
+	db := openTestDB(t)
+	h := newsletter.NewHandler(db)
+
+	article, _, err := h.HandleInbound(context.Background(), readFixture(t, "nested.eml"))
+	if err != nil {
+		t.Fatalf("HandleInbound: %v", err)
+	}
+	parts, err := db.ListNewsletterParts(article.ID)
+	if err != nil {
+		t.Fatalf("ListNewsletterParts: %v", err)
+	}
+	if len(parts) != 2 {
+		t.Fatalf("parts = %+v, want the logo and the PDF", parts)
+	}
+	logo, pdf := parts[0], parts[1]
+	if logo.ContentID != "logo@tinyletter.example.com" || logo.Filename != "logo.png" || logo.ContentType != "image/png" || !logo.Inline {
+		t.Errorf("logo = %+v", logo)
+	}
+	if pdf.Filename != "issue.pdf" || pdf.ContentType != "application/pdf" || pdf.Inline {
+		t.Errorf("pdf = %+v", pdf)
+	}
+	stored, err := db.GetNewsletterPart(article.ID, pdf.ID)
+	if err != nil || stored == nil || !strings.HasPrefix(string(stored.Data), "%PDF-1.4") {
+		t.Errorf("stored pdf = %+v, %v; want decoded base64", stored, err)
+	}
+
+	// The HTML attachment of a plain-text email is kept rather than dropped.
+	article, _, err = h.HandleInbound(context.Background(), readFixture(t, "plain_with_attachment.eml"))
+	if err != nil {
+		t.Fatalf("HandleInbound: %v", err)
+	}
+	parts, _ = db.ListNewsletterParts(article.ID)
+	if len(parts) != 1 || parts[0].Filename != "summary.html" || parts[0].Inline {
+		t.Errorf("parts = %+v, want summary.html as an attachment", parts)
+	}
+}
+
+func TestRewriteContentIDs(t *testing.T) {
+	body := `<img src="cid:logo@x.com"><td background='cid:bg%40x.com'><div style="background:url(cid:dot@x.com)">cid:text@x.com</div>`
+	got := newsletter.RewriteContentIDs(body, func(cid string) string { return "/parts/" + cid })
+	want := `<img src="/parts/logo@x.com"><td background='/parts/bg@x.com'><div style="background:url(/parts/dot@x.com)">cid:text@x.com</div>`
+	if got != want {
+		t.Errorf("RewriteContentIDs =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHandleInboundMetadata(t *testing.T) {
+	db := openTestDB(t)
+	h := newsletter.NewHandler(db)
+
+	before := time.Now()
+	article, _, err := h.HandleInbound(context.Background(), readFixture(t, "metadata.eml"))
+	if err != nil {
+		t.Fatalf("HandleInbound: %v", err)
+	}
+
+	want := time.Date(2024, 1, 17, 14, 0, 0, 0, time.UTC)
+	if article.PublishedDate == nil || !article.PublishedDate.Equal(want) {
+		t.Errorf("PublishedDate = %v, want %v", article.PublishedDate, want)
+	}
+	if article.DiscoveredDate == nil || article.DiscoveredDate.Before(before.Add(-time.Second)) {
+		t.Errorf("DiscoveredDate = %v, want the receipt time", article.DiscoveredDate)
+	}
+	// The tracking pixel and logo come first but are skipped
+	if article.ThumbnailURL != "https://cdn.longread.example.com/images/2024/01/harbour-at-dawn.jpg" {
+		t.Errorf("ThumbnailURL = %q", article.ThumbnailURL)
+	}
+
+	meta, err := db.GetNewsletterMeta(article.ID)
+	if err != nil || meta == nil {
+		t.Fatalf("GetNewsletterMeta = %v, %v", meta, err)
+	}
+	if meta.WebURL != "https://link.mailer.example.io/c/abc123" {
+		t.Errorf("WebURL = %q", meta.WebURL)
+	}
+	if meta.UnsubscribeURL != "https://link.mailer.example.io/unsubscribe/abc123" {
+		t.Errorf("UnsubscribeURL = %q", meta.UnsubscribeURL)
+	}
+	if meta.UnsubscribeMailto != "mailto:unsubscribe@mailer.example.io?subject=unsubscribe%20abc123" {
+		t.Errorf("UnsubscribeMailto = %q", meta.UnsubscribeMailto)
+	}
+}
+
+func TestHandleInboundDateFallback(t *testing.T) {
+	tests := map[string]string{
+		"missing":    "",
+		"garbled":    "Date: sometime last week\r\n",
+		"far future": "Date: Fri, 01 Jan 2100 00:00:00 +0000\r\n",
+	}
+	for name, dateHeader := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := openTestDB(t)
+			h := newsletter.NewHandler(db)
+
+			raw := "From: news@acme.com\r\nSubject: Undated\r\n" + dateHeader +
+				"Message-ID: <undated@acme.com>\r\nContent-Type: text/html\r\n\r\n<p>Hi</p>"
+			before := time.Now().Add(-time.Second)
+			article, _, err := h.HandleInbound(context.Background(), []byte(raw))
+			if err != nil {
+				t.Fatalf("HandleInbound: %v", err)
+			}
+			if article.PublishedDate == nil || article.PublishedDate.Before(before) || article.PublishedDate.After(time.Now()) {
+				t.Errorf("PublishedDate = %v, want the receipt time", article.PublishedDate)
+			}
+			if meta, _ := db.GetNewsletterMeta(article.ID); meta != nil {
+				t.Errorf("metadata stored for an email without links: %+v", meta)
+			}
+		})
+	}
+}
@@ -0,0 +1,302 @@
+// ABOUTME: Polls an IMAP mailbox for newsletters and ingests its unseen messages.
+// ABOUTME: Connection settings live in the settings table; processed messages are flagged seen or moved.
+package newsletter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/events"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/imap"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/logging"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/metrics"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/storage"
+)
+
+// Setting keys used to persist the IMAP connection.
+const (
+	keyIMAPHost     = "imap_host"
+	keyIMAPPort     = "imap_port"
+	keyIMAPUsername = "imap_username"
+	keyIMAPPassword = "imap_password"
+	keyIMAPFolder   = "imap_folder"
+	keyIMAPMoveTo   = "imap_move_to"
+	keyIMAPInterval = "imap_interval_minutes"
+	keyIMAPInsecure = "imap_insecure"
+)
+
+const (
+	// DefaultIMAPPort is the IMAP-over-TLS port used when none is set.
+	DefaultIMAPPort = 993
+	// DefaultIMAPFolder is polled when no folder is set.
+	DefaultIMAPFolder = "INBOX"
+	// DefaultIMAPInterval is how often the mailbox is polled when no interval is set.
+	DefaultIMAPInterval = 15 * time.Minute
+
+	// imapTimeout bounds one connection, including every fetch in a poll.
+	imapTimeout = 5 * time.Minute
+	// maxIMAPBatch caps how many messages one poll ingests, so a large backlog
+	// is worked through over several polls.
+	maxIMAPBatch = 100
+)
+
+// IMAPSettings holds the mailbox to poll and what to do with processed mail.
+type IMAPSettings struct {
+	Host     string // empty disables polling
+	Port     int    // 0 means DefaultIMAPPort
+	Username string
+	Password string
+	Folder   string        // empty means DefaultIMAPFolder
+	MoveTo   string        // empty leaves processed mail in Folder, flagged as seen
+	Interval time.Duration // 0 means DefaultIMAPInterval
+	// Insecure allows logging in without TLS when a server on a port other
+	// than 993 does not offer STARTTLS. The password then crosses the
+	// network in the clear.
+	Insecure bool
+}
+
+// Enabled reports whether enough is configured to poll.
+func (s IMAPSettings) Enabled() bool {
+	return s.Host != "" && s.Username != ""
+}
+
+func (s IMAPSettings) port() int {
+	if s.Port > 0 {
+		return s.Port
+	}
+	return DefaultIMAPPort
+}
+
+func (s IMAPSettings) folder() string {
+	if s.Folder != "" {
+		return s.Folder
+	}
+	return DefaultIMAPFolder
+}
+
+func (s IMAPSettings) interval() time.Duration {
+	if s.Interval > 0 {
+		return s.Interval
+	}
+	return DefaultIMAPInterval
+}
+
+// LoadIMAPSettings reads the IMAP settings from the database. Missing keys
+// yield zero values, which leave polling off.
+func LoadIMAPSettings(db *storage.Database) (IMAPSettings, error) {
+	var s IMAPSettings
+	var port, minutes, insecure string
+	for key, dst := range map[string]*string{
+		keyIMAPHost:     &s.Host,
+		keyIMAPPort:     &port,
+		keyIMAPUsername: &s.Username,
+		keyIMAPPassword: &s.Password,
+		keyIMAPFolder:   &s.Folder,
+		keyIMAPMoveTo:   &s.MoveTo,
+		keyIMAPInterval: &minutes,
+		keyIMAPInsecure: &insecure,
+	} {
+		value, err := db.GetSetting(key)
+		if err != nil {
+			return s, fmt.Errorf("read %s: %w", key, err)
+		}
+		*dst = value
+	}
+	s.Port, _ = strconv.Atoi(port)
+	s.Insecure = insecure == "true"
+	if n, _ := strconv.Atoi(minutes); n > 0 {
+		s.Interval = time.Duration(n) * time.Minute
+	}
+	return s, nil
+}
+
+// SaveIMAPSettings persists the IMAP settings. The interval is stored in
+// whole minutes.
+func SaveIMAPSettings(db *storage.Database, s IMAPSettings) error {
+	port, minutes := "", ""
+	if s.Port > 0 {
+		port = strconv.Itoa(s.Port)
+	}
+	if s.Interval > 0 {
+		minutes = strconv.Itoa(int(s.Interval / time.Minute))
+	}
+	values := map[string]string{
+		keyIMAPHost:     s.Host,
+		keyIMAPPort:     port,
+		keyIMAPUsername: s.Username,
+		keyIMAPPassword: s.Password,
+		keyIMAPFolder:   s.Folder,
+		keyIMAPMoveTo:   s.MoveTo,
+		keyIMAPInterval: minutes,
+		keyIMAPInsecure: strconv.FormatBool(s.Insecure),
+	}
+	for key, value := range values {
+		if err := db.SetSetting(key, value); err != nil {
+			return fmt.Errorf("save %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// connectIMAP logs in and selects the folder to poll.
+func connectIMAP(ctx context.Context, s IMAPSettings) (*imap.Client, error) {
+	c, err := imap.Dial(ctx, s.Host, s.port(), s.Insecure)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Login(s.Username, s.Password); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if _, err := c.Select(s.folder()); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// CheckIMAPConnection logs in with s, opens the folder and the move-to folder
+// if set, and returns how many unseen messages are waiting.
+func CheckIMAPConnection(ctx context.Context, s IMAPSettings) (int, error) {
+	if !s.Enabled() {
+		return 0, errors.New("IMAP server and username are required")
+	}
+	c, err := connectIMAP(ctx, s)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Logout()
+	if s.MoveTo != "" {
+		if _, err := c.Status(s.MoveTo); err != nil {
+			return 0, fmt.Errorf("folder %q: %w", s.MoveTo, err)
+		}
+	}
+	uids, err := c.SearchUnseen()
+	if err != nil {
+		return 0, err
+	}
+	return len(uids), nil
+}
+
+// IMAPResult summarises one poll.
+type IMAPResult struct {
+	Fetched     int // messages downloaded
+	Ingested    int // messages stored as new articles
+	Duplicates  int // messages already stored, by Message-ID
+	Rejected    int // malformed messages, flagged seen and left in place
+	Quarantined int // messages held for review, handled like ingested ones
+}
+
+// IMAPPoller ingests newsletters from the mailbox configured in settings.
+type IMAPPoller struct {
+	db      *storage.Database
+	handler *Handler
+	events  *events.Broker
+}
+
+// NewIMAPPoller returns a poller storing articles in db. New articles are
+// announced on broker when it is not nil.
+func NewIMAPPoller(db *storage.Database, broker *events.Broker) *IMAPPoller {
+	return &IMAPPoller{db: db, handler: NewHandler(db), events: broker}
+}
+
+// Poll ingests up to maxIMAPBatch unseen messages from the folder in s. Each
+// processed message is flagged seen, then moved when MoveTo is set. A
+// message that fails to store stays unseen and ends the poll, so it is
+// retried next time.
+func (p *IMAPPoller) Poll(ctx context.Context, s IMAPSettings) (IMAPResult, error) {
+	var result IMAPResult
+	c, err := connectIMAP(ctx, s)
+	if err != nil {
+		return result, err
+	}
+	defer c.Logout()
+	defer func() {
+		if result.Ingested > 0 && p.events != nil {
+			p.events.Publish(events.Event{Type: events.TypeArticlesNew, Data: map[string]int{"count": result.Ingested}})
+		}
+	}()
+
+	uids, err := c.SearchUnseen()
+	if err != nil {
+		return result, err
+	}
+	if len(uids) > maxIMAPBatch {
+		uids = uids[:maxIMAPBatch]
+	}
+	for _, uid := range uids {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		data, err := c.Fetch(uid)
+		if err != nil {
+			return result, err
+		}
+		result.Fetched++
+
+		malformed := false
+		if _, created, err := p.handler.HandleInbound(ctx, data); errors.Is(err, ErrQuarantined) {
+			metrics.NewsletterIngests.Inc("imap", "quarantined")
+			result.Quarantined++
+		} else if err != nil {
+			metrics.NewsletterIngests.Inc("imap", "error")
+			if !errors.Is(err, ErrMalformed) {
+				return result, fmt.Errorf("ingest message %d: %w", uid, err)
+			}
+			logging.FromContext(ctx).Warn("skipping malformed newsletter", "uid", uid, "err", err)
+			malformed = true
+			result.Rejected++
+		} else if !created {
+			metrics.NewsletterIngests.Inc("imap", "duplicate")
+			result.Duplicates++
+		} else {
+			metrics.NewsletterIngests.Inc("imap", "success")
+			result.Ingested++
+		}
+
+		if err := c.MarkSeen(uid); err != nil {
+			return result, err
+		}
+		if s.MoveTo != "" && !malformed {
+			if err := c.Move(uid, s.MoveTo); err != nil {
+				return result, err
+			}
+		}
+	}
+	return result, nil
+}
+
+// RunScheduler polls the mailbox whenever its interval has passed, checking
+// the settings every tick so changes apply without a restart. Blocks until
+// ctx is cancelled. Logs go to the logger carried by ctx.
+func (p *IMAPPoller) RunScheduler(ctx context.Context, tick time.Duration) {
+	logger := logging.FromContext(ctx)
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	var lastPoll time.Time
+	for {
+		s, err := LoadIMAPSettings(p.db)
+		if err != nil {
+			logger.Error("load IMAP settings", "err", err)
+		} else if s.Enabled() && time.Since(lastPoll) >= s.interval() {
+			lastPoll = time.Now()
+			pollCtx, cancel := context.WithTimeout(ctx, imapTimeout)
+			result, err := p.Poll(pollCtx, s)
+			cancel()
+			if err != nil {
+				logger.Warn("IMAP poll failed", "host", s.Host, "folder", s.folder(), "ingested", result.Ingested, "err", err)
+			} else if result.Fetched > 0 {
+				logger.Info("IMAP poll complete", "host", s.Host, "folder", s.folder(), "ingested", result.Ingested, "duplicates", result.Duplicates, "rejected", result.Rejected, "quarantined", result.Quarantined, "duration", time.Since(lastPoll))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// ABOUTME: Tests for IMAP polling against the in-memory imaptest server.
+// ABOUTME: Covers settings persistence, ingesting and flagging or moving mail, malformed messages and the connection check.
+package newsletter_test
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/imap/imaptest"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/newsletter"
+)
+
+func testIMAPSettings(srv *imaptest.Server) newsletter.IMAPSettings {
+	// The test server speaks plaintext only
+	return newsletter.IMAPSettings{Host: "127.0.0.1", Port: srv.Port(), Username: "reader", Password: "secret", Insecure: true}
+}
+
+func TestIMAPSettingsRoundTrip(t *testing.T) {
+	db := openTestDB(t)
+	if s, err := newsletter.LoadIMAPSettings(db); err != nil || s.Enabled() {
+		t.Fatalf("LoadIMAPSettings on a new database = %+v, %v; want disabled", s, err)
+	}
+	want := newsletter.IMAPSettings{Host: "imap.example.com", Port: 143, Username: "me", Password: "pw", Folder: "Newsletters", MoveTo: "Read", Interval: 30 * time.Minute, Insecure: true}
+	if err := newsletter.SaveIMAPSettings(db, want); err != nil {
+		t.Fatalf("SaveIMAPSettings: %v", err)
+	}
+	if got, err := newsletter.LoadIMAPSettings(db); err != nil || got != want {
+		t.Errorf("LoadIMAPSettings = %+v, %v; want %+v", got, err, want)
+	}
+}
+
+func TestIMAPPollFlagsSeen(t *testing.T) {
+	srv := imaptest.NewServer("reader", "secret")
+	t.Cleanup(srv.Close)
+	srv.Append("INBOX", readFixture(t, "html_only.eml"), `\Seen`) // already read: skipped
+	srv.Append("INBOX", readFixture(t, "multipart.eml"))
+	srv.Append("INBOX", readFixture(t, "multipart.eml")) // redelivered: counted as a duplicate
+	srv.Append("INBOX", []byte("this is not an email"))
+
+	db := openTestDB(t)
+	p := newsletter.NewIMAPPoller(db, nil)
+	result, err := p.Poll(context.Background(), testIMAPSettings(srv))
+	if err != nil {
+		t.Fatalf("Poll: %v", err)
+	}
+	if result != (newsletter.IMAPResult{Fetched: 3, Ingested: 1, Duplicates: 1, Rejected: 1}) {
+		t.Errorf("Poll = %+v, want 3 fetched, 1 ingested, 1 duplicate, 1 rejected", result)
+	}
+	articles, _ := db.ListArticles(false, nil)
+	if len(articles) != 1 {
+		t.Errorf("stored %d articles, want 1", len(articles))
+	}
+	for _, m := range srv.Messages("INBOX") {
+		if !slices.Contains(m.Flags, `\Seen`) {
+			t.Errorf("message %d not flagged seen", m.UID)
+		}
+	}
+
+	// Nothing is left for the next poll
+	if result, err := p.Poll(context.Background(), testIMAPSettings(srv)); err != nil || result.Fetched != 0 {
+		t.Errorf("second Poll = %+v, %v; want nothing fetched", result, err)
+	}
+}
+
+func TestIMAPPollMoves(t *testing.T) {
+	srv := imaptest.NewServer("reader", "secret")
+	t.Cleanup(srv.Close)
+	srv.CreateMailbox("Newsletters")
+	srv.CreateMailbox("Imported")
+	srv.Append("Newsletters", readFixture(t, "html_only.eml"))
+	srv.Append("Newsletters", []byte("this is not an email"))
+
+	settings := testIMAPSettings(srv)
+	settings.Folder = "Newsletters"
+	settings.MoveTo = "Imported"
+	if _, err := newsletter.NewIMAPPoller(openTestDB(t), nil).Poll(context.Background(), settings); err != nil {
+		t.Fatalf("Poll: %v", err)
+	}
+
+	// The newsletter moves; the malformed message stays behind for a look
+	left := srv.Messages("Newsletters")
+	if len(left) != 1 || string(left[0].Data) != "this is not an email" {
+		t.Errorf("Newsletters = %d messages, want only the malformed one", len(left))
+	}
+	moved := srv.Messages("Imported")
+	if len(moved) != 1 || !slices.Contains(moved[0].Flags, `\Seen`) {
+		t.Errorf("Imported = %+v, want one message flagged seen", moved)
+	}
+}
+
+func TestCheckIMAPConnection(t *testing.T) {
+	srv := imaptest.NewServer("reader", "secret")
+	t.Cleanup(srv.Close)
+	srv.Append("INBOX", readFixture(t, "html_only.eml"))
+	srv.Append("INBOX", readFixture(t, "multipart.eml"))
+	ctx := context.Background()
+
+	if n, err := newsletter.CheckIMAPConnection(ctx, testIMAPSettings(srv)); err != nil || n != 2 {
+		t.Errorf("CheckIMAPConnection = %d, %v; want 2 unseen", n, err)
+	}
+
+	bad := testIMAPSettings(srv)
+	bad.Password = "wrong"
+	if _, err := newsletter.CheckIMAPConnection(ctx, bad); err == nil || !strings.Contains(err.Error(), "LOGIN") {
+		t.Errorf("CheckIMAPConnection with a bad password = %v, want a LOGIN error", err)
+	}
+
+	missing := testIMAPSettings(srv)
+	missing.MoveTo = "Nowhere"
+	if _, err := newsletter.CheckIMAPConnection(ctx, missing); err == nil || !strings.Contains(err.Error(), "Nowhere") {
+		t.Errorf("CheckIMAPConnection with a missing move-to folder = %v", err)
+	}
+	if srv.Messages("INBOX")[0].Flags != nil {
+		t.Error("the connection check changed message flags")
+	}
+}
@@ -0,0 +1,87 @@
+// ABOUTME: Connects the built-in SMTP/LMTP listener to newsletter ingestion.
+// ABOUTME: Accepts mail only for the configured addresses or domains and the inbox address from settings.
+package newsletter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/events"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/metrics"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/smtpd"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/storage"
+)
+
+// inboxSettingKey holds the inbox address saved on the settings page.
+const inboxSettingKey = "newsletter_inbox_email"
+
+// MailBackend is an smtpd.Backend that ingests mail sent to the newsletter
+// inbox and refuses everything else.
+type MailBackend struct {
+	db         *storage.Database
+	handler    *Handler
+	recipients []string
+	source     string
+	events     *events.Broker
+}
+
+// NewMailBackend returns a backend accepting mail for recipients, each a full
+// address ("news@example.com") or a whole domain ("@example.com"), plus the
+// inbox address from settings. source labels ingest metrics ("smtp" or
+// "lmtp"); new articles are announced on broker when it is not nil.
+func NewMailBackend(db *storage.Database, recipients []string, source string, broker *events.Broker) *MailBackend {
+	lower := make([]string, 0, len(recipients))
+	for _, r := range recipients {
+		lower = append(lower, strings.ToLower(strings.TrimSpace(r)))
+	}
+	return &MailBackend{db: db, handler: NewHandler(db), recipients: lower, source: source, events: broker}
+}
+
+// Recipient accepts addr when it matches a configured address or domain or
+// the inbox address setting.
+func (b *MailBackend) Recipient(ctx context.Context, addr string) error {
+	addr = strings.ToLower(addr)
+	for _, r := range b.recipients {
+		if addr == r || (strings.HasPrefix(r, "@") && strings.HasSuffix(addr, r)) {
+			return nil
+		}
+	}
+	inbox, err := b.db.GetSetting(inboxSettingKey)
+	if err != nil {
+		return fmt.Errorf("read inbox address: %w", err)
+	}
+	if inbox != "" && addr == strings.ToLower(strings.TrimSpace(inbox)) {
+		return nil
+	}
+	metrics.NewsletterIngests.Inc(b.source, "rejected")
+	return &smtpd.Error{Code: 550, Message: "No such newsletter inbox"}
+}
+
+// Deliver ingests one message. Malformed messages are refused permanently;
+// storage errors are temporary so the sender retries. Quarantined messages
+// are accepted, so senders cannot probe the sender filter.
+func (b *MailBackend) Deliver(ctx context.Context, env smtpd.Envelope, data []byte) error {
+	_, created, err := b.handler.HandleInbound(ctx, data)
+	if errors.Is(err, ErrQuarantined) {
+		metrics.NewsletterIngests.Inc(b.source, "quarantined")
+		return nil
+	}
+	if err != nil {
+		metrics.NewsletterIngests.Inc(b.source, "error")
+		if errors.Is(err, ErrMalformed) {
+			return &smtpd.Error{Code: 554, Message: "Message could not be parsed"}
+		}
+		return err
+	}
+	if !created {
+		metrics.NewsletterIngests.Inc(b.source, "duplicate")
+		return nil
+	}
+	metrics.NewsletterIngests.Inc(b.source, "success")
+	if b.events != nil {
+		b.events.Publish(events.Event{Type: events.TypeArticlesNew, Data: map[string]int{"count": 1}})
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+// ABOUTME: Tests for the SMTP/LMTP backend that feeds mail into newsletter ingestion.
+// ABOUTME: Checks recipient matching, reply codes for bad mail, the articles-new event and quarantine of forged authentication.
+package newsletter_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/events"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/newsletter"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/smtpd"
+)
+
+func TestMailBackendRecipient(t *testing.T) {
+	db := openTestDB(t)
+	if err := db.SetSetting("newsletter_inbox_email", "Inbox@Settings.test"); err != nil {
+		t.Fatalf("SetSetting: %v", err)
+	}
+	b := newsletter.NewMailBackend(db, []string{"news@example.com", "@lists.example.com"}, "smtp", nil)
+
+	tests := []struct {
+		addr string
+		ok   bool
+	}{
+		{"news@example.com", true},
+		{"NEWS@Example.com", true},
+		{"anything@lists.example.com", true},
+		{"inbox@settings.test", true},
+		{"other@example.com", false},
+		{"news@example.com.evil.test", false},
+		{"anything@notlists.example.com", false},
+	}
+	for _, tt := range tests {
+		err := b.Recipient(context.Background(), tt.addr)
+		if tt.ok && err != nil {
+			t.Errorf("Recipient(%q) = %v, want accepted", tt.addr, err)
+		}
+		if !tt.ok {
+			var smtpErr *smtpd.Error
+			if !errors.As(err, &smtpErr) || smtpErr.Code != 550 {
+				t.Errorf("Recipient(%q) = %v, want 550", tt.addr, err)
+			}
+		}
+	}
+}
+
+func TestMailBackendDeliver(t *testing.T) {
+	db := openTestDB(t)
+	broker := events.NewBroker()
+	defer broker.Close()
+	ch, unsubscribe := broker.Subscribe()
+	defer unsubscribe()
+	b := newsletter.NewMailBackend(db, []string{"news@example.com"}, "lmtp", broker)
+	env := smtpd.Envelope{From: "sender@news.test", To: []string{"news@example.com"}}
+
+	if err := b.Deliver(context.Background(), env, readFixture(t, "html_only.eml")); err != nil {
+		t.Fatalf("Deliver: %v", err)
+	}
+	select {
+	case e := <-ch:
+		if e.Type != events.TypeArticlesNew {
+			t.Errorf("event type = %q, want %q", e.Type, events.TypeArticlesNew)
+		}
+	case <-time.After(time.Second):
+		t.Error("no articles-new event after delivery")
+	}
+	articles, err := db.ListArticles(false, nil)
+	if err != nil {
+		t.Fatalf("ListArticles: %v", err)
+	}
+	if len(articles) != 1 {
+		t.Errorf("stored %d articles, want 1", len(articles))
+	}
+
+	// A redelivery is accepted but announces nothing new
+	if err := b.Deliver(context.Background(), env, readFixture(t, "html_only.eml")); err != nil {
+		t.Fatalf("Deliver again: %v", err)
+	}
+	select {
+	case e := <-ch:
+		t.Errorf("redelivery published %q", e.Type)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Garbage is refused for good rather than retried
+	err = b.Deliver(context.Background(), env, []byte("this is not an email"))
+	var smtpErr *smtpd.Error
+	if !errors.As(err, &smtpErr) || smtpErr.Code != 554 {
+		t.Errorf("Deliver(garbage) = %v, want 554", err)
+	}
+}
+
+func TestMailBackendDeliverQuarantinesForgedAuthentication(t *testing.T) {
+	db := openTestDB(t)
+	if err := newsletter.SaveFilterSettings(db, newsletter.FilterSettings{CheckAuthentication: true, AuthServIDs: []string{"mx.example.net"}}); err != nil {
+		t.Fatal(err)
+	}
+	broker := events.NewBroker()
+	defer broker.Close()
+	ch, unsubscribe := broker.Subscribe()
+	defer unsubscribe()
+	b := newsletter.NewMailBackend(db, []string{"news@example.com"}, "smtp", broker)
+	env := smtpd.Envelope{From: "billing@bank.example", To: []string{"news@example.com"}}
+
+	// Straight over SMTP the sender writes every header, so these claim
+	// passes that no trusted server vouched for the From domain with
+	forged := [][]byte{
+		filterEmail("billing@bank.example", "own-domain", "Authentication-Results: mx.example.net; spf=pass smtp.mailfrom=attacker.example; dkim=pass header.d=attacker.example"),
+		filterEmail("billing@bank.example", "other-server", "Authentication-Results: mx.attacker.example; dkim=pass header.d=bank.example; dmarc=pass"),
+	}
+	for _, data := range forged {
+		// Accepted, so the sender cannot tell it was held
+		if err := b.Deliver(context.Background(), env, data); err != nil {
+			t.Fatalf("Deliver: %v", err)
+		}
+	}
+	select {
+	case e := <-ch:
+		t.Errorf("forged delivery published %q", e.Type)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if articles, _ := db.ListArticles(false, nil); len(articles) != 0 {
+		t.Errorf("stored %d articles from forged mail, want none", len(articles))
+	}
+	if held, _ := db.ListQuarantinedNewsletters(); len(held) != len(forged) {
+		t.Errorf("quarantine = %+v, want %d entries", held, len(forged))
+	}
+}
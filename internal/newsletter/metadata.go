@@ -0,0 +1,102 @@
+// ABOUTME: Extracts newsletter metadata: publish date, thumbnail, "view in browser" link and unsubscribe links.
+// ABOUTME: Reads the Date and List-Unsubscribe headers and scans the decoded HTML body with goquery.
+package newsletter
+
+import (
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/model"
+)
+
+// maxClockSkew is how far past receipt a Date header may lie before it is
+// treated as bogus.
+const maxClockSkew = 24 * time.Hour
+
+// publishedDate returns the message's Date header, or receivedAt when the
+// header is missing, unparseable or implausibly far in the future.
+func publishedDate(header mail.Header, receivedAt time.Time) time.Time {
+	date, err := header.Date()
+	if err != nil || date.IsZero() || date.After(receivedAt.Add(maxClockSkew)) {
+		return receivedAt
+	}
+	return date.UTC()
+}
+
+var (
+	angleBracketed = regexp.MustCompile(`<([^>]+)>`)
+	// webVersionText matches the usual wording of "view in browser" links.
+	webVersionText = regexp.MustCompile(`(?i)\b(view|read|open|see)\b.{0,30}\b(online|in (your |a |the )?browser|on (the )?web)\b|\bweb version\b`)
+	// trackingImage matches image URLs used for open tracking or spacing.
+	trackingImage = regexp.MustCompile(`(?i)pixel|track|beacon|spacer|blank\.gif|/open[/?.]|/o\.gif`)
+	// decorativeImage matches logos and icons, which say little about an issue.
+	decorativeImage = regexp.MustCompile(`(?i)logo|icon|avatar|badge`)
+)
+
+// parseMeta collects the List-Unsubscribe links from the headers and the
+// "view in browser" link from the HTML body.
+func parseMeta(header mail.Header, doc *goquery.Document) model.NewsletterMeta {
+	var meta model.NewsletterMeta
+	for _, m := range angleBracketed.FindAllStringSubmatch(header.Get("List-Unsubscribe"), -1) {
+		link := strings.TrimSpace(m[1])
+		switch {
+		case isHTTPURL(link) && meta.UnsubscribeURL == "":
+			meta.UnsubscribeURL = link
+		case strings.HasPrefix(strings.ToLower(link), "mailto:") && meta.UnsubscribeMailto == "":
+			meta.UnsubscribeMailto = link
+		}
+	}
+
+	doc.Find("a[href]").EachWithBreak(func(_ int, a *goquery.Selection) bool {
+		href := strings.TrimSpace(a.AttrOr("href", ""))
+		text := strings.Join(strings.Fields(a.Text()), " ")
+		if isHTTPURL(href) && webVersionText.MatchString(text) {
+			meta.WebURL = href
+			return false
+		}
+		return true
+	})
+	return meta
+}
+
+// findThumbnail returns the first image in the body that looks like content:
+// an absolute http(s) image that is not a tracking pixel, spacer, logo or icon,
+// and is not sized smaller than a thumbnail.
+func findThumbnail(doc *goquery.Document) string {
+	var found string
+	doc.Find("img[src]").EachWithBreak(func(_ int, img *goquery.Selection) bool {
+		src := strings.TrimSpace(img.AttrOr("src", ""))
+		if !isHTTPURL(src) || trackingImage.MatchString(src) {
+			return true
+		}
+		described := src + " " + img.AttrOr("alt", "") + " " + img.AttrOr("class", "")
+		if decorativeImage.MatchString(described) {
+			return true
+		}
+		if tooSmall(img.AttrOr("width", "")) || tooSmall(img.AttrOr("height", "")) {
+			return true
+		}
+		if style := strings.ReplaceAll(strings.ToLower(img.AttrOr("style", "")), " ", ""); strings.Contains(style, "display:none") {
+			return true
+		}
+		found = src
+		return false
+	})
+	return found
+}
+
+// tooSmall reports whether a width or height attribute is below thumbnail size.
+func tooSmall(dimension string) bool {
+	n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(dimension), "px"))
+	return err == nil && n < 50
+}
+
+func isHTTPURL(s string) bool {
+	lower := strings.ToLower(s)
+	return strings.HasPrefix(lower, "https://") || strings.HasPrefix(lower, "http://")
+}
@@ -0,0 +1,334 @@
+// ABOUTME: MIME body extraction for inbound newsletters: transfer-encoding, charsets and nested multiparts.
+// ABOUTME: Prefers the text/html body and keeps other parts as inline images or attachments.
+package newsletter
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"net/mail"
+	"net/textproto"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/model"
+)
+
+// maxMultipartDepth bounds recursion into nested multiparts so a hostile
+// message cannot exhaust the stack.
+const maxMultipartDepth = 10
+
+// bodies collects the first HTML and first plain-text body found while
+// walking a message, plus every other part as an image or attachment.
+type bodies struct {
+	html  string
+	plain string
+	found bool // an HTML body was found; later text parts are not bodies
+	parts []model.NewsletterPart
+}
+
+// extractBody returns the email body as HTML along with its inline images
+// and attachments. It decodes Content-Transfer-Encoding, converts charsets
+// to UTF-8 and walks nested multiparts. The first text/html body wins; when
+// there is none, the first text/plain body is rendered to HTML. Parts the
+// body references by cid: are marked Inline.
+func extractBody(msg *mail.Message) (string, []model.NewsletterPart, error) {
+	var b bodies
+	if err := b.walk(textproto.MIMEHeader(msg.Header), msg.Body, 0); err != nil {
+		return "", nil, err
+	}
+	body := b.html
+	if !b.found && b.plain != "" {
+		body = plainToHTML(b.plain)
+	}
+
+	referenced := make(map[string]bool)
+	RewriteContentIDs(body, func(cid string) string {
+		referenced[cid] = true
+		return ""
+	})
+	for i := range b.parts {
+		b.parts[i].Inline = b.parts[i].ContentID != "" && referenced[b.parts[i].ContentID]
+	}
+	return body, b.parts, nil
+}
+
+// walk visits one MIME entity, recursing into multiparts.
+func (b *bodies) walk(header textproto.MIMEHeader, body io.Reader, depth int) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil && !errors.Is(err, mime.ErrInvalidMediaParameter) {
+		// Missing or malformed Content-Type: RFC 2045 says text/plain, but
+		// some senders omit it on HTML bodies, so sniff.
+		mediaType, params = "", nil
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		if depth >= maxMultipartDepth {
+			return fmt.Errorf("multipart nested deeper than %d levels", maxMultipartDepth)
+		}
+		return b.walkMultipart(body, params["boundary"], depth)
+	}
+
+	cte := header.Get("Content-Transfer-Encoding")
+	switch mediaType {
+	case "", "text/html", "text/plain":
+		if !isAttachment(header) {
+			return b.addText(mediaType, params, cte, body)
+		}
+	}
+
+	data, err := io.ReadAll(decodeTransfer(cte, body))
+	if err != nil {
+		return fmt.Errorf("decode %s part: %w", cte, err)
+	}
+	if mediaType == "" {
+		mediaType = "application/octet-stream"
+	}
+	b.parts = append(b.parts, model.NewsletterPart{
+		ContentID:   strings.Trim(strings.TrimSpace(header.Get("Content-ID")), "<>"),
+		Filename:    partFilename(header, params, mediaType, len(b.parts)+1),
+		ContentType: mediaType,
+		Data:        data,
+	})
+	return nil
+}
+
+// addText considers a text part as the message body.
+func (b *bodies) addText(mediaType string, params map[string]string, cte string, body io.Reader) error {
+	if b.found {
+		return nil
+	}
+	data, err := io.ReadAll(decodeTransfer(cte, body))
+	if err != nil {
+		return fmt.Errorf("decode %s body: %w", cte, err)
+	}
+	if mediaType == "" {
+		mediaType = "text/plain"
+		if strings.HasPrefix(http.DetectContentType(data), "text/html") {
+			mediaType = "text/html"
+		}
+	}
+	text := decodeCharset(data, params["charset"])
+
+	switch mediaType {
+	case "text/html":
+		b.html, b.found = text, true
+	case "text/plain":
+		if b.plain == "" {
+			if strings.EqualFold(params["format"], "flowed") {
+				text = unflow(text, strings.EqualFold(params["delsp"], "yes"))
+			}
+			b.plain = text
+		}
+	}
+	return nil
+}
+
+// walkMultipart visits each part of a multipart body in order. Parts are read
+// raw so that transfer-encoding is handled the same way at every level.
+func (b *bodies) walkMultipart(r io.Reader, boundary string, depth int) error {
+	if boundary == "" {
+		return errors.New("multipart without boundary")
+	}
+	mr := multipart.NewReader(r, boundary)
+	for {
+		part, err := mr.NextRawPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := b.walk(part.Header, part, depth+1); err != nil {
+			return err
+		}
+	}
+}
+
+// isAttachment reports whether a part is marked as an attachment rather than
+// as part of the message body.
+func isAttachment(header textproto.MIMEHeader) bool {
+	disposition, _, err := mime.ParseMediaType(header.Get("Content-Disposition"))
+	return err == nil && disposition == "attachment"
+}
+
+// partFilename names a stored part after its Content-Disposition filename or
+// Content-Type name, without any directory. Unnamed parts get a numbered name
+// with an extension for their type.
+func partFilename(header textproto.MIMEHeader, params map[string]string, mediaType string, n int) string {
+	_, disposition, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	name := disposition["filename"]
+	if name == "" {
+		name = params["name"]
+	}
+	name = path.Base(strings.ReplaceAll(decodeHeader(name), `\`, "/"))
+	if name == "" || name == "." || name == "/" {
+		name = fmt.Sprintf("part-%d", n)
+		if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+			name += exts[0]
+		}
+	}
+	return name
+}
+
+var cidRefPattern = regexp.MustCompile(`(?i)((?:src|background)\s*=\s*["']?|url\(\s*["']?)cid:([^"'\s)>]+)`)
+
+// RewriteContentIDs replaces cid: references in an HTML body's src and
+// background attributes and CSS url() values with the URL partURL returns
+// for each Content-ID.
+func RewriteContentIDs(body string, partURL func(contentID string) string) string {
+	return cidRefPattern.ReplaceAllStringFunc(body, func(ref string) string {
+		m := cidRefPattern.FindStringSubmatch(ref)
+		cid, err := url.PathUnescape(m[2])
+		if err != nil {
+			cid = m[2]
+		}
+		return m[1] + partURL(cid)
+	})
+}
+
+// decodeTransfer wraps r to undo Content-Transfer-Encoding. Unknown encodings
+// and 7bit/8bit/binary pass through unchanged.
+func decodeTransfer(cte string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(cte)) {
+	case "base64":
+		// The decoder skips the CR and LF line breaks but nothing else, so
+		// drop any other stray whitespace some senders emit.
+		return base64.NewDecoder(base64.StdEncoding, &stripSpaceReader{r: r})
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	default:
+		return r
+	}
+}
+
+// stripSpaceReader removes spaces and tabs from base64 input.
+type stripSpaceReader struct {
+	r io.Reader
+}
+
+func (s *stripSpaceReader) Read(p []byte) (int, error) {
+	for {
+		n, err := s.r.Read(p)
+		kept := 0
+		for _, c := range p[:n] {
+			if c != ' ' && c != '\t' {
+				p[kept] = c
+				kept++
+			}
+		}
+		if kept > 0 || err != nil {
+			return kept, err
+		}
+	}
+}
+
+// decodeCharset converts data from the named charset to UTF-8. Without a
+// charset, valid UTF-8 is kept and anything else is read as Windows-1252,
+// which covers the usual mislabelled Latin-1 mail. Unknown charsets fall back
+// the same way rather than failing the whole message.
+func decodeCharset(data []byte, charset string) string {
+	var enc encoding.Encoding
+	if charset != "" {
+		enc, _ = htmlindex.Get(charset)
+	}
+	if enc == nil {
+		if utf8.Valid(data) {
+			return string(data)
+		}
+		enc = charmap.Windows1252
+	}
+	if enc == encoding.Nop {
+		return string(data)
+	}
+	out, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return strings.ToValidUTF8(string(data), "�")
+	}
+	return string(out)
+}
+
+// charsetReader converts r from the named charset to UTF-8 for the header
+// decoder.
+func charsetReader(charset string, r io.Reader) (io.Reader, error) {
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported charset %q", charset)
+	}
+	return enc.NewDecoder().Reader(r), nil
+}
+
+// unflow joins the soft line breaks of a format=flowed (RFC 3676) body.
+func unflow(text string, delSp bool) string {
+	var out strings.Builder
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		line = strings.TrimPrefix(line, " ") // space-stuffing
+		if strings.HasSuffix(line, " ") && line != "-- " {
+			if delSp {
+				line = strings.TrimSuffix(line, " ")
+			}
+			out.WriteString(line)
+			continue
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	return strings.TrimSuffix(out.String(), "\n")
+}
+
+var (
+	paragraphBreak  = regexp.MustCompile(`\n[ \t]*\n`)
+	plainURLPattern = regexp.MustCompile(`https?://[^\s<>"]+`)
+)
+
+// plainToHTML renders a plain-text body as HTML. Everything is escaped;
+// blank lines separate paragraphs, single newlines become <br> and bare
+// http(s) URLs become links.
+func plainToHTML(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	var out bytes.Buffer
+	for _, para := range paragraphBreak.Split(strings.TrimSpace(text), -1) {
+		if para == "" {
+			continue
+		}
+		out.WriteString("<p>")
+		for i, line := range strings.Split(para, "\n") {
+			if i > 0 {
+				out.WriteString("<br>\n")
+			}
+			out.WriteString(linkify(line))
+		}
+		out.WriteString("</p>\n")
+	}
+	return out.String()
+}
+
+// linkify escapes a line of text and wraps bare URLs in anchors. Trailing
+// punctuation is left outside the link.
+func linkify(line string) string {
+	var out strings.Builder
+	last := 0
+	for _, m := range plainURLPattern.FindAllStringIndex(line, -1) {
+		url := strings.TrimRight(line[m[0]:m[1]], ".,;:!?)'")
+		end := m[0] + len(url)
+		out.WriteString(html.EscapeString(line[last:m[0]]))
+		fmt.Fprintf(&out, `<a href="%s">%s</a>`, html.EscapeString(url), html.EscapeString(url))
+		last = end
+	}
+	out.WriteString(html.EscapeString(line[last:]))
+	return out.String()
+}
@@ -0,0 +1,73 @@
+// ABOUTME: Tests for OPML parsing and writing.
+// ABOUTME: Covers nested folders, title fallbacks and a write/parse round trip.
+package opml
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseFlattensFolders(t *testing.T) {
+	src := `<?xml version="1.0" encoding="UTF-8"?>
+<opml version="1.0">
+  <head><title>Subscriptions</title></head>
+  <body>
+    <outline text="Tech">
+      <outline text="Alpha Blog" type="rss" xmlUrl="https://alpha.example.com/feed" htmlUrl="https://alpha.example.com"/>
+      <outline text="Nested">
+        <outline text="Beta" title="Beta Title" xmlUrl=" https://beta.example.com/rss.xml "/>
+      </outline>
+    </outline>
+    <outline text="No feed here" htmlUrl="https://nofeed.example.com"/>
+  </body>
+</opml>`
+
+	feeds, err := Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(feeds) != 2 {
+		t.Fatalf("got %d feeds, want 2: %+v", len(feeds), feeds)
+	}
+	if feeds[0].Title != "Alpha Blog" || feeds[0].SiteURL != "https://alpha.example.com" {
+		t.Errorf("feeds[0] = %+v", feeds[0])
+	}
+	if feeds[1].Title != "Beta Title" || feeds[1].FeedURL != "https://beta.example.com/rss.xml" {
+		t.Errorf("feeds[1] = %+v, want title attribute preferred and URL trimmed", feeds[1])
+	}
+}
+
+func TestParseRejectsInvalidXML(t *testing.T) {
+	if _, err := Parse(strings.NewReader("not xml")); err == nil {
+		t.Error("expected error for invalid document")
+	}
+}
+
+func TestWriteRoundTrip(t *testing.T) {
+	in := []Feed{
+		{Title: "Alpha & Co", FeedURL: "https://alpha.example.com/feed", SiteURL: "https://alpha.example.com"},
+		{Title: "Beta", FeedURL: "https://beta.example.com/rss"},
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, "BlogWatcher", in); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if !strings.HasPrefix(buf.String(), "<?xml") || !strings.Contains(buf.String(), `version="2.0"`) {
+		t.Errorf("unexpected document header: %s", buf.String())
+	}
+
+	out, err := Parse(&buf)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(out) != len(in) {
+		t.Fatalf("got %d feeds, want %d", len(out), len(in))
+	}
+	for i := range in {
+		if out[i] != in[i] {
+			t.Errorf("feed %d = %+v, want %+v", i, out[i], in[i])
+		}
+	}
+}
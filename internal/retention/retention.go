@@ -0,0 +1,140 @@
+// ABOUTME: Applies article retention policies: the global policy from settings plus per-blog overrides.
+// ABOUTME: Runs on demand or on a schedule; unread and starred articles are always kept.
+package retention
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/logging"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/metrics"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/storage"
+)
+
+// Setting keys used to persist the global policy.
+const (
+	keyReadDays    = "retention_read_days"
+	keyMaxArticles = "retention_max_articles"
+)
+
+// orphanedName labels articles whose blog was deleted in results and metrics.
+const orphanedName = "(deleted blogs)"
+
+// LoadSettings reads the global retention policy from the database.
+// Missing keys leave a rule disabled.
+func LoadSettings(db *storage.Database) (storage.RetentionPolicy, error) {
+	var p storage.RetentionPolicy
+	for key, dst := range map[string]*int{keyReadDays: &p.ReadDays, keyMaxArticles: &p.MaxArticles} {
+		value, err := db.GetSetting(key)
+		if err != nil {
+			return p, fmt.Errorf("read %s: %w", key, err)
+		}
+		*dst, _ = strconv.Atoi(value)
+	}
+	return p, nil
+}
+
+// SaveSettings persists the global retention policy. Zero disables a rule.
+func SaveSettings(db *storage.Database, p storage.RetentionPolicy) error {
+	values := map[string]int{keyReadDays: p.ReadDays, keyMaxArticles: p.MaxArticles}
+	for key, n := range values {
+		value := ""
+		if n > 0 {
+			value = strconv.Itoa(n)
+		}
+		if err := db.SetSetting(key, value); err != nil {
+			return fmt.Errorf("save %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// BlogResult is what a run removed (or would remove) from one blog.
+type BlogResult struct {
+	BlogID   *int64                  `json:"blog_id"` // nil for articles of deleted blogs
+	BlogName string                  `json:"blog_name"`
+	Policy   storage.RetentionPolicy `json:"policy"`
+	Pruned   int                     `json:"pruned"`
+}
+
+// Result summarises a retention run. Blogs lists only blogs with articles
+// to prune.
+type Result struct {
+	DryRun bool         `json:"dry_run"`
+	Pruned int          `json:"pruned"`
+	Blogs  []BlogResult `json:"blogs"`
+}
+
+// Run applies the global policy and per-blog overrides at now. Articles of
+// deleted blogs follow the global age rule. With dryRun nothing is deleted
+// and Result reports what would be.
+func Run(db *storage.Database, now time.Time, dryRun bool) (Result, error) {
+	result := Result{DryRun: dryRun, Blogs: []BlogResult{}}
+	global, err := LoadSettings(db)
+	if err != nil {
+		return result, err
+	}
+	overrides, err := db.ListBlogRetention()
+	if err != nil {
+		return result, fmt.Errorf("list retention overrides: %w", err)
+	}
+	blogs, err := db.ListBlogs()
+	if err != nil {
+		return result, fmt.Errorf("list blogs: %w", err)
+	}
+
+	prune := func(blogID *int64, name string, policy storage.RetentionPolicy) error {
+		n, err := db.PruneArticles(blogID, policy, now, dryRun)
+		if err != nil {
+			return fmt.Errorf("prune %s: %w", name, err)
+		}
+		if n == 0 {
+			return nil
+		}
+		if !dryRun {
+			metrics.ArticlesPruned.Add(float64(n), name)
+		}
+		result.Pruned += n
+		result.Blogs = append(result.Blogs, BlogResult{BlogID: blogID, BlogName: name, Policy: policy, Pruned: n})
+		return nil
+	}
+
+	for _, blog := range blogs {
+		policy := global
+		if o, ok := overrides[blog.ID]; ok {
+			policy = o.Apply(global)
+		}
+		if err := prune(&blog.ID, blog.Name, policy); err != nil {
+			return result, err
+		}
+	}
+	if err := prune(nil, orphanedName, storage.RetentionPolicy{ReadDays: global.ReadDays}); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// RunScheduler applies retention every interval until ctx is cancelled.
+// Logs go to the logger carried by ctx.
+func RunScheduler(ctx context.Context, db *storage.Database, interval time.Duration) {
+	logger := logging.FromContext(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		start := time.Now()
+		result, err := Run(db, start, false)
+		if err != nil {
+			logger.Error("retention run failed", "err", err)
+		} else if result.Pruned > 0 {
+			logger.Info("pruned old articles", "articles", result.Pruned, "blogs", len(result.Blogs), "duration", time.Since(start))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// ABOUTME: Tests for retention runs combining the global policy with per-blog overrides.
+// ABOUTME: Uses a real temporary SQLite database.
+package retention
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/model"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/storage"
+)
+
+func openTestDB(t *testing.T) *storage.Database {
+	t.Helper()
+	db, err := storage.OpenDatabase(filepath.Join(t.TempDir(), "bw.db"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestRunAppliesOverrides(t *testing.T) {
+	db := openTestDB(t)
+
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	old := now.AddDate(0, 0, -90)
+	var blogs []model.Blog
+	for _, name := range []string{"Alpha", "Beta", "Gone"} {
+		blog, err := db.AddBlog(model.Blog{Name: name, URL: "https://" + name + ".example.com"})
+		if err != nil {
+			t.Fatalf("add blog: %v", err)
+		}
+		if _, err := db.AddArticlesBulk([]model.Article{
+			{BlogID: blog.ID, Title: "Old", URL: blog.URL + "/old", PublishedDate: &old, IsRead: true},
+		}); err != nil {
+			t.Fatalf("add article: %v", err)
+		}
+		blogs = append(blogs, blog)
+	}
+	// Beta keeps everything; Gone's article becomes orphaned
+	keep := 0
+	if err := db.SetBlogRetention(storage.BlogRetention{BlogID: blogs[1].ID, ReadDays: &keep}); err != nil {
+		t.Fatalf("set override: %v", err)
+	}
+	if err := db.DeleteBlogOnly(blogs[2].ID); err != nil {
+		t.Fatalf("delete blog: %v", err)
+	}
+
+	// Nothing is pruned until a policy is configured
+	if result, err := Run(db, now, false); err != nil || result.Pruned != 0 {
+		t.Fatalf("Run without policy = %+v, %v", result, err)
+	}
+
+	if err := SaveSettings(db, storage.RetentionPolicy{ReadDays: 30}); err != nil {
+		t.Fatalf("SaveSettings: %v", err)
+	}
+	if p, err := LoadSettings(db); err != nil || p != (storage.RetentionPolicy{ReadDays: 30}) {
+		t.Fatalf("LoadSettings = %+v, %v", p, err)
+	}
+
+	dry, err := Run(db, now, true)
+	if err != nil {
+		t.Fatalf("dry Run: %v", err)
+	}
+	if dry.Pruned != 2 || len(dry.Blogs) != 2 || dry.Blogs[0].BlogName != "Alpha" || dry.Blogs[1].BlogID != nil {
+		t.Fatalf("dry Run = %+v, want Alpha and the orphaned article", dry)
+	}
+
+	result, err := Run(db, now, false)
+	if err != nil || result.Pruned != 2 {
+		t.Fatalf("Run = %+v, %v", result, err)
+	}
+	if a, _ := db.GetArticleByURL(blogs[1].URL + "/old"); a == nil {
+		t.Error("Beta's override did not keep its article")
+	}
+	if total, _, _ := db.ArticleCounts(); total != 1 {
+		t.Errorf("%d articles left, want 1", total)
+	}
+}
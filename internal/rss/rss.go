@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/fetch"
 	"github.com/esttorhe/blogwatcher-ui/v2/internal/thumbnail"
 	"github.com/mmcdole/gofeed"
 )
@@ -36,7 +37,7 @@ func ParseFeed(ctx context.Context, feedURL string) ([]FeedArticle, error) {
 	if err != nil {
 		return nil, FeedParseError{Message: fmt.Sprintf("failed to build request: %v", err)}
 	}
-	client := &http.Client{Transport: http.DefaultTransport}
+	client := fetch.Client()
 	response, err := client.Do(req)
 	if err != nil {
 		return nil, FeedParseError{Message: fmt.Sprintf("failed to fetch feed: %v", err)}
@@ -76,7 +77,7 @@ func DiscoverFeedURL(ctx context.Context, blogURL string) (string, error) {
 	if err != nil {
 		return "", nil
 	}
-	client := &http.Client{Transport: http.DefaultTransport}
+	client := fetch.Client()
 	response, err := client.Do(req)
 	if err != nil {
 		return "", nil
@@ -149,7 +150,7 @@ func isValidFeed(ctx context.Context, feedURL string) (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	client := &http.Client{Transport: http.DefaultTransport}
+	client := fetch.Client()
 	response, err := client.Do(req)
 	if err != nil {
 		return false, err
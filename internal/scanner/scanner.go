@@ -8,6 +8,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/logging"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/metrics"
 	"github.com/esttorhe/blogwatcher-ui/v2/internal/model"
 	"github.com/esttorhe/blogwatcher-ui/v2/internal/rss"
 	"github.com/esttorhe/blogwatcher-ui/v2/internal/scraper"
@@ -16,7 +18,9 @@ import (
 )
 
 type ScanResult struct {
+	BlogID      int64
 	BlogName    string
+	FeedURL     string
 	NewArticles int
 	TotalFound  int
 	Source      string
@@ -26,8 +30,48 @@ type ScanResult struct {
 // ScanBlog scans a single blog for articles. It performs an incremental sync:
 // only articles whose URLs are not already in the database are processed, and
 // expensive operations like Open Graph thumbnail extraction only run for those
-// genuinely new articles.
+// genuinely new articles. The outcome is logged with the logger carried by
+// ctx (see logging.WithLogger), tagged with the blog ID, feed URL and source.
 func ScanBlog(ctx context.Context, db *storage.Database, blog model.Blog) ScanResult {
+	start := time.Now()
+	result := scanBlog(ctx, db, blog)
+	recordMetrics(result, start)
+	logResult(ctx, result, time.Since(start))
+	return result
+}
+
+// logResult logs the outcome of one blog scan with the logger from ctx.
+// Failures are warnings: a broken feed is the site's problem, not ours.
+func logResult(ctx context.Context, result ScanResult, elapsed time.Duration) {
+	logger := logging.FromContext(ctx).With(
+		"blog_id", result.BlogID,
+		"blog", result.BlogName,
+		"feed_url", result.FeedURL,
+		"source", result.Source,
+	)
+	if result.Error != "" {
+		logger.Warn("blog scan failed", "err", result.Error, "duration", elapsed)
+		return
+	}
+	logger.Debug("blog scanned",
+		"new_articles", result.NewArticles,
+		"total_found", result.TotalFound,
+		"duration", elapsed)
+}
+
+// recordMetrics exports the outcome of one blog scan.
+func recordMetrics(result ScanResult, start time.Time) {
+	outcome := "success"
+	if result.Error != "" {
+		outcome = "failure"
+	}
+	metrics.Scans.Inc(result.BlogName, outcome)
+	metrics.ScanDuration.Observe(metrics.Since(start), result.BlogName)
+	metrics.ScanArticlesFound.Add(float64(result.TotalFound), result.BlogName)
+	metrics.ScanArticlesNew.Add(float64(result.NewArticles), result.BlogName)
+}
+
+func scanBlog(ctx context.Context, db *storage.Database, blog model.Blog) ScanResult {
 	var (
 		source  = "none"
 		errText string
@@ -132,13 +176,13 @@ func ScanBlog(ctx context.Context, db *storage.Database, blog model.Blog) ScanRe
 			thumbURL = thumbnail.ExtractFromOpenGraph(ctx, stub.URL)
 		}
 		newArticles = append(newArticles, model.Article{
-			BlogID:        stub.BlogID,
-			Title:         stub.Title,
-			URL:           stub.URL,
-			ThumbnailURL:  thumbURL,
-			PublishedDate: stub.PublishedDate,
+			BlogID:         stub.BlogID,
+			Title:          stub.Title,
+			URL:            stub.URL,
+			ThumbnailURL:   thumbURL,
+			PublishedDate:  stub.PublishedDate,
 			DiscoveredDate: &discoveredAt,
-			IsRead:        false,
+			IsRead:         false,
 		})
 	}
 
@@ -156,7 +200,9 @@ func ScanBlog(ctx context.Context, db *storage.Database, blog model.Blog) ScanRe
 	_ = db.UpdateBlogLastScanned(blog.ID, time.Now())
 
 	return ScanResult{
+		BlogID:      blog.ID,
 		BlogName:    blog.Name,
+		FeedURL:     feedURL,
 		NewArticles: newCount,
 		TotalFound:  len(seenURLs),
 		Source:      source,
@@ -164,6 +210,10 @@ func ScanBlog(ctx context.Context, db *storage.Database, blog model.Blog) ScanRe
 	}
 }
 
+// ProgressFunc is called once per blog as soon as its scan completes.
+// Calls are made sequentially from a single goroutine.
+type ProgressFunc func(result ScanResult)
+
 // ScanAllBlogs scans all blogs concurrently using goroutines and channels.
 // Each blog gets its own goroutine for network I/O, but database writes are
 // serialized through the single db connection to avoid SQLite write conflicts.
@@ -172,9 +222,15 @@ func ScanAllBlogs(ctx context.Context, db *storage.Database) ([]ScanResult, erro
 	if err != nil {
 		return nil, err
 	}
+	return ScanBlogs(ctx, db, blogs, nil), nil
+}
 
+// ScanBlogs scans the given blogs concurrently, reporting each result to
+// progress (if non-nil) in completion order. The returned slice is in the same
+// order as blogs.
+func ScanBlogs(ctx context.Context, db *storage.Database, blogs []model.Blog, progress ProgressFunc) []ScanResult {
 	if len(blogs) == 0 {
-		return nil, nil
+		return nil
 	}
 
 	results := make([]ScanResult, len(blogs))
@@ -204,9 +260,12 @@ func ScanAllBlogs(ctx context.Context, db *storage.Database) ([]ScanResult, erro
 
 	for item := range resultCh {
 		results[item.Index] = item.Result
+		if progress != nil {
+			progress(item.Result)
+		}
 	}
 
-	return results, nil
+	return results
 }
 
 func ScanBlogByName(ctx context.Context, db *storage.Database, name string) (*ScanResult, error) {
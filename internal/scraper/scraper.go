@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/fetch"
 )
 
 type ScrapedArticle struct {
@@ -33,7 +34,7 @@ func ScrapeBlog(ctx context.Context, blogURL string, selector string) ([]Scraped
 	if err != nil {
 		return nil, ScrapeError{Message: fmt.Sprintf("failed to build request: %v", err)}
 	}
-	client := &http.Client{Transport: http.DefaultTransport}
+	client := fetch.Client()
 	response, err := client.Do(req)
 	if err != nil {
 		return nil, ScrapeError{Message: fmt.Sprintf("failed to fetch page: %v", err)}
@@ -0,0 +1,174 @@
+// ABOUTME: Handlers for database backups and the JSON export/import of all data.
+// ABOUTME: Serves both the settings page (HTMX fragments) and the JSON API.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/events"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/storage"
+)
+
+// maxImportBytes bounds an uploaded export. Newsletter bodies make exports
+// much larger than the article count suggests.
+const maxImportBytes = 512 << 20
+
+// handleAPIListBackups returns the stored backups, newest first.
+func (s *Server) handleAPIListBackups(w http.ResponseWriter, r *http.Request) {
+	backups, err := s.backups.List()
+	if err != nil {
+		requestLogger(r).Error("list backups", "err", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, backups)
+}
+
+// handleAPICreateBackup backs up the database now and returns the new backup.
+func (s *Server) handleAPICreateBackup(w http.ResponseWriter, r *http.Request) {
+	b, err := s.backups.Create()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	w.Header().Set("Location", s.path("/api/backups/"+b.Name))
+	writeJSON(w, http.StatusCreated, b)
+}
+
+// handleDownloadBackup serves a backup file as an attachment.
+func (s *Server) handleDownloadBackup(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	path, err := s.backups.Path(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+// handleSettingsBackup backs up the database from the settings page and
+// re-renders the backup list.
+func (s *Server) handleSettingsBackup(w http.ResponseWriter, r *http.Request) {
+	_, err := s.backups.Create()
+	data := s.backupListData(r)
+	if err != nil {
+		data["Error"] = "Backup failed: " + err.Error()
+	} else {
+		data["Message"] = "Backup created"
+	}
+	s.renderTemplate(w, "backup-list.gohtml", data)
+}
+
+// backupListData is the template data for backup-list.gohtml.
+func (s *Server) backupListData(r *http.Request) map[string]interface{} {
+	backups, err := s.backups.List()
+	if err != nil {
+		requestLogger(r).Error("list backups", "err", err)
+	}
+	return map[string]interface{}{
+		"Backups":   backups,
+		"BackupDir": s.backups.Dir(),
+		"Keep":      s.backups.Keep(),
+	}
+}
+
+// handleExport downloads every blog, article and setting as JSON. Passwords
+// and signing keys are only included with include_secrets=true.
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	opts := storage.ExportOptions{IncludeSecrets: r.URL.Query().Get("include_secrets") == "true"}
+	exp, err := s.db.Export(r.Context(), opts)
+	if err != nil {
+		requestLogger(r).Error("export database", "err", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	filename := "blogwatcher-export-" + exp.ExportedAt.Format("20060102") + ".json"
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	writeJSON(w, http.StatusOK, exp)
+}
+
+// handleAPIImport merges a JSON export from the request body.
+func (s *Server) handleAPIImport(w http.ResponseWriter, r *http.Request) {
+	result, err := s.importExport(r, http.MaxBytesReader(w, r.Body, maxImportBytes))
+	if err != nil {
+		writeJSON(w, importErrorStatus(err), map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleSettingsImport merges a JSON export uploaded from the settings page.
+func (s *Server) handleSettingsImport(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		s.renderSettingsStatus(w, "", "Choose an export file to import")
+		return
+	}
+	defer file.Close()
+
+	result, err := s.importExport(r, file)
+	if err != nil {
+		s.renderSettingsStatus(w, "", "Import failed: "+err.Error())
+		return
+	}
+	s.renderSettingsStatus(w, fmt.Sprintf("Imported %d blogs and %d articles; updated read or starred state of %d articles",
+		result.BlogsAdded, result.ArticlesAdded, result.ArticlesUpdated), "")
+}
+
+// importExport decodes an export from body and merges it into the database.
+func (s *Server) importExport(r *http.Request, body io.Reader) (storage.ImportResult, error) {
+	var exp storage.Export
+	if err := json.NewDecoder(body).Decode(&exp); err != nil {
+		return storage.ImportResult{}, fmt.Errorf("%w: %w", storage.ErrInvalidExport, err)
+	}
+	start := time.Now()
+	result, err := s.db.Import(&exp)
+	if err != nil {
+		requestLogger(r).Warn("import failed", "err", err)
+		return result, err
+	}
+	requestLogger(r).Info("import complete",
+		"blogs_added", result.BlogsAdded,
+		"articles_added", result.ArticlesAdded,
+		"articles_updated", result.ArticlesUpdated,
+		"settings", result.Settings,
+		"duration", time.Since(start))
+	if result.ArticlesAdded > 0 {
+		s.events.Publish(events.Event{Type: events.TypeArticlesNew, Data: map[string]int{"count": result.ArticlesAdded}})
+	}
+	return result, nil
+}
+
+// importErrorStatus maps an import error to a response status: problems with
+// the document are the client's, anything else is ours.
+func importErrorStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, storage.ErrInvalidExport):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
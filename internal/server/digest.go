@@ -0,0 +1,147 @@
+// ABOUTME: HTTP handlers for the email digest preview, settings form and manual send.
+// ABOUTME: Digest building and delivery live in the digest package; these only wire HTTP to it.
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/digest"
+)
+
+// handleDigestPreview renders the digest that would be sent next.
+// Use ?format=text to preview the plain-text alternative.
+func (s *Server) handleDigestPreview(w http.ResponseWriter, r *http.Request) {
+	settings, err := digest.LoadSettings(s.db)
+	if err != nil {
+		requestLogger(r).Error("load digest settings", "err", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	d, err := digest.Build(s.db, settings.Since(now), now, s.baseURL(r))
+	if err != nil {
+		requestLogger(r).Error("build digest preview", "err", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "text" {
+		body, err := d.RenderText()
+		if err != nil {
+			requestLogger(r).Error("render digest text", "err", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(body))
+		return
+	}
+
+	body, err := d.RenderHTML()
+	if err != nil {
+		requestLogger(r).Error("render digest HTML", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(body))
+}
+
+// handleSaveDigestSettings stores the digest schedule, recipients and SMTP settings.
+// An empty password field keeps the previously stored password.
+func (s *Server) handleSaveDigestSettings(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	existing, err := digest.LoadSettings(s.db)
+	if err != nil {
+		requestLogger(r).Error("load digest settings", "err", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	settings := digest.Settings{
+		Schedule:     r.FormValue("schedule"),
+		Recipients:   digest.ParseRecipients(r.FormValue("recipients")),
+		From:         strings.TrimSpace(r.FormValue("from")),
+		SMTPHost:     strings.TrimSpace(r.FormValue("smtp_host")),
+		SMTPUsername: strings.TrimSpace(r.FormValue("smtp_username")),
+		SMTPPassword: r.FormValue("smtp_password"),
+	}
+	switch settings.Schedule {
+	case digest.ScheduleOff, digest.ScheduleDaily, digest.ScheduleWeekly:
+	default:
+		s.renderSettingsStatus(w, "", "Schedule must be off, daily or weekly")
+		return
+	}
+	if portParam := strings.TrimSpace(r.FormValue("smtp_port")); portParam != "" {
+		port, err := strconv.Atoi(portParam)
+		if err != nil || port <= 0 || port > 65535 {
+			s.renderSettingsStatus(w, "", "SMTP port must be a number between 1 and 65535")
+			return
+		}
+		settings.SMTPPort = port
+	}
+	if settings.SMTPPassword == "" {
+		settings.SMTPPassword = existing.SMTPPassword
+	}
+
+	if err := digest.SaveSettings(s.db, settings); err != nil {
+		requestLogger(r).Error("save digest settings", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	s.renderSettingsStatus(w, "Digest settings saved", "")
+}
+
+// handleSendDigest sends the pending digest immediately and advances the
+// last-sent marker so the scheduled digest does not repeat the same articles.
+func (s *Server) handleSendDigest(w http.ResponseWriter, r *http.Request) {
+	settings, err := digest.LoadSettings(s.db)
+	if err != nil {
+		requestLogger(r).Error("load digest settings", "err", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	d, err := digest.Build(s.db, settings.Since(now), now, s.baseURL(r))
+	if err != nil {
+		requestLogger(r).Error("build digest", "err", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if d.Total == 0 {
+		s.renderSettingsStatus(w, "", "No new articles since the last digest")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+	defer cancel()
+	if err := digest.Send(ctx, settings, d); err != nil {
+		requestLogger(r).Error("send digest", "err", err)
+		s.renderSettingsStatus(w, "", "Send failed: "+err.Error())
+		return
+	}
+	if err := digest.MarkSent(s.db, now); err != nil {
+		requestLogger(r).Error("record digest send time", "err", err)
+	}
+	requestLogger(r).Info("digest sent", "articles", d.Total, "manual", true)
+	s.renderSettingsStatus(w, "Digest sent to "+strings.Join(settings.Recipients, ", "), "")
+}
+
+// renderSettingsStatus renders a short success or error message for settings forms.
+func (s *Server) renderSettingsStatus(w http.ResponseWriter, message, errMessage string) {
+	data := map[string]interface{}{
+		"Message": message,
+		"Error":   errMessage,
+	}
+	s.renderTemplate(w, "settings-status.gohtml", data)
+}
@@ -0,0 +1,86 @@
+// ABOUTME: Server-Sent Events endpoint that streams live update events to browsers.
+// ABOUTME: Open tabs use these to refresh the article list and sidebar counts without polling.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/events"
+)
+
+// sseHeartbeatInterval keeps idle connections alive through proxies that
+// close silent streams.
+const sseHeartbeatInterval = 25 * time.Second
+
+// handleEvents streams broker events as text/event-stream until the client
+// disconnects or the server shuts down.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	// The server's WriteTimeout would otherwise cut the stream after a few seconds.
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		requestLogger(r).Warn("events: clear write deadline", "err", err)
+	}
+
+	ch, unsubscribe := s.events.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 5000\n\n")
+	if err := rc.Flush(); err != nil {
+		requestLogger(r).Warn("events: flush", "err", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(e.Data)
+			if err != nil {
+				requestLogger(r).Error("events: encode", "type", e.Type, "err", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// publishReadState notifies open tabs that the read state of one article, or
+// of all articles in a blog when articleID is 0, has changed.
+func (s *Server) publishReadState(articleID int64, blogID *int64, isRead bool) {
+	data := map[string]interface{}{"is_read": isRead}
+	if articleID != 0 {
+		data["article_id"] = articleID
+	}
+	if blogID != nil {
+		data["blog_id"] = *blogID
+	}
+	s.events.Publish(events.Event{Type: events.TypeReadStateChanged, Data: data})
+}
+
+// publishNewArticles notifies open tabs that count new articles were stored.
+func (s *Server) publishNewArticles(count int) {
+	if count <= 0 {
+		return
+	}
+	s.events.Publish(events.Event{Type: events.TypeArticlesNew, Data: map[string]int{"count": count}})
+}
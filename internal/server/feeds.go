@@ -0,0 +1,160 @@
+// ABOUTME: HTTP handlers that re-publish filtered article lists as Atom, RSS and JSON Feed.
+// ABOUTME: Accepts the same filter query parameters as the article list plus filter=all.
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/feed"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/model"
+)
+
+const (
+	// defaultFeedSize is the number of entries in a generated feed when no limit is given.
+	defaultFeedSize = 50
+	// maxFeedSize caps the limit query parameter to keep feed documents bounded.
+	maxFeedSize = 500
+)
+
+// handleFeed renders the articles matching the request's filters as a feed.
+// The {format} path value selects atom, rss or json output.
+func (s *Server) handleFeed(w http.ResponseWriter, r *http.Request) {
+	format, ok := feed.ParseFormat(r.PathValue("format"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	opts, filter, currentBlogID := parseFeedOptions(r)
+
+	articles, _, err := s.db.SearchArticles(opts)
+	if err != nil {
+		requestLogger(r).Error("fetch articles for feed", "err", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	base := s.baseURL(r)
+	f := feed.Feed{
+		Title:       feedTitle(filter, s.blogNameForID(currentBlogID), opts.SearchQuery),
+		Description: "Articles collected by BlogWatcher",
+		SelfURL:     base + r.URL.RequestURI(),
+		SiteURL:     base + "/articles?" + siteQuery(r),
+		Updated:     time.Now(),
+	}
+	for i, a := range articles {
+		item := feedItem(base, a)
+		if i == 0 {
+			f.Updated = item.Updated
+		}
+		f.Items = append(f.Items, item)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := feed.Write(buf, format, f); err != nil {
+		requestLogger(r).Error("render feed", "format", format, "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", format.ContentType())
+	if _, err := buf.WriteTo(w); err != nil {
+		requestLogger(r).Warn("write feed response", "format", format, "err", err)
+	}
+}
+
+// parseFeedOptions builds search options for a feed request. Unlike the article
+// list, feeds default to all articles; filter=read, filter=unread and
+// filter=starred narrow it.
+func parseFeedOptions(r *http.Request) (model.SearchOptions, string, int64) {
+	opts, filter, currentBlogID := parseSearchOptions(r)
+	switch r.URL.Query().Get("filter") {
+	case "read", "unread", "starred":
+	default:
+		opts.IsRead = nil
+		filter = "all"
+	}
+
+	opts.Offset = 0
+	opts.Limit = defaultFeedSize
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		if limit, err := strconv.Atoi(limitParam); err == nil && limit > 0 {
+			opts.Limit = min(limit, maxFeedSize)
+		}
+	}
+	return opts, filter, currentBlogID
+}
+
+// feedItem converts an article row into a feed entry with absolute URLs.
+// Newsletter articles link to their in-app page and carry their HTML body,
+// with inline images pointing back at this server.
+func feedItem(base string, a model.ArticleWithBlog) feed.Item {
+	item := feed.Item{
+		ID:         a.URL,
+		Title:      a.Title,
+		URL:        a.URL,
+		SourceName: a.BlogName,
+		ImageURL:   a.ThumbnailURL,
+	}
+	if strings.HasPrefix(a.BlogURL, "http://") || strings.HasPrefix(a.BlogURL, "https://") {
+		item.SourceURL = a.BlogURL
+	}
+	if isNewsletterURL(a.URL) {
+		item.URL = fmt.Sprintf("%s/newsletter/article/%d", base, a.ID)
+		item.ID = item.URL
+		item.ContentHTML = newsletterContent(base, a.ID, a.Content)
+	}
+	if a.PublishedDate != nil {
+		item.Published = *a.PublishedDate
+	}
+	if a.DiscoveredDate != nil {
+		item.Updated = *a.DiscoveredDate
+	}
+	if item.Updated.IsZero() {
+		item.Updated = item.Published
+	}
+	return item
+}
+
+// feedTitle describes the list a feed was generated from.
+func feedTitle(filter, blogName, search string) string {
+	title := "BlogWatcher"
+	switch {
+	case blogName != "":
+		title += " - " + blogName
+	case filter == "unread":
+		title += " - Unread"
+	case filter == "read":
+		title += " - Archived"
+	case filter == "starred":
+		title += " - Starred"
+	}
+	if search != "" {
+		title += fmt.Sprintf(" (search: %s)", search)
+	}
+	return title
+}
+
+// siteQuery returns the request's query without feed-only parameters so it can
+// be reused for the HTML article list link.
+func siteQuery(r *http.Request) string {
+	q := r.URL.Query()
+	q.Del("limit")
+	if q.Get("filter") == "all" {
+		q.Del("filter")
+	}
+	return q.Encode()
+}
+
+// baseURL returns the configured public URL, or the one derived from the
+// request when none is configured.
+func (s *Server) baseURL(r *http.Request) string {
+	if s.publicURL != "" {
+		return s.publicURL
+	}
+	return s.requestBaseURL(r)
+}
@@ -9,18 +9,28 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"html/template"
 	"io"
-	"log"
+	"log/slog"
+	"mime"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/digest"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/inbound"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/logging"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/metrics"
 	"github.com/esttorhe/blogwatcher-ui/v2/internal/model"
 	"github.com/esttorhe/blogwatcher-ui/v2/internal/newsletter"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/retention"
 	"github.com/esttorhe/blogwatcher-ui/v2/internal/scanner"
 	"github.com/esttorhe/blogwatcher-ui/v2/internal/service"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/storage"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/syncjob"
 )
 
 // generateWebhookSecret creates a cryptographically random 32-byte hex string.
@@ -40,12 +50,12 @@ func generateWebhookSecret() string {
 func (s *Server) renderTemplate(w http.ResponseWriter, name string, data interface{}) {
 	buf := &bytes.Buffer{}
 	if err := s.templates.ExecuteTemplate(buf, name, data); err != nil {
-		log.Printf("Error rendering template %s: %v", name, err)
+		s.logger.Error("render template", "template", name, "err", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 	if _, err := buf.WriteTo(w); err != nil {
-		log.Printf("Error writing template %s to response: %v", name, err)
+		s.logger.Warn("write template response", "template", name, "err", err)
 	}
 }
 
@@ -53,35 +63,20 @@ func (s *Server) renderTemplate(w http.ResponseWriter, name string, data interfa
 // Fetches both blogs and articles for initial render
 // Supports filter, blog, search, and date query params for direct URL access
 func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
-	blogs, err := s.db.ListBlogs()
-	if err != nil {
-		log.Printf("Error fetching blogs: %v", err)
-		blogs = nil
-	}
-
 	// Build search options from query parameters
 	opts, filter, currentBlogID := parseSearchOptions(r)
 
-	// Fetch articles using SearchArticles for all filter combinations
-	articles, articleCount, err := s.db.SearchArticles(opts)
+	// Fetch articles using SearchArticlesPage for all filter combinations
+	page, err := s.db.SearchArticlesPage(opts)
 	if err != nil {
-		log.Printf("Error fetching articles: %v", err)
-		articles = nil
-		articleCount = 0
+		requestLogger(r).Error("fetch articles", "err", err)
+		page = model.ArticlePage{}
 	}
-
-	// Calculate if there are more articles
-	pageSize := opts.Limit
-	if pageSize <= 0 {
-		pageSize = model.DefaultPageSize
-	}
-	hasMore := len(articles) == pageSize && opts.Offset+len(articles) < articleCount
-	nextOffset := opts.Offset + len(articles)
-	displayedCount := opts.Offset + len(articles)
+	articles := page.Articles
+	articleCount, displayedCount := pageCounts(r, page)
 
 	data := map[string]interface{}{
 		"Title":           "BlogWatcher",
-		"Blogs":           blogs,
 		"Articles":        articles,
 		"ArticleCount":    articleCount,
 		"DisplayedCount":  displayedCount,
@@ -92,9 +87,10 @@ func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 		"DateFrom":        r.URL.Query().Get("date_from"),
 		"DateTo":          r.URL.Query().Get("date_to"),
 		"Version":         s.version,
-		"HasMore":         hasMore,
-		"NextOffset":      nextOffset,
+		"HasMore":         page.NextCursor != "",
+		"NextCursor":      page.NextCursor,
 	}
+	s.addSidebarData(data)
 	s.renderTemplate(w, "index.gohtml", data)
 }
 
@@ -105,22 +101,20 @@ func (s *Server) handleArticleList(w http.ResponseWriter, r *http.Request) {
 	// Build search options from query parameters
 	opts, filter, currentBlogID := parseSearchOptions(r)
 
-	// Fetch articles using SearchArticles for all filter combinations
-	articles, articleCount, err := s.db.SearchArticles(opts)
+	// Fetch articles using SearchArticlesPage for all filter combinations
+	page, err := s.db.SearchArticlesPage(opts)
+	if errors.Is(err, storage.ErrInvalidCursor) {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
-		log.Printf("Error fetching articles: %v", err)
+		requestLogger(r).Error("fetch articles", "err", err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
-
-	// Calculate if there are more articles
-	pageSize := opts.Limit
-	if pageSize <= 0 {
-		pageSize = model.DefaultPageSize
-	}
-	hasMore := len(articles) == pageSize && opts.Offset+len(articles) < articleCount
-	nextOffset := opts.Offset + len(articles)
-	displayedCount := opts.Offset + len(articles)
+	articles := page.Articles
+	articleCount, displayedCount := pageCounts(r, page)
+	isLoadMore := opts.After != "" || opts.Offset > 0
 
 	data := map[string]interface{}{
 		"Articles":        articles,
@@ -132,15 +126,15 @@ func (s *Server) handleArticleList(w http.ResponseWriter, r *http.Request) {
 		"SearchQuery":     opts.SearchQuery,
 		"DateFrom":        r.URL.Query().Get("date_from"),
 		"DateTo":          r.URL.Query().Get("date_to"),
-		"HasMore":         hasMore,
-		"NextOffset":      nextOffset,
-		"IsLoadMore":      opts.Offset > 0,
+		"HasMore":         page.NextCursor != "",
+		"NextCursor":      page.NextCursor,
+		"IsLoadMore":      isLoadMore,
 	}
 
 	// Check if this is an HTMX request
 	if r.Header.Get("HX-Request") == "true" {
-		// If this is a "load more" request (offset > 0), return just the articles
-		if opts.Offset > 0 {
+		// If this is a "load more" request (cursor or offset), return just the articles
+		if isLoadMore {
 			s.renderTemplate(w, "article-items.gohtml", data)
 			return
 		}
@@ -152,21 +146,32 @@ func (s *Server) handleArticleList(w http.ResponseWriter, r *http.Request) {
 	// Return full page for direct navigation
 	data["Title"] = "BlogWatcher"
 	data["Version"] = s.version
-	blogs, err := s.db.ListBlogs()
-	if err != nil {
-		log.Printf("Error fetching blogs: %v", err)
-	} else {
-		data["Blogs"] = blogs
-	}
+	s.addSidebarData(data)
 	s.renderTemplate(w, "index.gohtml", data)
 }
 
+// pageCounts returns the total number of matching articles and how many are
+// shown once page is rendered. Later pages don't count the total again; the
+// loader carries it and the number already shown in its URL.
+func pageCounts(r *http.Request, page model.ArticlePage) (total, displayed int) {
+	shown, _ := strconv.Atoi(r.URL.Query().Get("shown"))
+	if offset, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && offset > 0 {
+		shown = offset
+	}
+	total = page.Total
+	if total < 0 {
+		total, _ = strconv.Atoi(r.URL.Query().Get("total"))
+	}
+	displayed = max(shown, 0) + len(page.Articles)
+	return max(total, displayed), displayed
+}
+
 // handleBlogList serves the blog list
 // Returns partial fragment for HTMX requests, full page otherwise
 func (s *Server) handleBlogList(w http.ResponseWriter, r *http.Request) {
 	blogs, err := s.db.ListBlogs()
 	if err != nil {
-		log.Printf("Error fetching blogs: %v", err)
+		requestLogger(r).Error("fetch blogs", "err", err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
@@ -174,9 +179,12 @@ func (s *Server) handleBlogList(w http.ResponseWriter, r *http.Request) {
 	data := map[string]interface{}{
 		"Blogs": blogs,
 	}
+	s.addUnreadCounts(data)
 
 	// Check if this is an HTMX request
 	if r.Header.Get("HX-Request") == "true" {
+		// Refresh the Inbox count outside #blog-list along with the list itself
+		data["RefreshInboxCount"] = true
 		// Return partial fragment for HTMX
 		s.renderTemplate(w, "blog-list.gohtml", data)
 	} else {
@@ -185,7 +193,7 @@ func (s *Server) handleBlogList(w http.ResponseWriter, r *http.Request) {
 		data["Version"] = s.version
 		articles, err := s.db.ListArticles(false, nil)
 		if err != nil {
-			log.Printf("Error fetching articles: %v", err)
+			requestLogger(r).Error("fetch articles", "err", err)
 		} else {
 			data["Articles"] = articles
 		}
@@ -204,7 +212,7 @@ func (s *Server) handleMarkRead(w http.ResponseWriter, r *http.Request) {
 
 	found, err := s.db.MarkArticleRead(id)
 	if err != nil {
-		log.Printf("Error marking article %d as read: %v", id, err)
+		requestLogger(r).Error("mark article read", "article_id", id, "err", err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
@@ -212,6 +220,7 @@ func (s *Server) handleMarkRead(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Article not found", http.StatusNotFound)
 		return
 	}
+	s.publishReadState(id, nil, true)
 
 	// Return 200 OK with empty body - HTMX outerHTML swap will remove the card
 	w.WriteHeader(http.StatusOK)
@@ -228,7 +237,7 @@ func (s *Server) handleMarkUnread(w http.ResponseWriter, r *http.Request) {
 
 	found, err := s.db.MarkArticleUnread(id)
 	if err != nil {
-		log.Printf("Error marking article %d as unread: %v", id, err)
+		requestLogger(r).Error("mark article unread", "article_id", id, "err", err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
@@ -236,11 +245,46 @@ func (s *Server) handleMarkUnread(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Article not found", http.StatusNotFound)
 		return
 	}
+	s.publishReadState(id, nil, false)
 
 	// Return 200 OK with empty body - HTMX outerHTML swap will remove the card
 	w.WriteHeader(http.StatusOK)
 }
 
+// handleStar stars an article and returns the toggled star button
+func (s *Server) handleStar(w http.ResponseWriter, r *http.Request) {
+	s.setStarred(w, r, true)
+}
+
+// handleUnstar unstars an article and returns the toggled star button
+func (s *Server) handleUnstar(w http.ResponseWriter, r *http.Request) {
+	s.setStarred(w, r, false)
+}
+
+func (s *Server) setStarred(w http.ResponseWriter, r *http.Request, starred bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid article ID", http.StatusBadRequest)
+		return
+	}
+
+	found, err := s.db.SetArticleStarred(id, starred)
+	if err != nil {
+		requestLogger(r).Error("star article", "article_id", id, "starred", starred, "err", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "Article not found", http.StatusNotFound)
+		return
+	}
+
+	s.renderTemplate(w, "star-button.gohtml", map[string]interface{}{
+		"ID":        id,
+		"IsStarred": starred,
+	})
+}
+
 // handleMarkAllRead marks all unread articles as read and returns refreshed article list
 func (s *Server) handleMarkAllRead(w http.ResponseWriter, r *http.Request) {
 	// Parse optional blog filter from query params
@@ -254,10 +298,11 @@ func (s *Server) handleMarkAllRead(w http.ResponseWriter, r *http.Request) {
 
 	// Mark all unread as read
 	if err := s.db.MarkAllUnreadArticlesRead(blogID); err != nil {
-		log.Printf("Error marking all articles as read: %v", err)
+		requestLogger(r).Error("mark all articles read", "err", err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
+	s.publishReadState(0, blogID, true)
 
 	// Build search options from query parameters (preserves search/date filters)
 	opts, filter, currentBlogID := parseSearchOptions(r)
@@ -265,7 +310,7 @@ func (s *Server) handleMarkAllRead(w http.ResponseWriter, r *http.Request) {
 	// Return refreshed article list with current filters
 	articles, articleCount, err := s.db.SearchArticles(opts)
 	if err != nil {
-		log.Printf("Error fetching articles: %v", err)
+		requestLogger(r).Error("fetch articles", "err", err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
@@ -283,102 +328,77 @@ func (s *Server) handleMarkAllRead(w http.ResponseWriter, r *http.Request) {
 	s.renderTemplate(w, "article-list.gohtml", data)
 }
 
-// handleSync triggers a scan of all blogs and returns refreshed article list.
-// Uses a 3-minute timeout to prevent hanging on slow external sites.
+// handleSync starts a background sync of all blogs, or attaches to the one
+// already running, and returns a status fragment immediately. Progress and
+// completion reach the page through the live event stream.
 func (s *Server) handleSync(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Minute)
-	defer cancel()
-
-	results, err := scanner.ScanAllBlogs(ctx, s.db)
-	if err != nil {
-		log.Printf("Sync failed: %v", err)
-		http.Error(w, "Sync failed", http.StatusInternalServerError)
-		return
-	}
-
-	// Log results
-	totalNew := 0
-	for _, result := range results {
-		if result.Error != "" {
-			log.Printf("Sync error for %s: %s", result.BlogName, result.Error)
-		} else {
-			log.Printf("Synced %s: %d new articles (source: %s)", result.BlogName, result.NewArticles, result.Source)
-			totalNew += result.NewArticles
-		}
-	}
-	log.Printf("Sync complete: %d blogs scanned, %d new articles total", len(results), totalNew)
-
-	// Bail out early if the client disconnected during scanning
-	if ctx.Err() != nil {
-		log.Printf("Sync client disconnected before rendering response")
-		return
-	}
-
-	// Build search options from query parameters (preserves all filters)
-	opts, filter, currentBlogID := parseSearchOptions(r)
-
-	// Return refreshed article list with current filters
-	articles, articleCount, err := s.db.SearchArticles(opts)
-	if err != nil {
-		log.Printf("Error fetching articles: %v", err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
-		return
+	job, started := s.syncJobs.Start()
+	if started {
+		requestLogger(r).Info("sync started", "job_id", job.ID())
 	}
 
 	data := map[string]interface{}{
-		"Articles":        articles,
-		"ArticleCount":    articleCount,
-		"CurrentFilter":   filter,
-		"CurrentBlogID":   currentBlogID,
-		"CurrentBlogName": s.blogNameForID(currentBlogID),
-		"SearchQuery":     opts.SearchQuery,
-		"DateFrom":        r.URL.Query().Get("date_from"),
-		"DateTo":          r.URL.Query().Get("date_to"),
+		"Job": job.Snapshot(),
 	}
-	s.renderTemplate(w, "article-list.gohtml", data)
+	s.renderTemplate(w, "sync-status.gohtml", data)
 }
 
-// handleAPISync triggers a scan of all blogs and returns JSON stats for programmatic use.
-// Intended for cronjob or API consumers that need structured data instead of HTML.
-// Uses a 3-minute timeout to prevent hanging on slow external sites.
+// handleAPISync starts a sync job (or attaches to the running one) and returns
+// JSON stats for programmatic use. By default it waits for the job to finish so
+// cronjob consumers get final counts; pass async=true to return 202 Accepted
+// immediately and poll GET /api/sync/{id} instead.
 // Thumbnail extraction happens during scanning via Open Graph fallback, so a separate
 // SyncThumbnails pass is not needed here.
 func (s *Server) handleAPISync(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Minute)
-	defer cancel()
+	job, started := s.syncJobs.Start()
+	if started {
+		requestLogger(r).Info("sync started", "job_id", job.ID(), "api", true)
+	}
+	w.Header().Set("Location", s.path("/api/sync/"+job.ID()))
 
-	results, err := scanner.ScanAllBlogs(ctx, s.db)
-	if err != nil {
-		log.Printf("API sync failed: %v", err)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+	if async, _ := strconv.ParseBool(r.URL.Query().Get("async")); async {
+		writeJSON(w, http.StatusAccepted, job.Snapshot())
 		return
 	}
 
-	// Aggregate scan results
-	totalNew := 0
-	var scanErrors []string
-	for _, result := range results {
-		if result.Error != "" {
-			scanErrors = append(scanErrors, result.BlogName+": "+result.Error)
-		} else {
-			totalNew += result.NewArticles
-		}
+	// Waiting can outlast the server's WriteTimeout; give this response as
+	// long as the job itself may take.
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Now().Add(s.syncJobs.Timeout() + 30*time.Second)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		requestLogger(r).Warn("extend write deadline for API sync", "err", err)
 	}
 
-	log.Printf("API sync complete: %d blogs scanned, %d new articles total", len(results), totalNew)
+	if err := job.Wait(r.Context()); err != nil {
+		// Client gave up; the job keeps running in the background.
+		writeJSON(w, http.StatusAccepted, job.Snapshot())
+		return
+	}
 
-	resp := map[string]interface{}{
-		"blogs_scanned": len(results),
-		"new_articles":  totalNew,
+	snap := job.Snapshot()
+	if snap.Status == syncjob.StatusFailed {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": snap.Error})
+		return
 	}
-	if len(scanErrors) > 0 {
-		resp["errors"] = scanErrors
+	writeJSON(w, http.StatusOK, snap)
+}
+
+// handleSyncStatus reports the progress of a sync job as JSON.
+func (s *Server) handleSyncStatus(w http.ResponseWriter, r *http.Request) {
+	job := s.syncJobs.Get(r.PathValue("id"))
+	if job == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "sync job not found"})
+		return
 	}
+	writeJSON(w, http.StatusOK, job.Snapshot())
+}
 
+// writeJSON encodes v as the JSON response body with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("write JSON response", "err", err)
+	}
 }
 
 // handleSettings serves the settings page showing all blogs with article counts
@@ -386,34 +406,59 @@ func (s *Server) handleAPISync(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleSettings(w http.ResponseWriter, r *http.Request) {
 	blogsWithCounts, err := s.db.ListBlogsWithCounts()
 	if err != nil {
-		log.Printf("Error fetching blogs with counts: %v", err)
+		requestLogger(r).Error("fetch blogs with counts", "err", err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 
-	// Ensure webhook secret exists — generate one on first visit.
-	webhookSecret, err := s.db.GetSetting("webhook_secret")
+	// The webhook secret is generated on first visit
+	webhookData, webhookAuth := s.webhookSecretData(r)
+
+	inboxEmail, err := s.db.GetSetting("newsletter_inbox_email")
 	if err != nil {
-		log.Printf("Error reading webhook_secret: %v", err)
+		requestLogger(r).Error("read setting", "key", "newsletter_inbox_email", "err", err)
 	}
-	if webhookSecret == "" {
-		webhookSecret = generateWebhookSecret()
-		if storeErr := s.db.SetSetting("webhook_secret", webhookSecret); storeErr != nil {
-			log.Printf("Error storing webhook_secret: %v", storeErr)
-		}
+
+	digestSettings, err := digest.LoadSettings(s.db)
+	if err != nil {
+		requestLogger(r).Error("read digest settings", "err", err)
 	}
 
-	inboxEmail, err := s.db.GetSetting("newsletter_inbox_email")
+	retentionPolicy, err := retention.LoadSettings(s.db)
+	if err != nil {
+		requestLogger(r).Error("read retention settings", "err", err)
+	}
+
+	imapSettings, err := newsletter.LoadIMAPSettings(s.db)
 	if err != nil {
-		log.Printf("Error reading newsletter_inbox_email: %v", err)
+		requestLogger(r).Error("read IMAP settings", "err", err)
+	}
+
+	inboundSettings, err := inbound.LoadSettings(s.db)
+	if err != nil {
+		requestLogger(r).Error("read inbound provider settings", "err", err)
+	}
+
+	filterSettings, err := newsletter.LoadFilterSettings(s.db)
+	if err != nil {
+		requestLogger(r).Error("read newsletter filter", "err", err)
 	}
 
 	data := map[string]interface{}{
 		"SettingsBlogs":  blogsWithCounts,
 		"IsSettingsPage": true,
-		"WebhookSecret":  webhookSecret,
-		"WebhookPath":    "/newsletter/webhook",
+		"Webhook":        webhookData,
+		"WebhookURL":     s.baseURL(r) + "/newsletter/webhook",
 		"InboxEmail":     inboxEmail,
+		"Digest":         digestSettings,
+		"Backup":         s.backupListData(r),
+		"Retention":      retentionPolicy,
+		"IMAP":           imapSettings,
+		"Inbound":        inboundSettings,
+		"Senders":        s.newsletterSendersData(r),
+		"Filter":         filterSettings,
+		"Quarantine":     s.quarantineData(r),
+		"PostmarkURL":    postmarkWebhookURL(s.baseURL(r)+"/newsletter/webhook/postmark", webhookAuth.Secret),
 	}
 
 	// Check if this is an HTMX request
@@ -424,12 +469,7 @@ func (s *Server) handleSettings(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Return full page for direct navigation - need regular Blogs for sidebar
-	blogs, err := s.db.ListBlogs()
-	if err != nil {
-		log.Printf("Error fetching blogs for sidebar: %v", err)
-	} else {
-		data["Blogs"] = blogs
-	}
+	s.addSidebarData(data)
 	data["Title"] = "Settings - BlogWatcher"
 	data["Version"] = s.version
 	s.renderTemplate(w, "settings.gohtml", data)
@@ -443,13 +483,41 @@ func (s *Server) handleSetNewsletterInbox(w http.ResponseWriter, r *http.Request
 	}
 	email := strings.TrimSpace(r.FormValue("email"))
 	if err := s.db.SetSetting("newsletter_inbox_email", email); err != nil {
-		log.Printf("Error saving newsletter_inbox_email: %v", err)
+		requestLogger(r).Error("store setting", "key", "newsletter_inbox_email", "err", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 }
 
+// addSidebarData fills in the blog list and unread counts rendered by the sidebar.
+// Errors are logged so the page still renders without the sidebar data.
+func (s *Server) addSidebarData(data map[string]interface{}) {
+	blogs, err := s.db.ListBlogs()
+	if err != nil {
+		s.logger.Error("fetch blogs for sidebar", "err", err)
+	} else {
+		data["Blogs"] = blogs
+	}
+	s.addUnreadCounts(data)
+}
+
+// addUnreadCounts adds per-blog unread counts and the Inbox total to data.
+func (s *Server) addUnreadCounts(data map[string]interface{}) {
+	counts, err := s.db.UnreadCountsByBlog()
+	if err != nil {
+		s.logger.Error("fetch unread counts", "err", err)
+		// Templates index into the map, so it must never be missing
+		counts = map[int64]int{}
+	}
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	data["UnreadCounts"] = counts
+	data["UnreadTotal"] = total
+}
+
 // blogNameForID returns the blog name for the given ID, or empty string if not found.
 func (s *Server) blogNameForID(id int64) string {
 	if id <= 0 {
@@ -475,6 +543,9 @@ func parseSearchOptions(r *http.Request) (model.SearchOptions, string, int64) {
 	case "read":
 		isRead := true
 		opts.IsRead = &isRead
+	case "starred":
+		starred := true
+		opts.Starred = &starred
 	case "unread", "":
 		isRead := false
 		opts.IsRead = &isRead
@@ -506,7 +577,8 @@ func parseSearchOptions(r *http.Request) (model.SearchOptions, string, int64) {
 		}
 	}
 
-	// Parse pagination
+	// Parse pagination; offset is still accepted for old links
+	opts.After = r.URL.Query().Get("cursor")
 	if offsetParam := r.URL.Query().Get("offset"); offsetParam != "" {
 		if offset, err := strconv.Atoi(offsetParam); err == nil && offset >= 0 {
 			opts.Offset = offset
@@ -548,31 +620,31 @@ func (s *Server) handleAddBlog(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		// Unexpected error
-		log.Printf("Error adding blog: %v", err)
+		requestLogger(r).Error("add blog", "err", err)
 		s.renderAddBlogError(w, "Failed to add blog", name, url)
 		return
 	}
 
-	log.Printf("Added blog '%s' with feed %s", result.Blog.Name, result.Blog.FeedURL)
+	requestLogger(r).Info("blog added", "blog_id", result.Blog.ID, "blog", result.Blog.Name, "feed_url", result.Blog.FeedURL)
 
 	// Auto-sync the new blog in background
-	go s.autoSyncNewBlog(result.Blog.Name)
+	go s.autoSyncNewBlog(requestLogger(r), result.Blog.Name)
 
 	s.renderAddBlogSuccess(w, result.Blog.Name, result.Blog.FeedURL)
 }
 
 // autoSyncNewBlog syncs a single blog by name in the background
-func (s *Server) autoSyncNewBlog(blogName string) {
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+func (s *Server) autoSyncNewBlog(logger *slog.Logger, blogName string) {
+	ctx, cancel := context.WithTimeout(logging.WithLogger(context.Background(), logger), 2*time.Minute)
 	defer cancel()
 
 	result, err := scanner.ScanBlogByName(ctx, s.db, blogName)
 	if err != nil {
-		log.Printf("Auto-sync failed for %s: %v", blogName, err)
+		logger.Error("auto-sync new blog", "blog", blogName, "err", err)
 		return
 	}
 	if result != nil {
-		log.Printf("Auto-synced %s: %d new articles", blogName, result.NewArticles)
+		s.publishNewArticles(result.NewArticles)
 	}
 }
 
@@ -607,7 +679,7 @@ func (s *Server) handleGetBlog(w http.ResponseWriter, r *http.Request) {
 
 	blog, err := s.db.GetBlogByID(id)
 	if err != nil {
-		log.Printf("Error fetching blog %d: %v", id, err)
+		requestLogger(r).Error("fetch blog", "blog_id", id, "err", err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
@@ -618,7 +690,7 @@ func (s *Server) handleGetBlog(w http.ResponseWriter, r *http.Request) {
 
 	articleCount, err := s.db.GetArticleCountForBlog(id)
 	if err != nil {
-		log.Printf("Error fetching article count for blog %d: %v", id, err)
+		requestLogger(r).Error("count blog articles", "blog_id", id, "err", err)
 		articleCount = 0
 	}
 
@@ -640,7 +712,7 @@ func (s *Server) handleEditBlog(w http.ResponseWriter, r *http.Request) {
 
 	blog, err := s.db.GetBlogByID(id)
 	if err != nil {
-		log.Printf("Error fetching blog %d: %v", id, err)
+		requestLogger(r).Error("fetch blog", "blog_id", id, "err", err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
@@ -649,14 +721,21 @@ func (s *Server) handleEditBlog(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	override, err := s.db.GetBlogRetention(id)
+	if err != nil {
+		requestLogger(r).Error("fetch blog retention", "blog_id", id, "err", err)
+	}
+
 	data := map[string]interface{}{
-		"Blog": blog,
+		"Blog":      blog,
+		"Retention": override,
 	}
 	s.renderTemplate(w, "blog-edit-form.gohtml", data)
 }
 
-// handleUpdateBlogName updates the blog name and returns the display row partial
-func (s *Server) handleUpdateBlogName(w http.ResponseWriter, r *http.Request) {
+// handleUpdateBlog updates the blog name and, when the form carries them, its
+// retention overrides, then returns the display row partial
+func (s *Server) handleUpdateBlog(w http.ResponseWriter, r *http.Request) {
 	idStr := r.PathValue("id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
@@ -675,15 +754,30 @@ func (s *Server) handleUpdateBlogName(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var override *storage.BlogRetention
+	if r.Form.Has("retention_read_days") || r.Form.Has("retention_max_articles") {
+		readDays, err := parseRetentionLimit(r.FormValue("retention_read_days"))
+		if err != nil {
+			http.Error(w, "Retention days must be a number", http.StatusBadRequest)
+			return
+		}
+		maxArticles, err := parseRetentionLimit(r.FormValue("retention_max_articles"))
+		if err != nil {
+			http.Error(w, "Retention article limit must be a number", http.StatusBadRequest)
+			return
+		}
+		override = &storage.BlogRetention{BlogID: id, ReadDays: readDays, MaxArticles: maxArticles}
+	}
+
 	if err := s.db.UpdateBlogName(id, name); err != nil {
-		log.Printf("Error updating blog %d name: %v", id, err)
+		requestLogger(r).Error("rename blog", "blog_id", id, "err", err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 
 	blog, err := s.db.GetBlogByID(id)
 	if err != nil {
-		log.Printf("Error fetching updated blog %d: %v", id, err)
+		requestLogger(r).Error("fetch updated blog", "blog_id", id, "err", err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
@@ -691,10 +785,17 @@ func (s *Server) handleUpdateBlogName(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Blog not found", http.StatusNotFound)
 		return
 	}
+	if override != nil {
+		if err := s.db.SetBlogRetention(*override); err != nil {
+			requestLogger(r).Error("save blog retention", "blog_id", id, "err", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+	}
 
 	articleCount, err := s.db.GetArticleCountForBlog(id)
 	if err != nil {
-		log.Printf("Error fetching article count for blog %d: %v", id, err)
+		requestLogger(r).Error("count blog articles", "blog_id", id, "err", err)
 		articleCount = 0
 	}
 
@@ -724,12 +825,12 @@ func (s *Server) handleDeleteBlog(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Blog not found", http.StatusNotFound)
 			return
 		}
-		log.Printf("Error deleting blog %d: %v", id, err)
+		requestLogger(r).Error("delete blog", "blog_id", id, "err", err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 
-	log.Printf("Deleted blog %d with articles", id)
+	requestLogger(r).Info("blog deleted", "blog_id", id)
 
 	// Trigger sidebar refresh via HTMX event
 	w.Header().Set("HX-Trigger", "blogListUpdated")
@@ -739,32 +840,80 @@ func (s *Server) handleDeleteBlog(w http.ResponseWriter, r *http.Request) {
 }
 
 // handleNewsletterWebhook receives a raw RFC 822 email from the Cloudflare Worker.
-// Requests must include X-Webhook-Secret matching the stored webhook_secret setting.
+// Requests must carry the webhook secret, either in X-Webhook-Secret or as the
+// key of an X-Webhook-Signature HMAC over the body; settings can require the latter.
 func (s *Server) handleNewsletterWebhook(w http.ResponseWriter, r *http.Request) {
-	secret, err := s.db.GetSetting("webhook_secret")
+	auth, err := s.loadWebhookAuth()
 	if err != nil {
-		log.Printf("newsletter webhook: failed to read secret: %v", err)
+		requestLogger(r).Error("newsletter webhook: read secret", "err", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-	if secret == "" || r.Header.Get("X-Webhook-Secret") != secret {
+	now := time.Now()
+	secrets := auth.secrets(now)
+	signature := r.Header.Get("X-Webhook-Signature")
+
+	// Unsigned requests are checked before the body is read
+	var authErr error
+	switch {
+	case len(secrets) == 0:
+		authErr = errWebhookSecret
+	case signature != "":
+	case auth.RequireSignature:
+		authErr = errWebhookUnsigned
+	case !checkWebhookSecret(r.Header.Get("X-Webhook-Secret"), secrets):
+		authErr = errWebhookSecret
+	}
+	if authErr != nil {
+		requestLogger(r).Warn("newsletter webhook: unauthorized", "client", s.clientIP(r), "err", authErr)
+		metrics.NewsletterIngests.Inc("webhook", "unauthorized")
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
+	r.Body = http.MaxBytesReader(w, r.Body, maxWebhookBytes)
 	raw, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("newsletter webhook: read body: %v", err)
-		http.Error(w, "Bad Request", http.StatusBadRequest)
+		requestLogger(r).Warn("newsletter webhook: read body", "err", err)
+		metrics.NewsletterIngests.Inc("webhook", "error")
+		status := http.StatusBadRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, http.StatusText(status), status)
 		return
 	}
 
+	if signature != "" {
+		if err := s.verifyWebhookSignature(r.Header.Get("X-Webhook-Timestamp"), signature, raw, secrets, now); err != nil {
+			requestLogger(r).Warn("newsletter webhook: unauthorized", "client", s.clientIP(r), "err", err)
+			metrics.NewsletterIngests.Inc("webhook", "unauthorized")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
 	h := newsletter.NewHandler(s.db)
-	if _, err := h.HandleInbound(r.Context(), raw); err != nil {
-		log.Printf("newsletter webhook: ingest: %v", err)
+	_, created, err := h.HandleInbound(r.Context(), raw)
+	if errors.Is(err, newsletter.ErrQuarantined) {
+		requestLogger(r).Info("newsletter webhook: quarantined", "err", err)
+		metrics.NewsletterIngests.Inc("webhook", "quarantined")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if err != nil {
+		requestLogger(r).Error("newsletter webhook: ingest", "err", err)
+		metrics.NewsletterIngests.Inc("webhook", "error")
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	if created {
+		metrics.NewsletterIngests.Inc("webhook", "success")
+		s.publishNewArticles(1)
+	} else {
+		metrics.NewsletterIngests.Inc("webhook", "duplicate")
+	}
 
 	w.WriteHeader(http.StatusOK)
 }
@@ -780,7 +929,7 @@ func (s *Server) handleNewsletterArticle(w http.ResponseWriter, r *http.Request)
 
 	article, err := s.db.GetArticleByID(id)
 	if err != nil {
-		log.Printf("newsletter article: fetch: %v", err)
+		requestLogger(r).Error("newsletter article: fetch", "article_id", id, "err", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
@@ -794,17 +943,104 @@ func (s *Server) handleNewsletterArticle(w http.ResponseWriter, r *http.Request)
 		blogName = blog.Name
 	}
 
+	parts, err := s.db.ListNewsletterParts(id)
+	if err != nil {
+		requestLogger(r).Error("newsletter article: list parts", "article_id", id, "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	var attachments []model.NewsletterPart
+	for _, p := range parts {
+		if !p.Inline {
+			attachments = append(attachments, p)
+		}
+	}
+	meta, err := s.db.GetNewsletterMeta(id)
+	if err != nil {
+		requestLogger(r).Error("newsletter article: fetch metadata", "article_id", id, "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
 	// Mark as read when the full article is viewed.
 	if _, err := s.db.MarkArticleRead(id); err != nil {
-		log.Printf("newsletter article: mark read: %v", err)
+		requestLogger(r).Error("newsletter article: mark read", "article_id", id, "err", err)
+	} else if !article.IsRead {
+		s.publishReadState(id, nil, true)
 	}
 
 	data := map[string]interface{}{
 		"Title":       article.Title,
 		"Article":     article,
 		"BlogName":    blogName,
-		"HTMLContent": template.HTML(article.Content),
+		"HTMLContent": template.HTML(newsletterContent(s.basePath, article.ID, article.Content)),
+		"Attachments": attachments,
+		"Meta":        meta,
 		"Version":     s.version,
 	}
+	s.addSidebarData(data)
 	s.renderTemplate(w, "newsletter_article", data)
 }
+
+// handleNewsletterPart serves an inline image or attachment of a newsletter,
+// addressed by part ID or by the Content-ID its body references. Only raster
+// images are shown inline; everything else is sent as a download so a mailed
+// HTML or SVG file cannot run scripts on this origin.
+func (s *Server) handleNewsletterPart(w http.ResponseWriter, r *http.Request) {
+	articleID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	var part *model.NewsletterPart
+	if cid := r.PathValue("cid"); cid != "" {
+		part, err = s.db.GetNewsletterPartByContentID(articleID, cid)
+	} else {
+		partID, perr := strconv.ParseInt(r.PathValue("part"), 10, 64)
+		if perr != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		part, err = s.db.GetNewsletterPart(articleID, partID)
+	}
+	if err != nil {
+		requestLogger(r).Error("newsletter part: fetch", "article_id", articleID, "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if part == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	disposition := "attachment"
+	if isInlineImage(part.ContentType) {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Type", part.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": part.Filename}))
+	w.Header().Set("Content-Length", strconv.Itoa(len(part.Data)))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	// Parts never change once stored
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	_, _ = w.Write(part.Data)
+}
+
+// isInlineImage reports whether a part type is safe to display in the page.
+func isInlineImage(contentType string) bool {
+	switch contentType {
+	case "image/png", "image/jpeg", "image/gif", "image/webp", "image/avif", "image/bmp":
+		return true
+	}
+	return false
+}
+
+// newsletterContent returns a newsletter's HTML body with cid: references
+// pointing at the part route under base.
+func newsletterContent(base string, articleID int64, content string) string {
+	return newsletter.RewriteContentIDs(content, func(cid string) string {
+		return fmt.Sprintf("%s/newsletter/article/%d/cid/%s", base, articleID, url.PathEscape(cid))
+	})
+}
@@ -3,17 +3,29 @@
 package server
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"io/fs"
+	"log/slog"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/esttorhe/blogwatcher-ui/v2/assets"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/imap/imaptest"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/metrics"
 	"github.com/esttorhe/blogwatcher-ui/v2/internal/model"
 	"github.com/esttorhe/blogwatcher-ui/v2/internal/storage"
 )
@@ -361,6 +373,11 @@ func createTestServer(t *testing.T) http.Handler {
 
 func createTestServerWithDB(t *testing.T) (http.Handler, *storage.Database) {
 	t.Helper()
+	return createTestServerWithOptions(t, Options{Version: "test"})
+}
+
+func createTestServerWithOptions(t *testing.T, opts Options) (*Server, *storage.Database) {
+	t.Helper()
 
 	// Create temp database
 	path := filepath.Join(t.TempDir(), "blogwatcher.db")
@@ -381,7 +398,7 @@ func createTestServerWithDB(t *testing.T) (http.Handler, *storage.Database) {
 	}
 
 	// Create server
-	srv, err := NewServerWithFS(db, templateFiles, staticFiles, "test")
+	srv, err := NewServerWithOptions(db, templateFiles, staticFiles, opts)
 	if err != nil {
 		t.Fatalf("create server: %v", err)
 	}
@@ -421,7 +438,9 @@ func TestHandleNewsletterWebhookWrongSecret(t *testing.T) {
 }
 
 func TestHandleNewsletterWebhookValidEmail(t *testing.T) {
-	srv, db := createTestServerWithDB(t)
+	srv, db := createTestServerWithOptions(t, Options{Version: "test"})
+	ch, unsubscribe := srv.Events().Subscribe()
+	defer unsubscribe()
 	if err := db.SetSetting("webhook_secret", "topsecret"); err != nil {
 		t.Fatalf("set secret: %v", err)
 	}
@@ -438,13 +457,171 @@ func TestHandleNewsletterWebhookValidEmail(t *testing.T) {
 		"<p>Hello</p>",
 	}, "\r\n")
 
-	req := httptest.NewRequest(http.MethodPost, "/newsletter/webhook", strings.NewReader(rawEmail))
+	// A redelivery is accepted too, but only the first announces an article
+	for i, wantEvent := range []bool{true, false} {
+		req := httptest.NewRequest(http.MethodPost, "/newsletter/webhook", strings.NewReader(rawEmail))
+		req.Header.Set("X-Webhook-Secret", "topsecret")
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("delivery %d: status = %d, want 200; body: %s", i+1, rec.Code, rec.Body.String())
+		}
+		select {
+		case <-ch:
+			if !wantEvent {
+				t.Errorf("delivery %d published an articles-new event", i+1)
+			}
+		case <-time.After(50 * time.Millisecond):
+			if wantEvent {
+				t.Errorf("delivery %d published no articles-new event", i+1)
+			}
+		}
+	}
+}
+
+func TestNewsletterWebhookSignature(t *testing.T) {
+	srv, db := createTestServerWithDB(t)
+	if err := db.SetSetting("webhook_secret", "topsecret"); err != nil {
+		t.Fatalf("set secret: %v", err)
+	}
+	rawEmail := "From: nl@example.com\r\nSubject: Signed\r\nMessage-ID: <signed@example.com>\r\n\r\nHello"
+	sign := func(secret string, at time.Time) (string, string) {
+		timestamp := strconv.FormatInt(at.Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(timestamp + "." + rawEmail))
+		return timestamp, "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	post := func(timestamp, signature string) int {
+		req := httptest.NewRequest(http.MethodPost, "/newsletter/webhook", strings.NewReader(rawEmail))
+		req.Header.Set("X-Webhook-Timestamp", timestamp)
+		req.Header.Set("X-Webhook-Signature", signature)
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	timestamp, signature := sign("topsecret", time.Now())
+	if code := post(timestamp, signature); code != http.StatusOK {
+		t.Fatalf("signed request = %d, want 200", code)
+	}
+	if code := post(timestamp, signature); code != http.StatusUnauthorized {
+		t.Errorf("replayed request = %d, want 401", code)
+	}
+	if code := post(sign("topsecret", time.Now().Add(-time.Hour))); code != http.StatusUnauthorized {
+		t.Errorf("stale request = %d, want 401", code)
+	}
+	if code := post(sign("wrongsecret", time.Now())); code != http.StatusUnauthorized {
+		t.Errorf("wrong key = %d, want 401", code)
+	}
+
+	// Once signatures are required the secret header alone is refused
+	form := url.Values{"require_signature": {"true"}}
+	req := httptest.NewRequest(http.MethodPost, "/settings/webhook-signing", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	srv.ServeHTTP(httptest.NewRecorder(), req)
+	req = httptest.NewRequest(http.MethodPost, "/newsletter/webhook", strings.NewReader(rawEmail))
 	req.Header.Set("X-Webhook-Secret", "topsecret")
 	rec := httptest.NewRecorder()
 	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("unsigned request with signatures required = %d, want 401", rec.Code)
+	}
+}
 
-	if rec.Code != http.StatusOK {
-		t.Errorf("status = %d, want 200; body: %s", rec.Code, rec.Body.String())
+func TestRotateWebhookSecret(t *testing.T) {
+	srv, db := createTestServerWithDB(t)
+	if err := db.SetSetting("webhook_secret", "oldsecret"); err != nil {
+		t.Fatalf("set secret: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/settings/webhook-secret/rotate", nil)
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	newSecret, err := db.GetSetting("webhook_secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newSecret == "oldsecret" || !strings.Contains(rec.Body.String(), newSecret) {
+		t.Fatalf("rotation did not show a new secret: %s", rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "previous secret is accepted until") {
+		t.Errorf("rotation should show the grace period: %s", rec.Body.String())
+	}
+
+	for i, secret := range []string{"oldsecret", newSecret} {
+		rawEmail := fmt.Sprintf("From: nl@example.com\r\nSubject: Rotated\r\nMessage-ID: <rotated%d@example.com>\r\n\r\nHello", i)
+		req := httptest.NewRequest(http.MethodPost, "/newsletter/webhook", strings.NewReader(rawEmail))
+		req.Header.Set("X-Webhook-Secret", secret)
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("secret %d after rotation = %d, want 200", i, rec.Code)
+		}
+	}
+
+	// After the grace period only the new secret works
+	if err := db.SetSetting("webhook_secret_previous_until", time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)); err != nil {
+		t.Fatal(err)
+	}
+	req = httptest.NewRequest(http.MethodPost, "/newsletter/webhook", strings.NewReader("raw email"))
+	req.Header.Set("X-Webhook-Secret", "oldsecret")
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expired secret = %d, want 401", rec.Code)
+	}
+}
+
+func TestNewsletterWebhookRateLimit(t *testing.T) {
+	srv := createTestServer(t)
+	var code int
+	for range webhookBurst + 1 {
+		req := httptest.NewRequest(http.MethodPost, "/newsletter/webhook", strings.NewReader("raw email"))
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		code = rec.Code
+		if code == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+			t.Error("429 without Retry-After")
+		}
+	}
+	if code != http.StatusTooManyRequests {
+		t.Errorf("request past the burst = %d, want 429", code)
+	}
+
+	// Requests to made-up providers are counted without their name as a label
+	before := metrics.NewsletterIngests.Value("unknown", "rate_limited")
+	for range webhookBurst + 1 {
+		req := httptest.NewRequest(http.MethodPost, "/newsletter/webhook/bogus-name", strings.NewReader("raw email"))
+		req.RemoteAddr = "192.0.2.7:1234"
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		code = rec.Code
+	}
+	if code != http.StatusTooManyRequests {
+		t.Errorf("provider request past the burst = %d, want 429", code)
+	}
+	if got := metrics.NewsletterIngests.Value("unknown", "rate_limited"); got != before+1 {
+		t.Errorf("unknown rate_limited count went from %v to %v, want one more", before, got)
+	}
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if strings.Contains(rec.Body.String(), "bogus-name") {
+		t.Error("bogus-name became a metric label")
+	}
+
+	limiter := newRateLimiter(1, 2)
+	now := time.Now()
+	limiter.allow("a", now)
+	limiter.allow("a", now)
+	if ok, wait := limiter.allow("a", now); ok || wait != time.Second {
+		t.Errorf("empty bucket = %v, %v; want refused for 1s", ok, wait)
+	}
+	if ok, _ := limiter.allow("b", now); !ok {
+		t.Error("another client should have its own bucket")
+	}
+	if ok, _ := limiter.allow("a", now.Add(time.Second)); !ok {
+		t.Error("bucket should refill")
 	}
 }
 
@@ -478,3 +655,1200 @@ func TestHandleNewsletterArticle(t *testing.T) {
 		t.Errorf("response should contain article title; got: %s", rec.Body.String())
 	}
 }
+
+func TestNewsletterArticleParts(t *testing.T) {
+	srv, db := createTestServerWithDB(t)
+
+	blog, err := db.GetOrCreateNewsletterBlog("Parts NL", "parts@example.com")
+	if err != nil {
+		t.Fatalf("create blog: %v", err)
+	}
+	id, err := db.AddNewsletterArticle(
+		model.Article{BlogID: blog.ID, Title: "With parts", URL: "message:<parts@example.com>", Content: `<p><img src="cid:logo@example.com" alt="Logo"></p>`},
+		model.NewsletterMeta{WebURL: "https://parts.example.com/web", UnsubscribeURL: "https://parts.example.com/unsub?u=1&l=2", UnsubscribeMailto: "mailto:unsub@parts.example.com"},
+		[]model.NewsletterPart{
+			{ContentID: "logo@example.com", Filename: "logo.png", ContentType: "image/png", Inline: true, Data: []byte("png")},
+			{Filename: "map.svg", ContentType: "image/svg+xml", Data: []byte("<svg/>")},
+		},
+	)
+	if err != nil {
+		t.Fatalf("add article: %v", err)
+	}
+	articlePath := "/newsletter/article/" + strconv.FormatInt(id, 10)
+	parts, _ := db.ListNewsletterParts(id)
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, articlePath, nil))
+	body := rec.Body.String()
+	if !strings.Contains(body, `src="`+articlePath+`/cid/logo@example.com"`) {
+		t.Errorf("cid: reference not rewritten; got: %s", body)
+	}
+	if !strings.Contains(body, articlePath+"/parts/"+strconv.FormatInt(parts[1].ID, 10)) || !strings.Contains(body, "map.svg") {
+		t.Errorf("attachment not listed; got: %s", body)
+	}
+	if strings.Contains(body, "logo.png") {
+		t.Error("inline image listed as an attachment")
+	}
+	if !strings.Contains(body, `href="https://parts.example.com/web"`) || !strings.Contains(body, `href="https://parts.example.com/unsub?u=1&amp;l=2"`) {
+		t.Errorf("web and unsubscribe links missing; got: %s", body)
+	}
+
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, articlePath+"/cid/logo@example.com", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "png" || rec.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("inline image: status %d, type %q, body %q", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Disposition"); got != `inline; filename=logo.png` {
+		t.Errorf("inline image disposition = %q", got)
+	}
+
+	// SVG can carry scripts, so it is only ever downloaded
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, articlePath+"/parts/"+strconv.FormatInt(parts[1].ID, 10), nil))
+	if got := rec.Header().Get("Content-Disposition"); got != `attachment; filename=map.svg` {
+		t.Errorf("svg disposition = %q, want attachment", got)
+	}
+
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, articlePath+"/cid/missing@example.com", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("missing part status = %d, want 404", rec.Code)
+	}
+}
+
+func TestHandleFeedFormats(t *testing.T) {
+	srv, db := createTestServerWithDB(t)
+
+	blog, err := db.AddBlog(model.Blog{Name: "Feed Blog", URL: "https://feedblog.example.com"})
+	if err != nil {
+		t.Fatalf("add blog: %v", err)
+	}
+	_, err = db.AddArticlesBulk([]model.Article{
+		{BlogID: blog.ID, Title: "Unread Post", URL: "https://feedblog.example.com/unread"},
+		{BlogID: blog.ID, Title: "Read Post", URL: "https://feedblog.example.com/read", IsRead: true},
+		{BlogID: blog.ID, Title: "Starred Post", URL: "https://feedblog.example.com/starred", IsRead: true},
+	})
+	if err != nil {
+		t.Fatalf("add articles: %v", err)
+	}
+	starred, _ := db.GetArticleByURL("https://feedblog.example.com/starred")
+	if _, err := db.SetArticleStarred(starred.ID, true); err != nil {
+		t.Fatalf("star article: %v", err)
+	}
+
+	tests := []struct {
+		path        string
+		contentType string
+		contains    []string
+		excludes    []string
+	}{
+		{"/feeds/atom", "application/atom+xml", []string{"Unread Post", "Read Post", "http://example.com/feeds/atom"}, nil},
+		{"/feeds/rss?filter=unread", "application/rss+xml", []string{"Unread Post"}, []string{"Read Post", "Starred Post"}},
+		{"/feeds/atom?filter=starred", "application/atom+xml", []string{"Starred Post", "BlogWatcher - Starred"}, []string{"Unread Post", "Read Post"}},
+		{"/feeds/json?filter=read&blog=" + strconv.FormatInt(blog.ID, 10), "application/feed+json", []string{"Read Post", "Starred Post", "Feed Blog"}, []string{"Unread Post"}},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: status = %d, want 200", tt.path, rec.Code)
+			continue
+		}
+		if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, tt.contentType) {
+			t.Errorf("%s: Content-Type = %q, want %q", tt.path, ct, tt.contentType)
+		}
+		body := rec.Body.String()
+		for _, want := range tt.contains {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s: body should contain %q; got: %s", tt.path, want, body)
+			}
+		}
+		for _, unwanted := range tt.excludes {
+			if strings.Contains(body, unwanted) {
+				t.Errorf("%s: body should not contain %q", tt.path, unwanted)
+			}
+		}
+	}
+}
+
+func TestHandleFeedUnknownFormat(t *testing.T) {
+	srv := createTestServer(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/feeds/opml", nil)
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", rec.Code)
+	}
+}
+
+func TestDigestSettingsAndPreview(t *testing.T) {
+	srv, db := createTestServerWithDB(t)
+
+	form := url.Values{
+		"schedule":   {"daily"},
+		"recipients": {"a@example.com, b@example.com"},
+		"smtp_host":  {"smtp.example.com"},
+		"smtp_port":  {"2525"},
+	}
+	req := httptest.NewRequest(http.MethodPost, "/settings/digest", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "saved") {
+		t.Fatalf("save: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if schedule, _ := db.GetSetting("digest_schedule"); schedule != "daily" {
+		t.Errorf("digest_schedule = %q, want daily", schedule)
+	}
+
+	blog, err := db.AddBlog(model.Blog{Name: "Digest Blog", URL: "https://digest.example.com"})
+	if err != nil {
+		t.Fatalf("add blog: %v", err)
+	}
+	discovered := time.Now().Add(-time.Hour)
+	if _, err := db.AddArticlesBulk([]model.Article{{BlogID: blog.ID, Title: "Fresh Post", URL: "https://digest.example.com/fresh", DiscoveredDate: &discovered}}); err != nil {
+		t.Fatalf("add article: %v", err)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/digest/preview", nil)
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("preview: status = %d", rec.Code)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "Digest Blog") || !strings.Contains(body, "Fresh Post") {
+		t.Errorf("preview should list new articles grouped by blog; got: %s", body)
+	}
+}
+
+func TestDigestSettingsRejectsInvalidSchedule(t *testing.T) {
+	srv := createTestServer(t)
+
+	form := url.Values{"schedule": {"hourly"}}
+	req := httptest.NewRequest(http.MethodPost, "/settings/digest", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	if !strings.Contains(rec.Body.String(), "settings-status-error") {
+		t.Errorf("expected validation error, got: %s", rec.Body.String())
+	}
+}
+
+func TestIMAPSettingsAndConnectionTest(t *testing.T) {
+	mailbox := imaptest.NewServer("reader", "secret")
+	t.Cleanup(mailbox.Close)
+	mailbox.Append("INBOX", []byte("Subject: hi\r\n\r\nhello\r\n"))
+	srv, db := createTestServerWithDB(t)
+
+	post := func(path string, form url.Values) string {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("POST %s: status = %d, body = %s", path, rec.Code, rec.Body.String())
+		}
+		return rec.Body.String()
+	}
+
+	form := url.Values{
+		"imap_host":     {"127.0.0.1"},
+		"imap_port":     {strconv.Itoa(mailbox.Port())},
+		"imap_username": {"reader"},
+		"imap_password": {"secret"},
+		"imap_interval": {"5"},
+		"imap_insecure": {"true"}, // the test server has no STARTTLS
+	}
+	if body := post("/settings/imap", form); !strings.Contains(body, "saved") {
+		t.Fatalf("save: body = %s", body)
+	}
+	if host, _ := db.GetSetting("imap_host"); host != "127.0.0.1" {
+		t.Errorf("imap_host = %q", host)
+	}
+
+	// A blank password keeps the stored one, for saving and for testing
+	form.Set("imap_password", "")
+	if body := post("/settings/imap/test", form); !strings.Contains(body, "Connected: INBOX has 1 unseen") {
+		t.Errorf("test connection: body = %s", body)
+	}
+	post("/settings/imap", form)
+	if password, _ := db.GetSetting("imap_password"); password != "secret" {
+		t.Errorf("imap_password = %q, want it kept", password)
+	}
+
+	// ...but never goes to another server, port or account, or over a
+	// connection it wasn't entered for
+	other := imaptest.NewServer("reader", "secret")
+	t.Cleanup(other.Close)
+	logins := mailbox.Logins()
+	for field, value := range map[string]string{
+		"imap_host":     "localhost",
+		"imap_port":     strconv.Itoa(other.Port()),
+		"imap_username": "someone",
+		"imap_insecure": "false",
+	} {
+		changed := url.Values{}
+		for k, v := range form {
+			changed[k] = v
+		}
+		changed.Set(field, value)
+		if body := post("/settings/imap/test", changed); !strings.Contains(body, "Enter the password again") {
+			t.Errorf("test with changed %s: body = %s", field, body)
+		}
+		post("/settings/imap", changed)
+		if password, _ := db.GetSetting("imap_password"); password != "secret" {
+			t.Fatalf("saving with changed %s: imap_password = %q, want the old one left alone", field, password)
+		}
+	}
+	if other.Logins() != 0 || mailbox.Logins() != logins {
+		t.Errorf("stored password was sent with changed settings: %d logins elsewhere, %d more to the mailbox", other.Logins(), mailbox.Logins()-logins)
+	}
+
+	// Without the insecure option, a server lacking STARTTLS is refused
+	form.Set("imap_password", "secret")
+	form.Set("imap_insecure", "false")
+	if body := post("/settings/imap/test", form); !strings.Contains(body, "STARTTLS") {
+		t.Errorf("test without STARTTLS: body = %s", body)
+	}
+	form.Set("imap_insecure", "true")
+
+	form.Set("imap_password", "wrong")
+	if body := post("/settings/imap/test", form); !strings.Contains(body, "settings-status-error") {
+		t.Errorf("test with a bad password: body = %s", body)
+	}
+	form.Set("imap_port", "99999")
+	if body := post("/settings/imap", form); !strings.Contains(body, "port") {
+		t.Errorf("save with a bad port: body = %s", body)
+	}
+}
+
+func TestNewsletterArchiveUpload(t *testing.T) {
+	srv, db := createTestServerWithDB(t)
+	mbox := "From news@acme.com Mon Jan  1 10:00:00 2024\n" +
+		"From: \"Acme Newsletter\" <news@acme.com>\nSubject: Issue 1\nDate: Mon, 01 Jan 2024 10:00:00 +0000\n" +
+		"Message-ID: <issue1@acme.com>\nContent-Type: text/html\n\n<p>One</p>\n\n" +
+		"From nobody Tue Jan  2 10:00:00 2024\nthis is not an email\n"
+
+	upload := func(content string) string {
+		t.Helper()
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		fw, _ := mw.CreateFormFile("file", "newsletters.mbox")
+		fw.Write([]byte(content))
+		mw.Close()
+		req := httptest.NewRequest(http.MethodPost, "/settings/newsletter-import", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("upload: status = %d, body = %s", rec.Code, rec.Body.String())
+		}
+		return rec.Body.String()
+	}
+
+	body := upload(mbox)
+	for _, want := range []string{"Imported 1 of 2 messages", "Acme Newsletter", "newsletters.mbox #2"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("upload result missing %q: %s", want, body)
+		}
+	}
+	if article, _ := db.GetArticleByURL("message:<issue1@acme.com>"); article == nil {
+		t.Error("uploaded newsletter not stored")
+	}
+	if body := upload(mbox); !strings.Contains(body, "Imported 0 of 2 messages; 1 already imported") {
+		t.Errorf("second upload: %s", body)
+	}
+	if body := upload("just some text"); !strings.Contains(body, "settings-status-error") {
+		t.Errorf("upload of a non-archive: %s", body)
+	}
+}
+
+func TestNewsletterAliasesAndMerge(t *testing.T) {
+	srv, db := createTestServerWithDB(t)
+	acme, err := db.GetOrCreateNewsletterBlog("Acme", "news@acme.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mailer, err := db.GetOrCreateNewsletterBlog("Acme Mailer", "mailer@acme.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.AddArticlesBulk([]model.Article{{BlogID: mailer.ID, Title: "Issue", URL: "message:<issue@acme.com>"}}); err != nil {
+		t.Fatal(err)
+	}
+	post := func(path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+
+	acmeID := strconv.FormatInt(acme.ID, 10)
+	rec := post("/settings/newsletter-aliases", url.Values{"alias": {"<Weekly.Acme.com>"}, "blog_id": {acmeID}})
+	if !strings.Contains(rec.Body.String(), "Alias saved") || !strings.Contains(rec.Body.String(), "weekly.acme.com") {
+		t.Fatalf("add List-Id alias: %s", rec.Body.String())
+	}
+	if blog, _ := db.ResolveNewsletterBlog("x", "someone@elsewhere.com", "weekly.acme.com"); blog.ID != acme.ID {
+		t.Errorf("List-Id alias resolved to blog %d, want %d", blog.ID, acme.ID)
+	}
+	rec = post("/settings/newsletter-aliases/delete", url.Values{"kind": {"list_id"}, "value": {"weekly.acme.com"}})
+	if strings.Contains(rec.Body.String(), "weekly.acme.com") {
+		t.Errorf("removed alias still listed: %s", rec.Body.String())
+	}
+
+	rec = post("/settings/newsletter-merge", url.Values{"from": {acmeID}, "into": {acmeID}})
+	if !strings.Contains(rec.Body.String(), "settings-status-error") {
+		t.Errorf("merge into itself: %s", rec.Body.String())
+	}
+	rec = post("/settings/newsletter-merge", url.Values{"from": {strconv.FormatInt(mailer.ID, 10)}, "into": {acmeID}})
+	body := rec.Body.String()
+	if !strings.Contains(body, "moved 1 articles") || !strings.Contains(body, "mailer@acme.com") {
+		t.Fatalf("merge: %s", body)
+	}
+	if !strings.Contains(body, `id="blog-`+strconv.FormatInt(mailer.ID, 10)+`" hx-swap-oob="delete"`) {
+		t.Errorf("merge should remove the merged blog's card: %s", body)
+	}
+	if rec.Header().Get("HX-Trigger") != "blogListUpdated" {
+		t.Error("merge should refresh the blog list")
+	}
+	if count, _ := db.GetArticleCountForBlog(acme.ID); count != 1 {
+		t.Errorf("articles after merge = %d, want 1", count)
+	}
+}
+
+func TestNewsletterQuarantine(t *testing.T) {
+	srv, db := createTestServerWithOptions(t, Options{Version: "test"})
+	ch, unsubscribe := srv.Events().Subscribe()
+	defer unsubscribe()
+	if err := db.SetSetting("webhook_secret", "topsecret"); err != nil {
+		t.Fatalf("set secret: %v", err)
+	}
+	post := func(path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+	deliver := func(from, id string) int {
+		raw := "From: " + from + "\r\nSubject: Issue " + id + "\r\nMessage-ID: <" + id + "@example.com>\r\n\r\n<p>Hi</p>"
+		req := httptest.NewRequest(http.MethodPost, "/newsletter/webhook", strings.NewReader(raw))
+		req.Header.Set("X-Webhook-Secret", "topsecret")
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	rec := post("/settings/newsletter-filter", url.Values{"block": {"not a domain"}})
+	if !strings.Contains(rec.Body.String(), "settings-status-error") {
+		t.Errorf("save with a bad rule: %s", rec.Body.String())
+	}
+	rec = post("/settings/newsletter-filter", url.Values{"block": {"spam.example"}, "check_authentication": {"true"}})
+	if !strings.Contains(rec.Body.String(), "settings-status-error") {
+		t.Errorf("save checking SPF/DKIM without trusted servers: %s", rec.Body.String())
+	}
+	rec = post("/settings/newsletter-filter", url.Values{"block": {"spam.example"}, "quarantine_unknown": {"false"}})
+	if !strings.Contains(rec.Body.String(), "saved") {
+		t.Fatalf("save filter: %s", rec.Body.String())
+	}
+
+	// Held mail is still acknowledged, so senders cannot probe the filter
+	if code := deliver("offers@spam.example", "spam"); code != http.StatusOK {
+		t.Errorf("quarantined webhook = %d, want 200", code)
+	}
+	if code := deliver("letters@spam.example", "letter"); code != http.StatusOK {
+		t.Errorf("quarantined webhook = %d, want 200", code)
+	}
+	held, err := db.ListQuarantinedNewsletters()
+	if err != nil || len(held) != 2 {
+		t.Fatalf("quarantine = %+v, %v; want 2 entries", held, err)
+	}
+	if article, _ := db.GetArticleByURL("message:<spam@example.com>"); article != nil {
+		t.Error("blocked newsletter was stored")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/settings", nil)
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if !strings.Contains(rec.Body.String(), "Issue letter") || !strings.Contains(rec.Body.String(), "Sender is blocked") {
+		t.Errorf("settings page should list the quarantine")
+	}
+
+	var spam, letter int64
+	for _, q := range held {
+		if strings.Contains(q.Sender, "offers") {
+			spam = q.ID
+		} else {
+			letter = q.ID
+		}
+	}
+	req = httptest.NewRequest(http.MethodGet, "/settings/quarantine/"+strconv.FormatInt(spam, 10)+"/raw", nil)
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Header().Get("Content-Type") != "message/rfc822" || !strings.HasPrefix(rec.Header().Get("Content-Disposition"), "attachment") ||
+		!strings.Contains(rec.Body.String(), "Message-ID: <spam@example.com>") {
+		t.Errorf("download: %v %s", rec.Header(), rec.Body.String())
+	}
+
+	rec = post("/settings/quarantine/"+strconv.FormatInt(spam, 10)+"/discard", nil)
+	if strings.Contains(rec.Body.String(), "Issue spam") {
+		t.Errorf("discarded newsletter still listed: %s", rec.Body.String())
+	}
+	rec = post("/settings/quarantine/"+strconv.FormatInt(letter, 10)+"/approve", nil)
+	if !strings.Contains(rec.Body.String(), "Approved") || rec.Header().Get("HX-Trigger") != "blogListUpdated" {
+		t.Errorf("approve: %s", rec.Body.String())
+	}
+	if article, _ := db.GetArticleByURL("message:<letter@example.com>"); article == nil {
+		t.Error("approved newsletter was not stored")
+	}
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Error("approval published no articles-new event")
+	}
+	if left, _ := db.ListQuarantinedNewsletters(); len(left) != 0 {
+		t.Errorf("quarantine after approve and discard = %+v", left)
+	}
+
+	// Held mail that arrived again once allowed is already in the feed, so
+	// approving it announces nothing
+	if code := deliver("offers@spam.example", "again"); code != http.StatusOK {
+		t.Fatalf("quarantined webhook = %d, want 200", code)
+	}
+	held, _ = db.ListQuarantinedNewsletters()
+	if len(held) != 1 {
+		t.Fatalf("quarantine = %+v, want 1 entry", held)
+	}
+	post("/settings/newsletter-filter", url.Values{"allow": {"offers@spam.example"}, "block": {"spam.example"}})
+	if code := deliver("offers@spam.example", "again"); code != http.StatusOK {
+		t.Fatalf("allowed webhook = %d, want 200", code)
+	}
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatal("allowed newsletter published no articles-new event")
+	}
+	rec = post("/settings/quarantine/"+strconv.FormatInt(held[0].ID, 10)+"/approve", nil)
+	if !strings.Contains(rec.Body.String(), "Already stored") || rec.Header().Get("HX-Trigger") != "" {
+		t.Errorf("approve of a stored newsletter: %s", rec.Body.String())
+	}
+	select {
+	case e := <-ch:
+		t.Errorf("approving a stored newsletter published %q", e.Type)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if left, _ := db.ListQuarantinedNewsletters(); len(left) != 0 {
+		t.Errorf("quarantine after approving a stored newsletter = %+v", left)
+	}
+}
+
+func TestProviderWebhooks(t *testing.T) {
+	srv, db := createTestServerWithDB(t)
+	body, err := os.ReadFile(filepath.Join("..", "inbound", "testdata", "mailgun_mime.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Re-sign the recorded post with a current timestamp
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte("test-signing-key"))
+	mac.Write([]byte(timestamp + "5e0b4d1f2a9c8e7d6b5a4f3e2d1c0b9a8f7e6d5c4b3a2f1e0d"))
+	body = bytes.Replace(body, []byte("1704103200"), []byte(timestamp), 1)
+	body = bytes.Replace(body, []byte("d65c4ee3193adae29c4b690dddf77ce97c0930560e87a06c11e059eb68838f81"), []byte(hex.EncodeToString(mac.Sum(nil))), 1)
+	post := func(path string) int {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "multipart/form-data; boundary=mgboundary")
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := post("/newsletter/webhook/hotmail"); code != http.StatusNotFound {
+		t.Errorf("unknown provider = %d, want 404", code)
+	}
+	// Off until the signing key is saved
+	if code := post("/newsletter/webhook/mailgun"); code != http.StatusUnauthorized {
+		t.Errorf("unconfigured Mailgun = %d, want 401", code)
+	}
+
+	form := url.Values{"mailgun_signing_key": {"test-signing-key"}, "ses_topic_arns": {"not-an-arn"}}
+	req := httptest.NewRequest(http.MethodPost, "/settings/newsletter-providers", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if !strings.Contains(rec.Body.String(), "settings-status-error") {
+		t.Errorf("save with a bad ARN: %s", rec.Body.String())
+	}
+	form.Set("ses_topic_arns", "arn:aws:sns:us-east-1:123456789012:newsletters")
+	req = httptest.NewRequest(http.MethodPost, "/settings/newsletter-providers", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if !strings.Contains(rec.Body.String(), "saved") {
+		t.Fatalf("save: %s", rec.Body.String())
+	}
+
+	if code := post("/newsletter/webhook/mailgun"); code != http.StatusOK {
+		t.Fatalf("Mailgun webhook = %d, want 200", code)
+	}
+	if article, _ := db.GetArticleByURL("message:<issue42@acme.com>"); article == nil {
+		t.Error("Mailgun newsletter not stored")
+	}
+	if code := post("/newsletter/webhook/mailgun"); code != http.StatusUnauthorized {
+		t.Errorf("replayed Mailgun webhook = %d, want 401", code)
+	}
+}
+
+func TestEventsStreamReadStateChanges(t *testing.T) {
+	handler, db := createTestServerWithDB(t)
+	blog, err := db.AddBlog(model.Blog{Name: "Live Blog", URL: "https://live.example.com"})
+	if err != nil {
+		t.Fatalf("add blog: %v", err)
+	}
+	if _, err := db.AddArticlesBulk([]model.Article{{BlogID: blog.ID, Title: "Live Post", URL: "https://live.example.com/p"}}); err != nil {
+		t.Fatalf("add article: %v", err)
+	}
+	article, err := db.GetArticleByURL("https://live.example.com/p")
+	if err != nil || article == nil {
+		t.Fatalf("fetch article: %v", err)
+	}
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+	defer handler.(*Server).Close()
+
+	resp, err := http.Get(ts.URL + "/events")
+	if err != nil {
+		t.Fatalf("GET /events: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	lines := bufio.NewScanner(resp.Body)
+	// The initial retry hint confirms the subscription is registered.
+	if !lines.Scan() || !strings.HasPrefix(lines.Text(), "retry:") {
+		t.Fatalf("first line = %q, want retry hint", lines.Text())
+	}
+
+	markResp, err := http.Post(ts.URL+"/articles/"+strconv.FormatInt(article.ID, 10)+"/read", "", nil)
+	if err != nil {
+		t.Fatalf("mark read: %v", err)
+	}
+	markResp.Body.Close()
+
+	for lines.Scan() {
+		if lines.Text() != "event: read-state-changed" {
+			continue
+		}
+		if !lines.Scan() || !strings.Contains(lines.Text(), `"article_id":`+strconv.FormatInt(article.ID, 10)) {
+			t.Errorf("data line = %q, want article id", lines.Text())
+		}
+		return
+	}
+	t.Fatalf("stream ended without read-state-changed event: %v", lines.Err())
+}
+
+func TestBlogListShowsUnreadCounts(t *testing.T) {
+	srv, db := createTestServerWithDB(t)
+	blog, err := db.AddBlog(model.Blog{Name: "Counted Blog", URL: "https://counted.example.com"})
+	if err != nil {
+		t.Fatalf("add blog: %v", err)
+	}
+	if _, err := db.AddArticlesBulk([]model.Article{
+		{BlogID: blog.ID, Title: "One", URL: "https://counted.example.com/1"},
+		{BlogID: blog.ID, Title: "Two", URL: "https://counted.example.com/2"},
+	}); err != nil {
+		t.Fatalf("add articles: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/blogs", nil)
+	req.Header.Set("HX-Request", "true")
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	body := rec.Body.String()
+	if !strings.Contains(body, `<span class="unread-count">2</span>`) {
+		t.Errorf("blog list should show unread count; got: %s", body)
+	}
+	if !strings.Contains(body, `id="inbox-unread-count"`) || !strings.Contains(body, `hx-swap-oob="true">2</span>`) {
+		t.Errorf("blog list should refresh inbox count out of band; got: %s", body)
+	}
+}
+
+func TestHandleSyncReturnsJobStatus(t *testing.T) {
+	srv := createTestServer(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/sync", nil)
+	req.Header.Set("HX-Request", "true")
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, `id="sync-status"`) || !strings.Contains(body, "data-job-id=") {
+		t.Errorf("response should be the sync status fragment; got: %s", body)
+	}
+}
+
+func TestHandleAPISyncAsyncAndStatus(t *testing.T) {
+	srv := createTestServer(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/sync?async=true", nil)
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202", rec.Code)
+	}
+	var started struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &started); err != nil || started.ID == "" {
+		t.Fatalf("response should contain job id: %s (%v)", rec.Body.String(), err)
+	}
+	if loc := rec.Header().Get("Location"); loc != "/api/sync/"+started.ID {
+		t.Errorf("Location = %q, want /api/sync/%s", loc, started.ID)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/sync/"+started.ID, nil)
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"blogs_scanned"`) {
+		t.Errorf("status lookup = %d %s", rec.Code, rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/sync/unknown", nil)
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown job status = %d, want 404", rec.Code)
+	}
+}
+
+func TestBasePathRouting(t *testing.T) {
+	srv, _ := createTestServerWithOptions(t, Options{Version: "test", BasePath: "/reader/"})
+
+	req := httptest.NewRequest(http.MethodGet, "/reader/", nil)
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /reader/ status = %d, want 200", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`href="/reader/static/styles.css"`,
+		`hx-get="/reader/articles?filter=unread"`,
+		`hx-get="/reader/settings"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("index page missing %s", want)
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/reader", nil)
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != "/reader/" {
+		t.Errorf("GET /reader = %d %q, want redirect to /reader/", rec.Code, rec.Header().Get("Location"))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/articles", nil)
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET /articles outside base path = %d, want 404", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/reader/static/styles.css", nil)
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET static under base path = %d, want 200", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/reader/api/sync?async=true", nil)
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if loc := rec.Header().Get("Location"); !strings.HasPrefix(loc, "/reader/api/sync/") {
+		t.Errorf("Location = %q, want it under /reader", loc)
+	}
+}
+
+func TestForwardedHeadersOnlyFromTrustedProxies(t *testing.T) {
+	forwarded := func(srv http.Handler, target string) string {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.RemoteAddr = "10.1.2.3:4567"
+		req.Header.Set("X-Forwarded-Proto", "https")
+		req.Header.Set("X-Forwarded-Host", "news.example.com")
+		req.Header.Set("HX-Request", "true")
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s status = %d", target, rec.Code)
+		}
+		return rec.Body.String()
+	}
+
+	untrusted, _ := createTestServerWithOptions(t, Options{Version: "test"})
+	if body := forwarded(untrusted, "/settings"); !strings.Contains(body, "http://example.com/newsletter/webhook") {
+		t.Errorf("untrusted proxy headers should be ignored; settings body: %s", body)
+	}
+
+	trusted, _ := createTestServerWithOptions(t, Options{
+		Version:        "test",
+		BasePath:       "/reader",
+		TrustedProxies: []string{"10.0.0.0/8"},
+	})
+	if body := forwarded(trusted, "/reader/settings"); !strings.Contains(body, "https://news.example.com/reader/newsletter/webhook") {
+		t.Errorf("webhook URL should use forwarded scheme, host and base path; settings body: %s", body)
+	}
+	if body := forwarded(trusted, "/reader/feeds/atom"); !strings.Contains(body, `href="https://news.example.com/reader/feeds/atom"`) {
+		t.Errorf("feed self link should use forwarded URL; feed: %s", body)
+	}
+}
+
+func TestClientIPWalksTrustedProxies(t *testing.T) {
+	srv, _ := createTestServerWithOptions(t, Options{Version: "test", TrustedProxies: []string{"10.0.0.0/8", "unix"}})
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        string
+		want       string
+	}{
+		{"direct client", "203.0.113.9:5000", "", "203.0.113.9"},
+		{"untrusted peer cannot spoof", "203.0.113.9:5000", "1.2.3.4", "203.0.113.9"},
+		{"one trusted proxy", "10.0.0.2:5000", "198.51.100.7", "198.51.100.7"},
+		{"chain of trusted proxies", "10.0.0.2:5000", "198.51.100.7, 10.0.0.5", "198.51.100.7"},
+		{"spoofed left entry ignored", "10.0.0.2:5000", "1.2.3.4, 198.51.100.7", "198.51.100.7"},
+		{"unix socket proxy", "@", "198.51.100.8", "198.51.100.8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.xff != "" {
+				req.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if got := srv.clientIP(req); got != tt.want {
+				t.Errorf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := parseTrustedProxies([]string{"not-an-ip"}); err == nil {
+		t.Error("expected error for invalid trusted proxy")
+	}
+}
+
+func TestHandleMetrics(t *testing.T) {
+	srv, db := createTestServerWithDB(t)
+
+	blog, err := db.AddBlog(model.Blog{Name: "Metrics Blog", URL: "https://metrics.example.com"})
+	if err != nil {
+		t.Fatalf("add blog: %v", err)
+	}
+	if _, err := db.AddArticlesBulk([]model.Article{
+		{BlogID: blog.ID, Title: "One", URL: "https://metrics.example.com/1"},
+		{BlogID: blog.ID, Title: "Two", URL: "https://metrics.example.com/2", IsRead: true},
+	}); err != nil {
+		t.Fatalf("add articles: %v", err)
+	}
+
+	// Make a request so the route histogram has a series
+	req := httptest.NewRequest(http.MethodGet, "/articles?filter=unread", nil)
+	srv.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`blogwatcher_http_requests_total{route="GET /articles",code="200"}`,
+		`blogwatcher_http_request_duration_seconds_count{route="GET /articles"}`,
+		`blogwatcher_db_query_duration_seconds_count{statement="select"}`,
+		"blogwatcher_articles 2\n",
+		"blogwatcher_articles_unread 1\n",
+		"# TYPE blogwatcher_scans_total counter",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+}
+
+func TestRequestIDAndAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	srv, _ := createTestServerWithOptions(t, Options{
+		Version:        "test",
+		Logger:         logger,
+		TrustedProxies: []string{"10.0.0.0/8"},
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/articles/999/read", nil)
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	id := rec.Header().Get("X-Request-ID")
+	if len(id) != 16 {
+		t.Fatalf("X-Request-ID = %q, want a generated ID", id)
+	}
+
+	var entry map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var e map[string]any
+		if err := json.Unmarshal([]byte(line), &e); err == nil && e["msg"] == "http request" {
+			entry = e
+		}
+	}
+	if entry == nil {
+		t.Fatalf("no access log entry in:\n%s", buf.String())
+	}
+	if entry["request_id"] != id || entry["method"] != "POST" || entry["path"] != "/articles/999/read" ||
+		entry["route"] != "POST /articles/{id}/read" || entry["status"] != float64(rec.Code) {
+		t.Errorf("access log entry = %v", entry)
+	}
+	if _, ok := entry["duration"]; !ok {
+		t.Errorf("access log entry missing duration: %v", entry)
+	}
+
+	// A trusted proxy's request ID is kept; an untrusted client's is replaced
+	req = httptest.NewRequest(http.MethodGet, "/blogs", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Request-ID", "proxy-id-1")
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if got := rec.Header().Get("X-Request-ID"); got != "proxy-id-1" {
+		t.Errorf("trusted proxy request ID = %q, want proxy-id-1", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/blogs", nil)
+	req.Header.Set("X-Request-ID", "spoofed")
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if got := rec.Header().Get("X-Request-ID"); got == "spoofed" {
+		t.Error("untrusted client should not choose its request ID")
+	}
+}
+
+func TestHandleHealthzAndReadyz(t *testing.T) {
+	srv, db := createTestServerWithDB(t)
+	if _, err := db.AddBlog(model.Blog{Name: "Ready Blog", URL: "https://ready.example.com"}); err != nil {
+		t.Fatalf("add blog: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"version":"test"`) {
+		t.Errorf("healthz = %d %s", rec.Code, rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/readyz", nil)
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("readyz = %d %s", rec.Code, rec.Body.String())
+	}
+	var ready struct {
+		Status string `json:"status"`
+		Checks struct {
+			Database   struct{ OK bool } `json:"database"`
+			Migrations struct {
+				OK            bool `json:"ok"`
+				SchemaVersion int  `json:"schema_version"`
+				LatestVersion int  `json:"latest_schema_version"`
+			} `json:"migrations"`
+			Sync struct {
+				OK    bool `json:"ok"`
+				Stuck bool `json:"stuck"`
+			} `json:"sync"`
+		} `json:"checks"`
+		Database struct {
+			Path      string `json:"path"`
+			SizeBytes int64  `json:"size_bytes"`
+			Blogs     int    `json:"blogs"`
+		} `json:"database"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &ready); err != nil {
+		t.Fatalf("decode readyz: %v", err)
+	}
+	if ready.Status != "ready" || !ready.Checks.Database.OK || !ready.Checks.Migrations.OK || !ready.Checks.Sync.OK {
+		t.Errorf("readyz = %+v", ready)
+	}
+	if ready.Checks.Migrations.SchemaVersion == 0 || ready.Checks.Migrations.SchemaVersion != ready.Checks.Migrations.LatestVersion {
+		t.Errorf("schema versions = %+v", ready.Checks.Migrations)
+	}
+	if ready.Database.Path != db.Path() || ready.Database.SizeBytes == 0 || ready.Database.Blogs != 1 {
+		t.Errorf("database stats = %+v", ready.Database)
+	}
+
+	// A closed database makes the server unready but still alive
+	db.Close()
+	req = httptest.NewRequest(http.MethodGet, "/readyz", nil)
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), `"status":"unavailable"`) {
+		t.Errorf("readyz with closed db = %d %s", rec.Code, rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("healthz with closed db = %d, want 200", rec.Code)
+	}
+}
+
+func TestBackupAPI(t *testing.T) {
+	srv, _ := createTestServerWithOptions(t, Options{Version: "test", BackupKeep: 2})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/backups", nil)
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create backup = %d %s", rec.Code, rec.Body.String())
+	}
+	var created struct {
+		Name string `json:"name"`
+		Size int64  `json:"size_bytes"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil || created.Name == "" || created.Size == 0 {
+		t.Fatalf("created = %+v, %v", created, err)
+	}
+	if loc := rec.Header().Get("Location"); loc != "/api/backups/"+created.Name {
+		t.Errorf("Location = %q", loc)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/backups", nil)
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), created.Name) {
+		t.Errorf("list backups = %d %s", rec.Code, rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/backups/"+created.Name, nil)
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Body.String(), "SQLite format 3") {
+		t.Errorf("download backup = %d, %d bytes", rec.Code, rec.Body.Len())
+	}
+	if cd := rec.Header().Get("Content-Disposition"); !strings.Contains(cd, created.Name) {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/backups/blogwatcher.db", nil)
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("download of non-backup = %d, want 404", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/settings/backup", nil)
+	req.Header.Set("HX-Request", "true")
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Backup created") {
+		t.Errorf("settings backup = %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestExportImportAPI(t *testing.T) {
+	src, srcDB := createTestServerWithDB(t)
+	blog, err := srcDB.AddBlog(model.Blog{Name: "Moving", URL: "https://moving.example.com"})
+	if err != nil {
+		t.Fatalf("add blog: %v", err)
+	}
+	if _, err := srcDB.AddArticlesBulk([]model.Article{{BlogID: blog.ID, Title: "Post", URL: "https://moving.example.com/post", IsRead: true}}); err != nil {
+		t.Fatalf("add article: %v", err)
+	}
+	if err := srcDB.SetSetting("webhook_secret", "topsecret"); err != nil {
+		t.Fatalf("set secret: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/export", nil)
+	rec := httptest.NewRecorder()
+	src.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Header().Get("Content-Disposition"), "attachment") {
+		t.Fatalf("export = %d %v", rec.Code, rec.Header())
+	}
+	exported := rec.Body.Bytes()
+	if bytes.Contains(exported, []byte("topsecret")) {
+		t.Error("export includes the webhook secret without include_secrets")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/export?include_secrets=true", nil)
+	rec = httptest.NewRecorder()
+	src.ServeHTTP(rec, req)
+	if !strings.Contains(rec.Body.String(), "topsecret") {
+		t.Error("export with include_secrets=true lacks the webhook secret")
+	}
+
+	dst, dstDB := createTestServerWithDB(t)
+	req = httptest.NewRequest(http.MethodPost, "/api/import", bytes.NewReader(exported))
+	rec = httptest.NewRecorder()
+	dst.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"articles_added":1`) {
+		t.Fatalf("import = %d %s", rec.Code, rec.Body.String())
+	}
+	if article, _ := dstDB.GetArticleByURL("https://moving.example.com/post"); article == nil || !article.IsRead {
+		t.Errorf("imported article = %+v, want read", article)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/import", strings.NewReader(`{"format":"other"}`))
+	rec = httptest.NewRecorder()
+	dst.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("import of foreign document = %d, want 400", rec.Code)
+	}
+}
+
+func TestStarredArticlesSurviveRetention(t *testing.T) {
+	srv, db := createTestServerWithDB(t)
+	blog, err := db.AddBlog(model.Blog{Name: "Old Blog", URL: "https://old.example.com"})
+	if err != nil {
+		t.Fatalf("add blog: %v", err)
+	}
+	old := time.Now().AddDate(-1, 0, 0)
+	if _, err := db.AddArticlesBulk([]model.Article{
+		{BlogID: blog.ID, Title: "Keeper", URL: "https://old.example.com/keeper", PublishedDate: &old, IsRead: true},
+		{BlogID: blog.ID, Title: "Stale", URL: "https://old.example.com/stale", PublishedDate: &old, IsRead: true},
+	}); err != nil {
+		t.Fatalf("add articles: %v", err)
+	}
+	keeper, _ := db.GetArticleByURL("https://old.example.com/keeper")
+
+	req := httptest.NewRequest(http.MethodPost, "/articles/"+strconv.FormatInt(keeper.ID, 10)+"/star", nil)
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "/unstar") {
+		t.Fatalf("star = %d %s", rec.Code, rec.Body.String())
+	}
+
+	form := url.Values{"read_days": {"30"}, "max_articles": {""}}
+	req = httptest.NewRequest(http.MethodPost, "/settings/retention", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "saved") {
+		t.Fatalf("save retention = %d %s", rec.Code, rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/retention/run?dry_run=1", nil)
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"pruned":1`) {
+		t.Fatalf("dry run = %d %s", rec.Code, rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/settings/retention/run", nil)
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if !strings.Contains(rec.Body.String(), "Removed 1 read articles") {
+		t.Fatalf("run = %d %s", rec.Code, rec.Body.String())
+	}
+	if a, _ := db.GetArticleByURL(keeper.URL); a == nil || !a.IsStarred {
+		t.Errorf("starred article = %+v, want kept", a)
+	}
+	if a, _ := db.GetArticleByURL("https://old.example.com/stale"); a != nil {
+		t.Error("stale article was not removed")
+	}
+}
+
+func TestUpdateBlogSavesRetentionOverride(t *testing.T) {
+	srv, db := createTestServerWithDB(t)
+	blog, err := db.AddBlog(model.Blog{Name: "Archive", URL: "https://archive.example.com"})
+	if err != nil {
+		t.Fatalf("add blog: %v", err)
+	}
+
+	form := url.Values{"name": {"Archive"}, "retention_read_days": {"0"}, "retention_max_articles": {""}}
+	req := httptest.NewRequest(http.MethodPut, "/blogs/"+strconv.FormatInt(blog.ID, 10), strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update blog = %d %s", rec.Code, rec.Body.String())
+	}
+	override, err := db.GetBlogRetention(blog.ID)
+	if err != nil || override.ReadDays == nil || *override.ReadDays != 0 || override.MaxArticles != nil {
+		t.Errorf("override = %+v, %v; want read days 0 and no article limit", override, err)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/blogs/"+strconv.FormatInt(blog.ID, 10)+"/edit", nil)
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if !strings.Contains(rec.Body.String(), `value="0"`) {
+		t.Errorf("edit form should show the override; got: %s", rec.Body.String())
+	}
+}
+
+func TestArticleListLoadsMoreByCursor(t *testing.T) {
+	srv, db := createTestServerWithDB(t)
+	blog, err := db.AddBlog(model.Blog{Name: "Prolific", URL: "https://prolific.example.com"})
+	if err != nil {
+		t.Fatalf("add blog: %v", err)
+	}
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	var articles []model.Article
+	for i := range 25 {
+		published := base.Add(time.Duration(i) * time.Hour)
+		articles = append(articles, model.Article{BlogID: blog.ID, Title: "Post " + strconv.Itoa(i), URL: "https://prolific.example.com/" + strconv.Itoa(i), PublishedDate: &published})
+	}
+	if _, err := db.AddArticlesBulk(articles); err != nil {
+		t.Fatalf("add articles: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/articles", nil)
+	req.Header.Set("HX-Request", "true")
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	body := rec.Body.String()
+	start := strings.Index(body, "cursor=")
+	if rec.Code != http.StatusOK || start < 0 || strings.Contains(body, "offset=") {
+		t.Fatalf("first page should load more by cursor; got %d: %s", rec.Code, body)
+	}
+	loadMore := body[strings.LastIndex(body[:start], `hx-get="`)+len(`hx-get="`):]
+	loadMore = strings.ReplaceAll(loadMore[:strings.Index(loadMore, `"`)], "&amp;", "&")
+
+	req = httptest.NewRequest(http.MethodGet, loadMore, nil)
+	req.Header.Set("HX-Request", "true")
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	body = rec.Body.String()
+	if rec.Code != http.StatusOK || strings.Count(body, `class="article-card"`) != 5 {
+		t.Fatalf("second page = %d with %d cards: %s", rec.Code, strings.Count(body, `class="article-card"`), body)
+	}
+	if !strings.Contains(body, "Showing 25 of 25") || strings.Contains(body, "load-more-trigger") {
+		t.Errorf("last page should show all 25 and stop loading: %s", body)
+	}
+	if !strings.Contains(body, "Post 0") || strings.Contains(body, "Post 24") {
+		t.Errorf("second page should hold the oldest articles: %s", body)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/articles?cursor=bogus!", nil)
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("bad cursor = %d, want 400", rec.Code)
+	}
+}
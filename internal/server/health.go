@@ -0,0 +1,130 @@
+// ABOUTME: Liveness (/healthz) and readiness (/readyz) endpoints for container orchestration.
+// ABOUTME: Readiness checks the database, schema migrations and background sync progress.
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/syncjob"
+)
+
+// readyTimeout bounds the database checks so a wedged connection fails the
+// probe instead of hanging it.
+const readyTimeout = 2 * time.Second
+
+// check is the outcome of one readiness check.
+type check struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// readiness is the /readyz response body.
+type readiness struct {
+	Status   string        `json:"status"`
+	Version  string        `json:"version"`
+	Checks   readyChecks   `json:"checks"`
+	Database databaseStats `json:"database"`
+}
+
+type readyChecks struct {
+	Database   check           `json:"database"`
+	Migrations migrationsCheck `json:"migrations"`
+	Sync       syncCheck       `json:"sync"`
+}
+
+type migrationsCheck struct {
+	check
+	SchemaVersion int `json:"schema_version"`
+	LatestVersion int `json:"latest_schema_version"`
+}
+
+type syncCheck struct {
+	check
+	syncjob.Health
+}
+
+type databaseStats struct {
+	Path           string `json:"path"`
+	SizeBytes      int64  `json:"size_bytes"`
+	Blogs          int    `json:"blogs"`
+	Articles       int    `json:"articles"`
+	UnreadArticles int    `json:"unread_articles"`
+}
+
+// handleHealthz reports that the process is up and serving requests. It
+// deliberately checks nothing else so a slow database never gets the
+// container restarted.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]string{
+		"status":  "ok",
+		"version": s.version,
+	})
+}
+
+// handleReadyz reports whether the server can do useful work: the database
+// answers, all schema migrations are applied and syncing is not stuck.
+// Responds 503 when any check fails.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+
+	resp := readiness{Status: "ready", Version: s.version}
+	resp.Database.Path = s.db.Path()
+
+	if err := s.db.Ping(ctx); err != nil {
+		resp.Checks.Database.Error = err.Error()
+	} else {
+		resp.Checks.Database.OK = true
+		s.fillDatabaseStats(r, &resp.Database)
+
+		current, latest, err := s.db.SchemaVersion()
+		resp.Checks.Migrations.SchemaVersion = current
+		resp.Checks.Migrations.LatestVersion = latest
+		resp.Checks.Migrations.OK = err == nil && current == latest
+		switch {
+		case err != nil:
+			resp.Checks.Migrations.Error = err.Error()
+		case !resp.Checks.Migrations.OK:
+			resp.Checks.Migrations.Error = "schema migrations pending"
+		}
+	}
+	if !resp.Checks.Database.OK {
+		resp.Checks.Migrations.Error = "database unavailable"
+	}
+
+	resp.Checks.Sync.Health = s.syncJobs.Health(time.Now())
+	resp.Checks.Sync.OK = !resp.Checks.Sync.Stuck
+	resp.Checks.Sync.Error = resp.Checks.Sync.Reason
+
+	status := http.StatusOK
+	if !resp.Checks.Database.OK || !resp.Checks.Migrations.OK || !resp.Checks.Sync.OK {
+		resp.Status = "unavailable"
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, status, resp)
+}
+
+// fillDatabaseStats adds size and row counts to stats. They are informational,
+// so failures are logged rather than failing the probe.
+func (s *Server) fillDatabaseStats(r *http.Request, stats *databaseStats) {
+	if size, err := s.db.Size(); err != nil {
+		requestLogger(r).Warn("readyz: database size", "err", err)
+	} else {
+		stats.SizeBytes = size
+	}
+	if blogs, err := s.db.CountBlogs(); err != nil {
+		requestLogger(r).Warn("readyz: count blogs", "err", err)
+	} else {
+		stats.Blogs = blogs
+	}
+	if total, unread, err := s.db.ArticleCounts(); err != nil {
+		requestLogger(r).Warn("readyz: count articles", "err", err)
+	} else {
+		stats.Articles = total
+		stats.UnreadArticles = unread
+	}
+}
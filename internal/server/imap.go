@@ -0,0 +1,114 @@
+// ABOUTME: Handlers for the IMAP newsletter mailbox on the settings page: save settings and test the connection.
+// ABOUTME: Polling itself runs in the background from the newsletter package's IMAPPoller.
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/newsletter"
+)
+
+// imapCheckTimeout bounds the settings page's connection test.
+const imapCheckTimeout = 30 * time.Second
+
+// handleSaveIMAPSettings stores the IMAP mailbox settings. An empty password
+// field keeps the previously stored password if the server and username are
+// unchanged.
+func (s *Server) handleSaveIMAPSettings(w http.ResponseWriter, r *http.Request) {
+	settings, ok := s.imapSettingsFromForm(w, r)
+	if !ok {
+		return
+	}
+	if err := newsletter.SaveIMAPSettings(s.db, settings); err != nil {
+		requestLogger(r).Error("save IMAP settings", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !settings.Enabled() {
+		s.renderSettingsStatus(w, "IMAP polling is off", "")
+		return
+	}
+	s.renderSettingsStatus(w, "IMAP settings saved", "")
+}
+
+// handleTestIMAPConnection logs in with the settings in the form, saved or
+// not, and reports how many unseen messages are waiting.
+func (s *Server) handleTestIMAPConnection(w http.ResponseWriter, r *http.Request) {
+	settings, ok := s.imapSettingsFromForm(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), imapCheckTimeout)
+	defer cancel()
+	unseen, err := newsletter.CheckIMAPConnection(ctx, settings)
+	if err != nil {
+		requestLogger(r).Info("IMAP connection test failed", "host", settings.Host, "err", err)
+		s.renderSettingsStatus(w, "", "Connection failed: "+err.Error())
+		return
+	}
+	folder := settings.Folder
+	if folder == "" {
+		folder = newsletter.DefaultIMAPFolder
+	}
+	s.renderSettingsStatus(w, fmt.Sprintf("Connected: %s has %d unseen", folder, unseen), "")
+}
+
+// imapSettingsFromForm parses the IMAP settings form. On invalid input it
+// writes the error status and returns false.
+func (s *Server) imapSettingsFromForm(w http.ResponseWriter, r *http.Request) (newsletter.IMAPSettings, bool) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return newsletter.IMAPSettings{}, false
+	}
+	existing, err := newsletter.LoadIMAPSettings(s.db)
+	if err != nil {
+		requestLogger(r).Error("load IMAP settings", "err", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return newsletter.IMAPSettings{}, false
+	}
+
+	settings := newsletter.IMAPSettings{
+		Host:     strings.TrimSpace(r.FormValue("imap_host")),
+		Username: strings.TrimSpace(r.FormValue("imap_username")),
+		Password: r.FormValue("imap_password"),
+		Folder:   strings.TrimSpace(r.FormValue("imap_folder")),
+		MoveTo:   strings.TrimSpace(r.FormValue("imap_move_to")),
+		Insecure: r.FormValue("imap_insecure") == "true",
+	}
+	if portParam := strings.TrimSpace(r.FormValue("imap_port")); portParam != "" {
+		port, err := strconv.Atoi(portParam)
+		if err != nil || port <= 0 || port > 65535 {
+			s.renderSettingsStatus(w, "", "IMAP port must be a number between 1 and 65535")
+			return settings, false
+		}
+		settings.Port = port
+	}
+	if minutesParam := strings.TrimSpace(r.FormValue("imap_interval")); minutesParam != "" {
+		minutes, err := strconv.Atoi(minutesParam)
+		if err != nil || minutes <= 0 {
+			s.renderSettingsStatus(w, "", "Check interval must be a positive number of minutes")
+			return settings, false
+		}
+		settings.Interval = time.Duration(minutes) * time.Minute
+	}
+	if settings.MoveTo != "" && strings.EqualFold(settings.MoveTo, settings.Folder) {
+		s.renderSettingsStatus(w, "", "Move-to folder must differ from the folder being checked")
+		return settings, false
+	}
+	// The stored password only goes to the server, port and account it was
+	// entered for, and never in the clear unless it was entered that way
+	if settings.Password == "" && settings.Enabled() {
+		if !strings.EqualFold(settings.Host, existing.Host) || settings.Username != existing.Username ||
+			settings.Port != existing.Port || settings.Insecure != existing.Insecure {
+			s.renderSettingsStatus(w, "", "Enter the password again after changing the server, port, username or STARTTLS option")
+			return settings, false
+		}
+		settings.Password = existing.Password
+	}
+	return settings, true
+}
@@ -0,0 +1,173 @@
+// ABOUTME: Handlers for email providers' inbound webhooks (Mailgun, Postmark, SendGrid, Amazon SES) and their settings.
+// ABOUTME: Each request is verified by the provider's adapter and the email it carries is ingested like any other newsletter.
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/inbound"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/metrics"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/newsletter"
+)
+
+var errUnknownProvider = errors.New("unknown inbound email provider")
+
+// handleProviderWebhook receives a newsletter from the provider named in the
+// path, in that provider's own webhook format.
+func (s *Server) handleProviderWebhook(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("provider")
+	provider, err := s.inboundProvider(name)
+	if errors.Is(err, errUnknownProvider) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		requestLogger(r).Error("newsletter webhook: load provider settings", "provider", name, "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxWebhookBytes)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		requestLogger(r).Warn("newsletter webhook: read body", "provider", name, "err", err)
+		metrics.NewsletterIngests.Inc(name, "error")
+		status := http.StatusBadRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	raw, err := provider.Parse(r, body)
+	switch {
+	case errors.Is(err, inbound.ErrNoMessage):
+		requestLogger(r).Info("newsletter webhook: no message", "provider", name)
+		w.WriteHeader(http.StatusOK)
+		return
+	case errors.Is(err, inbound.ErrNotConfigured), errors.Is(err, inbound.ErrUnauthorized):
+		requestLogger(r).Warn("newsletter webhook: rejected", "provider", name, "err", err)
+		metrics.NewsletterIngests.Inc(name, "unauthorized")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	case errors.Is(err, inbound.ErrInvalid):
+		requestLogger(r).Warn("newsletter webhook: invalid payload", "provider", name, "err", err)
+		metrics.NewsletterIngests.Inc(name, "rejected")
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	case err != nil:
+		requestLogger(r).Error("newsletter webhook", "provider", name, "err", err)
+		metrics.NewsletterIngests.Inc(name, "error")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	_, created, err := newsletter.NewHandler(s.db).HandleInbound(r.Context(), raw)
+	if err != nil {
+		if errors.Is(err, newsletter.ErrQuarantined) {
+			requestLogger(r).Info("newsletter webhook: quarantined", "provider", name, "err", err)
+			metrics.NewsletterIngests.Inc(name, "quarantined")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if errors.Is(err, newsletter.ErrMalformed) {
+			requestLogger(r).Warn("newsletter webhook: malformed email", "provider", name, "err", err)
+			metrics.NewsletterIngests.Inc(name, "rejected")
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		requestLogger(r).Error("newsletter webhook: ingest", "provider", name, "err", err)
+		metrics.NewsletterIngests.Inc(name, "error")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if created {
+		metrics.NewsletterIngests.Inc(name, "success")
+		s.publishNewArticles(1)
+	} else {
+		metrics.NewsletterIngests.Inc(name, "duplicate")
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// inboundProvider returns the adapter for a provider name, configured from
+// the stored settings.
+func (s *Server) inboundProvider(name string) (inbound.Provider, error) {
+	settings, err := inbound.LoadSettings(s.db)
+	if err != nil {
+		return nil, err
+	}
+	switch name {
+	case "mailgun":
+		return &inbound.Mailgun{
+			SigningKey: settings.MailgunSigningKey,
+			// Tokens share the cache with our own webhook's signatures
+			Seen: func(token string, expires time.Time) bool {
+				return !s.webhookReplays.add("mailgun:"+token, expires, time.Now())
+			},
+		}, nil
+	case "postmark":
+		auth, err := s.loadWebhookAuth()
+		return &inbound.Postmark{Secrets: auth.secrets(time.Now())}, err
+	case "sendgrid":
+		provider := &inbound.SendGrid{}
+		if settings.SendGridVerificationKey != "" {
+			if provider.VerificationKey, err = inbound.ParseSendGridKey(settings.SendGridVerificationKey); err != nil {
+				return nil, err
+			}
+		}
+		return provider, nil
+	case "ses":
+		return &inbound.SES{TopicARNs: settings.SESTopicARNs, Certificate: s.sns.Certificate, Confirm: s.sns.Confirm}, nil
+	}
+	return nil, errUnknownProvider
+}
+
+// handleSaveInboundSettings stores the keys and topics that provider
+// webhooks are verified with. Clearing a field turns that provider off.
+func (s *Server) handleSaveInboundSettings(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	settings := inbound.Settings{
+		MailgunSigningKey:       strings.TrimSpace(r.FormValue("mailgun_signing_key")),
+		SendGridVerificationKey: strings.TrimSpace(r.FormValue("sendgrid_verification_key")),
+	}
+	if settings.SendGridVerificationKey != "" {
+		if _, err := inbound.ParseSendGridKey(settings.SendGridVerificationKey); err != nil {
+			s.renderSettingsStatus(w, "", "SendGrid "+err.Error())
+			return
+		}
+	}
+	arns, err := inbound.ParseTopicARNs(r.FormValue("ses_topic_arns"))
+	if err != nil {
+		s.renderSettingsStatus(w, "", err.Error())
+		return
+	}
+	settings.SESTopicARNs = arns
+	if err := inbound.SaveSettings(s.db, settings); err != nil {
+		requestLogger(r).Error("save inbound provider settings", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	s.renderSettingsStatus(w, "Provider settings saved", "")
+}
+
+// postmarkWebhookURL embeds the webhook secret as basic-auth credentials, the
+// way Postmark authenticates its webhooks.
+func postmarkWebhookURL(webhookURL, secret string) string {
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return webhookURL
+	}
+	u.User = url.UserPassword("postmark", secret)
+	return u.String()
+}
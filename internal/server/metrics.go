@@ -0,0 +1,26 @@
+// ABOUTME: Prometheus /metrics endpoint for the HTTP server.
+// ABOUTME: Article counts are read from the database at scrape time.
+package server
+
+import (
+	"net/http"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/metrics"
+)
+
+// handleMetrics serves all metrics in the Prometheus text format.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", metrics.ContentType)
+	if err := metrics.Default.Write(w); err != nil {
+		requestLogger(r).Error("write metrics", "err", err)
+		return
+	}
+
+	total, unread, err := s.db.ArticleCounts()
+	if err != nil {
+		requestLogger(r).Error("count articles for metrics", "err", err)
+		return
+	}
+	_ = metrics.WriteGauge(w, "blogwatcher_articles", "Articles stored.", float64(total))
+	_ = metrics.WriteGauge(w, "blogwatcher_articles_unread", "Unread articles stored.", float64(unread))
+}
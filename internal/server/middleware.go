@@ -0,0 +1,104 @@
+// ABOUTME: Request middleware: request IDs, access logging and per-route metrics.
+// ABOUTME: Every request gets a context logger tagged with its request ID.
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/logging"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/metrics"
+)
+
+// requestIDHeader carries the request ID to and from clients and proxies.
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID limits IDs accepted from proxies to something safe to log.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// statusRecorder captures the response status for logs and metrics.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, which the
+// event stream needs for flushing and deadlines.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// instrument assigns a request ID, logs each request and records count and
+// latency labelled by the matched route pattern, such as
+// "GET /articles/{id}/read".
+func (s *Server) instrument(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		// Keep the ID a trusted proxy already assigned so logs line up
+		id := r.Header.Get(requestIDHeader)
+		if !s.proxies.trusts(r) || !validRequestID.MatchString(id) {
+			id = logging.NewRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		logger := s.logger.With("request_id", id)
+		r = r.WithContext(logging.WithLogger(r.Context(), logger))
+
+		rec := &statusRecorder{ResponseWriter: w}
+		mux.ServeHTTP(rec, r)
+
+		// ServeMux stores the matched pattern on the request it was given
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		duration := time.Since(start)
+		metrics.HTTPRequests.Inc(route, strconv.Itoa(status))
+		metrics.HTTPRequestDuration.Observe(duration.Seconds(), route)
+
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case strings.HasPrefix(route, "GET /static/"), route == "GET /metrics",
+			route == "GET /healthz", route == "GET /readyz":
+			// Asset fetches, scrapes and probes would drown out everything else
+			level = slog.LevelDebug
+		}
+		logger.LogAttrs(r.Context(), level, "http request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("route", route),
+			slog.Int("status", status),
+			slog.Duration("duration", duration),
+			slog.String("client_ip", s.clientIP(r)),
+		)
+	})
+}
+
+// requestLogger returns the logger for r, tagged with its request ID.
+func requestLogger(r *http.Request) *slog.Logger {
+	return logging.FromContext(r.Context())
+}
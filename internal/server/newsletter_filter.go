@@ -0,0 +1,140 @@
+// ABOUTME: Settings handlers for the newsletter sender filter and the quarantine of emails it held back.
+// ABOUTME: Quarantined emails can be approved, which ingests them and creates their blog, discarded, or downloaded.
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/newsletter"
+)
+
+// handleSaveNewsletterFilter stores the allow and block rules and the
+// quarantine and authentication options.
+func (s *Server) handleSaveNewsletterFilter(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	allow, err := newsletter.ParseSenderRules(r.FormValue("allow"))
+	if err != nil {
+		s.renderSettingsStatus(w, "", "Allow: "+err.Error())
+		return
+	}
+	block, err := newsletter.ParseSenderRules(r.FormValue("block"))
+	if err != nil {
+		s.renderSettingsStatus(w, "", "Block: "+err.Error())
+		return
+	}
+	settings := newsletter.FilterSettings{
+		Allow:               allow,
+		Block:               block,
+		QuarantineUnknown:   r.FormValue("quarantine_unknown") == "true",
+		CheckAuthentication: r.FormValue("check_authentication") == "true",
+		AuthServIDs:         strings.Fields(strings.ToLower(strings.ReplaceAll(r.FormValue("authserv_ids"), ",", " "))),
+	}
+	if settings.CheckAuthentication && len(settings.AuthServIDs) == 0 {
+		s.renderSettingsStatus(w, "", "List the mail servers whose Authentication-Results to trust before checking SPF/DKIM")
+		return
+	}
+	if err := newsletter.SaveFilterSettings(s.db, settings); err != nil {
+		requestLogger(r).Error("save newsletter filter", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	s.renderSettingsStatus(w, "Sender filter saved", "")
+}
+
+// renderQuarantine re-renders the quarantine list with a message or error.
+func (s *Server) renderQuarantine(w http.ResponseWriter, r *http.Request, message, errMsg string) {
+	data := s.quarantineData(r)
+	data["Message"] = message
+	data["Error"] = errMsg
+	s.renderTemplate(w, "newsletter-quarantine.gohtml", data)
+}
+
+// quarantineData is the template data for newsletter-quarantine.gohtml.
+func (s *Server) quarantineData(r *http.Request) map[string]interface{} {
+	list, err := s.db.ListQuarantinedNewsletters()
+	if err != nil {
+		requestLogger(r).Error("list quarantined newsletters", "err", err)
+	}
+	return map[string]interface{}{"Quarantine": list}
+}
+
+// handleApproveQuarantined ingests a quarantined newsletter, creating its
+// blog if the sender has none yet.
+func (s *Server) handleApproveQuarantined(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	article, created, err := newsletter.NewHandler(s.db).Approve(r.Context(), id)
+	switch {
+	case errors.Is(err, newsletter.ErrNotQuarantined):
+		s.renderQuarantine(w, r, "", "That newsletter is no longer in quarantine")
+		return
+	case errors.Is(err, newsletter.ErrMalformed):
+		s.renderQuarantine(w, r, "", "The email could not be parsed; discard it instead")
+		return
+	case err != nil:
+		requestLogger(r).Error("approve quarantined newsletter", "id", id, "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !created {
+		requestLogger(r).Info("quarantined newsletter already stored", "id", id, "article_id", article.ID)
+		s.renderQuarantine(w, r, "Already stored; removed from quarantine", "")
+		return
+	}
+	requestLogger(r).Info("quarantined newsletter approved", "id", id, "article_id", article.ID)
+	s.publishNewArticles(1)
+	w.Header().Set("HX-Trigger", "blogListUpdated")
+	s.renderQuarantine(w, r, fmt.Sprintf("Approved %q", article.Title), "")
+}
+
+// handleDiscardQuarantined deletes a quarantined newsletter.
+func (s *Server) handleDiscardQuarantined(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	if _, err := s.db.DeleteQuarantinedNewsletter(id); err != nil {
+		requestLogger(r).Error("discard quarantined newsletter", "id", id, "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	requestLogger(r).Info("quarantined newsletter discarded", "id", id)
+	s.renderQuarantine(w, r, "Discarded", "")
+}
+
+// handleQuarantinedRaw downloads a quarantined email so it can be inspected
+// in a mail client. It is never rendered in the browser.
+func (s *Server) handleQuarantinedRaw(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	q, raw, err := s.db.GetQuarantinedNewsletter(id)
+	if err != nil {
+		requestLogger(r).Error("read quarantined newsletter", "id", id, "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if q == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "message/rfc822")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="quarantined-%d.eml"`, q.ID))
+	w.Header().Set("Content-Length", strconv.Itoa(len(raw)))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	_, _ = w.Write(raw)
+}
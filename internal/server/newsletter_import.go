@@ -0,0 +1,73 @@
+// ABOUTME: Handler for uploading a newsletter archive (mbox or zipped Maildir) on the settings page.
+// ABOUTME: Messages are de-duplicated by Message-ID, so the same archive can be uploaded again safely.
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/newsletter"
+)
+
+const (
+	// maxNewsletterArchiveBytes bounds an uploaded newsletter archive.
+	maxNewsletterArchiveBytes = 1 << 30
+	// newsletterImportTimeout is how long an upload and its import may take,
+	// well past the server's usual read and write timeouts.
+	newsletterImportTimeout = 30 * time.Minute
+)
+
+// handleNewsletterImport imports an uploaded mbox file or zipped Maildir and
+// reports what it added per sender.
+func (s *Server) handleNewsletterImport(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(newsletterImportTimeout)
+	if err := rc.SetReadDeadline(deadline); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		requestLogger(r).Warn("extend read deadline for newsletter import", "err", err)
+	}
+	if err := rc.SetWriteDeadline(deadline); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		requestLogger(r).Warn("extend write deadline for newsletter import", "err", err)
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxNewsletterArchiveBytes)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			s.renderSettingsStatus(w, "", "Archive is larger than 1 GiB; import it with the import-newsletters command instead")
+			return
+		}
+		s.renderSettingsStatus(w, "", "Choose an mbox file or zipped Maildir to import")
+		return
+	}
+	defer file.Close()
+
+	start := time.Now()
+	im := newsletter.NewImporter(s.db)
+	err = im.ImportReader(r.Context(), header.Filename, file, header.Size)
+	result := im.Result()
+	s.publishNewArticles(result.Imported)
+	if err != nil {
+		requestLogger(r).Warn("newsletter import failed", "file", header.Filename, "imported", result.Imported, "err", err)
+		msg := "Import failed: " + err.Error()
+		if result.Imported > 0 {
+			msg = fmt.Sprintf("Import stopped after %d new newsletters: %v", result.Imported, err)
+		}
+		s.renderSettingsStatus(w, "", msg)
+		return
+	}
+	requestLogger(r).Info("newsletter import complete",
+		"file", header.Filename,
+		"messages", result.Messages,
+		"imported", result.Imported,
+		"duplicates", result.Duplicates,
+		"failed", result.Failed,
+		"duration", time.Since(start))
+
+	s.renderTemplate(w, "newsletter-import-result.gohtml", map[string]interface{}{
+		"Result":           result,
+		"UnlistedFailures": result.Failed - len(result.Failures),
+	})
+}
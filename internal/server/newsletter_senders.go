@@ -0,0 +1,132 @@
+// ABOUTME: Settings handlers for newsletter sender aliases and for merging one newsletter blog into another.
+// ABOUTME: Each action re-renders the Newsletter Senders panel with the outcome.
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/model"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/storage"
+)
+
+// newsletterSendersData is the template data for newsletter-senders.gohtml.
+func (s *Server) newsletterSendersData(r *http.Request) map[string]interface{} {
+	aliases, err := s.db.ListNewsletterAliases()
+	if err != nil {
+		requestLogger(r).Error("list newsletter aliases", "err", err)
+	}
+	blogs, err := s.db.ListBlogs()
+	if err != nil {
+		requestLogger(r).Error("list blogs", "err", err)
+	}
+	var newsletters []model.Blog
+	for _, blog := range blogs {
+		if blog.Type == model.BlogTypeNewsletter {
+			newsletters = append(newsletters, blog)
+		}
+	}
+	return map[string]interface{}{
+		"Aliases":     aliases,
+		"Newsletters": newsletters,
+	}
+}
+
+// renderNewsletterSenders re-renders the senders panel with a message or error.
+func (s *Server) renderNewsletterSenders(w http.ResponseWriter, r *http.Request, message, errMsg string) {
+	data := s.newsletterSendersData(r)
+	data["Message"] = message
+	data["Error"] = errMsg
+	s.renderTemplate(w, "newsletter-senders.gohtml", data)
+}
+
+// parseNewsletterAlias tells a sender address from a List-Id: List-Ids
+// (RFC 2919) never contain "@". Angle brackets around a List-Id are dropped.
+func parseNewsletterAlias(value string) (kind, normalized string) {
+	value = strings.TrimSpace(value)
+	if strings.Contains(value, "@") {
+		return model.NewsletterAliasSender, strings.ToLower(strings.Trim(value, "<>"))
+	}
+	return model.NewsletterAliasListID, strings.ToLower(strings.Trim(value, "<>"))
+}
+
+// handleAddNewsletterAlias routes a sender address or List-Id to a
+// newsletter blog.
+func (s *Server) handleAddNewsletterAlias(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	kind, value := parseNewsletterAlias(r.FormValue("alias"))
+	blogID, err := strconv.ParseInt(r.FormValue("blog_id"), 10, 64)
+	if value == "" || err != nil {
+		s.renderNewsletterSenders(w, r, "", "Enter an address or List-Id and choose a newsletter")
+		return
+	}
+	if err := s.db.SetNewsletterAlias(kind, value, blogID); err != nil {
+		if errors.Is(err, storage.ErrNotNewsletterBlog) {
+			s.renderNewsletterSenders(w, r, "", "Choose a newsletter")
+			return
+		}
+		requestLogger(r).Error("set newsletter alias", "kind", kind, "value", value, "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	requestLogger(r).Info("newsletter alias set", "kind", kind, "value", value, "blog_id", blogID)
+	s.renderNewsletterSenders(w, r, "Alias saved", "")
+}
+
+// handleDeleteNewsletterAlias removes a sender address or List-Id alias.
+func (s *Server) handleDeleteNewsletterAlias(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	kind, value := r.FormValue("kind"), r.FormValue("value")
+	if err := s.db.DeleteNewsletterAlias(kind, value); err != nil {
+		requestLogger(r).Error("delete newsletter alias", "kind", kind, "value", value, "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	requestLogger(r).Info("newsletter alias deleted", "kind", kind, "value", value)
+	s.renderNewsletterSenders(w, r, "Alias removed", "")
+}
+
+// handleMergeNewsletters moves every article of one newsletter blog into
+// another and deletes the first; its sender becomes an alias.
+func (s *Server) handleMergeNewsletters(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	fromID, err1 := strconv.ParseInt(r.FormValue("from"), 10, 64)
+	intoID, err2 := strconv.ParseInt(r.FormValue("into"), 10, 64)
+	if err1 != nil || err2 != nil {
+		s.renderNewsletterSenders(w, r, "", "Choose two newsletters to merge")
+		return
+	}
+	if fromID == intoID {
+		s.renderNewsletterSenders(w, r, "", "Choose two different newsletters")
+		return
+	}
+	moved, err := s.db.MergeNewsletterBlogs(fromID, intoID)
+	if errors.Is(err, storage.ErrNotNewsletterBlog) {
+		s.renderNewsletterSenders(w, r, "", "Only newsletters can be merged")
+		return
+	}
+	if err != nil {
+		requestLogger(r).Error("merge newsletters", "from", fromID, "into", intoID, "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	requestLogger(r).Info("newsletters merged", "from", fromID, "into", intoID, "articles", moved)
+
+	data := s.newsletterSendersData(r)
+	data["Message"] = fmt.Sprintf("Merged; moved %d articles", moved)
+	data["MergedBlogID"] = fromID
+	w.Header().Set("HX-Trigger", "blogListUpdated")
+	s.renderTemplate(w, "newsletter-senders.gohtml", data)
+}
@@ -0,0 +1,135 @@
+// ABOUTME: Reverse-proxy awareness: trusted proxies, X-Forwarded-* headers and the base path.
+// ABOUTME: Builds absolute URLs and client addresses as seen from outside the proxy.
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// trustedProxies decides whose X-Forwarded-* headers are believed.
+type trustedProxies struct {
+	prefixes []netip.Prefix
+	unix     bool // trust peers connected over a Unix socket
+}
+
+// parseTrustedProxies accepts IP addresses, CIDRs and "unix".
+func parseTrustedProxies(list []string) (trustedProxies, error) {
+	var tp trustedProxies
+	for _, item := range list {
+		if item == "unix" {
+			tp.unix = true
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(item); err == nil {
+			tp.prefixes = append(tp.prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			return tp, fmt.Errorf("trusted proxy %q is not an IP address or CIDR", item)
+		}
+		addr = addr.Unmap()
+		tp.prefixes = append(tp.prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return tp, nil
+}
+
+func (tp trustedProxies) containsAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range tp.prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// trusts reports whether the direct peer of r is a trusted proxy.
+func (tp trustedProxies) trusts(r *http.Request) bool {
+	addr, ok := remoteAddr(r)
+	if !ok {
+		// Unix socket peers have no IP address
+		return tp.unix
+	}
+	return tp.containsAddr(addr)
+}
+
+// remoteAddr parses the IP of the connection's peer.
+func remoteAddr(r *http.Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// firstHeaderValue returns the first comma-separated entry of a header, the
+// one set by the proxy closest to the client.
+func firstHeaderValue(r *http.Request, name string) string {
+	v, _, _ := strings.Cut(r.Header.Get(name), ",")
+	return strings.TrimSpace(v)
+}
+
+// requestBaseURL returns the scheme, host and base path the request was
+// addressed to, for building absolute URLs in generated documents.
+// X-Forwarded-Proto and X-Forwarded-Host are only honoured from trusted proxies.
+func (s *Server) requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	host := r.Host
+	if s.proxies.trusts(r) {
+		if proto := strings.ToLower(firstHeaderValue(r, "X-Forwarded-Proto")); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if fwdHost := firstHeaderValue(r, "X-Forwarded-Host"); fwdHost != "" {
+			host = fwdHost
+		}
+	}
+	return scheme + "://" + host + s.basePath
+}
+
+// clientIP returns the address of the client that made the request. Behind
+// trusted proxies it is the right-most X-Forwarded-For entry that is not
+// itself a trusted proxy.
+func (s *Server) clientIP(r *http.Request) string {
+	addr, ok := remoteAddr(r)
+	if !s.proxies.trusts(r) {
+		if ok {
+			return addr.String()
+		}
+		return r.RemoteAddr
+	}
+
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		if !s.proxies.containsAddr(hop) || i == 0 {
+			return hop.Unmap().String()
+		}
+	}
+	if ok {
+		return addr.String()
+	}
+	return r.RemoteAddr
+}
+
+// path prefixes an absolute application path with the base path.
+func (s *Server) path(p string) string {
+	return s.basePath + p
+}
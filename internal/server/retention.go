@@ -0,0 +1,103 @@
+// ABOUTME: Handlers for article retention: the global policy on the settings page and on-demand cleanup.
+// ABOUTME: Per-blog overrides are saved by the blog edit form in handleUpdateBlog.
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/retention"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/storage"
+)
+
+// handleSaveRetentionSettings stores the global retention policy. Empty fields
+// disable a rule.
+func (s *Server) handleSaveRetentionSettings(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	readDays, err := parseRetentionLimit(r.FormValue("read_days"))
+	if err != nil {
+		s.renderSettingsStatus(w, "", "Days must be a positive number")
+		return
+	}
+	maxArticles, err := parseRetentionLimit(r.FormValue("max_articles"))
+	if err != nil {
+		s.renderSettingsStatus(w, "", "Article limit must be a positive number")
+		return
+	}
+
+	policy := storage.RetentionPolicy{ReadDays: derefInt(readDays), MaxArticles: derefInt(maxArticles)}
+	if err := retention.SaveSettings(s.db, policy); err != nil {
+		requestLogger(r).Error("save retention settings", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	s.renderSettingsStatus(w, "Retention settings saved", "")
+}
+
+// handleRunRetention prunes old articles now from the settings page.
+func (s *Server) handleRunRetention(w http.ResponseWriter, r *http.Request) {
+	result, err := s.runRetention(r, false)
+	if err != nil {
+		s.renderSettingsStatus(w, "", "Cleanup failed: "+err.Error())
+		return
+	}
+	if result.Pruned == 0 {
+		s.renderSettingsStatus(w, "Nothing to clean up", "")
+		return
+	}
+	s.renderSettingsStatus(w, fmt.Sprintf("Removed %d read articles from %d blogs", result.Pruned, len(result.Blogs)), "")
+}
+
+// handleAPIRunRetention prunes old articles now and returns what was removed.
+// With ?dry_run=1 it only reports what would be.
+func (s *Server) handleAPIRunRetention(w http.ResponseWriter, r *http.Request) {
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+	result, err := s.runRetention(r, dryRun)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) runRetention(r *http.Request, dryRun bool) (retention.Result, error) {
+	start := time.Now()
+	result, err := retention.Run(s.db, start, dryRun)
+	if err != nil {
+		requestLogger(r).Error("retention run failed", "err", err)
+		return result, err
+	}
+	requestLogger(r).Info("retention run complete",
+		"dry_run", dryRun,
+		"articles", result.Pruned,
+		"blogs", len(result.Blogs),
+		"duration", time.Since(start))
+	return result, nil
+}
+
+// parseRetentionLimit parses a retention form field. Empty means unset (nil);
+// "0" is allowed so a blog can switch a global rule off.
+func parseRetentionLimit(value string) (*int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid limit %q", value)
+	}
+	return &n, nil
+}
+
+func derefInt(p *int) int {
+	if p == nil {
+		return 0
+	}
+	return *p
+}
@@ -20,21 +20,70 @@ func (s *Server) registerRoutes() {
 	// Article management actions
 	s.mux.HandleFunc("POST /articles/{id}/read", s.handleMarkRead)
 	s.mux.HandleFunc("POST /articles/{id}/unread", s.handleMarkUnread)
+	s.mux.HandleFunc("POST /articles/{id}/star", s.handleStar)
+	s.mux.HandleFunc("POST /articles/{id}/unstar", s.handleUnstar)
 	s.mux.HandleFunc("POST /articles/mark-all-read", s.handleMarkAllRead)
 
 	// Sync
 	s.mux.HandleFunc("POST /sync", s.handleSync)
 	s.mux.HandleFunc("POST /api/sync", s.handleAPISync)
+	s.mux.HandleFunc("GET /api/sync/{id}", s.handleSyncStatus)
+
+	// Live updates (Server-Sent Events)
+	s.mux.HandleFunc("GET /events", s.handleEvents)
 
 	// Blog management
 	s.mux.HandleFunc("POST /blogs/add", s.handleAddBlog)
 	s.mux.HandleFunc("GET /blogs/{id}", s.handleGetBlog)
 	s.mux.HandleFunc("GET /blogs/{id}/edit", s.handleEditBlog)
-	s.mux.HandleFunc("PUT /blogs/{id}", s.handleUpdateBlogName)
+	s.mux.HandleFunc("PUT /blogs/{id}", s.handleUpdateBlog)
 	s.mux.HandleFunc("DELETE /blogs/{id}", s.handleDeleteBlog)
 
+	// Generated feeds (atom, rss, json) of any article filter combination
+	s.mux.HandleFunc("GET /feeds/{format}", s.handleFeed)
+
 	// Newsletter
-	s.mux.HandleFunc("POST /newsletter/webhook", s.handleNewsletterWebhook)
+	s.mux.HandleFunc("POST /newsletter/webhook", s.limitWebhook(s.handleNewsletterWebhook))
+	s.mux.HandleFunc("POST /newsletter/webhook/{provider}", s.limitWebhook(s.handleProviderWebhook))
 	s.mux.HandleFunc("GET /newsletter/article/{id}", s.handleNewsletterArticle)
+	s.mux.HandleFunc("GET /newsletter/article/{id}/parts/{part}", s.handleNewsletterPart)
+	s.mux.HandleFunc("GET /newsletter/article/{id}/cid/{cid}", s.handleNewsletterPart)
 	s.mux.HandleFunc("POST /settings/newsletter-inbox", s.handleSetNewsletterInbox)
+	s.mux.HandleFunc("POST /settings/webhook-secret/rotate", s.handleRotateWebhookSecret)
+	s.mux.HandleFunc("POST /settings/webhook-signing", s.handleSaveWebhookSigning)
+	s.mux.HandleFunc("POST /settings/imap", s.handleSaveIMAPSettings)
+	s.mux.HandleFunc("POST /settings/imap/test", s.handleTestIMAPConnection)
+	s.mux.HandleFunc("POST /settings/newsletter-import", s.handleNewsletterImport)
+	s.mux.HandleFunc("POST /settings/newsletter-providers", s.handleSaveInboundSettings)
+	s.mux.HandleFunc("POST /settings/newsletter-aliases", s.handleAddNewsletterAlias)
+	s.mux.HandleFunc("POST /settings/newsletter-aliases/delete", s.handleDeleteNewsletterAlias)
+	s.mux.HandleFunc("POST /settings/newsletter-merge", s.handleMergeNewsletters)
+	s.mux.HandleFunc("POST /settings/newsletter-filter", s.handleSaveNewsletterFilter)
+	s.mux.HandleFunc("POST /settings/quarantine/{id}/approve", s.handleApproveQuarantined)
+	s.mux.HandleFunc("POST /settings/quarantine/{id}/discard", s.handleDiscardQuarantined)
+	s.mux.HandleFunc("GET /settings/quarantine/{id}/raw", s.handleQuarantinedRaw)
+
+	// Prometheus metrics and orchestration probes
+	s.mux.HandleFunc("GET /metrics", s.handleMetrics)
+	s.mux.HandleFunc("GET /healthz", s.handleHealthz)
+	s.mux.HandleFunc("GET /readyz", s.handleReadyz)
+
+	// Backups and JSON export/import
+	s.mux.HandleFunc("GET /api/backups", s.handleAPIListBackups)
+	s.mux.HandleFunc("POST /api/backups", s.handleAPICreateBackup)
+	s.mux.HandleFunc("GET /api/backups/{name}", s.handleDownloadBackup)
+	s.mux.HandleFunc("POST /settings/backup", s.handleSettingsBackup)
+	s.mux.HandleFunc("GET /api/export", s.handleExport)
+	s.mux.HandleFunc("POST /api/import", s.handleAPIImport)
+	s.mux.HandleFunc("POST /settings/import", s.handleSettingsImport)
+
+	// Article retention
+	s.mux.HandleFunc("POST /settings/retention", s.handleSaveRetentionSettings)
+	s.mux.HandleFunc("POST /settings/retention/run", s.handleRunRetention)
+	s.mux.HandleFunc("POST /api/retention/run", s.handleAPIRunRetention)
+
+	// Email digest
+	s.mux.HandleFunc("GET /digest/preview", s.handleDigestPreview)
+	s.mux.HandleFunc("POST /digest/send", s.handleSendDigest)
+	s.mux.HandleFunc("POST /settings/digest", s.handleSaveDigestSettings)
 }
@@ -6,10 +6,18 @@ import (
 	"fmt"
 	"html/template"
 	"io/fs"
+	"log/slog"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/backup"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/events"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/fetch"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/inbound"
 	"github.com/esttorhe/blogwatcher-ui/v2/internal/service"
 	"github.com/esttorhe/blogwatcher-ui/v2/internal/storage"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/syncjob"
 )
 
 // Server represents the HTTP server with all dependencies
@@ -18,8 +26,20 @@ type Server struct {
 	blogService *service.BlogService
 	templates   *template.Template
 	mux         *http.ServeMux
+	handler     http.Handler
 	staticFS    fs.FS
 	version     string
+	publicURL   string
+	logger      *slog.Logger
+	basePath    string
+	proxies     trustedProxies
+	events      *events.Broker
+	syncJobs    *syncjob.Manager
+	backups     *backup.Manager
+	sns         *inbound.SNSClient
+
+	webhookLimiter *rateLimiter
+	webhookReplays *replayCache
 }
 
 // NewServer creates a new HTTP server with dependency injection
@@ -29,22 +49,62 @@ func NewServer(db *storage.Database) (http.Handler, error) {
 	return nil, fmt.Errorf("NewServer is deprecated, use NewServerWithFS with embedded filesystems")
 }
 
+// Options holds optional server settings. The zero value is valid.
+type Options struct {
+	Version string
+	// BaseURL is the public URL used for absolute links in feeds and digests.
+	// When empty it is derived from each request.
+	BaseURL string
+	// BasePath serves every route under a prefix such as "/reader". The
+	// reverse proxy must forward the prefix rather than strip it.
+	BasePath string
+	// TrustedProxies lists IPs, CIDRs or "unix" whose X-Forwarded-* headers
+	// are honoured when building absolute URLs.
+	TrustedProxies []string
+	// Logger receives request and background logs; nil uses slog.Default().
+	Logger *slog.Logger
+	// SyncTimeout bounds one sync of all blogs; zero uses syncjob.DefaultTimeout.
+	SyncTimeout time.Duration
+	// BackupDir and BackupKeep configure database backups; zero values use
+	// the backup package defaults.
+	BackupDir  string
+	BackupKeep int
+}
+
 // NewServerWithFS creates a new HTTP server with embedded filesystems
 // Parses all templates at startup and registers routes
-func NewServerWithFS(db *storage.Database, templateFS fs.FS, staticFS fs.FS, version string) (http.Handler, error) {
+func NewServerWithFS(db *storage.Database, templateFS fs.FS, staticFS fs.FS, version string) (*Server, error) {
+	return NewServerWithOptions(db, templateFS, staticFS, Options{Version: version})
+}
+
+// NewServerWithOptions is NewServerWithFS with additional settings from the
+// server configuration.
+func NewServerWithOptions(db *storage.Database, templateFS fs.FS, staticFS fs.FS, opts Options) (*Server, error) {
+	proxies, err := parseTrustedProxies(opts.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	basePath := strings.TrimRight(opts.BasePath, "/")
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
 	// Register template functions BEFORE parsing templates
 	funcMap := template.FuncMap{
 		"timeAgo":          timeAgo,
 		"faviconURL":       faviconURL,
 		"smryURL":          smryURL,
 		"isNewsletterURL":  isNewsletterURL,
+		"newsletterSender": newsletterSender,
+		"basePath":         func() string { return basePath },
 	}
 
 	// Parse all templates once at startup from embedded filesystem
 	tmpl := template.New("").Funcs(funcMap)
 
 	// Walk the embedded template filesystem and parse all templates
-	err := fs.WalkDir(templateFS, ".", func(path string, d fs.DirEntry, err error) error {
+	err = fs.WalkDir(templateFS, ".", func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
@@ -72,16 +132,63 @@ func NewServerWithFS(db *storage.Database, templateFS fs.FS, staticFS fs.FS, ver
 		templates:   tmpl,
 		mux:         http.NewServeMux(),
 		staticFS:    staticFS,
-		version:     version,
+		version:     opts.Version,
+		publicURL:   strings.TrimRight(opts.BaseURL, "/"),
+		logger:      logger,
+		basePath:    basePath,
+		proxies:     proxies,
+		events:      events.NewBroker(),
+		sns:         inbound.NewSNSClient(fetch.Client()),
+
+		webhookLimiter: newRateLimiter(webhookRate, webhookBurst),
+		webhookReplays: newReplayCache(),
 	}
+	s.syncJobs = syncjob.NewManager(db, s.events, logger, opts.SyncTimeout)
+	s.backups = backup.NewManager(db, opts.BackupDir, opts.BackupKeep, logger)
 
 	// Register all routes
 	s.registerRoutes()
+	s.handler = s.instrument(s.mux)
+	if basePath != "" {
+		s.handler = http.StripPrefix(basePath, s.handler)
+	}
 
 	return s, nil
 }
 
 // ServeHTTP implements http.Handler interface
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	s.mux.ServeHTTP(w, r)
+	if s.basePath != "" {
+		if r.URL.Path == s.basePath {
+			http.Redirect(w, r, s.basePath+"/", http.StatusMovedPermanently)
+			return
+		}
+		if !strings.HasPrefix(r.URL.Path, s.basePath+"/") {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	s.handler.ServeHTTP(w, r)
+}
+
+// SyncJobs returns the manager that runs background syncs.
+func (s *Server) SyncJobs() *syncjob.Manager {
+	return s.syncJobs
+}
+
+// Backups returns the manager that writes database backups.
+func (s *Server) Backups() *backup.Manager {
+	return s.backups
+}
+
+// Events returns the broker that pushes live updates to open tabs.
+func (s *Server) Events() *events.Broker {
+	return s.events
+}
+
+// Close cancels any running sync job and disconnects live event streams so
+// http.Server.Shutdown is not held open by long-lived SSE connections.
+func (s *Server) Close() {
+	s.syncJobs.Close()
+	s.events.Close()
 }
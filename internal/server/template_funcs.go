@@ -67,6 +67,12 @@ func isNewsletterURL(u string) bool {
 	return strings.HasPrefix(u, "message:")
 }
 
+// newsletterSender returns the sender address of a newsletter blog, whose
+// URL is stored as "mailto:<address>".
+func newsletterSender(blogURL string) string {
+	return strings.TrimPrefix(blogURL, "mailto:")
+}
+
 // smryURL strips the protocol from an article URL and prepends the smry.ai domain
 // so the article can be opened in smry.ai's reader/summarizer.
 func smryURL(articleURL string) string {
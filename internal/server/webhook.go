@@ -0,0 +1,309 @@
+// ABOUTME: Authentication for the newsletter webhooks: secrets with rotation, HMAC body signatures and per-IP rate limits.
+// ABOUTME: A rotated-out secret keeps working for a grace period so senders can be updated without losing mail.
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/inbound"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/metrics"
+)
+
+// Setting keys for the webhook secret and its rotation.
+const (
+	keyWebhookSecret        = "webhook_secret"
+	keyWebhookPrevious      = "webhook_secret_previous"
+	keyWebhookPreviousUntil = "webhook_secret_previous_until"
+	keyWebhookRequireSigned = "webhook_require_signature"
+)
+
+const (
+	// maxWebhookBytes bounds a webhook body: a raw email, or a provider
+	// payload carrying one with its attachments base64 or form encoded.
+	maxWebhookBytes = 64 << 20
+	// webhookSecretGrace is how long a rotated-out secret is still accepted.
+	webhookSecretGrace = 24 * time.Hour
+	// webhookSignatureTolerance is how far a signed request's timestamp may
+	// be from now; signatures are remembered this long to refuse replays.
+	webhookSignatureTolerance = 5 * time.Minute
+	// webhookRate and webhookBurst limit webhook requests per client IP.
+	webhookRate  = 1.0 // requests per second
+	webhookBurst = 30
+)
+
+var (
+	errWebhookUnsigned  = errors.New("request is not signed")
+	errWebhookSecret    = errors.New("missing or wrong webhook secret")
+	errWebhookSignature = errors.New("bad webhook signature")
+	errWebhookStale     = errors.New("webhook timestamp outside the allowed window")
+	errWebhookReplayed  = errors.New("webhook signature already used")
+)
+
+// webhookAuth is the stored webhook secret state.
+type webhookAuth struct {
+	Secret           string
+	Previous         string    // the rotated-out secret, empty when none
+	PreviousUntil    time.Time // when Previous stops being accepted
+	RequireSignature bool
+}
+
+// secrets returns the secrets accepted at now, current first.
+func (a webhookAuth) secrets(now time.Time) []string {
+	var secrets []string
+	if a.Secret != "" {
+		secrets = append(secrets, a.Secret)
+	}
+	if a.Previous != "" && now.Before(a.PreviousUntil) {
+		secrets = append(secrets, a.Previous)
+	}
+	return secrets
+}
+
+// loadWebhookAuth reads the webhook secret state.
+func (s *Server) loadWebhookAuth() (webhookAuth, error) {
+	var a webhookAuth
+	values := make(map[string]string)
+	for _, key := range []string{keyWebhookSecret, keyWebhookPrevious, keyWebhookPreviousUntil, keyWebhookRequireSigned} {
+		v, err := s.db.GetSetting(key)
+		if err != nil {
+			return a, err
+		}
+		values[key] = v
+	}
+	a.Secret = values[keyWebhookSecret]
+	a.Previous = values[keyWebhookPrevious]
+	a.PreviousUntil, _ = time.Parse(time.RFC3339, values[keyWebhookPreviousUntil])
+	a.RequireSignature = values[keyWebhookRequireSigned] == "true"
+	return a, nil
+}
+
+// checkWebhookSecret compares a presented secret with the accepted ones in
+// constant time. Hashing first keeps the comparison independent of length.
+func checkWebhookSecret(presented string, secrets []string) bool {
+	got := sha256.Sum256([]byte(presented))
+	ok := 0
+	for _, secret := range secrets {
+		want := sha256.Sum256([]byte(secret))
+		ok |= subtle.ConstantTimeCompare(got[:], want[:])
+	}
+	return presented != "" && ok == 1
+}
+
+// verifyWebhookSignature checks an X-Webhook-Signature header: "sha256="
+// and the hex HMAC-SHA256 of the X-Webhook-Timestamp value, a dot and the
+// body, under any accepted secret.
+func (s *Server) verifyWebhookSignature(timestamp, signature string, body []byte, secrets []string, now time.Time) error {
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: X-Webhook-Timestamp %q", errWebhookSignature, timestamp)
+	}
+	signedAt := time.Unix(unix, 0)
+	if now.Sub(signedAt).Abs() > webhookSignatureTolerance {
+		return errWebhookStale
+	}
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return fmt.Errorf("%w: %w", errWebhookSignature, err)
+	}
+	valid := false
+	for _, secret := range secrets {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(body)
+		if hmac.Equal(got, mac.Sum(nil)) {
+			valid = true
+		}
+	}
+	if !valid {
+		return errWebhookSignature
+	}
+	if !s.webhookReplays.add(hex.EncodeToString(got), signedAt.Add(webhookSignatureTolerance), now) {
+		return errWebhookReplayed
+	}
+	return nil
+}
+
+// replayCache remembers signatures until their timestamp leaves the
+// tolerance window, after which they are refused as stale anyway.
+type replayCache struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func newReplayCache() *replayCache {
+	return &replayCache{seen: make(map[string]time.Time)}
+}
+
+// add records key until expires and reports whether it was new.
+func (c *replayCache) add(key string, expires, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, exp := range c.seen {
+		if !now.Before(exp) {
+			delete(c.seen, k)
+		}
+	}
+	if _, ok := c.seen[key]; ok {
+		return false
+	}
+	c.seen[key] = expires
+	return true
+}
+
+// rateLimiter is a token bucket per client IP.
+type rateLimiter struct {
+	rate  float64 // tokens per second
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: float64(burst), buckets: make(map[string]*tokenBucket)}
+}
+
+// allow takes a token for key. When none is left it returns false and how
+// long until one is.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[key]
+	if !ok {
+		// Buckets that have refilled are the same as no bucket; drop them
+		// now and then so the map stays small
+		if len(l.buckets) >= 1024 {
+			for k, old := range l.buckets {
+				if old.tokens+now.Sub(old.last).Seconds()*l.rate >= l.burst {
+					delete(l.buckets, k)
+				}
+			}
+		}
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// limitWebhook rate-limits a webhook handler by client IP.
+func (s *Server) limitWebhook(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ok, wait := s.webhookLimiter.allow(s.clientIP(r), time.Now())
+		if !ok {
+			// The path is the client's to choose, so only known names become labels
+			source := r.PathValue("provider")
+			switch {
+			case source == "":
+				source = "webhook"
+			case !slices.Contains(inbound.Providers, source):
+				source = "unknown"
+			}
+			metrics.NewsletterIngests.Inc(source, "rate_limited")
+			requestLogger(r).Warn("newsletter webhook: rate limited", "client", s.clientIP(r))
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// webhookSecretData is the template data for webhook-secret.gohtml. It
+// generates the secret on first use.
+func (s *Server) webhookSecretData(r *http.Request) (map[string]interface{}, webhookAuth) {
+	auth, err := s.loadWebhookAuth()
+	if err != nil {
+		requestLogger(r).Error("read webhook secret", "err", err)
+	}
+	if auth.Secret == "" {
+		auth.Secret = generateWebhookSecret()
+		if err := s.db.SetSetting(keyWebhookSecret, auth.Secret); err != nil {
+			requestLogger(r).Error("store setting", "key", keyWebhookSecret, "err", err)
+		}
+	}
+	data := map[string]interface{}{
+		"Secret":           auth.Secret,
+		"RequireSignature": auth.RequireSignature,
+	}
+	if len(auth.secrets(time.Now())) > 1 {
+		data["PreviousUntil"] = auth.PreviousUntil
+	}
+	return data, auth
+}
+
+// handleRotateWebhookSecret replaces the webhook secret. The old one is
+// still accepted for webhookSecretGrace.
+func (s *Server) handleRotateWebhookSecret(w http.ResponseWriter, r *http.Request) {
+	current, err := s.db.GetSetting(keyWebhookSecret)
+	if err != nil {
+		requestLogger(r).Error("read webhook secret", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	// Keep the old secret before replacing it, so a failure part-way never
+	// leaves senders with a secret that no longer works
+	if current != "" {
+		until := time.Now().Add(webhookSecretGrace).UTC().Format(time.RFC3339)
+		if err := s.db.SetSetting(keyWebhookPreviousUntil, until); err == nil {
+			err = s.db.SetSetting(keyWebhookPrevious, current)
+		}
+		if err != nil {
+			requestLogger(r).Error("store previous webhook secret", "err", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := s.db.SetSetting(keyWebhookSecret, generateWebhookSecret()); err != nil {
+		requestLogger(r).Error("store webhook secret", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	requestLogger(r).Info("webhook secret rotated", "grace", webhookSecretGrace)
+
+	data, _ := s.webhookSecretData(r)
+	data["Message"] = "Secret rotated. Update your senders before the old one expires."
+	s.renderTemplate(w, "webhook-secret.gohtml", data)
+}
+
+// handleSaveWebhookSigning sets whether the webhook only accepts signed
+// requests.
+func (s *Server) handleSaveWebhookSigning(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	require := r.FormValue("require_signature") == "true"
+	if err := s.db.SetSetting(keyWebhookRequireSigned, strconv.FormatBool(require)); err != nil {
+		requestLogger(r).Error("store setting", "key", keyWebhookRequireSigned, "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if require {
+		s.renderSettingsStatus(w, "Only signed webhook requests are accepted", "")
+		return
+	}
+	s.renderSettingsStatus(w, "Webhook accepts the secret header or a signature", "")
+}
@@ -0,0 +1,498 @@
+// ABOUTME: A small SMTP and LMTP server (RFC 5321, RFC 2033) that hands each accepted message to a Backend.
+// ABOUTME: Supports PIPELINING, 8BITMIME, SIZE and STARTTLS; it only receives mail and never relays it.
+package smtpd
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultMaxMessageBytes caps a message when Server.MaxMessageBytes is zero.
+	DefaultMaxMessageBytes = 25 << 20
+	// DefaultTimeout bounds each command and the message body when
+	// Server.Timeout is zero. RFC 5321 suggests at least five minutes.
+	DefaultTimeout = 5 * time.Minute
+
+	maxRecipients = 100
+	maxLineBytes  = 4096
+)
+
+// ErrServerClosed is returned by Serve after Shutdown.
+var ErrServerClosed = errors.New("smtpd: server closed")
+
+// Envelope is the sender and recipients a client gave for one message.
+type Envelope struct {
+	RemoteAddr string
+	Helo       string
+	From       string // empty for bounces (MAIL FROM:<>)
+	To         []string
+}
+
+// Backend decides which recipients to accept and stores messages.
+type Backend interface {
+	// Recipient accepts or refuses mail for addr. Return an *Error to choose
+	// the reply; any other error refuses it temporarily.
+	Recipient(ctx context.Context, addr string) error
+	// Deliver stores one message, with dot-stuffing undone and line endings
+	// normalised to LF. Return an *Error to choose the reply; any other error is reported as a temporary failure so the sender retries.
+	Deliver(ctx context.Context, env Envelope, data []byte) error
+}
+
+// Error is an SMTP reply chosen by a Backend, such as 550 for an unknown
+// mailbox or 554 for a message that cannot be accepted.
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return strconv.Itoa(e.Code) + " " + e.Message
+}
+
+// Server receives mail over SMTP or LMTP.
+type Server struct {
+	Backend Backend
+	// Hostname is announced in the greeting and EHLO reply.
+	Hostname string
+	// LMTP speaks LMTP (LHLO, one DATA reply per recipient) instead of SMTP.
+	LMTP bool
+	// MaxMessageBytes caps the message size; zero uses DefaultMaxMessageBytes.
+	MaxMessageBytes int64
+	// TLSConfig enables STARTTLS when set.
+	TLSConfig *tls.Config
+	// Timeout bounds each command and the message body; zero uses DefaultTimeout.
+	Timeout time.Duration
+	Logger  *slog.Logger
+
+	closing   atomic.Bool
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[*conn]struct{}
+}
+
+// Serve accepts connections on ln until Shutdown is called or ln fails.
+func (s *Server) Serve(ln net.Listener) error {
+	if !s.track(ln, nil, true) {
+		ln.Close()
+		return ErrServerClosed
+	}
+	defer s.track(ln, nil, false)
+
+	for {
+		nc, err := ln.Accept()
+		if err != nil {
+			if s.closing.Load() {
+				return ErrServerClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(50 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		c := &conn{srv: s, raw: nc, nc: nc, logger: s.logger().With("remote_addr", nc.RemoteAddr().String())}
+		if !s.track(nil, c, true) {
+			nc.Close()
+			return ErrServerClosed
+		}
+		go func() {
+			defer s.track(nil, c, false)
+			c.serve()
+		}()
+	}
+}
+
+// Shutdown stops accepting connections, closes idle ones and waits for
+// messages being received or delivered to finish. If ctx ends first, the
+// remaining connections are closed and ctx's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.closing.Store(true)
+	s.mu.Lock()
+	for ln := range s.listeners {
+		ln.Close()
+	}
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		s.mu.Lock()
+		for c := range s.conns {
+			if !c.busy.Load() {
+				c.raw.Close()
+			}
+		}
+		remaining := len(s.conns)
+		s.mu.Unlock()
+		if remaining == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			s.mu.Lock()
+			for c := range s.conns {
+				c.raw.Close()
+			}
+			s.mu.Unlock()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// track adds or removes a listener or connection. Adding fails once the
+// server is shutting down.
+func (s *Server) track(ln net.Listener, c *conn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if add && s.closing.Load() {
+		return false
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+		s.conns = make(map[*conn]struct{})
+	}
+	switch {
+	case ln != nil && add:
+		s.listeners[ln] = struct{}{}
+	case ln != nil:
+		delete(s.listeners, ln)
+	case add:
+		s.conns[c] = struct{}{}
+	default:
+		delete(s.conns, c)
+	}
+	return true
+}
+
+func (s *Server) logger() *slog.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return slog.Default()
+}
+
+func (s *Server) maxMessageBytes() int64 {
+	if s.MaxMessageBytes > 0 {
+		return s.MaxMessageBytes
+	}
+	return DefaultMaxMessageBytes
+}
+
+func (s *Server) timeout() time.Duration {
+	if s.Timeout > 0 {
+		return s.Timeout
+	}
+	return DefaultTimeout
+}
+
+func (s *Server) hostname() string {
+	if s.Hostname != "" {
+		return s.Hostname
+	}
+	return "localhost"
+}
+
+// conn is one client session.
+type conn struct {
+	srv    *Server
+	raw    net.Conn // the accepted connection, for Shutdown to close
+	nc     net.Conn // raw, or a TLS connection over it after STARTTLS
+	logger *slog.Logger
+	busy   atomic.Bool // receiving or delivering a message; Shutdown waits for it
+
+	limit *io.LimitedReader
+	text  *textproto.Conn
+	tls   bool
+
+	helo string
+	from *string // nil until MAIL; empty string for the null sender
+	to   []string
+}
+
+// reset starts a new transaction, keeping the HELO name.
+func (c *conn) reset() {
+	c.from, c.to = nil, nil
+}
+
+// attach (re)builds the line reader and writer over nc, for the start of a
+// session and after STARTTLS.
+func (c *conn) attach() {
+	c.limit = &io.LimitedReader{R: c.nc, N: maxLineBytes}
+	c.text = textproto.NewConn(struct {
+		io.Reader
+		io.Writer
+		io.Closer
+	}{bufio.NewReader(c.limit), c.nc, c.nc})
+}
+
+func (c *conn) reply(code int, format string, args ...interface{}) {
+	_ = c.text.PrintfLine("%d %s", code, fmt.Sprintf(format, args...))
+}
+
+func (c *conn) serve() {
+	defer c.nc.Close()
+	c.attach()
+
+	proto := "ESMTP"
+	if c.srv.LMTP {
+		proto = "LMTP"
+	}
+	c.deadline()
+	c.reply(220, "%s %s blogwatcher ready", c.srv.hostname(), proto)
+
+	for {
+		c.deadline()
+		c.limit.N = maxLineBytes
+		line, err := c.text.ReadLine()
+		if err != nil {
+			if c.limit.N <= 0 {
+				c.reply(500, "Line too long")
+			}
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		if !c.command(strings.ToUpper(verb), strings.TrimSpace(arg)) {
+			return
+		}
+	}
+}
+
+func (c *conn) deadline() {
+	_ = c.nc.SetDeadline(time.Now().Add(c.srv.timeout()))
+}
+
+// command runs one command and reports whether the session continues.
+func (c *conn) command(verb, arg string) bool {
+	switch verb {
+	case "HELO", "EHLO", "LHLO":
+		c.hello(verb, arg)
+	case "MAIL":
+		c.mail(arg)
+	case "RCPT":
+		c.rcpt(arg)
+	case "DATA":
+		c.data()
+	case "RSET":
+		c.reset()
+		c.reply(250, "OK")
+	case "NOOP":
+		c.reply(250, "OK")
+	case "VRFY":
+		c.reply(252, "Cannot VRFY user, but will accept message")
+	case "STARTTLS":
+		return c.startTLS()
+	case "QUIT":
+		c.reply(221, "Bye")
+		return false
+	default:
+		c.reply(500, "Command not recognized")
+	}
+	return true
+}
+
+func (c *conn) hello(verb, arg string) {
+	if (verb == "LHLO") != c.srv.LMTP {
+		c.reply(500, "Command not recognized")
+		return
+	}
+	if arg == "" {
+		c.reply(501, "Domain name required")
+		return
+	}
+	c.helo = arg
+	c.reset()
+	if verb == "HELO" {
+		c.reply(250, "%s", c.srv.hostname())
+		return
+	}
+	lines := []string{c.srv.hostname(), "PIPELINING", "8BITMIME", "SIZE " + strconv.FormatInt(c.srv.maxMessageBytes(), 10)}
+	if c.srv.TLSConfig != nil && !c.tls {
+		lines = append(lines, "STARTTLS")
+	}
+	for i, l := range lines {
+		sep := "-"
+		if i == len(lines)-1 {
+			sep = " "
+		}
+		_ = c.text.PrintfLine("250%s%s", sep, l)
+	}
+}
+
+func (c *conn) mail(arg string) {
+	if c.helo == "" {
+		c.reply(503, "Send HELO first")
+		return
+	}
+	if c.from != nil {
+		c.reply(503, "Sender already given")
+		return
+	}
+	addr, params, ok := parsePath(arg, "FROM:")
+	if !ok {
+		c.reply(501, "Syntax: MAIL FROM:<address>")
+		return
+	}
+	for _, p := range params {
+		key, value, _ := strings.Cut(p, "=")
+		if strings.EqualFold(key, "SIZE") {
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil && n > c.srv.maxMessageBytes() {
+				c.reply(552, "Message exceeds maximum size of %d bytes", c.srv.maxMessageBytes())
+				return
+			}
+		}
+	}
+	c.from = &addr
+	c.reply(250, "OK")
+}
+
+func (c *conn) rcpt(arg string) {
+	if c.from == nil {
+		c.reply(503, "Send MAIL first")
+		return
+	}
+	addr, _, ok := parsePath(arg, "TO:")
+	if !ok || addr == "" {
+		c.reply(501, "Syntax: RCPT TO:<address>")
+		return
+	}
+	if len(c.to) >= maxRecipients {
+		c.reply(452, "Too many recipients")
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.srv.timeout())
+	defer cancel()
+	if err := c.srv.Backend.Recipient(ctx, addr); err != nil {
+		c.replyError(err, "rcpt", "to", addr)
+		return
+	}
+	c.to = append(c.to, addr)
+	c.reply(250, "OK")
+}
+
+func (c *conn) data() {
+	if len(c.to) == 0 {
+		c.reply(503, "Send RCPT first")
+		return
+	}
+	c.busy.Store(true)
+	defer c.busy.Store(false)
+	c.reply(354, "End data with <CR><LF>.<CR><LF>")
+
+	maxBytes := c.srv.maxMessageBytes()
+	// Allow for dot-stuffing and line endings, then stop reading outright
+	c.limit.N = 2*maxBytes + maxLineBytes
+	body := c.text.DotReader()
+	data, err := io.ReadAll(io.LimitReader(body, maxBytes+1))
+	if err == nil && int64(len(data)) > maxBytes {
+		_, err = io.Copy(io.Discard, body)
+		if err == nil {
+			c.replyAll(552, "Message exceeds maximum size of %d bytes", maxBytes)
+			c.reset()
+			return
+		}
+	}
+	if err != nil {
+		c.logger.Warn("read message", "err", err)
+		return
+	}
+
+	env := Envelope{RemoteAddr: c.nc.RemoteAddr().String(), Helo: c.helo, From: *c.from, To: c.to}
+	c.reset()
+	ctx, cancel := context.WithTimeout(context.Background(), c.srv.timeout())
+	defer cancel()
+	if err := c.srv.Backend.Deliver(ctx, env, data); err != nil {
+		var smtpErr *Error
+		if !errors.As(err, &smtpErr) {
+			smtpErr = &Error{Code: 451, Message: "Temporary failure, try again later"}
+		}
+		c.logger.Warn("message not delivered", "from", env.From, "to", env.To, "bytes", len(data), "err", err)
+		c.replyN(len(env.To), smtpErr.Code, "%s", smtpErr.Message)
+		return
+	}
+	c.logger.Info("message received", "from", env.From, "to", env.To, "bytes", len(data))
+	c.replyN(len(env.To), 250, "OK")
+}
+
+// replyAll sends one DATA reply per recipient under LMTP.
+func (c *conn) replyAll(code int, format string, args ...interface{}) {
+	c.replyN(len(c.to), code, format, args...)
+}
+
+// replyN answers DATA: once for SMTP, once per recipient for LMTP.
+func (c *conn) replyN(recipients, code int, format string, args ...interface{}) {
+	n := 1
+	if c.srv.LMTP {
+		n = recipients
+	}
+	for i := 0; i < n; i++ {
+		c.reply(code, format, args...)
+	}
+}
+
+// replyError sends the reply a Backend chose, or a temporary failure.
+func (c *conn) replyError(err error, op string, attrs ...interface{}) {
+	var smtpErr *Error
+	if errors.As(err, &smtpErr) {
+		c.reply(smtpErr.Code, "%s", smtpErr.Message)
+		return
+	}
+	c.logger.Error(op+" failed", append(attrs, "err", err)...)
+	c.reply(451, "Temporary failure, try again later")
+}
+
+func (c *conn) startTLS() bool {
+	if c.srv.TLSConfig == nil || c.tls {
+		c.reply(502, "STARTTLS not available")
+		return true
+	}
+	c.reply(220, "Ready to start TLS")
+	tc := tls.Server(c.nc, c.srv.TLSConfig)
+	c.deadline()
+	if err := tc.Handshake(); err != nil {
+		c.logger.Warn("TLS handshake failed", "err", err)
+		return false
+	}
+	// RFC 3207: forget everything learned before the handshake
+	c.nc, c.tls, c.helo = tc, true, ""
+	c.reset()
+	c.attach()
+	return true
+}
+
+// parsePath parses "FROM:<addr> PARAMS" or "TO:<addr> PARAMS". Spaces after
+// the colon and a missing pair of angle brackets are tolerated.
+func parsePath(arg, prefix string) (addr string, params []string, ok bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", nil, false
+	}
+	rest := strings.TrimSpace(arg[len(prefix):])
+	if strings.HasPrefix(rest, "<") {
+		end := strings.IndexByte(rest, '>')
+		if end < 0 {
+			return "", nil, false
+		}
+		addr, rest = rest[1:end], rest[end+1:]
+	} else {
+		addr, rest, _ = strings.Cut(rest, " ")
+	}
+	// Drop a source route (<@a,@b:user@host>)
+	if i := strings.IndexByte(addr, ':'); i >= 0 && strings.HasPrefix(addr, "@") {
+		addr = addr[i+1:]
+	}
+	return addr, strings.Fields(rest), true
+}
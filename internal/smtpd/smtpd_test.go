@@ -0,0 +1,291 @@
+// ABOUTME: Tests for the SMTP/LMTP server using net/smtp and raw protocol sessions.
+// ABOUTME: Covers delivery, recipient refusal, size limits, LMTP per-recipient replies, STARTTLS and shutdown.
+package smtpd
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder is a Backend that accepts mail for @example.com and keeps it.
+type recorder struct {
+	mu       sync.Mutex
+	messages []string
+	envs     []Envelope
+	fail     error
+}
+
+func (r *recorder) Recipient(_ context.Context, addr string) error {
+	if !strings.HasSuffix(addr, "@example.com") {
+		return &Error{Code: 550, Message: "No such user"}
+	}
+	return nil
+}
+
+func (r *recorder) Deliver(_ context.Context, env Envelope, data []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fail != nil {
+		return r.fail
+	}
+	r.messages = append(r.messages, string(data))
+	r.envs = append(r.envs, env)
+	return nil
+}
+
+func startServer(t *testing.T, srv *Server) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(ln) }()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			t.Errorf("Shutdown: %v", err)
+		}
+		if err := <-done; !errors.Is(err, ErrServerClosed) {
+			t.Errorf("Serve = %v, want ErrServerClosed", err)
+		}
+	})
+	return ln.Addr().String()
+}
+
+func TestSMTPDelivery(t *testing.T) {
+	backend := &recorder{}
+	addr := startServer(t, &Server{Backend: backend, Hostname: "mx.test", MaxMessageBytes: 1024})
+
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+	if err := c.Hello("client.test"); err != nil {
+		t.Fatalf("EHLO: %v", err)
+	}
+	if ok, size := c.Extension("SIZE"); !ok || size != "1024" {
+		t.Errorf("SIZE extension = %v %q", ok, size)
+	}
+	if err := c.Mail("sender@news.test"); err != nil {
+		t.Fatalf("MAIL: %v", err)
+	}
+	if err := c.Rcpt("stranger@elsewhere.test"); err == nil || !strings.HasPrefix(err.Error(), "550") {
+		t.Errorf("RCPT to unknown address = %v, want 550", err)
+	}
+	if err := c.Rcpt("inbox@example.com"); err != nil {
+		t.Fatalf("RCPT: %v", err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		t.Fatalf("DATA: %v", err)
+	}
+	body := "Subject: Hi\r\n\r\n.leading dot\r\nbody\r\n"
+	if _, err := w.Write([]byte(body)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("end DATA: %v", err)
+	}
+
+	want := "Subject: Hi\n\n.leading dot\nbody\n"
+	if len(backend.messages) != 1 || backend.messages[0] != want {
+		t.Fatalf("messages = %q, want %q", backend.messages, want)
+	}
+	env := backend.envs[0]
+	if env.From != "sender@news.test" || len(env.To) != 1 || env.To[0] != "inbox@example.com" || env.Helo != "client.test" {
+		t.Errorf("envelope = %+v", env)
+	}
+
+	// An oversized message is refused but the session carries on
+	if err := c.Mail("sender@news.test"); err != nil {
+		t.Fatalf("MAIL: %v", err)
+	}
+	if err := c.Rcpt("inbox@example.com"); err != nil {
+		t.Fatalf("RCPT: %v", err)
+	}
+	w, _ = c.Data()
+	_, _ = w.Write([]byte(strings.Repeat("x", 2000) + "\r\n"))
+	if err := w.Close(); err == nil || !strings.HasPrefix(err.Error(), "552") {
+		t.Errorf("oversized DATA = %v, want 552", err)
+	}
+	if err := c.Quit(); err != nil {
+		t.Errorf("QUIT: %v", err)
+	}
+	if len(backend.messages) != 1 {
+		t.Errorf("oversized message was delivered")
+	}
+}
+
+func TestSMTPDeliveryFailureIsTemporary(t *testing.T) {
+	backend := &recorder{fail: errors.New("database is locked")}
+	addr := startServer(t, &Server{Backend: backend})
+
+	err := smtp.SendMail(addr, nil, "sender@news.test", []string{"inbox@example.com"}, []byte("Subject: x\r\n\r\nx\r\n"))
+	if err == nil || !strings.HasPrefix(err.Error(), "451") {
+		t.Errorf("SendMail = %v, want 451", err)
+	}
+
+	backend.fail = &Error{Code: 554, Message: "Message could not be parsed"}
+	err = smtp.SendMail(addr, nil, "sender@news.test", []string{"inbox@example.com"}, []byte("x"))
+	if err == nil || !strings.HasPrefix(err.Error(), "554") {
+		t.Errorf("SendMail = %v, want the backend's 554", err)
+	}
+}
+
+func TestLMTPRepliesPerRecipient(t *testing.T) {
+	backend := &recorder{}
+	addr := startServer(t, &Server{Backend: backend, LMTP: true})
+
+	conn, err := textproto.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	expect := func(code int) {
+		t.Helper()
+		if _, _, err := conn.ReadResponse(code); err != nil {
+			t.Fatalf("want %d: %v", code, err)
+		}
+	}
+	send := func(line string, code int) {
+		t.Helper()
+		if err := conn.PrintfLine("%s", line); err != nil {
+			t.Fatalf("send %q: %v", line, err)
+		}
+		expect(code)
+	}
+
+	expect(220)
+	send("EHLO client.test", 500) // LMTP only speaks LHLO
+	send("LHLO client.test", 250)
+	send("MAIL FROM:<>", 250)
+	send("RCPT TO:<a@example.com>", 250)
+	send("RCPT TO:<b@example.com>", 250)
+	send("DATA", 354)
+	if err := conn.PrintfLine("Subject: bounce\r\n\r\nhello\r\n."); err != nil {
+		t.Fatalf("send body: %v", err)
+	}
+	expect(250)
+	expect(250)
+	send("QUIT", 221)
+
+	if len(backend.envs) != 1 || backend.envs[0].From != "" || len(backend.envs[0].To) != 2 {
+		t.Errorf("envelopes = %+v, want one null-sender message for two recipients", backend.envs)
+	}
+}
+
+func TestSTARTTLS(t *testing.T) {
+	backend := &recorder{}
+	addr := startServer(t, &Server{Backend: backend, Hostname: "mx.test", TLSConfig: &tls.Config{Certificates: []tls.Certificate{selfSigned(t)}}})
+
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); !ok {
+		t.Fatal("STARTTLS not advertised")
+	}
+	if err := c.StartTLS(&tls.Config{InsecureSkipVerify: true}); err != nil {
+		t.Fatalf("STARTTLS: %v", err)
+	}
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		t.Error("STARTTLS still advertised inside TLS")
+	}
+	if err := c.Mail("sender@news.test"); err != nil {
+		t.Fatalf("MAIL over TLS: %v", err)
+	}
+	if err := c.Rcpt("inbox@example.com"); err != nil {
+		t.Fatalf("RCPT over TLS: %v", err)
+	}
+}
+
+func TestShutdownClosesIdleSessions(t *testing.T) {
+	srv := &Server{Backend: &recorder{}}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(ln) }()
+
+	conn, err := textproto.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	if _, _, err := conn.ReadResponse(220); err != nil {
+		t.Fatalf("greeting: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if err := <-done; !errors.Is(err, ErrServerClosed) {
+		t.Errorf("Serve = %v, want ErrServerClosed", err)
+	}
+	if _, err := conn.ReadLine(); err == nil {
+		t.Error("idle session still open after Shutdown")
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		arg, prefix, addr string
+		params            int
+		ok                bool
+	}{
+		{"FROM:<a@b.test> SIZE=10 BODY=8BITMIME", "FROM:", "a@b.test", 2, true},
+		{"from: <a@b.test>", "FROM:", "a@b.test", 0, true},
+		{"FROM:<>", "FROM:", "", 0, true},
+		{"TO:<@relay.test:c@d.test>", "TO:", "c@d.test", 0, true},
+		{"TO:c@d.test", "TO:", "c@d.test", 0, true},
+		{"TO:<c@d.test", "TO:", "", 0, false},
+		{"<c@d.test>", "TO:", "", 0, false},
+	}
+	for _, tt := range tests {
+		addr, params, ok := parsePath(tt.arg, tt.prefix)
+		if addr != tt.addr || len(params) != tt.params || ok != tt.ok {
+			t.Errorf("parsePath(%q) = %q, %v, %v", tt.arg, addr, params, ok)
+		}
+	}
+}
+
+func selfSigned(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "mx.test"},
+		DNSNames:     []string{"mx.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
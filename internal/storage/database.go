@@ -3,11 +3,14 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -26,12 +29,35 @@ func DefaultDBPath() (string, error) {
 	return filepath.Join(home, ".blogwatcher", "blogwatcher.db"), nil
 }
 
+// Database is a SQLite database in WAL mode. Writes, transactions and schema
+// changes go through conn, a single connection, because SQLite allows one
+// writer at a time; queries go through reader, a pool of read-only
+// connections that WAL lets run alongside the writer.
 type Database struct {
-	path string
-	conn *sql.DB
+	path   string
+	conn   timedDB
+	reader timedDB
 }
 
+// readConns is the size of the read-only connection pool.
+const readConns = 4
+
+// OpenOptions controls how OpenDatabase prepares the schema.
+type OpenOptions struct {
+	// SkipMigrations opens the database as-is, for inspecting migration status.
+	SkipMigrations bool
+	// NoBackup skips the copy of the database taken before migrating.
+	NoBackup bool
+}
+
+// OpenDatabase opens (creating if needed) the database at path, or the default
+// location when path is empty, and applies pending schema migrations.
 func OpenDatabase(path string) (*Database, error) {
+	return OpenDatabaseWithOptions(path, OpenOptions{})
+}
+
+// OpenDatabaseWithOptions is OpenDatabase with control over migrations.
+func OpenDatabaseWithOptions(path string, opts OpenOptions) (*Database, error) {
 	if path == "" {
 		var err error
 		path, err = DefaultDBPath()
@@ -45,7 +71,11 @@ func OpenDatabase(path string) (*Database, error) {
 		return nil, fmt.Errorf("failed to create database directory: %w", err)
 	}
 
-	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)", path)
+	// WAL lets readers run while a write is in progress. Transactions take
+	// the write lock up front so one never fails to upgrade halfway through
+	// when another process (such as a CLI sync) is writing.
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)"+
+		"&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)&_txlock=immediate", path)
 	conn, err := sql.Open("sqlite", dsn)
 	if err != nil {
 		return nil, err
@@ -54,140 +84,30 @@ func OpenDatabase(path string) (*Database, error) {
 	// Set SQLite single-writer constraint
 	conn.SetMaxOpenConns(1)
 
-	db := &Database{path: path, conn: conn}
-
-	// Verify connection works
+	// Verify connection works; this also creates the file the readers open
 	if err := conn.Ping(); err != nil {
 		_ = conn.Close()
 		return nil, err
 	}
 
-	// Initialize schema (idempotent - safe for existing databases)
-	if err := db.initSchema(); err != nil {
-		_ = conn.Close()
-		return nil, fmt.Errorf("schema initialization failed: %w", err)
-	}
-
-	// Run migrations for new columns (idempotent)
-	if err := db.ensureMigrations(); err != nil {
+	reader, err := sql.Open("sqlite", fmt.Sprintf("file:%s?mode=ro&_pragma=busy_timeout(5000)", path))
+	if err != nil {
 		_ = conn.Close()
-		return nil, fmt.Errorf("migration failed: %w", err)
-	}
-
-	return db, nil
-}
-
-// initSchema creates the base database schema if it doesn't exist.
-// This is idempotent - safe to call on existing databases.
-// Schema matches the blogwatcher CLI for full compatibility.
-func (db *Database) initSchema() error {
-	schema := `
-		CREATE TABLE IF NOT EXISTS blogs (
-			id INTEGER PRIMARY KEY,
-			name TEXT NOT NULL,
-			url TEXT NOT NULL UNIQUE,
-			feed_url TEXT,
-			scrape_selector TEXT,
-			last_scanned TIMESTAMP
-		);
-		CREATE TABLE IF NOT EXISTS articles (
-			id INTEGER PRIMARY KEY,
-			blog_id INTEGER,
-			title TEXT NOT NULL,
-			url TEXT NOT NULL UNIQUE,
-			published_date TIMESTAMP,
-			discovered_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			is_read BOOLEAN DEFAULT FALSE,
-			thumbnail_url TEXT,
-			FOREIGN KEY (blog_id) REFERENCES blogs(id)
-		);
-	`
-	_, err := db.conn.Exec(schema)
-	return err
-}
-
-// ensureMigrations runs idempotent schema migrations for new columns and tables.
-// Checks column/table existence before adding.
-func (db *Database) ensureMigrations() error {
-	// Add thumbnail_url column if it doesn't exist
-	if !db.columnExists("articles", "thumbnail_url") {
-		if _, err := db.conn.Exec(`ALTER TABLE articles ADD COLUMN thumbnail_url TEXT`); err != nil {
-			return err
-		}
-	}
-
-	// Migrate articles.blog_id to nullable (for blog deletion without cascade)
-	// SQLite does not support ALTER COLUMN, so we must recreate the table
-	if db.columnIsNotNull("articles", "blog_id") {
-		if err := db.migrateBlogIDToNullable(); err != nil {
-			return fmt.Errorf("failed to migrate articles.blog_id to nullable: %w", err)
-		}
+		return nil, err
 	}
+	reader.SetMaxOpenConns(readConns)
+	reader.SetMaxIdleConns(readConns)
 
-	// Add type column to blogs for newsletter support
-	if !db.columnExists("blogs", "type") {
-		if _, err := db.conn.Exec(`ALTER TABLE blogs ADD COLUMN type TEXT NOT NULL DEFAULT 'rss'`); err != nil {
-			return err
-		}
-	}
+	db := &Database{path: path, conn: timedDB{conn}, reader: timedDB{reader}}
 
-	// Add content column to articles for storing newsletter email HTML bodies
-	if !db.columnExists("articles", "content") {
-		if _, err := db.conn.Exec(`ALTER TABLE articles ADD COLUMN content TEXT`); err != nil {
-			return err
+	if !opts.SkipMigrations {
+		if _, err := db.Migrate(!opts.NoBackup); err != nil {
+			_ = db.Close()
+			return nil, fmt.Errorf("migration failed: %w", err)
 		}
 	}
 
-	// Add settings table for persisting app-level config (webhook secret, inbox address)
-	if !db.tableExists("settings") {
-		if _, err := db.conn.Exec(`CREATE TABLE IF NOT EXISTS settings (
-			key   TEXT PRIMARY KEY,
-			value TEXT NOT NULL
-		)`); err != nil {
-			return err
-		}
-	}
-
-	// Add FTS5 virtual table and sync triggers for title search
-	if !db.tableExists("articles_fts") {
-		// Create FTS5 virtual table with external content pattern
-		if _, err := db.conn.Exec(`CREATE VIRTUAL TABLE articles_fts USING fts5(
-			title,
-			content='articles',
-			content_rowid='id'
-		)`); err != nil {
-			return fmt.Errorf("failed to create articles_fts: %w", err)
-		}
-
-		// Create INSERT trigger
-		if _, err := db.conn.Exec(`CREATE TRIGGER articles_ai AFTER INSERT ON articles BEGIN
-			INSERT INTO articles_fts(rowid, title) VALUES (new.id, new.title);
-		END`); err != nil {
-			return fmt.Errorf("failed to create articles_ai trigger: %w", err)
-		}
-
-		// Create UPDATE trigger (delete old entry first, then insert new)
-		if _, err := db.conn.Exec(`CREATE TRIGGER articles_au AFTER UPDATE ON articles BEGIN
-			INSERT INTO articles_fts(articles_fts, rowid, title) VALUES('delete', old.id, old.title);
-			INSERT INTO articles_fts(rowid, title) VALUES (new.id, new.title);
-		END`); err != nil {
-			return fmt.Errorf("failed to create articles_au trigger: %w", err)
-		}
-
-		// Create DELETE trigger
-		if _, err := db.conn.Exec(`CREATE TRIGGER articles_ad AFTER DELETE ON articles BEGIN
-			INSERT INTO articles_fts(articles_fts, rowid, title) VALUES('delete', old.id, old.title);
-		END`); err != nil {
-			return fmt.Errorf("failed to create articles_ad trigger: %w", err)
-		}
-
-		// Populate FTS5 from existing articles
-		if _, err := db.conn.Exec(`INSERT INTO articles_fts(rowid, title) SELECT id, title FROM articles`); err != nil {
-			return fmt.Errorf("failed to populate articles_fts: %w", err)
-		}
-	}
-
-	return nil
+	return db, nil
 }
 
 // columnExists checks if a column exists in a table using PRAGMA table_info.
@@ -236,90 +156,96 @@ func (db *Database) columnIsNotNull(table, column string) bool {
 	return false
 }
 
-// migrateBlogIDToNullable recreates articles table with nullable blog_id column.
-// SQLite does not support ALTER COLUMN, so we must recreate the table.
-func (db *Database) migrateBlogIDToNullable() error {
-	tx, err := db.conn.Begin()
-	if err != nil {
+// tableExists checks if a table exists using sqlite_master.
+func (db *Database) tableExists(tableName string) bool {
+	var name string
+	err := db.conn.QueryRow("SELECT name FROM sqlite_master WHERE type='table' AND name=?", tableName).Scan(&name)
+	return err == nil && name == tableName
+}
+
+func (db *Database) Path() string {
+	return db.path
+}
+
+// Ping verifies both the writer and the reader pool are usable.
+func (db *Database) Ping(ctx context.Context) error {
+	if err := db.conn.PingContext(ctx); err != nil {
 		return err
 	}
-	defer func() {
-		if err != nil {
-			_ = tx.Rollback()
-		}
-	}()
-
-	// Create new table with nullable blog_id (matching existing schema order)
-	_, err = tx.Exec(`CREATE TABLE articles_new (
-		id INTEGER PRIMARY KEY,
-		blog_id INTEGER,
-		title TEXT NOT NULL,
-		url TEXT NOT NULL UNIQUE,
-		published_date TIMESTAMP,
-		discovered_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		is_read BOOLEAN DEFAULT FALSE,
-		thumbnail_url TEXT,
-		FOREIGN KEY (blog_id) REFERENCES blogs(id)
-	)`)
-	if err != nil {
-		return fmt.Errorf("create articles_new: %w", err)
-	}
+	return db.reader.PingContext(ctx)
+}
 
-	// Copy data from old table using explicit column list
-	_, err = tx.Exec(`INSERT INTO articles_new
-		(id, blog_id, title, url, published_date, discovered_date, is_read, thumbnail_url)
-		SELECT id, blog_id, title, url, published_date, discovered_date, is_read, thumbnail_url
-		FROM articles`)
+// Size returns the on-disk size of the database file plus its write-ahead
+// log, if any.
+func (db *Database) Size() (int64, error) {
+	info, err := os.Stat(db.path)
 	if err != nil {
-		return fmt.Errorf("copy data: %w", err)
+		return 0, err
 	}
+	size := info.Size()
+	if wal, err := os.Stat(db.path + "-wal"); err == nil {
+		size += wal.Size()
+	}
+	return size, nil
+}
 
-	// Drop FTS5 triggers (they reference the old table)
-	_, _ = tx.Exec(`DROP TRIGGER IF EXISTS articles_ai`)
-	_, _ = tx.Exec(`DROP TRIGGER IF EXISTS articles_au`)
-	_, _ = tx.Exec(`DROP TRIGGER IF EXISTS articles_ad`)
-
-	// Drop FTS5 table (will be recreated by ensureMigrations)
-	_, _ = tx.Exec(`DROP TABLE IF EXISTS articles_fts`)
+// CheckIntegrity runs SQLite's integrity check and confirms the blogs and
+// articles tables exist, to vet a file before it replaces the database.
+func (db *Database) CheckIntegrity() error {
+	return checkIntegrity(db.conn.DB)
+}
 
-	// Drop old table and rename new table
-	_, err = tx.Exec(`DROP TABLE articles`)
-	if err != nil {
-		return fmt.Errorf("drop old table: %w", err)
+// CheckFile runs CheckIntegrity's checks on the database file at path
+// without writing to it: the file is opened read-only with no pragmas and
+// no directory is created, so a backup on read-only media can be vetted.
+func CheckFile(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
 	}
-
-	_, err = tx.Exec(`ALTER TABLE articles_new RENAME TO articles`)
+	conn, err := sql.Open("sqlite", fmt.Sprintf("file:%s?mode=ro", path))
 	if err != nil {
-		return fmt.Errorf("rename table: %w", err)
+		return err
 	}
+	defer conn.Close()
+	return checkIntegrity(conn)
+}
 
-	if err = tx.Commit(); err != nil {
-		return fmt.Errorf("commit: %w", err)
+func checkIntegrity(conn *sql.DB) error {
+	var result string
+	if err := conn.QueryRow(`PRAGMA integrity_check`).Scan(&result); err != nil {
+		return err
+	}
+	if result != "ok" {
+		return fmt.Errorf("integrity check failed: %s", result)
+	}
+	var tables int
+	if err := conn.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name IN ('blogs', 'articles')`).Scan(&tables); err != nil {
+		return err
+	}
+	if tables != 2 {
+		return errors.New("not a BlogWatcher database")
 	}
-
 	return nil
 }
 
-// tableExists checks if a table exists using sqlite_master.
-func (db *Database) tableExists(tableName string) bool {
-	var name string
-	err := db.conn.QueryRow("SELECT name FROM sqlite_master WHERE type='table' AND name=?", tableName).Scan(&name)
-	return err == nil && name == tableName
-}
-
-func (db *Database) Path() string {
-	return db.path
+// CountBlogs returns the number of tracked blogs, newsletters included.
+func (db *Database) CountBlogs() (int, error) {
+	var n int
+	err := db.reader.QueryRow(`SELECT COUNT(*) FROM blogs`).Scan(&n)
+	return n, err
 }
 
+// Close closes the reader pool, then the writer. Closing the writer last
+// checkpoints the write-ahead log back into the database file.
 func (db *Database) Close() error {
-	if db.conn == nil {
+	if db.conn.DB == nil {
 		return nil
 	}
-	return db.conn.Close()
+	return errors.Join(db.reader.Close(), db.conn.Close())
 }
 
 func (db *Database) ListBlogs() ([]model.Blog, error) {
-	rows, err := db.conn.Query(`SELECT id, name, url, feed_url, scrape_selector, last_scanned, type FROM blogs ORDER BY name`)
+	rows, err := db.reader.Query(`SELECT id, name, url, feed_url, scrape_selector, last_scanned, type FROM blogs ORDER BY name`)
 	if err != nil {
 		return nil, err
 	}
@@ -361,7 +287,7 @@ func (db *Database) ListBlogsWithCounts() ([]BlogWithCount, error) {
 	GROUP BY b.id, b.name, b.url, b.feed_url, b.scrape_selector, b.last_scanned, b.type
 	ORDER BY b.name`
 
-	rows, err := db.conn.Query(query)
+	rows, err := db.reader.Query(query)
 	if err != nil {
 		return nil, err
 	}
@@ -404,8 +330,35 @@ func (db *Database) ListBlogsWithCounts() ([]BlogWithCount, error) {
 	return blogs, rows.Err()
 }
 
+// ArticleCounts returns the number of stored articles and how many are unread.
+func (db *Database) ArticleCounts() (total, unread int, err error) {
+	err = db.reader.QueryRow(`SELECT COUNT(*), COALESCE(SUM(CASE WHEN is_read = 0 THEN 1 ELSE 0 END), 0) FROM articles`).Scan(&total, &unread)
+	return total, unread, err
+}
+
+// UnreadCountsByBlog returns the number of unread articles per blog ID.
+// Blogs with no unread articles are absent from the map.
+func (db *Database) UnreadCountsByBlog() (map[int64]int, error) {
+	rows, err := db.reader.Query(`SELECT blog_id, COUNT(*) FROM articles WHERE is_read = 0 AND blog_id IS NOT NULL GROUP BY blog_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[int64]int)
+	for rows.Next() {
+		var blogID int64
+		var count int
+		if err := rows.Scan(&blogID, &count); err != nil {
+			return nil, err
+		}
+		counts[blogID] = count
+	}
+	return counts, rows.Err()
+}
+
 func (db *Database) ListArticles(unreadOnly bool, blogID *int64) ([]model.Article, error) {
-	query := `SELECT id, blog_id, title, url, thumbnail_url, published_date, discovered_date, is_read, content FROM articles WHERE 1=1`
+	query := `SELECT id, blog_id, title, url, thumbnail_url, published_date, discovered_date, is_read, content, is_starred FROM articles WHERE 1=1`
 	var args []interface{}
 	if unreadOnly {
 		query += " AND is_read = 0"
@@ -414,9 +367,9 @@ func (db *Database) ListArticles(unreadOnly bool, blogID *int64) ([]model.Articl
 		query += " AND blog_id = ?"
 		args = append(args, *blogID)
 	}
-	query += " ORDER BY COALESCE(published_date, discovered_date) DESC"
+	query += " ORDER BY sort_date DESC, id DESC"
 
-	rows, err := db.conn.Query(query, args...)
+	rows, err := db.reader.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -439,16 +392,16 @@ func (db *Database) ListArticles(unreadOnly bool, blogID *int64) ([]model.Articl
 // isRead=true returns read articles, isRead=false returns unread articles.
 // blogID filters to a specific blog if provided.
 func (db *Database) ListArticlesByReadStatus(isRead bool, blogID *int64) ([]model.Article, error) {
-	query := `SELECT id, blog_id, title, url, thumbnail_url, published_date, discovered_date, is_read, content FROM articles WHERE is_read = ?`
+	query := `SELECT id, blog_id, title, url, thumbnail_url, published_date, discovered_date, is_read, content, is_starred FROM articles WHERE is_read = ?`
 	args := []interface{}{isRead}
 
 	if blogID != nil {
 		query += " AND blog_id = ?"
 		args = append(args, *blogID)
 	}
-	query += " ORDER BY COALESCE(published_date, discovered_date) DESC"
+	query += " ORDER BY sort_date DESC, id DESC"
 
-	rows, err := db.conn.Query(query, args...)
+	rows, err := db.reader.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -471,7 +424,7 @@ func (db *Database) ListArticlesByReadStatus(isRead bool, blogID *int64) ([]mode
 // Uses INNER JOIN to fetch blog info alongside article data.
 // isRead filters by read status, blogID optionally filters to a specific blog.
 func (db *Database) ListArticlesWithBlog(isRead bool, blogID *int64) ([]model.ArticleWithBlog, error) {
-	query := `SELECT a.id, a.blog_id, a.title, a.url, a.thumbnail_url, a.published_date, a.discovered_date, a.is_read, b.name, b.url, a.content
+	query := `SELECT a.id, a.blog_id, a.title, a.url, a.thumbnail_url, a.published_date, a.discovered_date, a.is_read, b.name, b.url, a.content, a.is_starred
 		FROM articles a
 		INNER JOIN blogs b ON a.blog_id = b.id
 		WHERE a.is_read = ?`
@@ -481,9 +434,9 @@ func (db *Database) ListArticlesWithBlog(isRead bool, blogID *int64) ([]model.Ar
 		query += " AND a.blog_id = ?"
 		args = append(args, *blogID)
 	}
-	query += " ORDER BY COALESCE(a.published_date, a.discovered_date) DESC"
+	query += " ORDER BY a.sort_date DESC, a.id DESC"
 
-	rows, err := db.conn.Query(query, args...)
+	rows, err := db.reader.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -502,26 +455,44 @@ func (db *Database) ListArticlesWithBlog(isRead bool, blogID *int64) ([]model.Ar
 	return articles, rows.Err()
 }
 
-// SearchArticles returns articles matching the given search options with total count.
+// SearchArticles returns one page of articles matching the given search
+// options together with the total number of matches.
 // Uses FTS5 for title search when SearchQuery is non-empty.
 // Returns (articles, totalCount, error).
 func (db *Database) SearchArticles(opts model.SearchOptions) ([]model.ArticleWithBlog, int, error) {
+	page, err := db.SearchArticlesPage(opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	return page.Articles, page.Total, nil
+}
+
+// ErrInvalidCursor is returned for an After cursor this package did not issue.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// SearchArticlesPage returns articles matching opts ordered by sort date then
+// ID, newest first. Pages continue from opts.After, a cursor naming the last
+// article already shown, so articles added or removed meanwhile don't shift
+// later pages the way OFFSET does. The total is only counted for the first
+// page.
+func (db *Database) SearchArticlesPage(opts model.SearchOptions) (model.ArticlePage, error) {
+	page := model.ArticlePage{Total: -1}
+
 	// Build base query - conditionally add FTS5 JOIN only when searching
-	var query strings.Builder
-	query.WriteString(`SELECT a.id, a.blog_id, a.title, a.url, a.thumbnail_url, a.published_date, a.discovered_date, a.is_read, b.name, b.url, a.content, COUNT(*) OVER() as total_count
-		FROM articles a`)
+	var from strings.Builder
+	from.WriteString(` FROM articles a`)
 
 	var conditions []string
 	var args []interface{}
 
 	// Add FTS5 JOIN only if search query provided
 	if opts.SearchQuery != "" {
-		query.WriteString(` JOIN articles_fts ON a.id = articles_fts.rowid`)
+		from.WriteString(` JOIN articles_fts ON a.id = articles_fts.rowid`)
 		conditions = append(conditions, "articles_fts MATCH ?")
 		args = append(args, opts.SearchQuery)
 	}
 
-	query.WriteString(` INNER JOIN blogs b ON a.blog_id = b.id`)
+	from.WriteString(` INNER JOIN blogs b ON a.blog_id = b.id`)
 
 	// Add status condition only if IsRead is not nil
 	if opts.IsRead != nil {
@@ -529,66 +500,139 @@ func (db *Database) SearchArticles(opts model.SearchOptions) ([]model.ArticleWit
 		args = append(args, *opts.IsRead)
 	}
 
+	if opts.Starred != nil {
+		conditions = append(conditions, "a.is_starred = ?")
+		args = append(args, *opts.Starred)
+	}
+
 	// Add blog filter if provided
 	if opts.BlogID != nil {
 		conditions = append(conditions, "a.blog_id = ?")
 		args = append(args, *opts.BlogID)
 	}
 
-	// Add date range using COALESCE for published_date fallback to discovered_date
+	// Date range on sort_date (published_date falling back to discovered_date);
+	// undated articles have an empty sort_date and match neither bound
 	if opts.DateFrom != nil {
-		conditions = append(conditions, "COALESCE(a.published_date, a.discovered_date) >= ?")
+		conditions = append(conditions, "a.sort_date >= ?")
 		args = append(args, opts.DateFrom.Format("2006-01-02"))
 	}
 	if opts.DateTo != nil {
 		// Include entire end date by comparing to next day
 		endDate := opts.DateTo.AddDate(0, 0, 1)
-		conditions = append(conditions, "COALESCE(a.published_date, a.discovered_date) < ?")
+		conditions = append(conditions, "a.sort_date > '' AND a.sort_date < ?")
 		args = append(args, endDate.Format("2006-01-02"))
 	}
 
-	// Build WHERE clause
 	if len(conditions) > 0 {
-		query.WriteString(" WHERE ")
-		query.WriteString(strings.Join(conditions, " AND "))
+		from.WriteString(" WHERE ")
+		from.WriteString(strings.Join(conditions, " AND "))
 	}
 
-	query.WriteString(" ORDER BY COALESCE(a.published_date, a.discovered_date) DESC")
+	if opts.After == "" {
+		if err := db.reader.QueryRow(`SELECT COUNT(*)`+from.String(), args...).Scan(&page.Total); err != nil {
+			return page, err
+		}
+	} else {
+		sortDate, id, err := decodeCursor(opts.After)
+		if err != nil {
+			return page, err
+		}
+		if len(conditions) > 0 {
+			from.WriteString(" AND ")
+		} else {
+			from.WriteString(" WHERE ")
+		}
+		from.WriteString("(a.sort_date, a.id) < (?, ?)")
+		args = append(args, sortDate, id)
+	}
 
-	// Add pagination
 	limit := opts.Limit
 	if limit <= 0 {
 		limit = model.DefaultPageSize
 	}
-	query.WriteString(fmt.Sprintf(" LIMIT %d", limit))
-	if opts.Offset > 0 {
-		query.WriteString(fmt.Sprintf(" OFFSET %d", opts.Offset))
+	// Fetch one extra row to learn whether another page follows
+	query := `SELECT a.id, a.blog_id, a.title, a.url, a.thumbnail_url, a.published_date, a.discovered_date, a.is_read, b.name, b.url, a.content, a.is_starred, a.sort_date` +
+		from.String() + fmt.Sprintf(" ORDER BY a.sort_date DESC, a.id DESC LIMIT %d", limit+1)
+	if opts.Offset > 0 && opts.After == "" {
+		query += fmt.Sprintf(" OFFSET %d", opts.Offset)
 	}
 
-	rows, err := db.conn.Query(query.String(), args...)
+	rows, err := db.reader.Query(query, args...)
 	if err != nil {
-		return nil, 0, err
+		return page, err
 	}
 	defer rows.Close()
 
-	var articles []model.ArticleWithBlog
-	var totalCount int
+	var lastSortDate string
 	for rows.Next() {
-		article, count, err := scanArticleWithBlogAndCount(rows)
+		if len(page.Articles) == limit {
+			last := page.Articles[limit-1]
+			page.NextCursor = encodeCursor(lastSortDate, last.ID)
+			break
+		}
+		article, sortDate, err := scanArticleWithBlogAndSortDate(rows)
 		if err != nil {
-			return nil, 0, err
+			return page, err
 		}
 		if article != nil {
-			articles = append(articles, *article)
-			totalCount = count
+			page.Articles = append(page.Articles, *article)
+			lastSortDate = sortDate
 		}
 	}
-
 	if err := rows.Err(); err != nil {
-		return nil, 0, err
+		return page, err
 	}
+	return page, nil
+}
+
+// encodeCursor returns an opaque, URL-safe cursor for an article position.
+func encodeCursor(sortDate string, id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(id, 10) + "|" + sortDate))
+}
 
-	return articles, totalCount, nil
+func decodeCursor(cursor string) (sortDate string, id int64, err error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", 0, ErrInvalidCursor
+	}
+	idPart, sortDate, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return "", 0, ErrInvalidCursor
+	}
+	id, err = strconv.ParseInt(idPart, 10, 64)
+	if err != nil {
+		return "", 0, ErrInvalidCursor
+	}
+	return sortDate, id, nil
+}
+
+// ListArticlesDiscoveredSince returns articles discovered strictly after since
+// and at or before until, with blog metadata, grouped by blog (ordered by name)
+// and newest first within each blog. Used to build email digests.
+func (db *Database) ListArticlesDiscoveredSince(since, until time.Time) ([]model.ArticleWithBlog, error) {
+	rows, err := db.reader.Query(`SELECT a.id, a.blog_id, a.title, a.url, a.thumbnail_url, a.published_date, a.discovered_date, a.is_read, b.name, b.url, a.content, a.is_starred
+		FROM articles a
+		INNER JOIN blogs b ON a.blog_id = b.id
+		WHERE julianday(a.discovered_date) > julianday(?) AND julianday(a.discovered_date) <= julianday(?)
+		ORDER BY b.name, b.id, a.sort_date DESC, a.id DESC`,
+		since.UTC().Format(sqliteTimeLayout), until.UTC().Format(sqliteTimeLayout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var articles []model.ArticleWithBlog
+	for rows.Next() {
+		article, err := scanArticleWithBlog(rows)
+		if err != nil {
+			return nil, err
+		}
+		if article != nil {
+			articles = append(articles, *article)
+		}
+	}
+	return articles, rows.Err()
 }
 
 func (db *Database) MarkArticleRead(id int64) (bool, error) {
@@ -615,6 +659,20 @@ func (db *Database) MarkArticleUnread(id int64) (bool, error) {
 	return rows > 0, nil
 }
 
+// SetArticleStarred stars or unstars an article. Starred articles are never
+// removed by retention. Returns false if the article does not exist.
+func (db *Database) SetArticleStarred(id int64, starred bool) (bool, error) {
+	result, err := db.conn.Exec(`UPDATE articles SET is_starred = ? WHERE id = ?`, starred, id)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
 // MarkAllUnreadArticlesRead marks all unread articles as read.
 // If blogID is provided, only marks articles from that blog.
 func (db *Database) MarkAllUnreadArticlesRead(blogID *int64) error {
@@ -632,26 +690,26 @@ func (db *Database) MarkAllUnreadArticlesRead(blogID *int64) error {
 
 // GetBlogByName returns a blog by its name, or nil if not found.
 func (db *Database) GetBlogByName(name string) (*model.Blog, error) {
-	row := db.conn.QueryRow(`SELECT id, name, url, feed_url, scrape_selector, last_scanned, type FROM blogs WHERE name = ?`, name)
+	row := db.reader.QueryRow(`SELECT id, name, url, feed_url, scrape_selector, last_scanned, type FROM blogs WHERE name = ?`, name)
 	return scanBlog(row)
 }
 
 // GetBlogByID returns a blog by its ID, or nil if not found.
 func (db *Database) GetBlogByID(id int64) (*model.Blog, error) {
-	row := db.conn.QueryRow(`SELECT id, name, url, feed_url, scrape_selector, last_scanned, type FROM blogs WHERE id = ?`, id)
+	row := db.reader.QueryRow(`SELECT id, name, url, feed_url, scrape_selector, last_scanned, type FROM blogs WHERE id = ?`, id)
 	return scanBlog(row)
 }
 
 // GetBlogByURL returns a blog by its URL, or nil if not found.
 func (db *Database) GetBlogByURL(url string) (*model.Blog, error) {
-	row := db.conn.QueryRow(`SELECT id, name, url, feed_url, scrape_selector, last_scanned, type FROM blogs WHERE url = ?`, url)
+	row := db.reader.QueryRow(`SELECT id, name, url, feed_url, scrape_selector, last_scanned, type FROM blogs WHERE url = ?`, url)
 	return scanBlog(row)
 }
 
 // GetArticleByURL returns an article by its URL, or nil if not found.
 func (db *Database) GetArticleByURL(url string) (*model.Article, error) {
-	row := db.conn.QueryRow(
-		`SELECT id, blog_id, title, url, thumbnail_url, published_date, discovered_date, is_read, content FROM articles WHERE url = ?`,
+	row := db.reader.QueryRow(
+		`SELECT id, blog_id, title, url, thumbnail_url, published_date, discovered_date, is_read, content, is_starred FROM articles WHERE url = ?`,
 		url,
 	)
 	return scanArticle(row)
@@ -659,8 +717,8 @@ func (db *Database) GetArticleByURL(url string) (*model.Article, error) {
 
 // GetArticleByID returns an article by its ID, or nil if not found.
 func (db *Database) GetArticleByID(id int64) (*model.Article, error) {
-	row := db.conn.QueryRow(
-		`SELECT id, blog_id, title, url, thumbnail_url, published_date, discovered_date, is_read, content FROM articles WHERE id = ?`,
+	row := db.reader.QueryRow(
+		`SELECT id, blog_id, title, url, thumbnail_url, published_date, discovered_date, is_read, content, is_starred FROM articles WHERE id = ?`,
 		id,
 	)
 	return scanArticle(row)
@@ -702,7 +760,7 @@ func (db *Database) UpdateBlogName(id int64, name string) error {
 // GetArticleCountForBlog returns the number of articles for a specific blog.
 func (db *Database) GetArticleCountForBlog(blogID int64) (int, error) {
 	var count int
-	err := db.conn.QueryRow(`SELECT COUNT(*) FROM articles WHERE blog_id = ?`, blogID).Scan(&count)
+	err := db.reader.QueryRow(`SELECT COUNT(*) FROM articles WHERE blog_id = ?`, blogID).Scan(&count)
 	return count, err
 }
 
@@ -719,6 +777,10 @@ func (db *Database) DeleteBlogOnly(id int64) error {
 		_ = tx.Rollback()
 		return fmt.Errorf("orphan articles: %w", err)
 	}
+	if _, err := tx.Exec(`UPDATE deleted_articles SET blog_id = NULL WHERE blog_id = ?`, id); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("orphan deleted articles: %w", err)
+	}
 
 	// Delete the blog
 	result, err := tx.Exec(`DELETE FROM blogs WHERE id = ?`, id)
@@ -753,6 +815,11 @@ func (db *Database) DeleteBlogWithArticles(id int64) error {
 		_ = tx.Rollback()
 		return fmt.Errorf("delete articles: %w", err)
 	}
+	// Forget URLs pruned from this blog so re-adding it imports them again
+	if _, err := tx.Exec(`DELETE FROM deleted_articles WHERE blog_id = ?`, id); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("delete tombstones: %w", err)
+	}
 
 	// Delete the blog
 	result, err := tx.Exec(`DELETE FROM blogs WHERE id = ?`, id)
@@ -799,6 +866,22 @@ func (db *Database) UpdateBlogLastScanned(id int64, lastScanned time.Time) error
 	return err
 }
 
+const insertArticleSQL = `INSERT INTO articles (blog_id, title, url, thumbnail_url, published_date, discovered_date, is_read, content) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+// articleInsertArgs returns the arguments for insertArticleSQL.
+func articleInsertArgs(article model.Article) []interface{} {
+	return []interface{}{
+		article.BlogID,
+		article.Title,
+		article.URL,
+		nullIfEmpty(article.ThumbnailURL),
+		formatTimePtr(article.PublishedDate),
+		formatTimePtr(article.DiscoveredDate),
+		article.IsRead,
+		nullIfEmpty(article.Content),
+	}
+}
+
 // AddArticlesBulk inserts multiple articles in a single transaction.
 // Returns the count of inserted articles.
 func (db *Database) AddArticlesBulk(articles []model.Article) (int, error) {
@@ -809,7 +892,7 @@ func (db *Database) AddArticlesBulk(articles []model.Article) (int, error) {
 	if err != nil {
 		return 0, err
 	}
-	stmt, err := tx.Prepare(`INSERT INTO articles (blog_id, title, url, thumbnail_url, published_date, discovered_date, is_read, content) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+	stmt, err := tx.Prepare(insertArticleSQL)
 	if err != nil {
 		_ = tx.Rollback()
 		return 0, err
@@ -817,17 +900,7 @@ func (db *Database) AddArticlesBulk(articles []model.Article) (int, error) {
 	defer stmt.Close()
 
 	for _, article := range articles {
-		_, err := stmt.Exec(
-			article.BlogID,
-			article.Title,
-			article.URL,
-			nullIfEmpty(article.ThumbnailURL),
-			formatTimePtr(article.PublishedDate),
-			formatTimePtr(article.DiscoveredDate),
-			article.IsRead,
-			nullIfEmpty(article.Content),
-		)
-		if err != nil {
+		if _, err := stmt.Exec(articleInsertArgs(article)...); err != nil {
 			_ = tx.Rollback()
 			return 0, err
 		}
@@ -838,7 +911,8 @@ func (db *Database) AddArticlesBulk(articles []model.Article) (int, error) {
 	return len(articles), nil
 }
 
-// GetExistingArticleURLs returns a set of article URLs that already exist in the database.
+// GetExistingArticleURLs returns a set of article URLs that already exist in the database,
+// including URLs of articles removed by retention so scans don't re-import them.
 // Used for deduplication during scanning. Handles chunking for large URL lists.
 func (db *Database) GetExistingArticleURLs(urls []string) (map[string]struct{}, error) {
 	result := make(map[string]struct{})
@@ -854,8 +928,9 @@ func (db *Database) GetExistingArticleURLs(urls []string) (map[string]struct{},
 		}
 		chunk := urls[start:end]
 		placeholders := strings.TrimRight(strings.Repeat("?,", len(chunk)), ",")
-		query := fmt.Sprintf("SELECT url FROM articles WHERE url IN (%s)", placeholders)
-		rows, err := db.conn.Query(query, interfaceSlice(chunk)...)
+		query := fmt.Sprintf("SELECT url FROM articles WHERE url IN (%[1]s) UNION SELECT url FROM deleted_articles WHERE url IN (%[1]s)", placeholders)
+		args := interfaceSlice(chunk)
+		rows, err := db.reader.Query(query, append(args, args...)...)
 		if err != nil {
 			return nil, err
 		}
@@ -920,8 +995,9 @@ func scanArticle(scanner interface{ Scan(dest ...any) error }) (*model.Article,
 		discovered    sql.NullString
 		isRead        bool
 		content       sql.NullString
+		isStarred     bool
 	)
-	if err := scanner.Scan(&id, &blogID, &title, &url, &thumbnailURL, &publishedDate, &discovered, &isRead, &content); err != nil {
+	if err := scanner.Scan(&id, &blogID, &title, &url, &thumbnailURL, &publishedDate, &discovered, &isRead, &content, &isStarred); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
@@ -935,6 +1011,7 @@ func scanArticle(scanner interface{ Scan(dest ...any) error }) (*model.Article,
 		URL:          url,
 		ThumbnailURL: thumbnailURL.String,
 		IsRead:       isRead,
+		IsStarred:    isStarred,
 		Content:      content.String,
 	}
 	if publishedDate.Valid {
@@ -964,8 +1041,9 @@ func scanArticleWithBlog(scanner interface{ Scan(dest ...any) error }) (*model.A
 		blogName      string
 		blogURL       string
 		content       sql.NullString
+		isStarred     bool
 	)
-	if err := scanner.Scan(&id, &blogID, &title, &url, &thumbnailURL, &publishedDate, &discovered, &isRead, &blogName, &blogURL, &content); err != nil {
+	if err := scanner.Scan(&id, &blogID, &title, &url, &thumbnailURL, &publishedDate, &discovered, &isRead, &blogName, &blogURL, &content, &isStarred); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
@@ -979,6 +1057,7 @@ func scanArticleWithBlog(scanner interface{ Scan(dest ...any) error }) (*model.A
 		URL:          url,
 		ThumbnailURL: thumbnailURL.String,
 		IsRead:       isRead,
+		IsStarred:    isStarred,
 		BlogName:     blogName,
 		BlogURL:      blogURL,
 		Content:      content.String,
@@ -997,7 +1076,7 @@ func scanArticleWithBlog(scanner interface{ Scan(dest ...any) error }) (*model.A
 	return article, nil
 }
 
-func scanArticleWithBlogAndCount(scanner interface{ Scan(dest ...any) error }) (*model.ArticleWithBlog, int, error) {
+func scanArticleWithBlogAndSortDate(scanner interface{ Scan(dest ...any) error }) (*model.ArticleWithBlog, string, error) {
 	var (
 		id            int64
 		blogID        int64
@@ -1010,13 +1089,14 @@ func scanArticleWithBlogAndCount(scanner interface{ Scan(dest ...any) error }) (
 		blogName      string
 		blogURL       string
 		content       sql.NullString
-		totalCount    int
+		isStarred     bool
+		sortDate      string
 	)
-	if err := scanner.Scan(&id, &blogID, &title, &url, &thumbnailURL, &publishedDate, &discovered, &isRead, &blogName, &blogURL, &content, &totalCount); err != nil {
+	if err := scanner.Scan(&id, &blogID, &title, &url, &thumbnailURL, &publishedDate, &discovered, &isRead, &blogName, &blogURL, &content, &isStarred, &sortDate); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, 0, nil
+			return nil, "", nil
 		}
-		return nil, 0, err
+		return nil, "", err
 	}
 
 	article := &model.ArticleWithBlog{
@@ -1026,6 +1106,7 @@ func scanArticleWithBlogAndCount(scanner interface{ Scan(dest ...any) error }) (
 		URL:          url,
 		ThumbnailURL: thumbnailURL.String,
 		IsRead:       isRead,
+		IsStarred:    isStarred,
 		BlogName:     blogName,
 		BlogURL:      blogURL,
 		Content:      content.String,
@@ -1041,14 +1122,14 @@ func scanArticleWithBlogAndCount(scanner interface{ Scan(dest ...any) error }) (
 		}
 	}
 
-	return article, totalCount, nil
+	return article, sortDate, nil
 }
 
 // GetSetting retrieves a value from the settings table by key.
 // Returns ("", nil) when the key does not exist.
 func (db *Database) GetSetting(key string) (string, error) {
 	var value string
-	err := db.conn.QueryRow(`SELECT value FROM settings WHERE key = ?`, key).Scan(&value)
+	err := db.reader.QueryRow(`SELECT value FROM settings WHERE key = ?`, key).Scan(&value)
 	if errors.Is(err, sql.ErrNoRows) {
 		return "", nil
 	}
@@ -1126,7 +1207,7 @@ type ArticleForThumbnailSync struct {
 // GetArticlesMissingThumbnails returns articles that have empty thumbnail_url.
 // Includes feed_url from the blog for RSS re-parsing.
 func (db *Database) GetArticlesMissingThumbnails() ([]ArticleForThumbnailSync, error) {
-	rows, err := db.conn.Query(`
+	rows, err := db.reader.Query(`
 		SELECT a.id, a.url, b.feed_url
 		FROM articles a
 		INNER JOIN blogs b ON a.blog_id = b.id
@@ -1,10 +1,15 @@
 // ABOUTME: Tests for database storage layer operations.
-// ABOUTME: Covers schema initialization, blog CRUD, and migration scenarios.
+// ABOUTME: Covers schema initialization, blog CRUD, WAL reader concurrency and read latency under writes.
 package storage
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -350,6 +355,46 @@ func TestArticleContentStoredAndRetrieved(t *testing.T) {
 	}
 }
 
+func TestUnreadCountsByBlog(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	alpha, err := db.AddBlog(model.Blog{Name: "Alpha", URL: "https://alpha.example.com"})
+	if err != nil {
+		t.Fatalf("add blog: %v", err)
+	}
+	beta, err := db.AddBlog(model.Blog{Name: "Beta", URL: "https://beta.example.com"})
+	if err != nil {
+		t.Fatalf("add blog: %v", err)
+	}
+	if _, err := db.AddArticlesBulk([]model.Article{
+		{BlogID: alpha.ID, Title: "A1", URL: "https://alpha.example.com/1"},
+		{BlogID: alpha.ID, Title: "A2", URL: "https://alpha.example.com/2"},
+		{BlogID: beta.ID, Title: "B1", URL: "https://beta.example.com/1", IsRead: true},
+	}); err != nil {
+		t.Fatalf("add articles: %v", err)
+	}
+
+	counts, err := db.UnreadCountsByBlog()
+	if err != nil {
+		t.Fatalf("UnreadCountsByBlog: %v", err)
+	}
+	if counts[alpha.ID] != 2 {
+		t.Errorf("alpha unread = %d, want 2", counts[alpha.ID])
+	}
+	if _, ok := counts[beta.ID]; ok {
+		t.Errorf("beta should be absent, got %d", counts[beta.ID])
+	}
+
+	total, unread, err := db.ArticleCounts()
+	if err != nil {
+		t.Fatalf("ArticleCounts: %v", err)
+	}
+	if total != 3 || unread != 2 {
+		t.Errorf("ArticleCounts = %d, %d; want 3, 2", total, unread)
+	}
+}
+
 func TestArticleContentEmptyForRSS(t *testing.T) {
 	db := openTestDB(t)
 	defer db.Close()
@@ -460,7 +505,7 @@ func TestGetOrCreateNewsletterBlogIdempotent(t *testing.T) {
 	}
 }
 
-func openTestDB(t *testing.T) *Database {
+func openTestDB(t testing.TB) *Database {
 	t.Helper()
 	path := filepath.Join(t.TempDir(), "blogwatcher.db")
 	db, err := OpenDatabase(path)
@@ -469,3 +514,234 @@ func openTestDB(t *testing.T) *Database {
 	}
 	return db
 }
+
+func TestSchemaVersionUpToDate(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	current, latest, err := db.SchemaVersion()
+	if err != nil || latest == 0 || current != latest {
+		t.Errorf("SchemaVersion = %d of %d, want fully migrated", current, latest)
+	}
+	if size, err := db.Size(); err != nil || size == 0 {
+		t.Errorf("Size = %d, %v", size, err)
+	}
+}
+
+func TestReadsDoNotWaitForWriter(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	var mode string
+	if err := db.conn.QueryRow(`PRAGMA journal_mode`).Scan(&mode); err != nil || mode != "wal" {
+		t.Fatalf("journal_mode = %q, %v; want wal", mode, err)
+	}
+	if _, err := db.reader.Exec(`INSERT INTO settings (key, value) VALUES ('k', 'v')`); err == nil {
+		t.Error("reader pool accepted a write")
+	}
+
+	// Hold the only writer connection in an open transaction
+	tx, err := db.conn.Begin()
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`INSERT INTO blogs (name, url) VALUES ('Pending', 'https://pending.example.com')`); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		blogs, err := db.ListBlogs()
+		if err == nil && len(blogs) != 0 {
+			err = fmt.Errorf("saw %d uncommitted blogs", len(blogs))
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("read blocked behind the open write transaction")
+	}
+}
+
+// BenchmarkReadLatencyDuringScan measures the UI's article query while a
+// scan-like writer inserts (and removes) batches of articles, once through the read-only
+// pool and once through the writer connection alone (the old single
+// connection layout). p50-ms and p99-ms are per-query latencies.
+func BenchmarkReadLatencyDuringScan(b *testing.B) {
+	db := openTestDB(b)
+	defer db.Close()
+
+	blog, err := db.AddBlog(model.Blog{Name: "Busy", URL: "https://busy.example.com"})
+	if err != nil {
+		b.Fatalf("add blog: %v", err)
+	}
+	content := string(make([]byte, 4096))
+	batch := func(n, size int) []model.Article {
+		articles := make([]model.Article, size)
+		for i := range articles {
+			url := fmt.Sprintf("https://busy.example.com/%d-%d", n, i)
+			articles[i] = model.Article{BlogID: blog.ID, Title: "Post " + url, URL: url, Content: content}
+		}
+		return articles
+	}
+	if _, err := db.AddArticlesBulk(batch(-1, 1000)); err != nil {
+		b.Fatalf("seed articles: %v", err)
+	}
+
+	singleConn := &Database{path: db.path, conn: db.conn, reader: db.conn}
+	for _, bc := range []struct {
+		name string
+		db   *Database
+	}{{"reader-pool", db}, {"single-connection", singleConn}} {
+		b.Run(bc.name, func(b *testing.B) {
+			stop := make(chan struct{})
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for n := 0; ; n++ {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					articles := batch(n, 2000)
+					urls := make([]string, len(articles))
+					for i, a := range articles {
+						urls[i] = a.URL
+					}
+					if _, err := db.GetExistingArticleURLs(urls); err != nil {
+						b.Error(err)
+						return
+					}
+					if _, err := db.AddArticlesBulk(articles); err != nil {
+						b.Error(err)
+						return
+					}
+					// Keep the table size steady so runs are comparable
+					if _, err := db.conn.Exec(`DELETE FROM articles WHERE url LIKE ?`, fmt.Sprintf("https://busy.example.com/%d-%%", n)); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			}()
+
+			latencies := make([]time.Duration, 0, b.N)
+			unread := false
+			b.ResetTimer()
+			for b.Loop() {
+				start := time.Now()
+				if _, _, err := bc.db.SearchArticles(model.SearchOptions{IsRead: &unread, Limit: model.DefaultPageSize}); err != nil {
+					b.Fatal(err)
+				}
+				latencies = append(latencies, time.Since(start))
+			}
+			b.StopTimer()
+			close(stop)
+			wg.Wait()
+
+			slices.Sort(latencies)
+			percentile := func(p float64) float64 {
+				return float64(latencies[int(float64(len(latencies)-1)*p)]) / float64(time.Millisecond)
+			}
+			b.ReportMetric(percentile(0.50), "p50-ms")
+			b.ReportMetric(percentile(0.99), "p99-ms")
+		})
+	}
+}
+
+func TestSearchArticlesPageCursor(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	blog, err := db.AddBlog(model.Blog{Name: "Paged", URL: "https://paged.example.com"})
+	if err != nil {
+		t.Fatalf("add blog: %v", err)
+	}
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	var articles []model.Article
+	// Posts 2 and 3 share a date so the ID breaks the tie
+	for i, day := range []int{0, 1, 2, 2, 3, 4} {
+		published := base.AddDate(0, 0, day)
+		articles = append(articles, model.Article{BlogID: blog.ID, Title: fmt.Sprintf("Post %d", i), URL: fmt.Sprintf("https://paged.example.com/%d", i), PublishedDate: &published})
+	}
+	articles = append(articles, model.Article{BlogID: blog.ID, Title: "Undated", URL: "https://paged.example.com/undated"})
+	if _, err := db.AddArticlesBulk(articles); err != nil {
+		t.Fatalf("add articles: %v", err)
+	}
+
+	unread := false
+	opts := model.SearchOptions{IsRead: &unread, Limit: 3}
+	page, err := db.SearchArticlesPage(opts)
+	if err != nil {
+		t.Fatalf("first page: %v", err)
+	}
+	if page.Total != 7 || len(page.Articles) != 3 || page.NextCursor == "" {
+		t.Fatalf("first page = %d articles of %d, cursor %q", len(page.Articles), page.Total, page.NextCursor)
+	}
+
+	// A new article arriving mid-scroll must not shift later pages
+	newest := base.AddDate(1, 0, 0)
+	if _, err := db.AddArticlesBulk([]model.Article{{BlogID: blog.ID, Title: "Newest", URL: "https://paged.example.com/newest", PublishedDate: &newest}}); err != nil {
+		t.Fatalf("add newest: %v", err)
+	}
+
+	seen := map[int64]bool{}
+	var order []string
+	for {
+		for _, a := range page.Articles {
+			if seen[a.ID] {
+				t.Fatalf("article %q returned twice", a.Title)
+			}
+			seen[a.ID] = true
+			order = append(order, a.Title)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		opts.After = page.NextCursor
+		if page, err = db.SearchArticlesPage(opts); err != nil {
+			t.Fatalf("next page: %v", err)
+		}
+		if page.Total != -1 {
+			t.Errorf("later page counted the total (%d)", page.Total)
+		}
+	}
+	if len(order) != 7 || order[len(order)-1] != "Undated" {
+		t.Errorf("order = %v, want the 7 original articles with the undated one last", order)
+	}
+
+	if _, err := db.SearchArticlesPage(model.SearchOptions{After: "not a cursor"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("bad cursor = %v, want ErrInvalidCursor", err)
+	}
+}
+
+func TestArticleListingUsesSortIndex(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	rows, err := db.reader.Query(`EXPLAIN QUERY PLAN SELECT a.id FROM articles a INNER JOIN blogs b ON a.blog_id = b.id
+		WHERE a.is_read = 0 AND (a.sort_date, a.id) < ('2025-01-01', 100) ORDER BY a.sort_date DESC, a.id DESC LIMIT 21`)
+	if err != nil {
+		t.Fatalf("explain: %v", err)
+	}
+	defer rows.Close()
+	var plan []string
+	for rows.Next() {
+		var id, parent, unused int
+		var detail string
+		if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+			t.Fatalf("scan plan: %v", err)
+		}
+		plan = append(plan, detail)
+	}
+	joined := strings.Join(plan, "; ")
+	if !strings.Contains(joined, "articles_read_sort_date") || strings.Contains(joined, "TEMP B-TREE") {
+		t.Errorf("plan = %s, want the is_read/sort_date index without sorting", joined)
+	}
+}
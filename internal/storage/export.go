@@ -0,0 +1,529 @@
+// ABOUTME: Full JSON export and import of blogs, articles, read/starred state, newsletter data, retention and settings.
+// ABOUTME: Blogs and articles are keyed by URL so exports merge cleanly into another database.
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/model"
+)
+
+// ExportFormat identifies a BlogWatcher export document.
+const ExportFormat = "blogwatcher-export"
+
+// ExportVersion is the version of the export document layout. Version 2 adds
+// newsletter parts, links and aliases, retention overrides and pruned URLs;
+// version 1 documents still import.
+const ExportVersion = 2
+
+// Export is a portable copy of everything in the database. Row IDs are not
+// included; articles refer to their blog by URL.
+type Export struct {
+	Format        string            `json:"format"`
+	Version       int               `json:"version"`
+	ExportedAt    time.Time         `json:"exported_at"`
+	SchemaVersion int               `json:"schema_version"`
+	Blogs         []ExportedBlog    `json:"blogs"`
+	Articles      []ExportedArticle `json:"articles"`
+	Settings      map[string]string `json:"settings"`
+	// DeletedArticles are the URLs retention pruned, so scans in the
+	// importing database don't bring them back either.
+	DeletedArticles []ExportedDeletedArticle `json:"deleted_articles,omitempty"`
+}
+
+// ErrInvalidExport is wrapped by Import errors caused by the document itself.
+var ErrInvalidExport = errors.New("invalid export")
+
+// ExportedBlog is one blog in an Export.
+type ExportedBlog struct {
+	Name           string     `json:"name"`
+	URL            string     `json:"url"`
+	FeedURL        string     `json:"feed_url,omitempty"`
+	ScrapeSelector string     `json:"scrape_selector,omitempty"`
+	Type           string     `json:"type"`
+	LastScanned    *time.Time `json:"last_scanned,omitempty"`
+	// Retention is the blog's override of the global retention policy.
+	Retention *ExportedRetention `json:"retention,omitempty"`
+	// Aliases are the newsletter sender addresses and List-Ids routed here.
+	Aliases []ExportedAlias `json:"aliases,omitempty"`
+}
+
+// ExportedRetention is a blog's retention override; see BlogRetention.
+type ExportedRetention struct {
+	ReadDays    *int `json:"read_days,omitempty"`
+	MaxArticles *int `json:"max_articles,omitempty"`
+}
+
+// ExportedAlias is a newsletter alias; see model.NewsletterAlias.
+type ExportedAlias struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+// ExportedDeletedArticle is a URL pruned by retention. BlogURL is empty when
+// the blog was deleted.
+type ExportedDeletedArticle struct {
+	URL       string    `json:"url"`
+	BlogURL   string    `json:"blog_url,omitempty"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// ExportedArticle is one article in an Export. BlogURL is empty for articles
+// whose blog was deleted.
+type ExportedArticle struct {
+	BlogURL        string     `json:"blog_url,omitempty"`
+	Title          string     `json:"title"`
+	URL            string     `json:"url"`
+	ThumbnailURL   string     `json:"thumbnail_url,omitempty"`
+	PublishedDate  *time.Time `json:"published_date,omitempty"`
+	DiscoveredDate *time.Time `json:"discovered_date,omitempty"`
+	IsRead         bool       `json:"is_read"`
+	IsStarred      bool       `json:"is_starred,omitempty"`
+	Content        string     `json:"content,omitempty"`
+	// Newsletter is set for newsletter articles with links or parts.
+	Newsletter *ExportedNewsletter `json:"newsletter,omitempty"`
+}
+
+// ExportedNewsletter holds a newsletter article's links and its inline images
+// and attachments.
+type ExportedNewsletter struct {
+	WebURL            string         `json:"web_url,omitempty"`
+	UnsubscribeURL    string         `json:"unsubscribe_url,omitempty"`
+	UnsubscribeMailto string         `json:"unsubscribe_mailto,omitempty"`
+	Parts             []ExportedPart `json:"parts,omitempty"`
+}
+
+// ExportedPart is one inline image or attachment; Data is base64 in JSON.
+type ExportedPart struct {
+	ContentID   string `json:"content_id,omitempty"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Inline      bool   `json:"inline,omitempty"`
+	Data        []byte `json:"data"`
+}
+
+// ImportResult counts what Import changed.
+type ImportResult struct {
+	BlogsAdded      int `json:"blogs_added"`
+	ArticlesAdded   int `json:"articles_added"`
+	ArticlesUpdated int `json:"articles_updated"`
+	Settings        int `json:"settings"`
+}
+
+// ExportOptions controls what Export includes.
+type ExportOptions struct {
+	// IncludeSecrets adds the settings holding passwords and signing keys,
+	// which are left out by default so an export can be shared or stored
+	// without handing them over.
+	IncludeSecrets bool
+}
+
+// secretSettings are the settings holding credentials: the digest SMTP and
+// IMAP passwords, the newsletter webhook secrets and the provider signing keys.
+var secretSettings = map[string]bool{
+	"digest_smtp_password":      true,
+	"imap_password":             true,
+	"webhook_secret":            true,
+	"webhook_secret_previous":   true,
+	"mailgun_signing_key":       true,
+	"sendgrid_verification_key": true,
+}
+
+// Export reads every blog, article, newsletter part, retention override,
+// pruned URL and setting in one read-only transaction, so the document is a
+// consistent snapshot even while feeds are being synced.
+func (db *Database) Export(ctx context.Context, opts ExportOptions) (*Export, error) {
+	tx, err := db.reader.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	exp := &Export{
+		Format:     ExportFormat,
+		Version:    ExportVersion,
+		ExportedAt: time.Now().UTC(),
+		Blogs:      []ExportedBlog{},
+		Articles:   []ExportedArticle{},
+		Settings:   map[string]string{},
+	}
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&exp.SchemaVersion); err != nil {
+		return nil, fmt.Errorf("read schema version: %w", err)
+	}
+	if err := exportBlogs(ctx, tx, exp); err != nil {
+		return nil, fmt.Errorf("list blogs: %w", err)
+	}
+	if err := exportArticles(ctx, tx, exp); err != nil {
+		return nil, fmt.Errorf("list articles: %w", err)
+	}
+	if err := exportDeletedArticles(ctx, tx, exp); err != nil {
+		return nil, fmt.Errorf("list deleted articles: %w", err)
+	}
+	if err := exportSettings(ctx, tx, exp, opts.IncludeSecrets); err != nil {
+		return nil, fmt.Errorf("list settings: %w", err)
+	}
+	return exp, nil
+}
+
+func exportBlogs(ctx context.Context, tx *sql.Tx, exp *Export) error {
+	rows, err := tx.QueryContext(ctx, `SELECT id, name, url, feed_url, scrape_selector, last_scanned, type FROM blogs ORDER BY name, id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	index := make(map[int64]int)
+	for rows.Next() {
+		b, err := scanBlog(rows)
+		if err != nil {
+			return err
+		}
+		index[b.ID] = len(exp.Blogs)
+		exp.Blogs = append(exp.Blogs, ExportedBlog{
+			Name:           b.Name,
+			URL:            b.URL,
+			FeedURL:        b.FeedURL,
+			ScrapeSelector: b.ScrapeSelector,
+			Type:           b.Type,
+			LastScanned:    b.LastScanned,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	retention, err := tx.QueryContext(ctx, `SELECT blog_id, read_days, max_articles FROM blog_retention`)
+	if err != nil {
+		return fmt.Errorf("retention: %w", err)
+	}
+	defer retention.Close()
+	for retention.Next() {
+		var (
+			blogID                int64
+			readDays, maxArticles sql.NullInt64
+		)
+		if err := retention.Scan(&blogID, &readDays, &maxArticles); err != nil {
+			return err
+		}
+		if i, ok := index[blogID]; ok {
+			exp.Blogs[i].Retention = &ExportedRetention{ReadDays: intPtr(readDays), MaxArticles: intPtr(maxArticles)}
+		}
+	}
+	if err := retention.Err(); err != nil {
+		return err
+	}
+
+	aliases, err := tx.QueryContext(ctx, `SELECT blog_id, kind, value FROM newsletter_aliases ORDER BY kind DESC, value`)
+	if err != nil {
+		return fmt.Errorf("newsletter aliases: %w", err)
+	}
+	defer aliases.Close()
+	for aliases.Next() {
+		var (
+			blogID int64
+			alias  ExportedAlias
+		)
+		if err := aliases.Scan(&blogID, &alias.Kind, &alias.Value); err != nil {
+			return err
+		}
+		if i, ok := index[blogID]; ok {
+			exp.Blogs[i].Aliases = append(exp.Blogs[i].Aliases, alias)
+		}
+	}
+	return aliases.Err()
+}
+
+func exportArticles(ctx context.Context, tx *sql.Tx, exp *Export) error {
+	rows, err := tx.QueryContext(ctx, `SELECT a.id, b.url, a.title, a.url, a.thumbnail_url, a.published_date,
+		a.discovered_date, a.is_read, a.is_starred, a.content
+		FROM articles a LEFT JOIN blogs b ON b.id = a.blog_id
+		ORDER BY a.id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	index := make(map[int64]int)
+	for rows.Next() {
+		var (
+			id                                     int64
+			a                                      ExportedArticle
+			blogURL, thumbnail, published, content sql.NullString
+			discovered                             sql.NullString
+		)
+		if err := rows.Scan(&id, &blogURL, &a.Title, &a.URL, &thumbnail, &published, &discovered, &a.IsRead, &a.IsStarred, &content); err != nil {
+			return err
+		}
+		a.BlogURL = blogURL.String
+		a.ThumbnailURL = thumbnail.String
+		a.Content = content.String
+		if published.Valid {
+			if t, err := parseTime(published.String); err == nil {
+				a.PublishedDate = &t
+			}
+		}
+		if discovered.Valid {
+			if t, err := parseTime(discovered.String); err == nil {
+				a.DiscoveredDate = &t
+			}
+		}
+		index[id] = len(exp.Articles)
+		exp.Articles = append(exp.Articles, a)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// newsletter returns the newsletter data of an article, adding it first
+	newsletter := func(articleID int64) *ExportedNewsletter {
+		i, ok := index[articleID]
+		if !ok {
+			return nil
+		}
+		if exp.Articles[i].Newsletter == nil {
+			exp.Articles[i].Newsletter = &ExportedNewsletter{}
+		}
+		return exp.Articles[i].Newsletter
+	}
+
+	meta, err := tx.QueryContext(ctx, `SELECT article_id, web_url, unsubscribe_url, unsubscribe_mailto FROM newsletter_meta`)
+	if err != nil {
+		return fmt.Errorf("newsletter links: %w", err)
+	}
+	defer meta.Close()
+	for meta.Next() {
+		var (
+			articleID                int64
+			web, unsubscribe, mailto string
+		)
+		if err := meta.Scan(&articleID, &web, &unsubscribe, &mailto); err != nil {
+			return err
+		}
+		if n := newsletter(articleID); n != nil {
+			n.WebURL, n.UnsubscribeURL, n.UnsubscribeMailto = web, unsubscribe, mailto
+		}
+	}
+	if err := meta.Err(); err != nil {
+		return err
+	}
+
+	parts, err := tx.QueryContext(ctx, `SELECT article_id, content_id, filename, content_type, inline, data
+		FROM newsletter_parts ORDER BY article_id, id`)
+	if err != nil {
+		return fmt.Errorf("newsletter parts: %w", err)
+	}
+	defer parts.Close()
+	for parts.Next() {
+		var (
+			articleID int64
+			p         ExportedPart
+		)
+		if err := parts.Scan(&articleID, &p.ContentID, &p.Filename, &p.ContentType, &p.Inline, &p.Data); err != nil {
+			return err
+		}
+		if n := newsletter(articleID); n != nil {
+			n.Parts = append(n.Parts, p)
+		}
+	}
+	return parts.Err()
+}
+
+func exportDeletedArticles(ctx context.Context, tx *sql.Tx, exp *Export) error {
+	rows, err := tx.QueryContext(ctx, `SELECT d.url, b.url, d.deleted_at
+		FROM deleted_articles d LEFT JOIN blogs b ON b.id = d.blog_id
+		ORDER BY d.deleted_at, d.url`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			d         ExportedDeletedArticle
+			blogURL   sql.NullString
+			deletedAt string
+		)
+		if err := rows.Scan(&d.URL, &blogURL, &deletedAt); err != nil {
+			return err
+		}
+		d.BlogURL = blogURL.String
+		if t, err := parseTime(deletedAt); err == nil {
+			d.DeletedAt = t
+		}
+		exp.DeletedArticles = append(exp.DeletedArticles, d)
+	}
+	return rows.Err()
+}
+
+func exportSettings(ctx context.Context, tx *sql.Tx, exp *Export, includeSecrets bool) error {
+	rows, err := tx.QueryContext(ctx, `SELECT key, value FROM settings ORDER BY key`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return err
+		}
+		if secretSettings[key] && !includeSecrets {
+			continue
+		}
+		exp.Settings[key] = value
+	}
+	return rows.Err()
+}
+
+// Import merges exp into the database in a single transaction. Blogs and
+// articles already present (matched by URL) are kept, but articles take the
+// read and starred state from the export. Newsletter parts and links come
+// with the articles that are added. Retention overrides and aliases from the
+// export replace local ones, and pruned URLs are added to the local ones. Settings in the export overwrite
+// local ones; those an export without secrets leaves out are kept as they are.
+func (db *Database) Import(exp *Export) (result ImportResult, err error) {
+	if exp.Format != ExportFormat {
+		return result, fmt.Errorf("%w: not a BlogWatcher export (format %q)", ErrInvalidExport, exp.Format)
+	}
+	if exp.Version > ExportVersion {
+		return result, fmt.Errorf("%w: version %d is newer than this build supports (%d)", ErrInvalidExport, exp.Version, ExportVersion)
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	blogIDs := make(map[string]int64, len(exp.Blogs))
+	for _, b := range exp.Blogs {
+		if b.URL == "" || b.Name == "" {
+			return ImportResult{}, fmt.Errorf("%w: blog with empty name or URL", ErrInvalidExport)
+		}
+		var id int64
+		err = tx.QueryRow(`SELECT id FROM blogs WHERE url = ?`, b.URL).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			blogType := b.Type
+			if blogType == "" {
+				blogType = "rss"
+			}
+			var res sql.Result
+			res, err = tx.Exec(`INSERT INTO blogs (name, url, feed_url, scrape_selector, last_scanned, type) VALUES (?, ?, ?, ?, ?, ?)`,
+				b.Name, b.URL, nullIfEmpty(b.FeedURL), nullIfEmpty(b.ScrapeSelector), formatTimePtr(b.LastScanned), blogType)
+			if err != nil {
+				return ImportResult{}, fmt.Errorf("import blog %s: %w", b.URL, err)
+			}
+			id, err = res.LastInsertId()
+			result.BlogsAdded++
+		}
+		if err != nil {
+			return ImportResult{}, fmt.Errorf("import blog %s: %w", b.URL, err)
+		}
+		blogIDs[b.URL] = id
+
+		if r := b.Retention; r != nil {
+			if _, err = tx.Exec(`INSERT INTO blog_retention (blog_id, read_days, max_articles) VALUES (?, ?, ?)
+				ON CONFLICT(blog_id) DO UPDATE SET read_days = excluded.read_days, max_articles = excluded.max_articles`,
+				id, r.ReadDays, r.MaxArticles); err != nil {
+				return ImportResult{}, fmt.Errorf("import retention of %s: %w", b.URL, err)
+			}
+		}
+		for _, alias := range b.Aliases {
+			if alias.Kind != model.NewsletterAliasSender && alias.Kind != model.NewsletterAliasListID || alias.Value == "" {
+				return ImportResult{}, fmt.Errorf("%w: invalid newsletter alias %s %q", ErrInvalidExport, alias.Kind, alias.Value)
+			}
+			if _, err = tx.Exec(`INSERT INTO newsletter_aliases (kind, value, blog_id) VALUES (?, ?, ?)
+				ON CONFLICT(kind, value) DO UPDATE SET blog_id = excluded.blog_id`, alias.Kind, alias.Value, id); err != nil {
+				return ImportResult{}, fmt.Errorf("import alias %s: %w", alias.Value, err)
+			}
+		}
+	}
+
+	for _, a := range exp.Articles {
+		if a.URL == "" || a.Title == "" {
+			return ImportResult{}, fmt.Errorf("%w: article with empty title or URL", ErrInvalidExport)
+		}
+		var blogID *int64
+		if a.BlogURL != "" {
+			id, ok := blogIDs[a.BlogURL]
+			if !ok {
+				return ImportResult{}, fmt.Errorf("%w: article %s refers to unknown blog %s", ErrInvalidExport, a.URL, a.BlogURL)
+			}
+			blogID = &id
+		}
+		var isRead, isStarred bool
+		err = tx.QueryRow(`SELECT is_read, is_starred FROM articles WHERE url = ?`, a.URL).Scan(&isRead, &isStarred)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			var res sql.Result
+			res, err = tx.Exec(`INSERT INTO articles (blog_id, title, url, thumbnail_url, published_date, discovered_date, is_read, is_starred, content)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				blogID, a.Title, a.URL, nullIfEmpty(a.ThumbnailURL), formatTimePtr(a.PublishedDate),
+				formatTimePtr(a.DiscoveredDate), a.IsRead, a.IsStarred, nullIfEmpty(a.Content))
+			if err == nil && a.Newsletter != nil {
+				var id int64
+				if id, err = res.LastInsertId(); err == nil {
+					err = importNewsletter(tx, id, a.Newsletter)
+				}
+			}
+			result.ArticlesAdded++
+		case err == nil && (isRead != a.IsRead || isStarred != a.IsStarred):
+			_, err = tx.Exec(`UPDATE articles SET is_read = ?, is_starred = ? WHERE url = ?`, a.IsRead, a.IsStarred, a.URL)
+			result.ArticlesUpdated++
+		}
+		if err != nil {
+			return ImportResult{}, fmt.Errorf("import article %s: %w", a.URL, err)
+		}
+	}
+
+	for _, d := range exp.DeletedArticles {
+		if d.URL == "" {
+			return ImportResult{}, fmt.Errorf("%w: deleted article with empty URL", ErrInvalidExport)
+		}
+		var blogID *int64
+		if id, ok := blogIDs[d.BlogURL]; ok {
+			blogID = &id
+		}
+		if _, err = tx.Exec(`INSERT OR IGNORE INTO deleted_articles (url, blog_id, deleted_at) VALUES (?, ?, ?)`,
+			d.URL, blogID, d.DeletedAt.UTC().Format(sqliteTimeLayout)); err != nil {
+			return ImportResult{}, fmt.Errorf("import deleted article %s: %w", d.URL, err)
+		}
+	}
+
+	for key, value := range exp.Settings {
+		if _, err = tx.Exec(`INSERT INTO settings (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value`, key, value); err != nil {
+			return ImportResult{}, fmt.Errorf("import setting %s: %w", key, err)
+		}
+		result.Settings++
+	}
+
+	if err = tx.Commit(); err != nil {
+		return ImportResult{}, err
+	}
+	return result, nil
+}
+
+// importNewsletter stores the links and parts of a newly imported article.
+func importNewsletter(tx *sql.Tx, articleID int64, n *ExportedNewsletter) error {
+	if n.WebURL != "" || n.UnsubscribeURL != "" || n.UnsubscribeMailto != "" {
+		if _, err := tx.Exec(`INSERT INTO newsletter_meta (article_id, web_url, unsubscribe_url, unsubscribe_mailto) VALUES (?, ?, ?, ?)`,
+			articleID, n.WebURL, n.UnsubscribeURL, n.UnsubscribeMailto); err != nil {
+			return fmt.Errorf("links: %w", err)
+		}
+	}
+	for _, p := range n.Parts {
+		data := p.Data
+		if data == nil {
+			data = []byte{}
+		}
+		if _, err := tx.Exec(`INSERT INTO newsletter_parts (article_id, content_id, filename, content_type, inline, size, data) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			articleID, p.ContentID, p.Filename, p.ContentType, p.Inline, len(data), data); err != nil {
+			return fmt.Errorf("part %q: %w", p.Filename, err)
+		}
+	}
+	return nil
+}
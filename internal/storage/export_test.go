@@ -0,0 +1,173 @@
+// ABOUTME: Tests for the JSON export and import of blogs, articles, read state and settings.
+// ABOUTME: Round-trips data between two databases and checks merges into existing data.
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/model"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	src := openTestDB(t)
+	defer src.Close()
+
+	blog, err := src.AddBlog(model.Blog{Name: "Go", URL: "https://go.dev/blog", FeedURL: "https://go.dev/blog/feed.atom"})
+	if err != nil {
+		t.Fatalf("add blog: %v", err)
+	}
+	published := time.Date(2024, 5, 6, 10, 0, 0, 0, time.UTC)
+	if _, err := src.AddArticlesBulk([]model.Article{
+		{BlogID: blog.ID, Title: "Read post", URL: "https://go.dev/blog/read", PublishedDate: &published, IsRead: true},
+		{BlogID: blog.ID, Title: "Unread post", URL: "https://go.dev/blog/unread"},
+	}); err != nil {
+		t.Fatalf("add articles: %v", err)
+	}
+	if err := src.SetSetting("newsletter_inbox_email", "inbox@example.com"); err != nil {
+		t.Fatalf("set setting: %v", err)
+	}
+	if err := src.SetSetting("imap_password", "hunter2"); err != nil {
+		t.Fatalf("set setting: %v", err)
+	}
+
+	exp, err := src.Export(context.Background(), ExportOptions{})
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	if len(exp.Blogs) != 1 || len(exp.Articles) != 2 || exp.Settings["newsletter_inbox_email"] != "inbox@example.com" {
+		t.Fatalf("export = %+v", exp)
+	}
+	if _, ok := exp.Settings["imap_password"]; ok {
+		t.Error("export without secrets includes the IMAP password")
+	}
+	withSecrets, err := src.Export(context.Background(), ExportOptions{IncludeSecrets: true})
+	if err != nil || withSecrets.Settings["imap_password"] != "hunter2" {
+		t.Errorf("export with secrets: settings = %v, err = %v", withSecrets.Settings, err)
+	}
+
+	dst := openTestDB(t)
+	defer dst.Close()
+	result, err := dst.Import(exp)
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if result.BlogsAdded != 1 || result.ArticlesAdded != 2 || result.Settings != 1 {
+		t.Errorf("result = %+v", result)
+	}
+
+	imported, err := dst.GetArticleByURL("https://go.dev/blog/read")
+	if err != nil || imported == nil {
+		t.Fatalf("imported article: %v", err)
+	}
+	if !imported.IsRead || imported.PublishedDate == nil || !imported.PublishedDate.Equal(published) {
+		t.Errorf("imported article = %+v, want read with published date", imported)
+	}
+	if b, _ := dst.GetBlogByID(imported.BlogID); b == nil || b.URL != blog.URL {
+		t.Errorf("article blog = %+v, want %s", b, blog.URL)
+	}
+
+	// Importing again adds nothing but carries read state changes across
+	exp.Articles[1].IsRead = true
+	result, err = dst.Import(exp)
+	if err != nil {
+		t.Fatalf("second Import: %v", err)
+	}
+	if result.BlogsAdded != 0 || result.ArticlesAdded != 0 || result.ArticlesUpdated != 1 {
+		t.Errorf("second result = %+v, want only one read state update", result)
+	}
+}
+
+func TestImportRejectsInvalidExport(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	exp := &Export{
+		Format:   ExportFormat,
+		Version:  ExportVersion,
+		Articles: []ExportedArticle{{BlogURL: "https://missing.example.com", Title: "T", URL: "https://missing.example.com/t"}},
+	}
+	if _, err := db.Import(exp); !errors.Is(err, ErrInvalidExport) {
+		t.Errorf("Import with unknown blog = %v, want ErrInvalidExport", err)
+	}
+	if _, err := db.Import(&Export{Format: "something-else"}); !errors.Is(err, ErrInvalidExport) {
+		t.Errorf("Import with wrong format = %v, want ErrInvalidExport", err)
+	}
+	if total, _, _ := db.ArticleCounts(); total != 0 {
+		t.Errorf("failed import left %d articles behind", total)
+	}
+}
+
+func TestExportImportNewsletterData(t *testing.T) {
+	src := openTestDB(t)
+	defer src.Close()
+
+	blog, err := src.GetOrCreateNewsletterBlog("Acme Weekly", "news@acme.com")
+	if err != nil {
+		t.Fatalf("newsletter blog: %v", err)
+	}
+	published := time.Date(2024, 5, 6, 10, 0, 0, 0, time.UTC)
+	meta := model.NewsletterMeta{WebURL: "https://acme.com/issue/1", UnsubscribeURL: "https://acme.com/unsubscribe"}
+	parts := []model.NewsletterPart{
+		{ContentID: "logo@acme", Filename: "logo.png", ContentType: "image/png", Inline: true, Data: []byte{0x89, 'P', 'N', 'G'}},
+		{Filename: "report.pdf", ContentType: "application/pdf", Data: []byte("%PDF-1.4")},
+	}
+	if _, err := src.AddNewsletterArticle(model.Article{BlogID: blog.ID, Title: "Issue 1", URL: "message:<1@acme.com>", PublishedDate: &published}, meta, parts); err != nil {
+		t.Fatalf("add newsletter: %v", err)
+	}
+	if err := src.SetNewsletterAlias(model.NewsletterAliasListID, "weekly.acme.com", blog.ID); err != nil {
+		t.Fatalf("alias: %v", err)
+	}
+	readDays := 30
+	if err := src.SetBlogRetention(BlogRetention{BlogID: blog.ID, ReadDays: &readDays}); err != nil {
+		t.Fatalf("retention: %v", err)
+	}
+	if _, err := src.AddArticlesBulk([]model.Article{{BlogID: blog.ID, Title: "Old", URL: "message:<0@acme.com>", PublishedDate: &published, IsRead: true}}); err != nil {
+		t.Fatalf("add old article: %v", err)
+	}
+	if n, err := src.PruneArticles(&blog.ID, RetentionPolicy{MaxArticles: 1}, time.Now(), false); err != nil || n != 1 {
+		t.Fatalf("PruneArticles = %d, %v; want 1", n, err)
+	}
+
+	exp, err := src.Export(context.Background(), ExportOptions{})
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	dst := openTestDB(t)
+	defer dst.Close()
+	if _, err := dst.Import(exp); err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+
+	article, err := dst.GetArticleByURL("message:<1@acme.com>")
+	if err != nil || article == nil {
+		t.Fatalf("imported newsletter: %v", err)
+	}
+	if got, _ := dst.GetNewsletterMeta(article.ID); got == nil || got.WebURL != meta.WebURL || got.UnsubscribeURL != meta.UnsubscribeURL {
+		t.Errorf("imported links = %+v, want %+v", got, meta)
+	}
+	gotParts, err := dst.ListNewsletterParts(article.ID)
+	if err != nil || len(gotParts) != 2 {
+		t.Fatalf("imported parts = %+v, %v; want 2", gotParts, err)
+	}
+	logo, err := dst.GetNewsletterPartByContentID(article.ID, "logo@acme")
+	if err != nil || logo == nil || !logo.Inline || string(logo.Data) != string(parts[0].Data) {
+		t.Errorf("imported inline image = %+v, %v", logo, err)
+	}
+	if got, _ := dst.GetBlogRetention(article.BlogID); got.ReadDays == nil || *got.ReadDays != 30 {
+		t.Errorf("imported retention = %+v, want 30 read days", got)
+	}
+	if aliases, _ := dst.ListNewsletterAliases(); len(aliases) != 1 || aliases[0].BlogID != article.BlogID {
+		t.Errorf("imported aliases = %+v", aliases)
+	}
+	if existing, _ := dst.GetExistingArticleURLs([]string{"message:<0@acme.com>"}); len(existing) != 1 {
+		t.Error("pruned URL was not imported")
+	}
+
+	// A version 1 document, without any of this, still imports
+	if _, err := dst.Import(&Export{Format: ExportFormat, Version: 1}); err != nil {
+		t.Errorf("Import of version 1: %v", err)
+	}
+}
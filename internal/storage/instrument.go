@@ -0,0 +1,51 @@
+// ABOUTME: Wraps the SQL connection to record statement timings in the metrics registry.
+// ABOUTME: Statements are labelled by kind (select, insert, ...) to keep label cardinality low.
+package storage
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/metrics"
+)
+
+// timedDB is a *sql.DB whose Exec, Query and QueryRow are timed. Query time
+// covers executing the statement, not iterating the returned rows, and
+// statements inside transactions are not timed individually.
+type timedDB struct {
+	*sql.DB
+}
+
+func (c timedDB) Exec(query string, args ...any) (sql.Result, error) {
+	defer observeStatement(query, time.Now())
+	return c.DB.Exec(query, args...)
+}
+
+func (c timedDB) Query(query string, args ...any) (*sql.Rows, error) {
+	defer observeStatement(query, time.Now())
+	return c.DB.Query(query, args...)
+}
+
+func (c timedDB) QueryRow(query string, args ...any) *sql.Row {
+	defer observeStatement(query, time.Now())
+	return c.DB.QueryRow(query, args...)
+}
+
+func observeStatement(query string, start time.Time) {
+	metrics.DBQueryDuration.Observe(metrics.Since(start), statementKind(query))
+}
+
+// statementKind returns the lower-cased leading keyword of a SQL statement
+// when it is one of the common DML verbs, and "other" otherwise.
+func statementKind(query string) string {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return "other"
+	}
+	switch kind := strings.ToLower(fields[0]); kind {
+	case "select", "insert", "update", "delete":
+		return kind
+	}
+	return "other"
+}
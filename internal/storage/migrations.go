@@ -0,0 +1,462 @@
+// ABOUTME: Numbered schema migrations recorded in the schema_migrations table.
+// ABOUTME: Adopts databases created by the blogwatcher CLI and backs up the file before migrating.
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// migration is one numbered schema change. Versions are consecutive from 1
+// and never reused; add new migrations to the end of the list.
+type migration struct {
+	version int
+	name    string
+	// present reports whether the change already exists in a database that
+	// predates schema_migrations, so it can be recorded without running up.
+	// Only the migrations that existed before version tracking need it.
+	present func(db *Database) bool
+	up      func(tx *sql.Tx) error
+}
+
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "base schema",
+		present: func(db *Database) bool { return db.tableExists("blogs") && db.tableExists("articles") },
+		up:      createBaseSchema,
+	},
+	{
+		version: 2,
+		name:    "articles.thumbnail_url",
+		present: func(db *Database) bool { return db.columnExists("articles", "thumbnail_url") },
+		up:      execMigration(`ALTER TABLE articles ADD COLUMN thumbnail_url TEXT`),
+	},
+	{
+		version: 3,
+		name:    "nullable articles.blog_id",
+		present: func(db *Database) bool { return !db.columnIsNotNull("articles", "blog_id") },
+		up:      migrateBlogIDToNullable,
+	},
+	{
+		version: 4,
+		name:    "blogs.type",
+		present: func(db *Database) bool { return db.columnExists("blogs", "type") },
+		up:      execMigration(`ALTER TABLE blogs ADD COLUMN type TEXT NOT NULL DEFAULT 'rss'`),
+	},
+	{
+		version: 5,
+		name:    "articles.content",
+		present: func(db *Database) bool { return db.columnExists("articles", "content") },
+		up:      execMigration(`ALTER TABLE articles ADD COLUMN content TEXT`),
+	},
+	{
+		version: 6,
+		name:    "settings table",
+		present: func(db *Database) bool { return db.tableExists("settings") },
+		up: execMigration(`CREATE TABLE settings (
+			key   TEXT PRIMARY KEY,
+			value TEXT NOT NULL
+		)`),
+	},
+	{
+		version: 7,
+		name:    "articles_fts title search",
+		present: func(db *Database) bool { return db.tableExists("articles_fts") },
+		up:      createArticlesFTS,
+	},
+	{
+		version: 8,
+		name:    "articles.is_starred",
+		up:      execMigration(`ALTER TABLE articles ADD COLUMN is_starred BOOLEAN NOT NULL DEFAULT FALSE`),
+	},
+	{
+		version: 9,
+		name:    "retention overrides and deleted article URLs",
+		up: execMigration(
+			`CREATE TABLE blog_retention (
+				blog_id INTEGER PRIMARY KEY REFERENCES blogs(id) ON DELETE CASCADE,
+				read_days INTEGER,
+				max_articles INTEGER
+			)`,
+			`CREATE TABLE deleted_articles (
+				url TEXT PRIMARY KEY,
+				blog_id INTEGER,
+				deleted_at TEXT NOT NULL
+			)`,
+			`CREATE INDEX deleted_articles_blog_id ON deleted_articles(blog_id)`,
+		),
+	},
+	{
+		version: 10,
+		name:    "indexed article sort date",
+		// Undated articles (some newsletters) sort as '' so they come last
+		// and cursor comparisons never meet a NULL
+		up: execMigration(
+			`ALTER TABLE articles ADD COLUMN sort_date TEXT
+				GENERATED ALWAYS AS (COALESCE(published_date, discovered_date, '')) VIRTUAL`,
+			`CREATE INDEX articles_sort_date ON articles(sort_date, id)`,
+			`CREATE INDEX articles_read_sort_date ON articles(is_read, sort_date, id)`,
+			`CREATE INDEX articles_blog_sort_date ON articles(blog_id, sort_date, id)`,
+		),
+	},
+	{
+		version: 11,
+		name:    "newsletter inline images and attachments",
+		up: execMigration(
+			`CREATE TABLE newsletter_parts (
+				id INTEGER PRIMARY KEY,
+				article_id INTEGER NOT NULL REFERENCES articles(id) ON DELETE CASCADE,
+				content_id TEXT NOT NULL DEFAULT '',
+				filename TEXT NOT NULL,
+				content_type TEXT NOT NULL,
+				inline BOOLEAN NOT NULL DEFAULT FALSE,
+				size INTEGER NOT NULL,
+				data BLOB NOT NULL
+			)`,
+			`CREATE INDEX newsletter_parts_article_id ON newsletter_parts(article_id, content_id)`,
+		),
+	},
+	{
+		version: 12,
+		name:    "newsletter web and unsubscribe links",
+		up: execMigration(`CREATE TABLE newsletter_meta (
+			article_id INTEGER PRIMARY KEY REFERENCES articles(id) ON DELETE CASCADE,
+			web_url TEXT NOT NULL DEFAULT '',
+			unsubscribe_url TEXT NOT NULL DEFAULT '',
+			unsubscribe_mailto TEXT NOT NULL DEFAULT ''
+		)`),
+	},
+	{
+		version: 13,
+		name:    "newsletter sender aliases",
+		up: execMigration(`CREATE TABLE newsletter_aliases (
+			kind TEXT NOT NULL,
+			value TEXT NOT NULL,
+			blog_id INTEGER NOT NULL REFERENCES blogs(id) ON DELETE CASCADE,
+			PRIMARY KEY (kind, value)
+		)`),
+	},
+	{
+		version: 14,
+		name:    "newsletter quarantine",
+		up: execMigration(
+			`CREATE TABLE newsletter_quarantine (
+				id INTEGER PRIMARY KEY,
+				message_id TEXT NOT NULL UNIQUE,
+				sender TEXT NOT NULL,
+				subject TEXT NOT NULL,
+				reason TEXT NOT NULL,
+				received_at TEXT NOT NULL,
+				raw BLOB NOT NULL
+			)`,
+			`CREATE INDEX newsletter_quarantine_received_at ON newsletter_quarantine(received_at)`,
+		),
+	},
+}
+
+// execMigration returns a migration step that runs the given statements in order.
+func execMigration(statements ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, stmt := range statements {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// createBaseSchema creates the blogs and articles tables.
+// Schema matches the blogwatcher CLI for full compatibility.
+func createBaseSchema(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS blogs (
+			id INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			url TEXT NOT NULL UNIQUE,
+			feed_url TEXT,
+			scrape_selector TEXT,
+			last_scanned TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS articles (
+			id INTEGER PRIMARY KEY,
+			blog_id INTEGER,
+			title TEXT NOT NULL,
+			url TEXT NOT NULL UNIQUE,
+			published_date TIMESTAMP,
+			discovered_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			is_read BOOLEAN DEFAULT FALSE,
+			thumbnail_url TEXT,
+			FOREIGN KEY (blog_id) REFERENCES blogs(id)
+		);
+	`)
+	return err
+}
+
+// migrateBlogIDToNullable recreates articles table with nullable blog_id column.
+// SQLite does not support ALTER COLUMN, so we must recreate the table.
+func migrateBlogIDToNullable(tx *sql.Tx) error {
+	// Create new table with nullable blog_id (matching existing schema order)
+	if _, err := tx.Exec(`CREATE TABLE articles_new (
+		id INTEGER PRIMARY KEY,
+		blog_id INTEGER,
+		title TEXT NOT NULL,
+		url TEXT NOT NULL UNIQUE,
+		published_date TIMESTAMP,
+		discovered_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		is_read BOOLEAN DEFAULT FALSE,
+		thumbnail_url TEXT,
+		FOREIGN KEY (blog_id) REFERENCES blogs(id)
+	)`); err != nil {
+		return fmt.Errorf("create articles_new: %w", err)
+	}
+
+	// Copy data from old table using explicit column list
+	if _, err := tx.Exec(`INSERT INTO articles_new
+		(id, blog_id, title, url, published_date, discovered_date, is_read, thumbnail_url)
+		SELECT id, blog_id, title, url, published_date, discovered_date, is_read, thumbnail_url
+		FROM articles`); err != nil {
+		return fmt.Errorf("copy data: %w", err)
+	}
+
+	// Drop FTS5 triggers and table (they reference the old table); the
+	// articles_fts migration recreates them
+	for _, stmt := range []string{
+		`DROP TRIGGER IF EXISTS articles_ai`,
+		`DROP TRIGGER IF EXISTS articles_au`,
+		`DROP TRIGGER IF EXISTS articles_ad`,
+		`DROP TABLE IF EXISTS articles_fts`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	// Drop old table and rename new table
+	if _, err := tx.Exec(`DROP TABLE articles`); err != nil {
+		return fmt.Errorf("drop old table: %w", err)
+	}
+	if _, err := tx.Exec(`ALTER TABLE articles_new RENAME TO articles`); err != nil {
+		return fmt.Errorf("rename table: %w", err)
+	}
+	return nil
+}
+
+// createArticlesFTS adds the FTS5 index on article titles, the triggers that
+// keep it in sync, and indexes existing articles.
+func createArticlesFTS(tx *sql.Tx) error {
+	steps := []struct{ name, sql string }{
+		// External content table backed by articles
+		{"articles_fts", `CREATE VIRTUAL TABLE articles_fts USING fts5(
+			title,
+			content='articles',
+			content_rowid='id'
+		)`},
+		{"articles_ai trigger", `CREATE TRIGGER articles_ai AFTER INSERT ON articles BEGIN
+			INSERT INTO articles_fts(rowid, title) VALUES (new.id, new.title);
+		END`},
+		// Delete the old entry first, then insert the new one
+		{"articles_au trigger", `CREATE TRIGGER articles_au AFTER UPDATE ON articles BEGIN
+			INSERT INTO articles_fts(articles_fts, rowid, title) VALUES('delete', old.id, old.title);
+			INSERT INTO articles_fts(rowid, title) VALUES (new.id, new.title);
+		END`},
+		{"articles_ad trigger", `CREATE TRIGGER articles_ad AFTER DELETE ON articles BEGIN
+			INSERT INTO articles_fts(articles_fts, rowid, title) VALUES('delete', old.id, old.title);
+		END`},
+		{"articles_fts contents", `INSERT INTO articles_fts(rowid, title) SELECT id, title FROM articles`},
+	}
+	for _, step := range steps {
+		if _, err := tx.Exec(step.sql); err != nil {
+			return fmt.Errorf("failed to create %s: %w", step.name, err)
+		}
+	}
+	return nil
+}
+
+// Migration states reported by MigrationStatus.
+const (
+	MigrationApplied = "applied"
+	// MigrationPresent means the change exists in a database created before
+	// version tracking; migrating only records it.
+	MigrationPresent = "present"
+	MigrationPending = "pending"
+)
+
+// MigrationInfo describes one schema migration and whether it has been applied.
+type MigrationInfo struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	State     string     `json:"state"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+// MigrateResult reports what Migrate did.
+type MigrateResult struct {
+	// Applied lists the migrations run or recorded, oldest first, with the
+	// state each was in beforehand: pending, or present when only recorded.
+	Applied []MigrationInfo
+	// BackupPath is the copy of the database taken before migrating, if any.
+	BackupPath string
+}
+
+// ensureMigrationsTable creates schema_migrations if it does not exist.
+func (db *Database) ensureMigrationsTable() error {
+	_, err := db.conn.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TEXT NOT NULL
+	)`)
+	return err
+}
+
+// appliedMigrations returns when each recorded migration was applied, keyed by
+// version. A database without schema_migrations has none.
+func (db *Database) appliedMigrations() (map[int]time.Time, error) {
+	applied := make(map[int]time.Time)
+	if !db.tableExists("schema_migrations") {
+		return applied, nil
+	}
+	rows, err := db.conn.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		var appliedAt string
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		t, _ := time.Parse(sqliteTimeLayout, appliedAt)
+		applied[version] = t
+	}
+	return applied, rows.Err()
+}
+
+// MigrationStatus lists every migration this build knows about, oldest first,
+// without changing the database. Use it for dry runs.
+func (db *Database) MigrationStatus() ([]MigrationInfo, error) {
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	// Probes are meaningless against an empty database
+	hasSchema := db.tableExists("blogs")
+	status := make([]MigrationInfo, 0, len(migrations))
+	for _, m := range migrations {
+		info := MigrationInfo{Version: m.version, Name: m.name, State: MigrationPending}
+		if t, ok := applied[m.version]; ok {
+			info.State = MigrationApplied
+			info.AppliedAt = &t
+		} else if hasSchema && m.present != nil && m.present(db) {
+			info.State = MigrationPresent
+		}
+		status = append(status, info)
+	}
+	return status, nil
+}
+
+// SchemaVersion reports the highest applied migration and the latest one this
+// build knows about. The database is up to date when they are equal.
+func (db *Database) SchemaVersion() (current, latest int, err error) {
+	latest = migrations[len(migrations)-1].version
+	if !db.tableExists("schema_migrations") {
+		return 0, latest, nil
+	}
+	err = db.conn.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	return current, latest, err
+}
+
+// Migrate applies pending migrations, each in its own transaction together
+// with its schema_migrations row. When backup is set and the database already
+// holds a schema, the file is first copied next to itself (see BackupTo).
+func (db *Database) Migrate(backup bool) (MigrateResult, error) {
+	var result MigrateResult
+
+	status, err := db.MigrationStatus()
+	if err != nil {
+		return result, err
+	}
+	var pending []MigrationInfo
+	for _, info := range status {
+		if info.State != MigrationApplied {
+			pending = append(pending, info)
+		}
+	}
+	if len(pending) == 0 {
+		return result, nil
+	}
+
+	if backup && db.tableExists("blogs") {
+		result.BackupPath = fmt.Sprintf("%s.pre-v%d-%s.bak",
+			db.path, pending[len(pending)-1].Version, time.Now().UTC().Format("20060102T150405"))
+		if err := db.BackupTo(result.BackupPath); err != nil {
+			return MigrateResult{}, fmt.Errorf("pre-migration backup: %w", err)
+		}
+		slog.Info("database backed up before migrating", "path", result.BackupPath)
+	}
+
+	if err := db.ensureMigrationsTable(); err != nil {
+		return result, fmt.Errorf("create schema_migrations: %w", err)
+	}
+
+	for _, info := range pending {
+		m := migrations[info.Version-1]
+		// Re-check presence: an earlier step (nullable blog_id) can remove
+		// what a later one detected.
+		run := m.present == nil || !m.present(db)
+		if err := db.applyMigration(m, run); err != nil {
+			return result, fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+		}
+		if !run {
+			info.State = MigrationPresent
+		}
+		result.Applied = append(result.Applied, info)
+		if run {
+			slog.Info("applied schema migration", "version", m.version, "name", m.name)
+		} else {
+			slog.Debug("recorded existing schema migration", "version", m.version, "name", m.name)
+		}
+	}
+	return result, nil
+}
+
+// applyMigration records m in schema_migrations, running its up step first
+// when run is set, all in one transaction.
+func (db *Database) applyMigration(m migration, run bool) (err error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if run {
+		if err = m.up(tx); err != nil {
+			return err
+		}
+	}
+	if _, err = tx.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+		m.version, m.name, time.Now().UTC().Format(sqliteTimeLayout)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// BackupTo writes a consistent copy of the database to path using VACUUM INTO.
+// path must not already exist. The copy is made on a reader connection, so
+// writes carry on while it runs.
+func (db *Database) BackupTo(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("backup %s already exists", path)
+	}
+	_, err := db.reader.Exec(`VACUUM INTO ?`, path)
+	return err
+}
@@ -0,0 +1,143 @@
+// ABOUTME: Tests for numbered schema migrations and adoption of blogwatcher CLI databases.
+// ABOUTME: Legacy databases are built with raw SQL before being opened through the migrator.
+package storage
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/model"
+)
+
+// createLegacyDB writes a database in the original blogwatcher CLI layout:
+// blog_id NOT NULL and none of the columns or tables added since.
+func createLegacyDB(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "blogwatcher.db")
+	conn, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("open legacy db: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Exec(`
+		CREATE TABLE blogs (
+			id INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			url TEXT NOT NULL UNIQUE,
+			feed_url TEXT,
+			scrape_selector TEXT,
+			last_scanned TIMESTAMP
+		);
+		CREATE TABLE articles (
+			id INTEGER PRIMARY KEY,
+			blog_id INTEGER NOT NULL,
+			title TEXT NOT NULL,
+			url TEXT NOT NULL UNIQUE,
+			published_date TIMESTAMP,
+			discovered_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			is_read BOOLEAN DEFAULT FALSE,
+			FOREIGN KEY (blog_id) REFERENCES blogs(id)
+		);
+		INSERT INTO blogs (id, name, url) VALUES (1, 'Legacy', 'https://legacy.example.com');
+		INSERT INTO articles (blog_id, title, url) VALUES (1, 'Old post', 'https://legacy.example.com/old');
+	`); err != nil {
+		t.Fatalf("create legacy schema: %v", err)
+	}
+	return path
+}
+
+func TestMigrateLegacyCLIDatabase(t *testing.T) {
+	path := createLegacyDB(t)
+
+	db, err := OpenDatabase(path)
+	if err != nil {
+		t.Fatalf("open legacy database: %v", err)
+	}
+	defer db.Close()
+
+	current, latest, err := db.SchemaVersion()
+	if err != nil || current != latest {
+		t.Fatalf("SchemaVersion = %d of %d, %v; want fully migrated", current, latest, err)
+	}
+	status, err := db.MigrationStatus()
+	if err != nil {
+		t.Fatalf("MigrationStatus: %v", err)
+	}
+	for _, m := range status {
+		if m.State != MigrationApplied || m.AppliedAt == nil {
+			t.Errorf("migration %d (%s) = %s, want applied", m.Version, m.Name, m.State)
+		}
+	}
+
+	// Existing rows survive the table rebuild and are searchable
+	articles, total, err := db.SearchArticles(model.SearchOptions{SearchQuery: "Old"})
+	if err != nil || total != 1 || articles[0].BlogName != "Legacy" {
+		t.Errorf("search after migration = %+v (total %d), %v", articles, total, err)
+	}
+
+	backups, _ := filepath.Glob(path + ".pre-v*.bak")
+	if len(backups) != 1 {
+		t.Fatalf("backups = %v, want one pre-migration backup", backups)
+	}
+	backup, err := OpenDatabaseWithOptions(backups[0], OpenOptions{SkipMigrations: true})
+	if err != nil {
+		t.Fatalf("open backup: %v", err)
+	}
+	defer backup.Close()
+	if !backup.columnIsNotNull("articles", "blog_id") || backup.tableExists("schema_migrations") {
+		t.Error("backup should hold the database as it was before migrating")
+	}
+}
+
+func TestMigrationStatusDoesNotChangeDatabase(t *testing.T) {
+	path := createLegacyDB(t)
+
+	db, err := OpenDatabaseWithOptions(path, OpenOptions{SkipMigrations: true})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+
+	status, err := db.MigrationStatus()
+	if err != nil {
+		t.Fatalf("MigrationStatus: %v", err)
+	}
+	var states []string
+	for _, m := range status {
+		states = append(states, m.State)
+	}
+	// Base schema exists; thumbnail_url and the nullable blog_id do not
+	if got := strings.Join(states[:3], ","); got != "present,pending,pending" {
+		t.Errorf("first states = %s, want present,pending,pending", got)
+	}
+	if current, _, err := db.SchemaVersion(); err != nil || current != 0 {
+		t.Errorf("SchemaVersion = %d, %v; want 0", current, err)
+	}
+	if db.tableExists("schema_migrations") || db.columnExists("articles", "thumbnail_url") {
+		t.Error("status must not modify the database")
+	}
+}
+
+func TestFreshDatabaseNeedsNoBackup(t *testing.T) {
+	dir := t.TempDir()
+	db, err := OpenDatabase(filepath.Join(dir, "blogwatcher.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".bak") {
+			t.Errorf("unexpected backup %s for a new database", e.Name())
+		}
+	}
+
+	result, err := db.Migrate(true)
+	if err != nil || len(result.Applied) != 0 || result.BackupPath != "" {
+		t.Errorf("second Migrate = %+v, %v; want nothing to do", result, err)
+	}
+}
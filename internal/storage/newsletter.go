@@ -0,0 +1,119 @@
+// ABOUTME: Storage for newsletter metadata, inline images and attachments, kept next to their article.
+// ABOUTME: A newsletter and its parts are inserted in one transaction; parts go when the article does.
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/model"
+)
+
+// AddNewsletterArticle inserts a newsletter article together with its links
+// and its inline images and attachments. Returns the article's ID.
+func (db *Database) AddNewsletterArticle(article model.Article, meta model.NewsletterMeta, parts []model.NewsletterPart) (int64, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	result, err := tx.Exec(insertArticleSQL, articleInsertArgs(article)...)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	if meta != (model.NewsletterMeta{ArticleID: meta.ArticleID}) {
+		if _, err := tx.Exec(`INSERT INTO newsletter_meta (article_id, web_url, unsubscribe_url, unsubscribe_mailto) VALUES (?, ?, ?, ?)`,
+			id, meta.WebURL, meta.UnsubscribeURL, meta.UnsubscribeMailto); err != nil {
+			return 0, fmt.Errorf("store metadata: %w", err)
+		}
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO newsletter_parts (article_id, content_id, filename, content_type, inline, size, data) VALUES (?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+	for _, p := range parts {
+		data := p.Data
+		if data == nil {
+			data = []byte{}
+		}
+		if _, err := stmt.Exec(id, p.ContentID, p.Filename, p.ContentType, p.Inline, len(data), data); err != nil {
+			return 0, fmt.Errorf("store part %q: %w", p.Filename, err)
+		}
+	}
+	return id, tx.Commit()
+}
+
+// GetNewsletterMeta returns a newsletter's links, or nil if it has none.
+func (db *Database) GetNewsletterMeta(articleID int64) (*model.NewsletterMeta, error) {
+	meta := model.NewsletterMeta{ArticleID: articleID}
+	err := db.reader.QueryRow(
+		`SELECT web_url, unsubscribe_url, unsubscribe_mailto FROM newsletter_meta WHERE article_id = ?`,
+		articleID,
+	).Scan(&meta.WebURL, &meta.UnsubscribeURL, &meta.UnsubscribeMailto)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// ListNewsletterParts returns an article's parts in message order, without
+// their data.
+func (db *Database) ListNewsletterParts(articleID int64) ([]model.NewsletterPart, error) {
+	rows, err := db.reader.Query(
+		`SELECT id, article_id, content_id, filename, content_type, inline, size FROM newsletter_parts WHERE article_id = ? ORDER BY id`,
+		articleID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var parts []model.NewsletterPart
+	for rows.Next() {
+		var p model.NewsletterPart
+		if err := rows.Scan(&p.ID, &p.ArticleID, &p.ContentID, &p.Filename, &p.ContentType, &p.Inline, &p.Size); err != nil {
+			return nil, err
+		}
+		parts = append(parts, p)
+	}
+	return parts, rows.Err()
+}
+
+// GetNewsletterPart returns one part of an article with its data, or nil if
+// the article has no such part.
+func (db *Database) GetNewsletterPart(articleID, partID int64) (*model.NewsletterPart, error) {
+	return db.getNewsletterPart(`article_id = ? AND id = ?`, articleID, partID)
+}
+
+// GetNewsletterPartByContentID returns the part an article's body references
+// as cid:contentID, or nil if there is none.
+func (db *Database) GetNewsletterPartByContentID(articleID int64, contentID string) (*model.NewsletterPart, error) {
+	return db.getNewsletterPart(`article_id = ? AND content_id = ? AND content_id != ''`, articleID, contentID)
+}
+
+func (db *Database) getNewsletterPart(where string, args ...interface{}) (*model.NewsletterPart, error) {
+	var p model.NewsletterPart
+	err := db.reader.QueryRow(
+		`SELECT id, article_id, content_id, filename, content_type, inline, size, data FROM newsletter_parts WHERE `+where+` ORDER BY id LIMIT 1`,
+		args...,
+	).Scan(&p.ID, &p.ArticleID, &p.ContentID, &p.Filename, &p.ContentType, &p.Inline, &p.Size, &p.Data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
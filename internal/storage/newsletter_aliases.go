@@ -0,0 +1,176 @@
+// ABOUTME: Newsletter aliases route mail from several sender addresses, or with one List-Id, to a single newsletter blog.
+// ABOUTME: Also merges one newsletter blog into another, carrying its articles and aliases along.
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/model"
+)
+
+// ErrNotNewsletterBlog is returned when an alias or merge names a blog that
+// does not exist or is not a newsletter.
+var ErrNotNewsletterBlog = errors.New("not a newsletter blog")
+
+// ResolveNewsletterBlog returns the blog a newsletter from senderEmail with
+// the given List-Id (empty when it has none) belongs to. A sender alias wins
+// over a List-Id, which wins over the sender's own blog; that is created
+// when nothing matches. The first newsletter with a List-Id binds the List-Id
+// to the blog it landed in, so later issues sent from other addresses follow.
+func (db *Database) ResolveNewsletterBlog(name, senderEmail, listID string) (model.Blog, error) {
+	blog, err := db.newsletterAliasBlog(model.NewsletterAliasSender, strings.ToLower(senderEmail))
+	if err != nil {
+		return model.Blog{}, err
+	}
+	if blog == nil && listID != "" {
+		if blog, err = db.newsletterAliasBlog(model.NewsletterAliasListID, listID); err != nil {
+			return model.Blog{}, err
+		}
+	}
+	if blog == nil {
+		created, err := db.GetOrCreateNewsletterBlog(name, senderEmail)
+		if err != nil {
+			return model.Blog{}, err
+		}
+		blog = &created
+	}
+	if listID != "" {
+		if _, err := db.conn.Exec(`INSERT OR IGNORE INTO newsletter_aliases (kind, value, blog_id) VALUES (?, ?, ?)`,
+			model.NewsletterAliasListID, listID, blog.ID); err != nil {
+			return model.Blog{}, fmt.Errorf("bind List-Id: %w", err)
+		}
+	}
+	return *blog, nil
+}
+
+// IsKnownNewsletterSender reports whether newsletters from senderEmail
+// already have a blog, their own or through a sender alias. A List-Id does
+// not count: anyone can put one in a message.
+func (db *Database) IsKnownNewsletterSender(senderEmail string) (bool, error) {
+	var known bool
+	err := db.reader.QueryRow(`SELECT EXISTS (SELECT 1 FROM newsletter_aliases WHERE kind = ? AND value = ?)
+		OR EXISTS (SELECT 1 FROM blogs WHERE type = ? AND url = ? COLLATE NOCASE)`,
+		model.NewsletterAliasSender, strings.ToLower(senderEmail),
+		model.BlogTypeNewsletter, "mailto:"+senderEmail).Scan(&known)
+	return known, err
+}
+
+// newsletterAliasBlog returns the blog an alias points at, or nil if there
+// is no such alias.
+func (db *Database) newsletterAliasBlog(kind, value string) (*model.Blog, error) {
+	row := db.reader.QueryRow(`SELECT b.id, b.name, b.url, b.feed_url, b.scrape_selector, b.last_scanned, b.type
+		FROM newsletter_aliases a JOIN blogs b ON b.id = a.blog_id
+		WHERE a.kind = ? AND a.value = ?`, kind, value)
+	blog, err := scanBlog(row)
+	if err != nil {
+		return nil, fmt.Errorf("lookup newsletter alias: %w", err)
+	}
+	return blog, nil
+}
+
+// ListNewsletterAliases returns every alias with its blog's name, ordered by
+// blog name.
+func (db *Database) ListNewsletterAliases() ([]model.NewsletterAlias, error) {
+	rows, err := db.reader.Query(`SELECT a.kind, a.value, a.blog_id, b.name
+		FROM newsletter_aliases a JOIN blogs b ON b.id = a.blog_id
+		ORDER BY b.name COLLATE NOCASE, a.kind DESC, a.value`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var aliases []model.NewsletterAlias
+	for rows.Next() {
+		var a model.NewsletterAlias
+		if err := rows.Scan(&a.Kind, &a.Value, &a.BlogID, &a.BlogName); err != nil {
+			return nil, err
+		}
+		aliases = append(aliases, a)
+	}
+	return aliases, rows.Err()
+}
+
+// SetNewsletterAlias points a sender address or List-Id at a newsletter
+// blog, replacing any earlier alias for it. Addresses are matched without
+// regard to case.
+func (db *Database) SetNewsletterAlias(kind, value string, blogID int64) error {
+	if kind == model.NewsletterAliasSender {
+		value = strings.ToLower(value)
+	}
+	if kind != model.NewsletterAliasSender && kind != model.NewsletterAliasListID || value == "" {
+		return fmt.Errorf("invalid newsletter alias %s %q", kind, value)
+	}
+	blog, err := db.GetBlogByID(blogID)
+	if err != nil {
+		return err
+	}
+	if blog == nil || blog.Type != model.BlogTypeNewsletter {
+		return ErrNotNewsletterBlog
+	}
+	_, err = db.conn.Exec(`INSERT INTO newsletter_aliases (kind, value, blog_id) VALUES (?, ?, ?)
+		ON CONFLICT(kind, value) DO UPDATE SET blog_id = excluded.blog_id`, kind, value, blogID)
+	return err
+}
+
+// DeleteNewsletterAlias removes an alias. Newsletters it matched go back to
+// their sender's own blog.
+func (db *Database) DeleteNewsletterAlias(kind, value string) error {
+	_, err := db.conn.Exec(`DELETE FROM newsletter_aliases WHERE kind = ? AND value = ?`, kind, value)
+	return err
+}
+
+// MergeNewsletterBlogs moves every article and alias of newsletter blog
+// fromID to intoID and deletes fromID, leaving an alias so newsletters from
+// its sender keep arriving in intoID. Returns how many articles moved.
+func (db *Database) MergeNewsletterBlogs(fromID, intoID int64) (int64, error) {
+	if fromID == intoID {
+		return 0, errors.New("cannot merge a newsletter into itself")
+	}
+	var from *model.Blog
+	for _, id := range []int64{fromID, intoID} {
+		blog, err := db.GetBlogByID(id)
+		if err != nil {
+			return 0, err
+		}
+		if blog == nil || blog.Type != model.BlogTypeNewsletter {
+			return 0, ErrNotNewsletterBlog
+		}
+		if id == fromID {
+			from = blog
+		}
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	result, err := tx.Exec(`UPDATE articles SET blog_id = ? WHERE blog_id = ?`, intoID, fromID)
+	if err != nil {
+		return 0, fmt.Errorf("move articles: %w", err)
+	}
+	moved, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`UPDATE deleted_articles SET blog_id = ? WHERE blog_id = ?`, intoID, fromID); err != nil {
+		return 0, fmt.Errorf("move deleted articles: %w", err)
+	}
+	if _, err := tx.Exec(`UPDATE newsletter_aliases SET blog_id = ? WHERE blog_id = ?`, intoID, fromID); err != nil {
+		return 0, fmt.Errorf("move aliases: %w", err)
+	}
+	if sender, ok := strings.CutPrefix(from.URL, "mailto:"); ok && sender != "" {
+		if _, err := tx.Exec(`INSERT INTO newsletter_aliases (kind, value, blog_id) VALUES (?, ?, ?)
+			ON CONFLICT(kind, value) DO UPDATE SET blog_id = excluded.blog_id`,
+			model.NewsletterAliasSender, strings.ToLower(sender), intoID); err != nil {
+			return 0, fmt.Errorf("alias merged sender: %w", err)
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM blogs WHERE id = ?`, fromID); err != nil {
+		return 0, fmt.Errorf("delete merged blog: %w", err)
+	}
+	return moved, tx.Commit()
+}
@@ -0,0 +1,94 @@
+// ABOUTME: Storage for newsletters held in quarantine: the raw email with its sender, subject and why it was held.
+// ABOUTME: Entries wait for approval or discarding and expire after quarantineRetention.
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/model"
+)
+
+// quarantineRetention is how long a quarantined newsletter is kept before it
+// is dropped unseen.
+const quarantineRetention = 30 * 24 * time.Hour
+
+// QuarantineNewsletter holds raw back for review. A message already in
+// quarantine (same Message-ID) is left as it is. Entries older than
+// quarantineRetention are dropped at the same time.
+func (db *Database) QuarantineNewsletter(q model.QuarantinedNewsletter, raw []byte) error {
+	if q.ReceivedAt.IsZero() {
+		q.ReceivedAt = time.Now()
+	}
+	cutoff := q.ReceivedAt.Add(-quarantineRetention).UTC().Format(sqliteTimeLayout)
+	if _, err := db.conn.Exec(`DELETE FROM newsletter_quarantine WHERE received_at < ?`, cutoff); err != nil {
+		return fmt.Errorf("expire quarantine: %w", err)
+	}
+	_, err := db.conn.Exec(`INSERT OR IGNORE INTO newsletter_quarantine (message_id, sender, subject, reason, received_at, raw)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		q.MessageID, q.Sender, q.Subject, q.Reason, q.ReceivedAt.UTC().Format(sqliteTimeLayout), raw)
+	return err
+}
+
+// ListQuarantinedNewsletters returns the quarantine, newest first, without
+// the emails themselves.
+func (db *Database) ListQuarantinedNewsletters() ([]model.QuarantinedNewsletter, error) {
+	rows, err := db.reader.Query(`SELECT id, message_id, sender, subject, reason, received_at, length(raw)
+		FROM newsletter_quarantine ORDER BY received_at DESC, id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []model.QuarantinedNewsletter
+	for rows.Next() {
+		q, err := scanQuarantined(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, q)
+	}
+	return list, rows.Err()
+}
+
+// GetQuarantinedNewsletter returns a quarantined newsletter and its raw
+// email, or nil if there is none with that ID.
+func (db *Database) GetQuarantinedNewsletter(id int64) (*model.QuarantinedNewsletter, []byte, error) {
+	var raw []byte
+	row := db.reader.QueryRow(`SELECT id, message_id, sender, subject, reason, received_at, length(raw), raw
+		FROM newsletter_quarantine WHERE id = ?`, id)
+	q, err := scanQuarantined(row, &raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return &q, raw, nil
+}
+
+// DeleteQuarantinedNewsletter removes a newsletter from quarantine and
+// reports whether it was there.
+func (db *Database) DeleteQuarantinedNewsletter(id int64) (bool, error) {
+	result, err := db.conn.Exec(`DELETE FROM newsletter_quarantine WHERE id = ?`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func scanQuarantined(scanner interface{ Scan(dest ...any) error }, extra ...any) (model.QuarantinedNewsletter, error) {
+	var q model.QuarantinedNewsletter
+	var receivedAt string
+	dest := append([]any{&q.ID, &q.MessageID, &q.Sender, &q.Subject, &q.Reason, &receivedAt, &q.Size}, extra...)
+	if err := scanner.Scan(dest...); err != nil {
+		return q, err
+	}
+	if parsed, err := parseTime(receivedAt); err == nil {
+		q.ReceivedAt = parsed
+	}
+	return q, nil
+}
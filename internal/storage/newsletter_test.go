@@ -0,0 +1,152 @@
+// ABOUTME: Tests for newsletter storage: inserting links and parts with their article and fetching them back.
+// ABOUTME: Checks that parts are removed along with their article, and sender aliases and merges.
+package storage
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/model"
+)
+
+func TestNewsletterArticleStorage(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	blog, err := db.GetOrCreateNewsletterBlog("Letter", "letter@example.com")
+	if err != nil {
+		t.Fatalf("create blog: %v", err)
+	}
+	logo := []byte("\x89PNG fake")
+	id, err := db.AddNewsletterArticle(
+		model.Article{BlogID: blog.ID, Title: "Issue", URL: "message:<issue@example.com>", Content: `<img src="cid:logo@example.com">`},
+		model.NewsletterMeta{WebURL: "https://letter.example.com/issue", UnsubscribeMailto: "mailto:leave@example.com"},
+		[]model.NewsletterPart{
+			{ContentID: "logo@example.com", Filename: "logo.png", ContentType: "image/png", Inline: true, Data: logo},
+			{Filename: "issue.pdf", ContentType: "application/pdf", Data: []byte("%PDF")},
+		},
+	)
+	if err != nil {
+		t.Fatalf("AddNewsletterArticle: %v", err)
+	}
+	if a, _ := db.GetArticleByID(id); a == nil || a.Title != "Issue" {
+		t.Fatalf("article %d = %+v", id, a)
+	}
+
+	meta, err := db.GetNewsletterMeta(id)
+	if err != nil || meta == nil || meta.WebURL != "https://letter.example.com/issue" || meta.UnsubscribeMailto != "mailto:leave@example.com" {
+		t.Errorf("GetNewsletterMeta = %+v, %v", meta, err)
+	}
+
+	parts, err := db.ListNewsletterParts(id)
+	if err != nil || len(parts) != 2 {
+		t.Fatalf("ListNewsletterParts = %+v, %v", parts, err)
+	}
+	if parts[0].Filename != "logo.png" || !parts[0].Inline || parts[0].Size != int64(len(logo)) || parts[0].Data != nil {
+		t.Errorf("first part = %+v", parts[0])
+	}
+	if parts[1].Filename != "issue.pdf" || parts[1].Inline {
+		t.Errorf("second part = %+v", parts[1])
+	}
+
+	byCID, err := db.GetNewsletterPartByContentID(id, "logo@example.com")
+	if err != nil || byCID == nil || !bytes.Equal(byCID.Data, logo) {
+		t.Fatalf("GetNewsletterPartByContentID = %+v, %v", byCID, err)
+	}
+	if p, _ := db.GetNewsletterPartByContentID(id, ""); p != nil {
+		t.Errorf("empty Content-ID matched %+v", p)
+	}
+	if p, _ := db.GetNewsletterPart(id+1, parts[1].ID); p != nil {
+		t.Errorf("part served for another article: %+v", p)
+	}
+	if p, err := db.GetNewsletterPart(id, parts[1].ID); err != nil || p == nil || string(p.Data) != "%PDF" {
+		t.Errorf("GetNewsletterPart = %+v, %v", p, err)
+	}
+
+	if err := db.DeleteBlogWithArticles(blog.ID); err != nil {
+		t.Fatalf("delete blog: %v", err)
+	}
+	if parts, _ := db.ListNewsletterParts(id); len(parts) != 0 {
+		t.Errorf("%d parts left after deleting the article", len(parts))
+	}
+	if meta, _ := db.GetNewsletterMeta(id); meta != nil {
+		t.Errorf("metadata left after deleting the article: %+v", meta)
+	}
+}
+
+func TestMergeNewsletterBlogs(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	into, err := db.ResolveNewsletterBlog("Acme", "news@acme.com", "")
+	if err != nil {
+		t.Fatalf("create blog: %v", err)
+	}
+	from, err := db.ResolveNewsletterBlog("Acme Mailer", "Mailer@acme.com", "weekly.acme.com")
+	if err != nil {
+		t.Fatalf("create blog: %v", err)
+	}
+	if into.ID == from.ID {
+		t.Fatal("different senders without aliases should get different blogs")
+	}
+	if _, err := db.AddArticlesBulk([]model.Article{
+		{BlogID: from.ID, Title: "One", URL: "message:<one@acme.com>"},
+		{BlogID: from.ID, Title: "Two", URL: "message:<two@acme.com>"},
+	}); err != nil {
+		t.Fatalf("add articles: %v", err)
+	}
+
+	rss, err := db.AddBlog(model.Blog{Name: "Feed", URL: "https://feed.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.MergeNewsletterBlogs(rss.ID, into.ID); err != ErrNotNewsletterBlog {
+		t.Errorf("merging an RSS blog: err = %v, want ErrNotNewsletterBlog", err)
+	}
+	if _, err := db.MergeNewsletterBlogs(into.ID, into.ID); err == nil {
+		t.Error("merging a blog into itself should fail")
+	}
+
+	moved, err := db.MergeNewsletterBlogs(from.ID, into.ID)
+	if err != nil {
+		t.Fatalf("MergeNewsletterBlogs: %v", err)
+	}
+	if moved != 2 {
+		t.Errorf("moved = %d, want 2", moved)
+	}
+	if count, _ := db.GetArticleCountForBlog(into.ID); count != 2 {
+		t.Errorf("articles in merged blog = %d, want 2", count)
+	}
+	if blog, _ := db.GetBlogByID(from.ID); blog != nil {
+		t.Error("merged blog should be deleted")
+	}
+
+	// The merged blog's sender and List-Id now lead to the blog it joined
+	for _, tc := range []struct{ sender, listID string }{{"mailer@acme.com", ""}, {"new@acme.com", "weekly.acme.com"}} {
+		blog, err := db.ResolveNewsletterBlog("x", tc.sender, tc.listID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if blog.ID != into.ID {
+			t.Errorf("%s %s resolved to blog %d, want %d", tc.sender, tc.listID, blog.ID, into.ID)
+		}
+	}
+	aliases, err := db.ListNewsletterAliases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aliases) != 2 || aliases[0].Kind != model.NewsletterAliasSender || aliases[0].Value != "mailer@acme.com" || aliases[0].BlogName != "Acme" {
+		t.Errorf("aliases = %+v", aliases)
+	}
+
+	if err := db.DeleteNewsletterAlias(model.NewsletterAliasSender, "mailer@acme.com"); err != nil {
+		t.Fatal(err)
+	}
+	blog, err := db.ResolveNewsletterBlog("Acme Mailer", "mailer@acme.com", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blog.ID == into.ID {
+		t.Error("without the alias the sender should get its own blog again")
+	}
+}
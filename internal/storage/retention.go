@@ -0,0 +1,203 @@
+// ABOUTME: Article retention: per-blog policy overrides and pruning of old read articles.
+// ABOUTME: Pruned URLs are remembered in deleted_articles so later scans don't re-import them.
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RetentionPolicy limits how many read articles a blog keeps. Zero disables
+// a rule. Unread and starred articles are never pruned.
+type RetentionPolicy struct {
+	// ReadDays removes read articles published (or discovered) more than
+	// this many days ago.
+	ReadDays int `json:"read_days"`
+	// MaxArticles removes the oldest read articles while a blog holds more
+	// than this many articles.
+	MaxArticles int `json:"max_articles"`
+}
+
+// Enabled reports whether any rule is set.
+func (p RetentionPolicy) Enabled() bool {
+	return p.ReadDays > 0 || p.MaxArticles > 0
+}
+
+// BlogRetention is a blog's override of the global policy. A nil field uses
+// the global value; zero disables the rule for that blog.
+type BlogRetention struct {
+	BlogID      int64 `json:"blog_id"`
+	ReadDays    *int  `json:"read_days,omitempty"`
+	MaxArticles *int  `json:"max_articles,omitempty"`
+}
+
+// Apply returns global with this override's fields substituted.
+func (r BlogRetention) Apply(global RetentionPolicy) RetentionPolicy {
+	if r.ReadDays != nil {
+		global.ReadDays = *r.ReadDays
+	}
+	if r.MaxArticles != nil {
+		global.MaxArticles = *r.MaxArticles
+	}
+	return global
+}
+
+// GetBlogRetention returns the override for a blog. A blog without one gets
+// an override with both fields nil.
+func (db *Database) GetBlogRetention(blogID int64) (BlogRetention, error) {
+	r := BlogRetention{BlogID: blogID}
+	var readDays, maxArticles sql.NullInt64
+	err := db.reader.QueryRow(`SELECT read_days, max_articles FROM blog_retention WHERE blog_id = ?`, blogID).
+		Scan(&readDays, &maxArticles)
+	if errors.Is(err, sql.ErrNoRows) {
+		return r, nil
+	}
+	if err != nil {
+		return r, err
+	}
+	r.ReadDays = intPtr(readDays)
+	r.MaxArticles = intPtr(maxArticles)
+	return r, nil
+}
+
+// ListBlogRetention returns every blog override keyed by blog ID.
+func (db *Database) ListBlogRetention() (map[int64]BlogRetention, error) {
+	rows, err := db.reader.Query(`SELECT blog_id, read_days, max_articles FROM blog_retention`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make(map[int64]BlogRetention)
+	for rows.Next() {
+		var (
+			r                     BlogRetention
+			readDays, maxArticles sql.NullInt64
+		)
+		if err := rows.Scan(&r.BlogID, &readDays, &maxArticles); err != nil {
+			return nil, err
+		}
+		r.ReadDays = intPtr(readDays)
+		r.MaxArticles = intPtr(maxArticles)
+		result[r.BlogID] = r
+	}
+	return result, rows.Err()
+}
+
+// SetBlogRetention stores a blog's override, removing it when both fields
+// are nil.
+func (db *Database) SetBlogRetention(r BlogRetention) error {
+	if r.ReadDays == nil && r.MaxArticles == nil {
+		_, err := db.conn.Exec(`DELETE FROM blog_retention WHERE blog_id = ?`, r.BlogID)
+		return err
+	}
+	_, err := db.conn.Exec(`INSERT INTO blog_retention (blog_id, read_days, max_articles) VALUES (?, ?, ?)
+		ON CONFLICT(blog_id) DO UPDATE SET read_days = excluded.read_days, max_articles = excluded.max_articles`,
+		r.BlogID, r.ReadDays, r.MaxArticles)
+	return err
+}
+
+// articleAge orders articles by when they were published, falling back to
+// when they were found. julianday copes with the mixed time formats in
+// databases written by the CLI.
+const articleAge = `julianday(COALESCE(published_date, discovered_date))`
+
+// PruneArticles deletes read, unstarred articles that policy no longer keeps
+// and returns how many matched. blogID nil prunes articles whose blog was
+// deleted, where only ReadDays applies. With dryRun nothing is deleted.
+//
+// Deleted URLs are recorded in deleted_articles so GetExistingArticleURLs
+// keeps reporting them and scans don't bring them back.
+func (db *Database) PruneArticles(blogID *int64, policy RetentionPolicy, now time.Time, dryRun bool) (int, error) {
+	if !policy.Enabled() {
+		return 0, nil
+	}
+
+	where := `is_read = 1 AND is_starred = 0`
+	var args []interface{}
+	if blogID != nil {
+		where += ` AND blog_id = ?`
+		args = append(args, *blogID)
+	} else {
+		where += ` AND blog_id IS NULL`
+	}
+
+	var rules []string
+	if policy.ReadDays > 0 {
+		cutoff := now.AddDate(0, 0, -policy.ReadDays).UTC().Format(sqliteTimeLayout)
+		rules = append(rules, articleAge+` < julianday(?)`)
+		args = append(args, cutoff)
+	}
+	if policy.MaxArticles > 0 && blogID != nil {
+		var total int
+		if err := db.reader.QueryRow(`SELECT COUNT(*) FROM articles WHERE blog_id = ?`, *blogID).Scan(&total); err != nil {
+			return 0, err
+		}
+		// Unread and starred articles count towards the limit but stay, so
+		// the excess comes out of the oldest read ones
+		if excess := total - policy.MaxArticles; excess > 0 {
+			rules = append(rules, `id IN (SELECT id FROM articles WHERE blog_id = ? AND is_read = 1 AND is_starred = 0
+				ORDER BY `+articleAge+` ASC, id ASC LIMIT ?)`)
+			args = append(args, *blogID, excess)
+		}
+	}
+	if len(rules) == 0 {
+		return 0, nil
+	}
+	where += ` AND (` + rules[0]
+	for _, rule := range rules[1:] {
+		where += ` OR ` + rule
+	}
+	where += `)`
+
+	if dryRun {
+		var n int
+		err := db.reader.QueryRow(`SELECT COUNT(*) FROM articles WHERE `+where, args...).Scan(&n)
+		return n, err
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`INSERT OR IGNORE INTO deleted_articles (url, blog_id, deleted_at)
+		SELECT url, blog_id, ? FROM articles WHERE `+where,
+		append([]interface{}{now.UTC().Format(sqliteTimeLayout)}, args...)...); err != nil {
+		_ = tx.Rollback()
+		return 0, fmt.Errorf("record deleted articles: %w", err)
+	}
+	result, err := tx.Exec(`DELETE FROM articles WHERE `+where, args...)
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, fmt.Errorf("delete articles: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+	return int(n), tx.Commit()
+}
+
+// IsArticleDeleted reports whether url belongs to an article retention pruned.
+func (db *Database) IsArticleDeleted(url string) (bool, error) {
+	var deleted bool
+	err := db.reader.QueryRow(`SELECT EXISTS (SELECT 1 FROM deleted_articles WHERE url = ?)`, url).Scan(&deleted)
+	return deleted, err
+}
+
+// CountDeletedArticles returns how many pruned article URLs are remembered.
+func (db *Database) CountDeletedArticles() (int, error) {
+	var n int
+	err := db.reader.QueryRow(`SELECT COUNT(*) FROM deleted_articles`).Scan(&n)
+	return n, err
+}
+
+func intPtr(v sql.NullInt64) *int {
+	if !v.Valid {
+		return nil
+	}
+	n := int(v.Int64)
+	return &n
+}
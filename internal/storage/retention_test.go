@@ -0,0 +1,129 @@
+// ABOUTME: Tests for article retention: which articles pruning removes and the deleted-URL tombstones.
+// ABOUTME: Checks that unread and starred articles survive and pruned URLs stay known to scans.
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/model"
+)
+
+func TestPruneArticlesKeepsUnreadAndStarred(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	blog, err := db.AddBlog(model.Blog{Name: "Blog", URL: "https://blog.example.com"})
+	if err != nil {
+		t.Fatalf("add blog: %v", err)
+	}
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	old := now.AddDate(0, 0, -60)
+	recent := now.AddDate(0, 0, -2)
+	if _, err := db.AddArticlesBulk([]model.Article{
+		{BlogID: blog.ID, Title: "Old read", URL: "https://blog.example.com/old-read", PublishedDate: &old, IsRead: true},
+		{BlogID: blog.ID, Title: "Old unread", URL: "https://blog.example.com/old-unread", PublishedDate: &old},
+		{BlogID: blog.ID, Title: "Old starred", URL: "https://blog.example.com/old-starred", PublishedDate: &old, IsRead: true},
+		{BlogID: blog.ID, Title: "Recent read", URL: "https://blog.example.com/recent-read", PublishedDate: &recent, IsRead: true},
+	}); err != nil {
+		t.Fatalf("add articles: %v", err)
+	}
+	starred, _ := db.GetArticleByURL("https://blog.example.com/old-starred")
+	if ok, err := db.SetArticleStarred(starred.ID, true); !ok || err != nil {
+		t.Fatalf("star article: %v, %v", ok, err)
+	}
+
+	policy := RetentionPolicy{ReadDays: 30}
+	n, err := db.PruneArticles(&blog.ID, policy, now, true)
+	if err != nil || n != 1 {
+		t.Fatalf("dry run = %d, %v; want 1", n, err)
+	}
+	if total, _, _ := db.ArticleCounts(); total != 4 {
+		t.Fatalf("dry run deleted articles, %d left", total)
+	}
+
+	n, err = db.PruneArticles(&blog.ID, policy, now, false)
+	if err != nil || n != 1 {
+		t.Fatalf("prune = %d, %v; want 1", n, err)
+	}
+	if a, _ := db.GetArticleByURL("https://blog.example.com/old-read"); a != nil {
+		t.Error("old read article was kept")
+	}
+	for _, url := range []string{"https://blog.example.com/old-unread", "https://blog.example.com/old-starred", "https://blog.example.com/recent-read"} {
+		if a, _ := db.GetArticleByURL(url); a == nil {
+			t.Errorf("%s was pruned", url)
+		}
+	}
+
+	// A cap of one article leaves only the unread and starred ones
+	n, err = db.PruneArticles(&blog.ID, RetentionPolicy{MaxArticles: 1}, now, false)
+	if err != nil || n != 1 {
+		t.Fatalf("prune by count = %d, %v; want 1", n, err)
+	}
+	if total, _, _ := db.ArticleCounts(); total != 2 {
+		t.Errorf("%d articles left, want the unread and starred ones", total)
+	}
+}
+
+func TestPrunedURLsAreNotReimported(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	blog, err := db.AddBlog(model.Blog{Name: "Blog", URL: "https://blog.example.com"})
+	if err != nil {
+		t.Fatalf("add blog: %v", err)
+	}
+	old := time.Now().AddDate(-1, 0, 0)
+	url := "https://blog.example.com/old"
+	if _, err := db.AddArticlesBulk([]model.Article{{BlogID: blog.ID, Title: "Old", URL: url, PublishedDate: &old, IsRead: true}}); err != nil {
+		t.Fatalf("add article: %v", err)
+	}
+	if _, err := db.PruneArticles(&blog.ID, RetentionPolicy{ReadDays: 7}, time.Now(), false); err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+
+	existing, err := db.GetExistingArticleURLs([]string{url, "https://blog.example.com/new"})
+	if err != nil {
+		t.Fatalf("existing urls: %v", err)
+	}
+	if _, ok := existing[url]; !ok || len(existing) != 1 {
+		t.Errorf("existing = %v, want only the pruned URL", existing)
+	}
+
+	// Deleting the blog forgets its pruned URLs
+	if err := db.DeleteBlogWithArticles(blog.ID); err != nil {
+		t.Fatalf("delete blog: %v", err)
+	}
+	if n, _ := db.CountDeletedArticles(); n != 0 {
+		t.Errorf("%d tombstones left after deleting the blog", n)
+	}
+}
+
+func TestBlogRetentionOverride(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	blog, err := db.AddBlog(model.Blog{Name: "Blog", URL: "https://blog.example.com"})
+	if err != nil {
+		t.Fatalf("add blog: %v", err)
+	}
+	zero := 0
+	if err := db.SetBlogRetention(BlogRetention{BlogID: blog.ID, ReadDays: &zero}); err != nil {
+		t.Fatalf("set override: %v", err)
+	}
+	r, err := db.GetBlogRetention(blog.ID)
+	if err != nil {
+		t.Fatalf("get override: %v", err)
+	}
+	got := r.Apply(RetentionPolicy{ReadDays: 30, MaxArticles: 100})
+	if got != (RetentionPolicy{ReadDays: 0, MaxArticles: 100}) {
+		t.Errorf("Apply = %+v, want read days disabled and the global cap", got)
+	}
+
+	if err := db.SetBlogRetention(BlogRetention{BlogID: blog.ID}); err != nil {
+		t.Fatalf("clear override: %v", err)
+	}
+	if all, _ := db.ListBlogRetention(); len(all) != 0 {
+		t.Errorf("overrides = %v, want none", all)
+	}
+}
@@ -0,0 +1,403 @@
+// ABOUTME: Runs blog syncs as background jobs with per-blog progress tracking.
+// ABOUTME: Only one sync runs at a time; concurrent requests attach to the running job.
+package syncjob
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/events"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/logging"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/scanner"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/storage"
+)
+
+// Job states.
+const (
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+// DefaultTimeout bounds a whole sync so a slow site cannot hold the job open forever.
+const DefaultTimeout = 3 * time.Minute
+
+// maxFinishedJobs is how many completed jobs are kept for status lookups.
+const maxFinishedJobs = 20
+
+// Job is a single sync run. All fields are guarded by the job's mutex; use
+// Snapshot to read them.
+type Job struct {
+	id        string
+	startedAt time.Time
+	done      chan struct{}
+
+	mu         sync.Mutex
+	status     string
+	total      int
+	results    []scanner.ScanResult
+	finishedAt *time.Time
+	err        string
+}
+
+// BlogResult is the outcome of scanning one blog within a job.
+type BlogResult struct {
+	Blog        string `json:"blog"`
+	NewArticles int    `json:"new_articles"`
+	TotalFound  int    `json:"total_found"`
+	Source      string `json:"source,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Snapshot is a point-in-time copy of a job's progress, suitable for JSON.
+type Snapshot struct {
+	ID           string       `json:"id"`
+	Status       string       `json:"status"`
+	StartedAt    time.Time    `json:"started_at"`
+	FinishedAt   *time.Time   `json:"finished_at,omitempty"`
+	BlogsTotal   int          `json:"blogs_total"`
+	BlogsScanned int          `json:"blogs_scanned"`
+	NewArticles  int          `json:"new_articles"`
+	Results      []BlogResult `json:"results"`
+	Errors       []string     `json:"errors,omitempty"`
+	Error        string       `json:"error,omitempty"`
+}
+
+// ID returns the job's identifier.
+func (j *Job) ID() string { return j.id }
+
+// Done is closed when the job finishes.
+func (j *Job) Done() <-chan struct{} { return j.done }
+
+// Wait blocks until the job finishes or ctx is cancelled.
+func (j *Job) Wait(ctx context.Context) error {
+	select {
+	case <-j.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Snapshot returns the job's current progress.
+func (j *Job) Snapshot() Snapshot {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	snap := Snapshot{
+		ID:           j.id,
+		Status:       j.status,
+		StartedAt:    j.startedAt,
+		FinishedAt:   j.finishedAt,
+		BlogsTotal:   j.total,
+		BlogsScanned: len(j.results),
+		Results:      make([]BlogResult, 0, len(j.results)),
+		Error:        j.err,
+	}
+	for _, r := range j.results {
+		snap.Results = append(snap.Results, BlogResult{
+			Blog:        r.BlogName,
+			NewArticles: r.NewArticles,
+			TotalFound:  r.TotalFound,
+			Source:      r.Source,
+			Error:       r.Error,
+		})
+		if r.Error != "" {
+			snap.Errors = append(snap.Errors, r.BlogName+": "+r.Error)
+		} else {
+			snap.NewArticles += r.NewArticles
+		}
+	}
+	return snap
+}
+
+// Manager starts sync jobs and keeps recent ones for status lookups.
+type Manager struct {
+	db      *storage.Database
+	broker  *events.Broker
+	timeout time.Duration
+	logger  *slog.Logger
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	current  *Job
+	jobs     map[string]*Job
+	finished []string // IDs of finished jobs, oldest first
+
+	// Scheduling state for Health
+	lastStarted    time.Time
+	lastFinished   time.Time
+	interval       time.Duration // zero unless RunEvery is active
+	scheduledSince time.Time
+}
+
+// NewManager returns a Manager that scans blogs in db and publishes progress
+// to broker. broker may be nil; a nil logger uses slog.Default().
+func NewManager(db *storage.Database, broker *events.Broker, logger *slog.Logger, timeout time.Duration) *Manager {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{
+		db:      db,
+		broker:  broker,
+		logger:  logger,
+		timeout: timeout,
+		ctx:     ctx,
+		cancel:  cancel,
+		jobs:    make(map[string]*Job),
+	}
+}
+
+// Start begins a new sync job, or returns the running one if a sync is already
+// in progress. started reports whether a new job was created.
+func (m *Manager) Start() (job *Job, started bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.current != nil {
+		return m.current, false
+	}
+
+	job = &Job{
+		id:        newJobID(),
+		startedAt: time.Now(),
+		done:      make(chan struct{}),
+		status:    StatusRunning,
+	}
+	m.current = job
+	m.jobs[job.id] = job
+	m.lastStarted = job.startedAt
+
+	go m.run(job)
+	return job, true
+}
+
+// Get returns the job with the given ID, or nil if it is unknown or has been
+// evicted.
+func (m *Manager) Get(id string) *Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.jobs[id]
+}
+
+// Current returns the running job, or nil when no sync is in progress.
+func (m *Manager) Current() *Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.current
+}
+
+// Timeout returns the time limit applied to each job.
+func (m *Manager) Timeout() time.Duration {
+	return m.timeout
+}
+
+// RunEvery starts a sync every interval until ctx is cancelled. A tick that
+// arrives while a sync is still running attaches to it rather than queueing.
+func (m *Manager) RunEvery(ctx context.Context, interval time.Duration) {
+	m.mu.Lock()
+	m.interval = interval
+	m.scheduledSince = time.Now()
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		m.interval = 0
+		m.mu.Unlock()
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if job, started := m.Start(); started {
+				m.logger.Info("scheduled sync started", "job_id", job.ID())
+			}
+		}
+	}
+}
+
+// stuckGrace is how far past its timeout a job may run before it is
+// considered stuck. Jobs stop at their timeout unless a fetch ignores ctx.
+const stuckGrace = time.Minute
+
+// Health summarises whether syncing is making progress, for readiness checks.
+type Health struct {
+	Running        bool       `json:"running"`
+	CurrentJobID   string     `json:"current_job_id,omitempty"`
+	LastStartedAt  *time.Time `json:"last_started_at,omitempty"`
+	LastFinishedAt *time.Time `json:"last_finished_at,omitempty"`
+	Interval       string     `json:"scheduled_interval,omitempty"`
+	// Stuck is set when the running job has outlived its timeout, or when
+	// scheduled syncs have stopped starting.
+	Stuck  bool   `json:"stuck"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Health reports the sync state as of now.
+func (m *Manager) Health(now time.Time) Health {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var h Health
+	if !m.lastStarted.IsZero() {
+		t := m.lastStarted
+		h.LastStartedAt = &t
+	}
+	if !m.lastFinished.IsZero() {
+		t := m.lastFinished
+		h.LastFinishedAt = &t
+	}
+	if m.interval > 0 {
+		h.Interval = m.interval.String()
+	}
+
+	if m.current != nil {
+		h.Running = true
+		h.CurrentJobID = m.current.id
+		if running := now.Sub(m.current.startedAt); running > m.timeout+stuckGrace {
+			h.Stuck = true
+			h.Reason = "sync job " + m.current.id + " has been running for " + running.Round(time.Second).String()
+		}
+		return h
+	}
+
+	if m.interval > 0 {
+		last := m.scheduledSince
+		if m.lastStarted.After(last) {
+			last = m.lastStarted
+		}
+		if idle := now.Sub(last); idle > 2*m.interval+m.timeout {
+			h.Stuck = true
+			h.Reason = "no scheduled sync has started for " + idle.Round(time.Second).String()
+		}
+	}
+	return h
+}
+
+// Close cancels any running job.
+func (m *Manager) Close() {
+	m.cancel()
+}
+
+func (m *Manager) run(job *Job) {
+	logger := m.logger.With("job_id", job.id)
+	ctx, cancel := context.WithTimeout(logging.WithLogger(m.ctx, logger), m.timeout)
+	defer cancel()
+	defer m.finish(job, logger)
+
+	blogs, err := m.db.ListBlogs()
+	if err != nil {
+		logger.Error("sync failed", "err", err)
+		job.mu.Lock()
+		job.status = StatusFailed
+		job.err = err.Error()
+		job.mu.Unlock()
+		return
+	}
+
+	job.mu.Lock()
+	job.total = len(blogs)
+	job.mu.Unlock()
+	m.publish(events.TypeSyncStarted, map[string]interface{}{
+		"job_id":      job.id,
+		"blogs_total": len(blogs),
+	})
+
+	// The scanner logs each blog's outcome with the job's logger from ctx
+	scanner.ScanBlogs(ctx, m.db, blogs, func(result scanner.ScanResult) {
+		job.mu.Lock()
+		job.results = append(job.results, result)
+		scanned := len(job.results)
+		job.mu.Unlock()
+
+		m.publish(events.TypeSyncProgress, map[string]interface{}{
+			"job_id":        job.id,
+			"blog":          result.BlogName,
+			"new_articles":  result.NewArticles,
+			"error":         result.Error,
+			"blogs_scanned": scanned,
+			"blogs_total":   len(blogs),
+		})
+	})
+
+	job.mu.Lock()
+	switch err := ctx.Err(); {
+	case errors.Is(err, context.DeadlineExceeded):
+		job.status = StatusFailed
+		job.err = "sync timed out after " + m.timeout.String()
+	case err != nil:
+		job.status = StatusFailed
+		job.err = err.Error()
+	default:
+		job.status = StatusDone
+	}
+	job.mu.Unlock()
+}
+
+// finish marks job complete, clears it as the current job and evicts old jobs.
+func (m *Manager) finish(job *Job, logger *slog.Logger) {
+	now := time.Now()
+	job.mu.Lock()
+	job.finishedAt = &now
+	job.mu.Unlock()
+
+	snap := job.Snapshot()
+	logger.Info("sync complete",
+		"status", snap.Status,
+		"blogs_scanned", snap.BlogsScanned,
+		"new_articles", snap.NewArticles,
+		"errors", len(snap.Errors),
+		"duration", now.Sub(snap.StartedAt))
+
+	m.mu.Lock()
+	m.current = nil
+	m.lastFinished = now
+	m.finished = append(m.finished, job.id)
+	for len(m.finished) > maxFinishedJobs {
+		delete(m.jobs, m.finished[0])
+		m.finished = m.finished[1:]
+	}
+	m.mu.Unlock()
+
+	close(job.done)
+	m.publish(events.TypeSyncFinished, map[string]interface{}{
+		"job_id":        job.id,
+		"status":        snap.Status,
+		"blogs_scanned": snap.BlogsScanned,
+		"new_articles":  snap.NewArticles,
+	})
+	// One refresh per sync rather than one per blog
+	if snap.NewArticles > 0 {
+		m.publish(events.TypeArticlesNew, map[string]int{"count": snap.NewArticles})
+	}
+}
+
+func (m *Manager) publish(eventType string, data any) {
+	if m.broker == nil {
+		return
+	}
+	m.broker.Publish(events.Event{Type: eventType, Data: data})
+}
+
+// newJobID returns a short random hex identifier.
+func newJobID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic("crypto/rand unavailable: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,237 @@
+// ABOUTME: Tests for background sync jobs: attaching to a running job and progress reporting.
+// ABOUTME: Feeds are served by a local httptest server that can be held open to keep a job running.
+package syncjob
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/events"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/model"
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/storage"
+)
+
+func openTestDB(t *testing.T) *storage.Database {
+	t.Helper()
+	db, err := storage.OpenDatabase(filepath.Join(t.TempDir(), "bw.db"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// heldFeedServer serves a one-item RSS feed once release is closed.
+func heldFeedServer(t *testing.T, release <-chan struct{}) *httptest.Server {
+	t.Helper()
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/feed.xml" {
+			http.NotFound(w, r)
+			return
+		}
+		<-release
+		w.Header().Set("Content-Type", "application/rss+xml")
+		fmt.Fprintf(w, `<?xml version="1.0"?><rss version="2.0"><channel><title>T</title>
+<item><title>Post</title><link>%s/post</link><pubDate>Mon, 06 May 2024 10:00:00 GMT</pubDate></item>
+</channel></rss>`, ts.URL)
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestStartAttachesToRunningJob(t *testing.T) {
+	db := openTestDB(t)
+	release := make(chan struct{})
+	ts := heldFeedServer(t, release)
+	if _, err := db.AddBlog(model.Blog{Name: "Held", URL: ts.URL, FeedURL: ts.URL + "/feed.xml"}); err != nil {
+		t.Fatalf("add blog: %v", err)
+	}
+
+	m := NewManager(db, nil, nil, time.Minute)
+	defer m.Close()
+
+	first, started := m.Start()
+	if !started {
+		t.Fatal("first Start should create a job")
+	}
+	second, started := m.Start()
+	if started || second.ID() != first.ID() {
+		t.Errorf("second Start = (%s, %v), want attach to %s", second.ID(), started, first.ID())
+	}
+	if m.Current() != first {
+		t.Error("Current should return the running job")
+	}
+
+	close(release)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := first.Wait(ctx); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+
+	snap := first.Snapshot()
+	if snap.Status != StatusDone || snap.BlogsTotal != 1 || snap.BlogsScanned != 1 || snap.NewArticles != 1 {
+		t.Errorf("snapshot = %+v, want done with 1 blog and 1 new article", snap)
+	}
+	if m.Current() != nil {
+		t.Error("Current should be nil once the job finishes")
+	}
+	if m.Get(first.ID()) != first {
+		t.Error("finished job should still be retrievable by ID")
+	}
+
+	next, started := m.Start()
+	if !started || next.ID() == first.ID() {
+		t.Error("Start after completion should create a new job")
+	}
+	next.Wait(ctx)
+}
+
+func TestTimedOutJobFails(t *testing.T) {
+	db := openTestDB(t)
+	release := make(chan struct{})
+	ts := heldFeedServer(t, release)
+	defer close(release)
+	if _, err := db.AddBlog(model.Blog{Name: "Held", URL: ts.URL, FeedURL: ts.URL + "/feed.xml"}); err != nil {
+		t.Fatalf("add blog: %v", err)
+	}
+
+	m := NewManager(db, nil, nil, 100*time.Millisecond)
+	defer m.Close()
+
+	job, _ := m.Start()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := job.Wait(ctx); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if snap := job.Snapshot(); snap.Status != StatusFailed || !strings.Contains(snap.Error, "timed out") {
+		t.Errorf("snapshot = %+v, want failed with a timeout error", snap)
+	}
+}
+
+func TestJobPublishesProgressEvents(t *testing.T) {
+	db := openTestDB(t)
+	release := make(chan struct{})
+	close(release)
+	ts := heldFeedServer(t, release)
+	if _, err := db.AddBlog(model.Blog{Name: "Quick", URL: ts.URL, FeedURL: ts.URL + "/feed.xml"}); err != nil {
+		t.Fatalf("add blog: %v", err)
+	}
+
+	broker := events.NewBroker()
+	ch, unsubscribe := broker.Subscribe()
+	defer unsubscribe()
+
+	m := NewManager(db, broker, nil, time.Minute)
+	defer m.Close()
+	job, _ := m.Start()
+
+	var got []string
+	timeout := time.After(10 * time.Second)
+	for len(got) == 0 || got[len(got)-1] != events.TypeArticlesNew {
+		select {
+		case e := <-ch:
+			got = append(got, e.Type)
+		case <-timeout:
+			t.Fatalf("timed out; events so far: %v", got)
+		}
+	}
+
+	want := []string{events.TypeSyncStarted, events.TypeSyncProgress, events.TypeSyncFinished, events.TypeArticlesNew}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+	if snap := job.Snapshot(); len(snap.Results) != 1 || snap.Results[0].Blog != "Quick" {
+		t.Errorf("results = %+v, want one result for Quick", snap.Results)
+	}
+}
+
+func TestScanFailuresAreLoggedWithBlogFields(t *testing.T) {
+	db := openTestDB(t)
+	release := make(chan struct{})
+	close(release)
+	ts := heldFeedServer(t, release)
+	blog, err := db.AddBlog(model.Blog{Name: "Broken", URL: ts.URL, FeedURL: ts.URL + "/missing.xml"})
+	if err != nil {
+		t.Fatalf("add blog: %v", err)
+	}
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	m := NewManager(db, nil, logger, time.Minute)
+	defer m.Close()
+
+	job, _ := m.Start()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := job.Wait(ctx); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+
+	var entry map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var e map[string]any
+		if json.Unmarshal([]byte(line), &e) == nil && e["msg"] == "blog scan failed" {
+			entry = e
+		}
+	}
+	if entry == nil {
+		t.Fatalf("no scan failure logged:\n%s", buf.String())
+	}
+	if entry["blog_id"] != float64(blog.ID) || entry["feed_url"] != blog.FeedURL ||
+		entry["source"] != "none" || entry["job_id"] != job.ID() || entry["err"] == "" {
+		t.Errorf("scan failure entry = %v", entry)
+	}
+}
+
+func TestHealthDetectsStuckSyncs(t *testing.T) {
+	db := openTestDB(t)
+	release := make(chan struct{})
+	ts := heldFeedServer(t, release)
+	if _, err := db.AddBlog(model.Blog{Name: "Held", URL: ts.URL, FeedURL: ts.URL + "/feed.xml"}); err != nil {
+		t.Fatalf("add blog: %v", err)
+	}
+
+	m := NewManager(db, nil, nil, time.Minute)
+	defer m.Close()
+
+	if h := m.Health(time.Now()); h.Running || h.Stuck || h.LastStartedAt != nil {
+		t.Errorf("idle manager health = %+v", h)
+	}
+
+	job, _ := m.Start()
+	if h := m.Health(time.Now()); !h.Running || h.Stuck || h.CurrentJobID != job.ID() {
+		t.Errorf("running health = %+v", h)
+	}
+	if h := m.Health(time.Now().Add(time.Minute + stuckGrace + time.Second)); !h.Stuck || h.Reason == "" {
+		t.Errorf("overdue job should be stuck: %+v", h)
+	}
+
+	close(release)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	job.Wait(ctx)
+	if h := m.Health(time.Now()); h.Running || h.Stuck || h.LastFinishedAt == nil {
+		t.Errorf("finished health = %+v", h)
+	}
+
+	// A scheduler that stops starting jobs is reported too
+	m.mu.Lock()
+	m.interval = time.Minute
+	m.scheduledSince = time.Now()
+	m.mu.Unlock()
+	if h := m.Health(time.Now().Add(10 * time.Minute)); !h.Stuck || h.Interval != "1m0s" {
+		t.Errorf("stalled schedule health = %+v", h)
+	}
+}
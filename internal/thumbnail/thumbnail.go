@@ -4,9 +4,9 @@ package thumbnail
 
 import (
 	"context"
-	"net/http"
 	"strings"
 
+	"github.com/esttorhe/blogwatcher-ui/v2/internal/fetch"
 	"github.com/mmcdole/gofeed"
 	"github.com/otiai10/opengraph/v2"
 )
@@ -101,7 +101,7 @@ func ExtractFromOpenGraph(ctx context.Context, articleURL string) string {
 	intent := opengraph.Intent{
 		Context:    ctx,
 		Strict:     true, // Only parse <meta> tags
-		HTTPClient: &http.Client{Transport: http.DefaultTransport},
+		HTTPClient: fetch.Client(),
 	}
 
 	ogp, err := opengraph.Fetch(articleURL, intent)